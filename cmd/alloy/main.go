@@ -2,44 +2,302 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
-
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anthropics/alloy/internal/cli"
+	"github.com/anthropics/alloy/internal/config"
 	"github.com/anthropics/alloy/internal/installer"
 	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/log"
+	"github.com/anthropics/alloy/internal/paths"
 	"github.com/anthropics/alloy/internal/pkg"
+	"github.com/anthropics/alloy/internal/registry"
 )
 
 const version = "0.1.0"
 
+// logger records commands, their progress, and their outcome to the file
+// named by ALLOY_LOG_FILE. It is nil when logging is disabled.
+var logger *log.Logger
+
+// stdout and stderr decorate output with glyphs and colors each stream can
+// show.
+var (
+	stdout = cli.NewOutput(os.Stdout)
+	stderr = cli.NewOutput(os.Stderr)
+)
+
 func main() {
 	if len(os.Args) < 2 {
 		usage()
-		os.Exit(1)
+		exit(1)
+	}
+
+	if path := os.Getenv(log.FileEnv); path != "" {
+		l, err := log.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		logger = l
+	}
+	logger.Printf("command: %s", strings.Join(os.Args[1:], " "))
+	installer.UserAgent = "alloy/" + version
+	ledger.Warnf = func(format string, args ...any) {
+		errorf("Warning: "+format+"\n", args...)
 	}
 
 	switch os.Args[1] {
 	case "install":
 		cmdInstall(os.Args[2:])
+	case "upgrade":
+		cmdUpgrade(os.Args[2:])
+	case "pin":
+		cmdPin(os.Args[2:], true)
+	case "unpin":
+		cmdPin(os.Args[2:], false)
 	case "remove":
 		cmdRemove(os.Args[2:])
+	case "autoremove":
+		cmdAutoremove(os.Args[2:])
+	case "rollback":
+		cmdRollback(os.Args[2:])
+	case "repair":
+		cmdRepair(os.Args[2:])
+	case "repair-backups":
+		cmdRepairBackups(os.Args[2:])
 	case "list":
 		cmdList(os.Args[2:])
 	case "info":
 		cmdInfo(os.Args[2:])
 	case "doctor":
 		cmdDoctor(os.Args[2:])
+	case "status":
+		cmdStatus(os.Args[2:])
+	case "outdated":
+		cmdOutdated(os.Args[2:])
+	case "which":
+		cmdWhich(os.Args[2:])
+	case "ledger":
+		cmdLedger(os.Args[2:])
+	case "pack":
+		cmdPack(os.Args[2:])
+	case "publish":
+		cmdPublish(os.Args[2:])
+	case "remote":
+		cmdRemote(os.Args[2:])
+	case "sync":
+		cmdSync(os.Args[2:])
+	case "clean":
+		cmdClean(os.Args[2:])
+	case "fmt":
+		cmdFmt(os.Args[2:])
 	case "version", "--version", "-v":
 		fmt.Printf("alloy version %s\n", version)
 	case "help", "--help", "-h":
 		usage()
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", os.Args[1])
+		errorf("Unknown command: %s\n", os.Args[1])
 		usage()
-		os.Exit(1)
+		exit(1)
+	}
+
+	logger.Printf("result: success")
+	logger.Close()
+}
+
+// exit logs the command's failure and exits with the given status.
+func exit(code int) {
+	logger.Printf("result: failed (exit status %d)", code)
+	logger.Close()
+	os.Exit(code)
+}
+
+// exitStatus exits with a status that reports a state, such as updates
+// being available, rather than a failure.
+func exitStatus(code int) {
+	logger.Printf("result: success (exit status %d)", code)
+	logger.Close()
+	os.Exit(code)
+}
+
+// errorf prints a message to stderr and records it in the log.
+func errorf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprint(os.Stderr, paintLine(stderr, msg))
+	logger.Printf("%s", msg)
+}
+
+// errorln prints a line to stderr and records it in the log.
+func errorln(args ...any) {
+	msg := fmt.Sprintln(args...)
+	fmt.Fprint(os.Stderr, paintLine(stderr, msg))
+	logger.Printf("%s", msg)
+}
+
+// printProgress prints an installer progress message and records it in the
+// log.
+func printProgress(msg string) {
+	fmt.Println(paintLine(stdout, msg))
+	logger.Printf("%s", msg)
+}
+
+// linePrefixes map the start of a message to the status it is colored as.
+var linePrefixes = []struct{ prefix, status string }{
+	{"Error", cli.StatusError},
+	{"Warning", cli.StatusWarning},
+	{"Conflict", cli.StatusWarning},
+	{"Successfully", cli.StatusOK},
+}
+
+// paintLine colors the first word of msg if it marks an error, warning, or
+// success, leaving the rest of the line plain.
+func paintLine(out *cli.Output, msg string) string {
+	for _, p := range linePrefixes {
+		if rest, ok := strings.CutPrefix(msg, p.prefix); ok {
+			word, tail, found := strings.Cut(rest, " ")
+			painted := out.Paint(p.status, p.prefix+word)
+			if !found {
+				return painted
+			}
+			return painted + " " + tail
+		}
+	}
+	return msg
+}
+
+// packageSizes is the disk space used by an installed package, as printed
+// by 'alloy info --size'.
+type packageSizes struct {
+	Package          string `json:"package"`
+	FilesCreated     int    `json:"files_created"`
+	CreatedBytes     int64  `json:"created_bytes"`
+	FilesOverwritten int    `json:"files_overwritten"`
+	OverwrittenBytes int64  `json:"overwritten_bytes"`
+	OriginalBytes    int64  `json:"original_bytes"`
+	InstalledBytes   int64  `json:"installed_bytes"`
+	BackupBytes      int64  `json:"backup_bytes"`
+	CacheBytes       int64  `json:"cache_bytes"`
+}
+
+// measurePackage totals the files a package installed, its backups, and its
+// cached source archive, if any. Reverted entries are not counted.
+func measurePackage(ledg *ledger.Ledger) (*packageSizes, error) {
+	sizes := &packageSizes{
+		Package:        ledg.Header.Package,
+		InstalledBytes: ledg.TotalInstalledSize(),
+	}
+	for _, entry := range ledg.Entries {
+		if entry.Reverted {
+			continue
+		}
+		switch entry.Op {
+		case ledger.OpFileCreate:
+			sizes.FilesCreated++
+			sizes.CreatedBytes += entry.Size
+		case ledger.OpFileOverwrite:
+			sizes.FilesOverwritten++
+			sizes.OverwrittenBytes += entry.Size
+			if entry.Original != nil {
+				sizes.OriginalBytes += entry.Original.Size
+			}
+		}
+	}
+
+	backupDir, err := ledger.DefaultBackupDir()
+	if err != nil {
+		return nil, err
+	}
+	if sizes.BackupBytes, err = ledger.BackupDirSize(backupDir, sizes.Package); err != nil {
+		return nil, fmt.Errorf("measuring backups: %w", err)
+	}
+
+	// Sources are cached by sha256, so git sources and sha512-only
+	// downloads never have a cached archive
+	if sum := ledg.Header.SourceChecksum; sum != "" {
+		cacheDir, err := paths.CacheDir()
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(filepath.Join(cacheDir, strings.ToLower(sum))); err == nil && info.Mode().IsRegular() {
+			sizes.CacheBytes = info.Size()
+		}
+	}
+	return sizes, nil
+}
+
+// printDepTree prints node and its dependencies with pstree-style branches,
+// stopping below maxDepth levels if it is positive.
+func printDepTree(node *pkg.DepNode, prefix, branch string, depth, maxDepth int) {
+	line := stdout.Text(prefix+branch) + node.Name
+	switch {
+	case node.Cycle:
+		line += " " + stdout.Paint(cli.StatusWarning, "[cycle]")
+	case node.Missing:
+		line += " " + stdout.Paint(cli.StatusError, "[not installed]")
+	}
+	fmt.Println(line)
+
+	if maxDepth > 0 && depth >= maxDepth {
+		return
+	}
+	switch branch {
+	case "├── ":
+		prefix += "│   "
+	case "└── ":
+		prefix += "    "
+	}
+	for idx, child := range node.Children {
+		childBranch := "├── "
+		if idx == len(node.Children)-1 {
+			childBranch = "└── "
+		}
+		printDepTree(child, prefix, childBranch, depth+1, maxDepth)
+	}
+}
+
+// eventMu serializes printEvent, since parallel installs emit concurrently.
+var eventMu sync.Mutex
+
+// printEvent writes an installer event to stdout as a line of JSON.
+func printEvent(e installer.Event) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	json.NewEncoder(os.Stdout).Encode(e)
+}
+
+// printMessageBox prints a package's post-install or post-remove message
+// between horizontal rules, so it stands out from the progress output.
+func printMessageBox(title, message string) {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	width := utf8.RuneCountInString(title) + 4
+	for _, line := range lines {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+
+	fmt.Println()
+	fmt.Println(stdout.Text(fmt.Sprintf("── %s %s", title, strings.Repeat("─", width-utf8.RuneCountInString(title)-4))))
+	for _, line := range lines {
+		fmt.Println(line)
 	}
+	fmt.Println(stdout.Text(strings.Repeat("─", width)))
 }
 
 func usage() {
@@ -51,27 +309,167 @@ Usage:
 Note: Options must come before arguments (e.g., 'alloy install --dry-run ripgrep')
 
 Commands:
-  install <package>   Install a package
+  install <pkg>...    Install one or more packages
+  upgrade <package>   Upgrade an installed package
+  pin <package>...    Hold packages at their installed version
+  unpin <package>...  Allow pinned packages to be upgraded again
   remove <package>    Remove an installed package
+  autoremove          Remove dependencies no installed package needs anymore
+  rollback <package>  Restore files a package overwrote, keeping it installed
+  repair <package>    Finish an interrupted installation
+  repair-backups <pkg>
+                      Re-create missing backups of files a package overwrote
   list                List installed packages
   info <package>      Show information about a package
   doctor              Check system health and diagnose issues
+  status              Summarize installed packages, available updates, and issues
+  outdated            List installed packages with a newer version available
+  which <path>        Show which package installed a file
+  ledger dump <pkg>   Print a package's ledger as JSON Lines
+  pack <file>         Bundle a package definition and its source for offline installs
+  publish <file>      Submit a package definition to the registry
+  remote <subcommand> Manage package remotes (add, remove, list, sync)
+  sync                Refresh package indexes from remotes
+  clean               Delete cached downloads
+  fmt <file>...       Rewrite package definitions in canonical form
   version             Show version information
   help                Show this help message
 
+Environment:
+  ALLOY_LOG_FILE      Append a timestamped log of commands and progress to this file
+  ALLOY_PACKAGES_DIR  Directory containing package definitions (default: ./packages)
+  NO_COLOR            Disable colored output (color is only used on terminals)
+
 Install Options:
   --dry-run           Show what would happen without making changes
+  --diff              With --dry-run, show file-level changes
+  --check-source      With --dry-run, download the source and verify its checksum
   --verbose           Show detailed output
   --version <ver>     Install a specific version
+  --compress-backups  Gzip backups of overwritten files
+  --strict-versions   Reject package versions that are not semver
+  --no-backup         Don't back up overwritten files (for ephemeral environments)
+  --no-cache          Always download sources instead of using the cache
+  --packages-dir <d>  Directory containing package definitions
+                      (default: $ALLOY_PACKAGES_DIR or ./packages)
+  --timeout <dur>     Abort and roll back if installing takes longer (e.g. 10m)
+  --verify            Check installed files against their checksums, rolling back on mismatch
+  --keep-partial      Leave completed steps in place if a step fails instead of rolling back
+  --sync-ledger       Flush the ledger to disk after every change, so a crash loses none (slower)
+  --resume            Continue a partial installation from its last completed step
+  --jobs <n>          Install up to n packages in parallel (default: 1)
+  --progress <fmt>    Progress output: text (default) or json, one event per line
+  --from-file <file>  Install the package in a bundle created by alloy pack
+  --reinstall         Remove and install again packages that are already installed
+  --keep-backups      With --reinstall, keep backups of files restored during removal
+  --allow-conflicts   Install without checking whether another package owns a target path
+  --env-file <file>   Set variables from a KEY=VALUE file in the environment of run steps
+  --atomic            Stage copied files and directories and move them into place only
+                      once every step has succeeded
+  --only-deps         Install the packages' missing dependencies but not the packages
+  --ref <ref>         Install this git branch, tag or commit instead of the one in the
+                      definition (git sources only); upgrades keep following it
+
+Upgrade Options:
+  --dry-run           Show what would happen without making changes
+  --verbose           Show detailed output
+  --force             Reinstall even if the package is up to date
+  --no-cache          Always download sources instead of using the cache
+  --allow-conflicts   Upgrade without checking whether another package owns a target path
+
+List Options:
+  --verbose           Show detailed information
+  --sort <field>      Sort by name, date, size, or source (default: name)
+  --reverse           Reverse the sort order
+  --since <age>       Only show packages installed within age (e.g. 7d, 12h)
+  --group-by-source   Group packages by source host
+  --group-by-date <p> Group packages by install day, week, or month
+  --installed-before <date>
+                      Only show packages installed before a date (YYYY-MM-DD or RFC3339)
+  --installed-after <date>
+                      Only show packages installed after a date
+  --tree              Show installed packages as a dependency tree
+  --depth <n>         With --tree, limit the tree to n levels
+  --json              Print the installed packages as JSON
+  --with-files        With --json, include every file path, operation, and checksum
+
+Info Options:
+  --history           Show modification history for the package's files
+  --tree              Show the dependency tree
+  --remote            Fetch the definition from the remote indexes
+  --packages-dir <d>  Directory containing package definitions
+  --size              Show disk space used by installed files, backups, and the cached source
+  --json              With --size, print the sizes as JSON
+  --format <tmpl>     Print fields with a Go template, e.g. '{{.Version}}' or
+                      '{{if .Installed}}{{.InstalledVersion}}{{end}}'
 
 Remove Options:
   --dry-run           Show what would happen without making changes
   --verbose           Show detailed output
   --force             Force removal even if files were modified
+  --assume-yes        Don't ask for confirmation
+  --purge             Delete the package's backups after removal
+  --keep-config       Leave installed files that were modified in place
+
+Autoremove Options:
+  --dry-run           List the packages that would be removed
+  --verbose           Show detailed output
+  --force             Force removal even if files were modified
+  --assume-yes        Don't ask for confirmation
+
+Rollback Options:
+  --dry-run           Show what would happen without making changes
+  --verbose           Show detailed output
+
+Repair Options:
+  --dry-run           Show which steps would be re-run
+  --verbose           Show detailed output
+
+Repair Backups Options:
+  --dry-run           List the backups that would be re-created
+
+Ledger Dump Options:
+  --redact            Replace the home directory in paths with ~
+
+Pack Options:
+  --output <file>     Write the bundle to this file (default: <name>-<version>.alloy)
+  --no-cache          Always download the source instead of using the cache
+
+Publish Options:
+  --sign              GPG-sign the package definition
+
+Sync Options:
+  --remote <name>     Only sync the named remote
+
+Clean Options:
+  --older-than <age>  Only delete downloads not used for age (e.g. 30d)
+
+Fmt Options:
+  --check             List files that aren't formatted and exit 1, without changing them
+
+Status Options:
+  --quiet             Print nothing; exit 1 if updates are available, 2 if any
+                      package has issues, 0 otherwise
+
+Outdated Options:
+  --json              Output as JSON
 
 Doctor Options:
   --verbose           Show detailed output
-  --check-files       Verify installed files exist and have correct checksums`)
+  --check-files       Verify installed files exist and have correct checksums
+  --check-symlinks    Verify installed symlinks point to existing targets
+  --check-hardlinks   Verify installed hard links still share their target's inode
+  --fix               Apply automatic repair suggestions
+  --format <fmt>      Output format: text (default) or json
+  --package <name>    Only check one package's ledger, skipping system checks
+  --check-network     Check the hosts of remotes and package sources are reachable
+                      (sends a HEAD request to each host)
+  --fix-permissions   Restore the permissions of installed files from their ledgers
+  --dry-run           With --fix-permissions, only report files with wrong permissions
+  --check-ownership   Verify installed files still have their recorded owner and group
+                      (Unix only)
+  --migrate           Rewrite ledgers written in an older format before checking
+  --check-shadowing   Warn about installed binaries hidden by others earlier in PATH`)
 }
 
 func cmdInstall(args []string) {
@@ -79,40 +477,360 @@ func cmdInstall(args []string) {
 	dryRun := fs.Bool("dry-run", false, "Run without making any changes")
 	verbose := fs.Bool("verbose", false, "Show detailed output")
 	versionFlag := fs.String("version", "", "Specific version to install")
+	compressBackups := fs.Bool("compress-backups", false, "Gzip backups of overwritten files")
+	strictVersions := fs.Bool("strict-versions", false, "Reject package versions that are not semver")
+	noBackup := fs.Bool("no-backup", false, "Don't back up overwritten files")
+	diff := fs.Bool("diff", false, "With --dry-run, show file-level changes")
+	checkSource := fs.Bool("check-source", false, "With --dry-run, download and verify the source")
+	noCache := fs.Bool("no-cache", false, "Always download sources instead of using the cache")
+	packagesDir := fs.String("packages-dir", "", "Directory containing package definitions")
+	timeout := fs.Duration("timeout", 0, "Abort the installation if it takes longer than this (e.g. 10m)")
+	verify := fs.Bool("verify", false, "Check installed files against their checksums before finishing")
+	keepPartial := fs.Bool("keep-partial", false, "Leave completed steps in place if a step fails")
+	syncLedger := fs.Bool("sync-ledger", false, "Flush the ledger to disk after every change")
+	resume := fs.Bool("resume", false, "Continue a partial installation left by --keep-partial")
+	jobs := fs.Int("jobs", 1, "Install up to this many packages in parallel")
+	progress := fs.String("progress", "text", "Progress output: text or json")
+	fromFile := fs.String("from-file", "", "Install the package in a bundle created by alloy pack")
+	reinstall := fs.Bool("reinstall", false, "Remove and install again packages that are already installed")
+	keepBackups := fs.Bool("keep-backups", false, "With --reinstall, keep backups of files restored during removal")
+	allowConflicts := fs.Bool("allow-conflicts", false, "Install without checking whether another package owns a target path")
+	envFile := fs.String("env-file", "", "Set variables from a KEY=VALUE file in the environment of run steps")
+	ref := fs.String("ref", "", "Install this git branch, tag or commit instead of the one in the definition")
+	onlyDeps := fs.Bool("only-deps", false, "Install the packages' missing dependencies but not the packages")
+	atomic := fs.Bool("atomic", false, "Stage copied files and move them into place once every step has succeeded")
+	fs.Parse(args)
+
+	if *progress != "text" && *progress != "json" {
+		errorf("Error: unknown progress format %q (want text or json)\n", *progress)
+		exit(1)
+	}
+
+	if *diff && !*dryRun {
+		errorln("Error: --diff requires --dry-run")
+		exit(1)
+	}
+	if *checkSource && !*dryRun {
+		errorln("Error: --check-source requires --dry-run")
+		exit(1)
+	}
+	if *keepBackups && !*reinstall {
+		errorln("Error: --keep-backups requires --reinstall")
+		exit(1)
+	}
+	if *reinstall && *resume {
+		errorln("Error: --reinstall cannot be combined with --resume")
+		exit(1)
+	}
+	if *onlyDeps && (*resume || *fromFile != "" || *ref != "") {
+		errorln("Error: --only-deps cannot be combined with --resume, --from-file or --ref")
+		exit(1)
+	}
+	if *atomic && *resume {
+		errorln("Error: --atomic cannot be combined with --resume")
+		exit(1)
+	}
+
+	if *fromFile != "" && (fs.NArg() > 0 || *resume) {
+		errorln("Error: --from-file cannot be combined with package names or --resume")
+		exit(1)
+	}
+	if fs.NArg() < 1 && *fromFile == "" {
+		errorln("Usage: alloy install <package>... [--version <version>]")
+		exit(1)
+	}
+	if fs.NArg() > 1 && (*versionFlag != "" || *resume || *ref != "") {
+		errorln("Error: --version, --resume and --ref take a single package")
+		exit(1)
+	}
+	if *ref != "" && (*resume || *fromFile != "") {
+		errorln("Error: --ref cannot be combined with --resume or --from-file")
+		exit(1)
+	}
+	if *jobs < 1 {
+		errorln("Error: --jobs must be at least 1")
+		exit(1)
+	}
+
+	packageName := strings.Join(fs.Args(), ", ")
+	if *fromFile != "" {
+		packageName = *fromFile
+	}
+
+	inst, err := installer.New()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	inst.DryRun = *dryRun
+	inst.Diff = *diff
+	inst.CheckSource = *checkSource
+	inst.Verbose = *verbose
+	inst.CompressBackups = *compressBackups
+	inst.NoBackup = *noBackup
+	inst.NoCache = *noCache
+	inst.GlobalTimeout = *timeout
+	inst.VerifyAfterInstall = *verify
+	inst.KeepPartial = *keepPartial
+	inst.SyncLedger = *syncLedger
+	inst.StrictVersions = *strictVersions
+	inst.Reinstall = *reinstall
+	inst.KeepBackups = *keepBackups
+	inst.AllowConflicts = *allowConflicts
+	inst.GitRef = *ref
+	if *atomic {
+		// Stage in each package's prefix, so files are renamed into place
+		inst.StagingDir = "."
+	}
+	if *envFile != "" {
+		env, err := cli.ParseEnvFile(*envFile)
+		if err != nil {
+			errorf("Error: reading env file: %v\n", err)
+			exit(1)
+		}
+		inst.ExtraEnv = env
+	}
+	if *packagesDir != "" {
+		inst.PackagesDir = *packagesDir
+	}
+	inst.OnProgress = printProgress
+
+	if *progress == "json" {
+		// Keep stdout to one JSON event per line; messages still reach the
+		// log and command output goes to stderr
+		inst.OnProgress = func(msg string) { logger.Printf("%s", msg) }
+		inst.OnEvent = printEvent
+		inst.CommandOutput = os.Stderr
+	} else {
+		if *resume {
+			fmt.Printf("Resuming %s\n", packageName)
+		} else if *onlyDeps {
+			fmt.Printf("Installing the dependencies of %s\n", packageName)
+		} else if *ref != "" {
+			fmt.Printf("Installing %s at ref %s\n", packageName, *ref)
+		} else if *versionFlag != "" {
+			fmt.Printf("Installing %s@%s\n", packageName, *versionFlag)
+		} else {
+			fmt.Printf("Installing %s (latest)\n", packageName)
+		}
+
+		if *dryRun {
+			fmt.Println("[dry-run] No changes will be made to the system")
+		}
+	}
+
+	// Post-install messages are shown once everything has finished, so
+	// parallel installs don't interleave them with progress output
+	type note struct{ pkg, message string }
+	var notes []note
+	if inst.OnEvent == nil {
+		// Download progress is drawn for a single package at a time only;
+		// parallel bars would overwrite each other
+		var bar *installer.TextProgress
+		if *jobs == 1 {
+			bar = installer.NewTextProgress(os.Stdout, cli.IsTerminal(os.Stdout))
+			inst.OnProgress = func(msg string) {
+				bar.Clear()
+				printProgress(msg)
+			}
+		}
+		inst.OnEvent = func(e installer.Event) {
+			if bar != nil {
+				bar.Event(e)
+			}
+			if e.Kind == installer.EventDone && e.Message != "" {
+				eventMu.Lock()
+				notes = append(notes, note{e.Package, e.Message})
+				eventMu.Unlock()
+			}
+		}
+	}
+
+	switch {
+	case *resume:
+		err = inst.Resume(fs.Arg(0))
+	case *fromFile != "":
+		err = installBundle(inst, *fromFile)
+	case *onlyDeps:
+		var installed []string
+		installed, err = inst.InstallDependencies(fs.Args(), *jobs)
+		// Keep stdout to JSON events, as with progress
+		var out io.Writer = os.Stdout
+		if *progress == "json" {
+			out = os.Stderr
+		}
+		switch {
+		case len(installed) == 0 && err == nil:
+			fmt.Fprintf(out, "All dependencies of %s are already installed\n", packageName)
+		case len(installed) > 0 && *dryRun:
+			fmt.Fprintf(out, "[dry-run] Would install %d dependencies: %s\n", len(installed), strings.Join(installed, ", "))
+		case len(installed) > 0:
+			fmt.Fprintf(out, "Installed %d dependencies: %s\n", len(installed), strings.Join(installed, ", "))
+		}
+	default:
+		err = inst.InstallAll(fs.Args(), *jobs)
+	}
+	for _, n := range notes {
+		printMessageBox("Notes for "+n.pkg, n.message)
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+}
+
+// installBundle installs the package in the bundle file at path.
+func installBundle(inst *installer.Installer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return inst.InstallBundle(f)
+}
+
+func cmdPack(args []string) {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	output := fs.String("output", "", "Write the bundle to this file (default: <name>-<version>.alloy)")
+	noCache := fs.Bool("no-cache", false, "Always download the source instead of using the cache")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		errorln("Usage: alloy pack <package.toml>")
+		exit(1)
+	}
+
+	pkgDef, err := pkg.ParseFile(fs.Arg(0))
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	inst, err := installer.New()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	inst.NoCache = *noCache
+	inst.OnProgress = printProgress
+
+	fmt.Printf("Packing %s %s\n", pkgDef.Name, pkgDef.Version)
+	source, err := inst.SourceArchive(pkgDef)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	path := *output
+	if path == "" {
+		path = installer.BundleName(pkgDef)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	err = installer.Pack(pkgDef, source, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Wrote %s (%s)\n", path, installer.FormatSize(info.Size()))
+	fmt.Printf("Install it offline with: alloy install --from-file %s\n", path)
+}
+
+func cmdUpgrade(args []string) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Run without making any changes")
+	verbose := fs.Bool("verbose", false, "Show detailed output")
+	force := fs.Bool("force", false, "Reinstall even if the package is up to date")
+	noCache := fs.Bool("no-cache", false, "Always download sources instead of using the cache")
+	allowConflicts := fs.Bool("allow-conflicts", false, "Upgrade without checking whether another package owns a target path")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "Usage: alloy install <package> [--version <version>]")
-		os.Exit(1)
+		errorln("Usage: alloy upgrade <package>")
+		exit(1)
 	}
 
 	packageName := fs.Arg(0)
 
 	inst, err := installer.New()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		errorf("Error: %v\n", err)
+		exit(1)
 	}
 
 	inst.DryRun = *dryRun
 	inst.Verbose = *verbose
-	inst.OnProgress = func(msg string) {
-		fmt.Println(msg)
+	inst.Force = *force
+	inst.NoCache = *noCache
+	inst.AllowConflicts = *allowConflicts
+	inst.OnProgress = printProgress
+
+	fmt.Printf("Upgrading %s\n", packageName)
+
+	if err := inst.Upgrade(packageName); err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
 	}
+}
 
-	if *versionFlag != "" {
-		fmt.Printf("Installing %s@%s\n", packageName, *versionFlag)
-	} else {
-		fmt.Printf("Installing %s (latest)\n", packageName)
+// cmdPin pins or unpins the named packages, depending on pinned.
+func cmdPin(args []string, pinned bool) {
+	command := "pin"
+	if !pinned {
+		command = "unpin"
 	}
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Parse(args)
 
-	if *dryRun {
-		fmt.Println("[dry-run] No changes will be made to the system")
+	if fs.NArg() < 1 {
+		errorf("Usage: alloy %s <package>...\n", command)
+		exit(1)
 	}
 
-	if err := inst.Install(packageName); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	failed := false
+	for _, name := range fs.Args() {
+		if !ledger.Exists(ledgerDir, name) {
+			errorf("Error: package %q is not installed\n", name)
+			failed = true
+			continue
+		}
+		changed, err := ledger.SetPinned(ledgerDir, name, pinned)
+		switch {
+		case err != nil:
+			errorf("Error: %s: %v\n", name, err)
+			failed = true
+		case !changed && pinned:
+			fmt.Printf("%s is already pinned\n", name)
+		case !changed:
+			fmt.Printf("%s is not pinned\n", name)
+		case pinned:
+			fmt.Printf("Pinned %s\n", name)
+		default:
+			fmt.Printf("Unpinned %s\n", name)
+		}
+	}
+	if failed {
+		exit(1)
 	}
 }
 
@@ -121,24 +839,27 @@ func cmdRemove(args []string) {
 	dryRun := fs.Bool("dry-run", false, "Run without making any changes")
 	force := fs.Bool("force", false, "Force removal even if files were modified")
 	verbose := fs.Bool("verbose", false, "Show detailed output")
+	assumeYes := fs.Bool("assume-yes", false, "Don't ask for confirmation")
+	purge := fs.Bool("purge", false, "Delete the package's backups after removal")
+	keepConfig := fs.Bool("keep-config", false, "Leave installed files that were modified in place")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "Usage: alloy remove <package>")
-		os.Exit(1)
+		errorln("Usage: alloy remove <package>")
+		exit(1)
 	}
 
 	packageName := fs.Arg(0)
 
 	ledgerDir, err := ledger.DefaultDir()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		errorf("Error: %v\n", err)
+		exit(1)
 	}
 
 	if !ledger.Exists(ledgerDir, packageName) {
-		fmt.Fprintf(os.Stderr, "Package %q is not installed\n", packageName)
-		os.Exit(1)
+		errorf("Package %q is not installed\n", packageName)
+		exit(1)
 	}
 
 	fmt.Printf("Removing %s\n", packageName)
@@ -148,224 +869,1702 @@ func cmdRemove(args []string) {
 
 	ledg, err := ledger.Open(ledgerDir, packageName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening ledger: %v\n", err)
-		os.Exit(1)
+		errorf("Error opening ledger: %v\n", err)
+		exit(1)
+	}
+
+	warned := false
+	if !*dryRun && !*assumeYes && cli.IsTerminal(os.Stdin) {
+		// Preview the removal so modified files are known before asking
+		preview, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{DryRun: true, Force: true, SkipModified: *keepConfig})
+		if err != nil {
+			errorf("Error during removal: %v\n", err)
+			exit(1)
+		}
+		if len(preview.ModifiedFiles) > 0 {
+			printModifiedFiles(preview.ModifiedFiles, *force)
+			warned = true
+		}
+		if !confirm(fmt.Sprintf("Remove %s (%d entries)?", packageName, preview.Processed)) {
+			fmt.Println("Aborted")
+			exit(1)
+		}
 	}
 
+	// A verbose dry run collects a table of what would happen to each file
+	var plan []removalPlanRow
 	result, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{
-		DryRun:  *dryRun,
-		Force:   *force,
-		Verbose: *verbose,
+		DryRun:       *dryRun,
+		Force:        *force,
+		Verbose:      *verbose,
+		SkipModified: *keepConfig,
 		OnEntry: func(entry ledger.Entry, action string) {
-			if *verbose {
+			switch {
+			case *dryRun && *verbose:
+				plan = append(plan, removalPlanRow{entry: entry, action: action})
+			case *verbose:
 				fmt.Printf("  %s %s -> %s\n", entry.Op, entry.Path, action)
 			}
 		},
 	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error during removal: %v\n", err)
-		os.Exit(1)
+		errorf("Error during removal: %v\n", err)
+		exit(1)
+	}
+	if len(plan) > 0 {
+		printRemovalPlan(plan)
 	}
 
-	if len(result.ModifiedFiles) > 0 {
-		fmt.Println("\nWarning: The following files were modified externally:")
-		for _, f := range result.ModifiedFiles {
-			fmt.Printf("  %s\n", f)
-		}
-		if !*force {
-			fmt.Println("Use --force to remove anyway")
+	if !reportRemoval(result, *force, warned) {
+		exit(1)
+	}
+
+	if *purge {
+		purgeBackups(ledg, result, *dryRun)
+	}
+
+	finishRemoval(ledg, result, *dryRun)
+}
+
+// reportRemoval prints the warnings and errors from reversing a package's
+// ledger. It reports whether the removal succeeded; modified files that
+// were left in place don't count as a failure. warned skips the modified
+// files warning when it was already shown before confirming.
+func reportRemoval(result *ledger.ReplayResult, force, warned bool) bool {
+	if len(result.ModifiedFiles) > 0 && !warned {
+		printModifiedFiles(result.ModifiedFiles, force)
+	}
+
+	if len(result.NonEmptyDirs) > 0 {
+		fmt.Println("\n" + paintLine(stdout, "Warning: The following directories were left because they contain other files:"))
+		for _, d := range result.NonEmptyDirs {
+			fmt.Printf("  %s\n", d)
 		}
 	}
 
 	if result.HasErrors() {
-		fmt.Println("\nErrors occurred during removal:")
+		fmt.Println("\n" + paintLine(stdout, "Errors occurred during removal:"))
 		for _, e := range result.Errors {
 			fmt.Printf("  %s: %v\n", e.Entry.Path, e.Err)
 		}
-		os.Exit(1)
+		return false
 	}
+	return true
+}
 
-	// Delete the ledger file
-	if !*dryRun {
-		ledgerPath := ledger.Path(ledgerDir, packageName)
-		os.Remove(ledgerPath)
+// finishRemoval deletes the ledger of a successfully removed package and
+// reports the removal with the package's post-remove message.
+func finishRemoval(ledg *ledger.Ledger, result *ledger.ReplayResult, dryRun bool) {
+	name := ledg.Header.Package
+	if !dryRun {
+		ledg.Delete()
 	}
 
-	fmt.Printf("Successfully removed %s (%d files processed, %d skipped)\n",
-		packageName, result.Processed, result.Skipped)
+	printProgress(fmt.Sprintf("Successfully removed %s (%d files processed, %d skipped)",
+		name, result.Processed, result.Skipped))
+	if msg := ledg.Header.PostRemoveMessage; msg != "" && !dryRun {
+		printMessageBox("Notes for "+name, msg)
+	}
 }
 
-func cmdList(args []string) {
-	fs := flag.NewFlagSet("list", flag.ExitOnError)
-	verbose := fs.Bool("verbose", false, "Show detailed information")
+func cmdAutoremove(args []string) {
+	fs := flag.NewFlagSet("autoremove", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "List the packages that would be removed")
+	verbose := fs.Bool("verbose", false, "Show detailed output")
+	force := fs.Bool("force", false, "Force removal even if files were modified")
+	assumeYes := fs.Bool("assume-yes", false, "Don't ask for confirmation")
 	fs.Parse(args)
 
 	ledgerDir, err := ledger.DefaultDir()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		errorf("Error: %v\n", err)
+		exit(1)
 	}
 
-	packages, err := ledger.List(ledgerDir)
+	// Definitions from remotes are needed too, or the dependencies of a
+	// package installed from one would look unneeded
+	inst, err := installer.New()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	load := inst.LoadPackage
+	unneeded, err := ledger.Unneeded(ledgerDir, load)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	if len(unneeded) == 0 {
+		fmt.Println("No unneeded dependencies installed")
+		return
 	}
 
-	if len(packages) == 0 {
-		fmt.Println("No packages installed")
+	fmt.Println("These packages were installed as dependencies and are no longer needed:")
+	for _, name := range unneeded {
+		fmt.Printf("  %s\n", name)
+	}
+	if *dryRun {
+		fmt.Println("[dry-run] No changes will be made to the system")
 		return
 	}
+	if !*assumeYes && cli.IsTerminal(os.Stdin) && !confirm(fmt.Sprintf("Remove %d packages?", len(unneeded))) {
+		fmt.Println("Aborted")
+		exit(1)
+	}
 
-	fmt.Printf("Installed packages (%d):\n", len(packages))
-	for _, name := range packages {
-		if *verbose {
-			ledg, err := ledger.Open(ledgerDir, name)
-			if err != nil {
-				fmt.Printf("  %s (error reading ledger)\n", name)
-				continue
-			}
-			fileCount := len(ledg.FilterByOp(ledger.OpFileCreate)) + len(ledg.FilterByOp(ledger.OpFileOverwrite))
-			fmt.Printf("  %s\n", name)
-			fmt.Printf("    Installed: %s\n", ledg.Header.InstalledAt.Format("2006-01-02 15:04:05"))
-			fmt.Printf("    Source: %s\n", ledg.Header.Source)
-			fmt.Printf("    Files: %d\n", fileCount)
-		} else {
-			fmt.Printf("  %s\n", name)
+	// Remove one package at a time, dependents first. A package that can't
+	// be removed stays installed and keeps its dependencies, so the set is
+	// recomputed after each removal until nothing more can go.
+	var kept []string
+	attempted := make(map[string]bool)
+	for {
+		unneeded, err := ledger.Unneeded(ledgerDir, load, kept...)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		if len(unneeded) == 0 {
+			break
 		}
+		name := unneeded[0]
+		// A package still listed after its removal kept its ledger
+		if attempted[name] || !removeUnneeded(ledgerDir, name, *force, *verbose) {
+			kept = append(kept, name)
+		}
+		attempted[name] = true
+	}
+	if len(kept) > 0 {
+		errorf("\nError: could not remove %s\n", strings.Join(kept, ", "))
+		exit(1)
 	}
 }
 
-func cmdInfo(args []string) {
-	fs := flag.NewFlagSet("info", flag.ExitOnError)
+// removeUnneeded removes one package for autoremove, reporting whether it
+// was removed.
+func removeUnneeded(ledgerDir, name string, force, verbose bool) bool {
+	fmt.Printf("\nRemoving %s\n", name)
+	ledg, err := ledger.Open(ledgerDir, name)
+	if err != nil {
+		errorf("Error opening ledger: %v\n", err)
+		return false
+	}
+
+	result, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{
+		Force:   force,
+		Verbose: verbose,
+		OnEntry: func(entry ledger.Entry, action string) {
+			if verbose {
+				fmt.Printf("  %s %s -> %s\n", entry.Op, entry.Path, action)
+			}
+		},
+	})
+	if err != nil {
+		errorf("Error during removal: %v\n", err)
+		return false
+	}
+	if !reportRemoval(result, force, false) {
+		return false
+	}
+	finishRemoval(ledg, result, false)
+	return true
+}
+
+func cmdRepairBackups(args []string) {
+	fs := flag.NewFlagSet("repair-backups", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "List the backups that would be re-created")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "Usage: alloy info <package>")
-		os.Exit(1)
+		errorln("Usage: alloy repair-backups <package>")
+		exit(1)
+	}
+
+	packageName := fs.Arg(0)
+
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	backupDir, err := ledger.DefaultBackupDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	if !ledger.Exists(ledgerDir, packageName) {
+		errorf("Package %q is not installed\n", packageName)
+		exit(1)
+	}
+
+	ledg, err := ledger.Open(ledgerDir, packageName)
+	if err != nil {
+		errorf("Error opening ledger: %v\n", err)
+		exit(1)
+	}
+
+	result, err := ledger.RepairBackups(ledg, backupDir, *dryRun)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	for _, path := range result.Recreated {
+		if *dryRun {
+			fmt.Printf("[dry-run] Would re-create backup of %s\n", path)
+		} else {
+			fmt.Printf("Re-created backup of %s\n", path)
+		}
+	}
+	for _, path := range result.Unrecoverable {
+		fmt.Printf("%s Cannot recover the original of %s: it was removed or changed since installation\n", stdout.Mark(cli.StatusError), path)
+	}
+
+	switch {
+	case len(result.Recreated) == 0 && len(result.Unrecoverable) == 0:
+		fmt.Printf("No missing backups for %s\n", packageName)
+	case len(result.Unrecoverable) > 0:
+		exit(1)
+	}
+}
+
+func cmdRollback(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Run without making any changes")
+	verbose := fs.Bool("verbose", false, "Show detailed output")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		errorln("Usage: alloy rollback <package>")
+		exit(1)
+	}
+
+	packageName := fs.Arg(0)
+
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	if !ledger.Exists(ledgerDir, packageName) {
+		errorf("Package %q is not installed\n", packageName)
+		exit(1)
+	}
+
+	fmt.Printf("Restoring original files for %s\n", packageName)
+	if *dryRun {
+		fmt.Println("[dry-run] No changes will be made to the system")
+	}
+
+	ledg, err := ledger.Open(ledgerDir, packageName)
+	if err != nil {
+		errorf("Error opening ledger: %v\n", err)
+		exit(1)
+	}
+
+	result, err := ledger.RestoreOriginals(ledg, ledger.ReplayOptions{
+		DryRun:  *dryRun,
+		Verbose: *verbose,
+		OnEntry: func(entry ledger.Entry, action string) {
+			if *verbose {
+				fmt.Printf("  %s %s -> %s\n", entry.Op, entry.Path, action)
+			}
+		},
+	})
+	if err != nil {
+		errorf("Error during rollback: %v\n", err)
+		exit(1)
+	}
+
+	if result.HasErrors() {
+		fmt.Println("\nErrors occurred during rollback:")
+		for _, e := range result.Errors {
+			fmt.Printf("  %s: %v\n", e.Entry.Path, e.Err)
+		}
+		exit(1)
+	}
+
+	fmt.Printf("Restored %d file(s) for %s (%d skipped)\n",
+		result.Processed, packageName, result.Skipped)
+}
+
+func cmdRepair(args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Run without making any changes")
+	verbose := fs.Bool("verbose", false, "Show detailed output")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		errorln("Usage: alloy repair <package>")
+		exit(1)
+	}
+
+	packageName := fs.Arg(0)
+
+	inst, err := installer.New()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	inst.DryRun = *dryRun
+	inst.Verbose = *verbose
+	inst.OnProgress = printProgress
+
+	fmt.Printf("Repairing %s\n", packageName)
+	if *dryRun {
+		fmt.Println("[dry-run] No changes will be made to the system")
+	}
+
+	if err := inst.Resume(packageName); err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+}
+
+func cmdList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	verbose := fs.Bool("verbose", false, "Show detailed information")
+	sortBy := fs.String("sort", "name", "Sort by name, date, size, or source")
+	reverse := fs.Bool("reverse", false, "Reverse the sort order")
+	since := fs.String("since", "", "Only show packages installed within a duration (e.g. 7d, 12h)")
+	groupBySource := fs.Bool("group-by-source", false, "Group packages by source host")
+	groupByDate := fs.String("group-by-date", "", "Group packages by install day, week, or month")
+	installedBefore := fs.String("installed-before", "", "Only show packages installed before a date (YYYY-MM-DD or RFC3339)")
+	installedAfter := fs.String("installed-after", "", "Only show packages installed after a date (YYYY-MM-DD or RFC3339)")
+	tree := fs.Bool("tree", false, "Show installed packages as a dependency tree")
+	depth := fs.Int("depth", 0, "With --tree, limit the tree to this many levels")
+	jsonOut := fs.Bool("json", false, "Print the installed packages as JSON")
+	withFiles := fs.Bool("with-files", false, "With --json, include every file each package installed")
+	fs.Parse(args)
+
+	if *tree && (*groupBySource || *groupByDate != "") {
+		errorln("Error: --tree cannot be combined with grouping")
+		exit(1)
+	}
+	if *withFiles && !*jsonOut {
+		errorln("Error: --with-files requires --json")
+		exit(1)
+	}
+	if *jsonOut && (*tree || *groupBySource || *groupByDate != "") {
+		errorln("Error: --json cannot be combined with --tree or grouping")
+		exit(1)
+	}
+
+	var groupFn func(ledger.Header) string
+	switch {
+	case *groupBySource && *groupByDate != "":
+		errorln("Error: --group-by-source and --group-by-date are mutually exclusive")
+		exit(1)
+	case *groupBySource:
+		groupFn = ledger.SourceHost
+	case *groupByDate != "":
+		fn, err := ledger.InstallPeriod(*groupByDate)
+		if err != nil {
+			errorf("Error: invalid --group-by-date: %v\n", err)
+			exit(1)
+		}
+		groupFn = fn
+	}
+
+	var cutoff, before time.Time
+	if *since != "" {
+		age, err := parseAge(*since)
+		if err != nil {
+			errorf("Error: invalid --since: %v\n", err)
+			exit(1)
+		}
+		cutoff = time.Now().Add(-age)
+	}
+	if *installedAfter != "" {
+		after, err := cli.ParseDateArg(*installedAfter)
+		if err != nil {
+			errorf("Error: invalid --installed-after: %v\n", err)
+			exit(1)
+		}
+		if after.After(cutoff) {
+			cutoff = after
+		}
+	}
+	if *installedBefore != "" {
+		t, err := cli.ParseDateArg(*installedBefore)
+		if err != nil {
+			errorf("Error: invalid --installed-before: %v\n", err)
+			exit(1)
+		}
+		before = t
+	}
+
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	packages, err := ledger.ListSorted(ledgerDir, ledger.SortKey(*sortBy))
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	if *reverse {
+		slices.Reverse(packages)
+	}
+
+	if !cutoff.IsZero() || !before.IsZero() {
+		var recent []string
+		for _, name := range packages {
+			s, err := ledger.OpenStream(ledgerDir, name)
+			if err != nil {
+				continue
+			}
+			at := s.Header().InstalledAt
+			if (cutoff.IsZero() || at.After(cutoff)) && (before.IsZero() || at.Before(before)) {
+				recent = append(recent, name)
+			}
+			s.Close()
+		}
+		if len(recent) == 0 && !*jsonOut {
+			if *since != "" && *installedAfter == "" && *installedBefore == "" {
+				fmt.Printf("No packages installed in the last %s\n", *since)
+			} else {
+				fmt.Println("No packages installed in the given date range")
+			}
+			return
+		}
+		packages = recent
+	}
+
+	if *jsonOut {
+		printListJSON(ledgerDir, packages, *withFiles)
+		return
+	}
+
+	if len(packages) == 0 {
+		fmt.Println("No packages installed")
+		return
+	}
+
+	if groupFn != nil {
+		printGroupedList(ledgerDir, packages, groupFn, *verbose)
+		return
+	}
+
+	if *tree {
+		// Definitions from remotes are needed too, or packages installed
+		// from one would show no dependencies
+		inst, err := installer.New()
+		if err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		root, err := ledger.BuildDepTree(packages, ledgerDir, inst.LoadPackage)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		for _, node := range root.Children {
+			printDepTree(node, "", "", 1, *depth)
+		}
+		return
+	}
+
+	fmt.Printf("Installed packages (%d):\n", len(packages))
+	for _, name := range packages {
+		if *verbose {
+			ledg, err := ledger.Open(ledgerDir, name)
+			if err != nil {
+				fmt.Printf("  %s (error reading ledger)\n", name)
+				continue
+			}
+			fileCount := len(ledg.FilterByOp(ledger.OpFileCreate)) + len(ledg.FilterByOp(ledger.OpFileOverwrite))
+			fmt.Printf("  %s\n", name)
+			fmt.Printf("    Installed: %s\n", ledg.Header.InstalledAt.Format("2006-01-02 15:04:05"))
+			fmt.Printf("    Source: %s\n", ledg.Header.Source)
+			fmt.Printf("    Files: %d\n", fileCount)
+			fmt.Printf("    Size: %d bytes\n", ledg.TotalInstalledSize())
+			if ledg.Header.Pinned {
+				fmt.Printf("    Pinned: yes\n")
+			}
+		} else {
+			fmt.Printf("  %s%s\n", name, pinnedMark(ledgerDir, name))
+		}
+	}
+}
+
+// printListJSON prints a JSON array summarizing each package. Without
+// withFiles only ledger headers are read.
+func printListJSON(ledgerDir string, packages []string, withFiles bool) {
+	summaries := []ledger.PackageSummaryJSON{}
+	for _, name := range packages {
+		if withFiles {
+			ledg, err := ledger.Open(ledgerDir, name)
+			if err != nil {
+				errorf("Error: %s: %v\n", name, err)
+				exit(1)
+			}
+			summaries = append(summaries, ledger.PackageSummary(ledg))
+			continue
+		}
+		s, err := ledger.OpenStream(ledgerDir, name)
+		if err != nil {
+			errorf("Error: %s: %v\n", name, err)
+			exit(1)
+		}
+		summaries = append(summaries, ledger.HeaderSummary(s.Header()))
+		s.Close()
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(summaries); err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+}
+
+// printGroupedList prints packages under a heading per group, with groups in
+// sorted order. Verbose output adds each package's version and install date.
+func printGroupedList(ledgerDir string, packages []string, groupFn func(ledger.Header) string, verbose bool) {
+	groups, err := ledger.GroupBy(packages, ledgerDir, groupFn)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	fmt.Printf("Installed packages (%d):\n", len(packages))
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		fmt.Printf("\n%s (%d):\n", key, len(groups[key]))
+		for _, name := range groups[key] {
+			if !verbose {
+				fmt.Printf("  %s%s\n", name, pinnedMark(ledgerDir, name))
+				continue
+			}
+			s, err := ledger.OpenStream(ledgerDir, name)
+			if err != nil {
+				fmt.Printf("  %s (error reading ledger)\n", name)
+				continue
+			}
+			h := s.Header()
+			s.Close()
+			fmt.Printf("  %s %s (installed %s)", name, h.PackageVersion, h.InstalledAt.Format("2006-01-02 15:04:05"))
+			if h.Pinned {
+				fmt.Print(" " + stdout.Paint(cli.StatusInfo, "[pinned]"))
+			}
+			fmt.Println()
+		}
+	}
+}
+
+// pinnedMark returns " [pinned]" if the package is pinned, for list output.
+func pinnedMark(ledgerDir, name string) string {
+	s, err := ledger.OpenStream(ledgerDir, name)
+	if err != nil {
+		return ""
+	}
+	defer s.Close()
+	if s.Header().Pinned {
+		return " " + stdout.Paint(cli.StatusInfo, "[pinned]")
+	}
+	return ""
+}
+
+// parseAge parses a duration that may also use a "d" suffix for days.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func cmdInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	history := fs.Bool("history", false, "Show modification history for the package's files")
+	tree := fs.Bool("tree", false, "Show the dependency tree")
+	fromRemote := fs.Bool("remote", false, "Fetch the package definition from the remote indexes")
+	packagesDir := fs.String("packages-dir", "", "Directory containing package definitions")
+	showSize := fs.Bool("size", false, "Show the disk space used by the package")
+	jsonOut := fs.Bool("json", false, "With --size, print the sizes as JSON")
+	format := fs.String("format", "", "Print the package using a Go template, e.g. '{{.Version}}'")
+	fs.Parse(args)
+
+	if *packagesDir == "" {
+		*packagesDir = installer.DefaultPackagesDir()
+	}
+
+	if fs.NArg() < 1 {
+		errorln("Usage: alloy info <package>")
+		exit(1)
+	}
+	if *jsonOut && !*showSize {
+		errorln("Error: --json requires --size")
+		exit(1)
+	}
+	if *format != "" && (*jsonOut || *history || *tree) {
+		errorln("Error: --format cannot be combined with --json, --history, or --tree")
+		exit(1)
+	}
+
+	// Parse the template first, so a mistake is reported before any work
+	var tmpl *template.Template
+	if *format != "" {
+		var err error
+		tmpl, err = template.New("format").Funcs(template.FuncMap{"join": strings.Join}).Parse(*format)
+		if err != nil {
+			errorf("Error: invalid --format template: %v\n", err)
+			exit(1)
+		}
 	}
 
 	packageName := fs.Arg(0)
 
 	// First try to read the package definition
-	pkgPath := filepath.Join("packages", packageName+".toml")
-	pkgDef, defErr := pkg.ParseFile(pkgPath)
+	var pkgDef *pkg.Package
+	var defErr error
+	remoteName := ""
+	if *fromRemote {
+		pkgDef, remoteName, defErr = fetchRemotePackage(packageName)
+		if defErr != nil && !errors.Is(defErr, os.ErrNotExist) {
+			errorf("Error: %v\n", defErr)
+			exit(1)
+		}
+	} else {
+		pkgPath := filepath.Join(*packagesDir, packageName+".toml")
+		pkgDef, defErr = pkg.ParseFile(pkgPath)
+	}
+
+	// Then check if it's installed
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	var ledg *ledger.Ledger
+	if ledger.Exists(ledgerDir, packageName) {
+		ledg, err = ledger.Open(ledgerDir, packageName)
+		if err != nil {
+			errorf("Error reading ledger: %v\n", err)
+		}
+	}
+
+	if defErr != nil && ledg == nil {
+		errorf("Package %q not found\n", packageName)
+		exit(1)
+	}
+
+	var sizes *packageSizes
+	if *showSize && ledg != nil {
+		sizes, err = measurePackage(ledg)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+	}
+
+	if tmpl != nil {
+		printInfoTemplate(tmpl, newInfoData(packageName, pkgDef, ledg, sizes))
+		return
+	}
+
+	if *jsonOut {
+		if ledg == nil {
+			errorf("Package %q is not installed\n", packageName)
+			exit(1)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(sizes); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+
+	fmt.Printf("Package: %s\n", packageName)
+	if remoteName != "" {
+		fmt.Printf("From remote index: %s\n", remoteName)
+	} else if *fromRemote {
+		fmt.Println("Not found in any remote index")
+	}
+
+	if pkgDef != nil {
+		fmt.Printf("Version: %s\n", pkgDef.Version)
+		if pkgDef.Description != "" {
+			fmt.Printf("Description: %s\n", pkgDef.Description)
+		}
+		if pkgDef.Homepage != "" {
+			fmt.Printf("Homepage: %s\n", pkgDef.Homepage)
+		}
+		if pkgDef.License != "" {
+			fmt.Printf("License: %s\n", pkgDef.License)
+		}
+		fmt.Printf("Source: %s (%s)\n", pkgDef.Source.Location(), pkgDef.Source.SourceType())
+		if pkgDef.ChangelogURL != "" {
+			fmt.Printf("Changelog: %s\n", pkgDef.ChangelogURL)
+		}
+		if pkgDef.Notes != "" {
+			fmt.Printf("\nRelease notes for %s:\n", pkgDef.Version)
+			for _, line := range strings.Split(strings.TrimRight(pkgDef.Notes, "\n"), "\n") {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+		if msg := pkgDef.ExpandedPostInstallMessage(); msg != "" {
+			fmt.Println("\nNotes:")
+			for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+	}
+
+	if pkgDef != nil && *tree {
+		// Dependencies may come from remotes, like the package itself
+		inst, err := installer.New()
+		if err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		inst.PackagesDir = *packagesDir
+		fmt.Println("\nDependencies:")
+		root := pkg.ResolveTree(packageName, inst.LoadPackage)
+		root.Walk(func(node *pkg.DepNode, depth int) {
+			var notes []string
+			switch {
+			case node.Cycle:
+				notes = append(notes, "cycle")
+			case node.Err != nil:
+				notes = append(notes, "not found")
+			}
+			if ledger.Exists(ledgerDir, node.Name) {
+				notes = append(notes, "installed")
+			}
+
+			line := strings.Repeat("  ", depth+1) + node.Name
+			if node.Package != nil {
+				line += "@" + node.Package.Version
+			}
+			if len(notes) > 0 {
+				line += " (" + strings.Join(notes, ", ") + ")"
+			}
+			fmt.Println(line)
+		})
+	}
+
+	if ledg != nil {
+		fmt.Println("\nInstallation:")
+		fmt.Printf("  Status: installed\n")
+		if v := ledg.Header.PackageVersion; v != "" {
+			fmt.Printf("  Version: %s\n", v)
+			if remoteName != "" && pkg.CompareVersions(pkgDef.Version, v) > 0 {
+				fmt.Printf("  Update available: %s -> %s\n", v, pkgDef.Version)
+			}
+		}
+		fmt.Printf("  Installed at: %s\n", ledg.Header.InstalledAt.Format("2006-01-02 15:04:05"))
+		if ledg.Header.Pinned {
+			fmt.Printf("  Pinned: yes (run 'alloy unpin %s' to allow upgrades)\n", ledg.Header.Package)
+		}
+		if !ledg.Header.Explicit() {
+			fmt.Printf("  Installed as a dependency of: %s\n", strings.Join(ledg.Header.RequestedBy, ", "))
+		}
+		fmt.Printf("  Source: %s\n", ledg.Header.Source)
+		if ledg.Header.SourceRef != "" {
+			fmt.Printf("  Ref: %s (overrides the package definition)\n", ledg.Header.SourceRef)
+		}
+
+		fileCreates := ledg.FilterByOp(ledger.OpFileCreate)
+		fileOverwrites := ledg.FilterByOp(ledger.OpFileOverwrite)
+		dirCreates := ledg.FilterByOp(ledger.OpDirCreate)
+		symlinkCreates := ledg.FilterByOp(ledger.OpSymlinkCreate)
+
+		fmt.Printf("  Files created: %d\n", len(fileCreates))
+		fmt.Printf("  Files overwritten: %d\n", len(fileOverwrites))
+		if appends := ledg.FilterByOp(ledger.OpFileAppend); len(appends) > 0 {
+			fmt.Printf("  Files appended to: %d\n", len(appends))
+		}
+		fmt.Printf("  Directories created: %d\n", len(dirCreates))
+		fmt.Printf("  Symlinks created: %d\n", len(symlinkCreates))
+
+		if sizes != nil {
+			fmt.Println("\nDisk usage:")
+			fmt.Printf("  Files created: %d (%s)\n", sizes.FilesCreated, installer.FormatSize(sizes.CreatedBytes))
+			fmt.Printf("  Files overwritten: %d (%s, originally %s)\n", sizes.FilesOverwritten,
+				installer.FormatSize(sizes.OverwrittenBytes), installer.FormatSize(sizes.OriginalBytes))
+			fmt.Printf("  Total installed: %s\n", installer.FormatSize(sizes.InstalledBytes))
+			fmt.Printf("  Backups: %s\n", installer.FormatSize(sizes.BackupBytes))
+			if sizes.CacheBytes > 0 {
+				fmt.Printf("  Cached source: %s\n", installer.FormatSize(sizes.CacheBytes))
+			} else {
+				fmt.Println("  Cached source: none")
+			}
+		}
+
+		if *history {
+			fmt.Println("\nHistory:")
+			for _, h := range ledger.FileHistory(ledg, "") {
+				status := ""
+				if !h.CurrentExists {
+					status = " [missing]"
+				} else if h.Modified() {
+					status = " [modified]"
+				}
+				fmt.Printf("  %s  %-16s %s (installed: %d bytes, current: %d bytes)%s\n",
+					h.Timestamp.Format("2006-01-02 15:04:05"), h.Op, h.Path, h.Size, h.CurrentSize, status)
+			}
+		}
+	} else {
+		fmt.Println("\nStatus: not installed")
+	}
+}
+
+// infoData is what 'alloy info --format' templates are executed against.
+// Definition and Header are nil if the package has no definition or isn't
+// installed; the other fields are filled from whichever is available.
+type infoData struct {
+	Name        string
+	Version     string
+	Description string
+	Homepage    string
+	License     string
+	Source      string
+
+	Installed        bool
+	InstalledVersion string
+	InstalledAt      time.Time
+	Pinned           bool
+	Explicit         bool
+	RequestedBy      []string
+	UpdateAvailable  bool
+
+	// Sizes is set with --size for installed packages.
+	Sizes *packageSizes
+
+	Definition *pkg.Package
+	Header     *ledger.Header
+}
+
+// newInfoData combines a package's definition and ledger, either of which
+// may be nil, for a --format template.
+func newInfoData(name string, pkgDef *pkg.Package, ledg *ledger.Ledger, sizes *packageSizes) *infoData {
+	data := &infoData{Name: name, Definition: pkgDef, Sizes: sizes}
+	if pkgDef != nil {
+		data.Version = pkgDef.Version
+		data.Description = pkgDef.Description
+		data.Homepage = pkgDef.Homepage
+		data.License = pkgDef.License
+		data.Source = pkgDef.ExpandedSource().Location()
+	}
+	if ledg != nil {
+		h := ledg.Header
+		data.Header = &h
+		data.Installed = true
+		data.InstalledVersion = h.PackageVersion
+		data.InstalledAt = h.InstalledAt
+		data.Pinned = h.Pinned
+		data.Explicit = h.Explicit()
+		data.RequestedBy = h.RequestedBy
+		if pkgDef == nil {
+			data.Version = h.PackageVersion
+			data.Source = h.Source
+		} else {
+			data.UpdateAvailable = pkg.CompareVersions(pkgDef.Version, h.PackageVersion) > 0
+		}
+	}
+	return data
+}
+
+// printInfoTemplate executes tmpl over data, ending the output with a
+// newline if the template doesn't.
+func printInfoTemplate(tmpl *template.Template, data *infoData) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		errorf("Error: --format: %v\n", err)
+		exit(1)
+	}
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	os.Stdout.Write(buf.Bytes())
+}
+
+// fetchRemotePackage downloads a package definition from the first configured
+// remote whose index lists it, returning the definition and remote name.
+func fetchRemotePackage(name string) (*pkg.Package, string, error) {
+	configPath, err := config.DefaultPath()
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(cfg.Remotes) == 0 {
+		return nil, "", fmt.Errorf("no remotes configured (add one with 'alloy remote add')")
+	}
+
+	for _, r := range cfg.Remotes {
+		remote := &registry.Remote{Name: r.Name, URL: r.URL}
+		p, err := remote.FetchPackage(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("remote %s: %w", r.Name, err)
+		}
+		return p, r.Name, nil
+	}
+	return nil, "", fmt.Errorf("package %s: %w", name, os.ErrNotExist)
+}
+
+func cmdWhich(args []string) {
+	fs := flag.NewFlagSet("which", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		errorln("Usage: alloy which <path>")
+		exit(1)
+	}
+
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	files, err := ledger.AllFiles(ledgerDir)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	owner, ok := files[path]
+	if !ok {
+		errorf("%s is not owned by any installed package\n", path)
+		exit(1)
+	}
+
+	fmt.Printf("%s is owned by %s\n", path, owner)
+}
+
+func cmdLedger(args []string) {
+	if len(args) < 1 {
+		errorln("Usage: alloy ledger dump [--redact] <package>")
+		exit(1)
+	}
+
+	switch args[0] {
+	case "dump":
+		fs := flag.NewFlagSet("ledger dump", flag.ExitOnError)
+		redact := fs.Bool("redact", false, "Replace the home directory in paths with ~")
+		fs.Parse(args[1:])
+
+		if fs.NArg() != 1 {
+			errorln("Usage: alloy ledger dump [--redact] <package>")
+			exit(1)
+		}
+
+		ledgerDir, err := ledger.DefaultDir()
+		if err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		if !ledger.Exists(ledgerDir, fs.Arg(0)) {
+			errorf("Package %q is not installed\n", fs.Arg(0))
+			exit(1)
+		}
+
+		home := ""
+		if *redact {
+			if home, err = os.UserHomeDir(); err != nil {
+				errorf("Error: %v\n", err)
+				exit(1)
+			}
+		}
+		if err := ledger.ExportJSONRedacted(os.Stdout, ledgerDir, fs.Arg(0), home); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+
+	default:
+		errorf("Unknown ledger subcommand: %s\n", args[0])
+		exit(1)
+	}
+}
+
+func cmdPublish(args []string) {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	sign := fs.Bool("sign", false, "GPG-sign the package definition")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		errorln("Usage: alloy publish <package.toml>")
+		exit(1)
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	pkgDef, err := pkg.Parse(data)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	configPath, err := config.DefaultPath()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	if cfg.RegistryURL == "" {
+		errorf("Error: registry_url is not set in %s\n", configPath)
+		exit(1)
+	}
+
+	inst, err := installer.New()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	inst.OnProgress = printProgress
+
+	fmt.Printf("Verifying source for %s@%s\n", pkgDef.Name, pkgDef.Version)
+	if err := inst.VerifySource(pkgDef); err != nil {
+		errorf("Error: source verification failed: %v\n", err)
+		exit(1)
+	}
+
+	client := registry.NewClient(cfg.RegistryURL, os.Getenv(registry.TokenEnv))
+	if *sign {
+		client.Sign = registry.GPGSign
+	}
+
+	fmt.Printf("Publishing %s@%s to %s\n", pkgDef.Name, pkgDef.Version, cfg.RegistryURL)
+	result, err := client.Publish(pkgDef, data)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	fmt.Printf("Submission ID: %s\n", result.ID)
+	fmt.Printf("Status: %s\n", result.Status)
+}
+
+func cmdRemote(args []string) {
+	if len(args) < 1 {
+		errorln("Usage: alloy remote <add|remove|list|sync> [arguments]")
+		exit(1)
+	}
+
+	configPath, err := config.DefaultPath()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			errorln("Usage: alloy remote add <name> <url>")
+			exit(1)
+		}
+		if err := cfg.AddRemote(args[1], args[2]); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		if err := cfg.Save(configPath); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		fmt.Printf("Added remote %s (%s)\n", args[1], args[2])
+		fmt.Printf("Run 'alloy sync --remote %s' to fetch its packages\n", args[1])
+
+	case "remove":
+		if len(args) != 2 {
+			errorln("Usage: alloy remote remove <name>")
+			exit(1)
+		}
+		if err := cfg.RemoveRemote(args[1]); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		if err := cfg.Save(configPath); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		if remotesDir, err := config.DefaultRemotesDir(); err == nil {
+			os.RemoveAll(filepath.Join(remotesDir, args[1]))
+		}
+		fmt.Printf("Removed remote %s\n", args[1])
+
+	case "list":
+		if len(cfg.Remotes) == 0 {
+			fmt.Println("No remotes configured")
+			return
+		}
+		for _, r := range cfg.Remotes {
+			fmt.Printf("  %s\t%s\n", r.Name, r.URL)
+		}
+
+	case "sync":
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+		syncRemotes(cfg, name)
+
+	default:
+		errorf("Unknown remote subcommand: %s\n", args[0])
+		exit(1)
+	}
+}
+
+func cmdSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	remoteName := fs.String("remote", "", "Only sync the named remote")
+	fs.Parse(args)
+
+	configPath, err := config.DefaultPath()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	syncRemotes(cfg, *remoteName)
+}
+
+// syncRemotes refreshes the local index of every configured remote, or only
+// the named one, and reports which packages changed.
+func syncRemotes(cfg *config.Config, name string) {
+	remotesDir, err := config.DefaultRemotesDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	remotes := cfg.Remotes
+	if name != "" {
+		r := cfg.FindRemote(name)
+		if r == nil {
+			errorf("Error: remote %q not found\n", name)
+			exit(1)
+		}
+		remotes = []config.Remote{*r}
+	}
+	if len(remotes) == 0 {
+		fmt.Println("No remotes configured")
+		return
+	}
+
+	failed := false
+	for _, r := range remotes {
+		remote := &registry.Remote{Name: r.Name, URL: r.URL}
+		result, err := remote.Sync(filepath.Join(remotesDir, r.Name))
+		if err != nil {
+			fmt.Printf("%s %s: %v\n", stdout.Mark(cli.StatusError), r.Name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s %s: %d package(s)\n", stdout.Mark(cli.StatusOK), r.Name, len(result.Index.Packages))
+		for _, p := range result.Added {
+			fmt.Printf("    + %s\n", p)
+		}
+		for _, p := range result.Updated {
+			fmt.Printf("    ~ %s\n", p)
+		}
+		for _, p := range result.Removed {
+			fmt.Printf("    - %s\n", p)
+		}
+	}
+	if failed {
+		exit(1)
+	}
+}
+
+func cmdClean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	olderThan := fs.String("older-than", "", "Only delete downloads not used for this long (e.g. 30d)")
+	fs.Parse(args)
+
+	var age time.Duration
+	if *olderThan != "" {
+		var err error
+		age, err = parseAge(*olderThan)
+		if err != nil {
+			errorf("Error: invalid --older-than: %v\n", err)
+			exit(1)
+		}
+	}
+
+	inst, err := installer.New()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	freed, err := inst.CleanCache(age)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	fmt.Printf("Removed %d bytes of cached downloads\n", freed)
+}
+
+func cmdFmt(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := fs.Bool("check", false, "List unformatted files and exit 1 without changing them")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		errorln("Usage: alloy fmt [--check] <package.toml>...")
+		exit(1)
+	}
+
+	failed := false
+	unformatted := false
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			failed = true
+			continue
+		}
+		formatted, err := pkg.FormatSource(data)
+		if err != nil {
+			errorf("Error: %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		if string(formatted) == string(data) {
+			continue
+		}
+
+		if *check {
+			fmt.Println(path)
+			unformatted = true
+			continue
+		}
+		if err := os.WriteFile(path, formatted, 0644); err != nil {
+			errorf("Error: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Formatted %s\n", path)
+	}
+
+	if failed || unformatted {
+		exit(1)
+	}
+}
+
+// Exit statuses of 'alloy status --quiet'.
+const (
+	statusClean   = 0
+	statusUpdates = 1
+	statusIssues  = 2
+)
+
+func cmdStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	quiet := fs.Bool("quiet", false, "Print nothing; report the state in the exit status")
+	fs.Parse(args)
+
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	backupDir, err := ledger.DefaultBackupDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	packages, err := ledger.List(ledgerDir)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	// Only local definitions are compared, so status never touches the
+	// network; git sources without a version change and pinned packages
+	// aren't counted
+	packagesDir := installer.DefaultPackagesDir()
+	var updates []string
+	for _, name := range packages {
+		stream, err := ledger.OpenStream(ledgerDir, name)
+		if err != nil {
+			continue // Reported as an issue below
+		}
+		header := stream.Header()
+		stream.Close()
+		if header.Pinned {
+			continue // Upgrades skip pinned packages
+		}
+		installed := header.PackageVersion
+
+		pkgDef, err := pkg.ParseFile(filepath.Join(packagesDir, name+".toml"))
+		if err != nil || pkg.CompareVersions(pkgDef.Version, installed) <= 0 {
+			continue
+		}
+		updates = append(updates, fmt.Sprintf("%s %s -> %s", name, installed, pkgDef.Version))
+	}
+
+	results, err := ledger.CheckAllLedgers(ledgerDir, backupDir, ledger.DoctorOptions{})
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	var issues []string
+	for _, r := range results {
+		if r.HasIssues() {
+			issues = append(issues, r.Package)
+		}
+	}
+
+	if *quiet {
+		switch {
+		case len(issues) > 0:
+			exitStatus(statusIssues)
+		case len(updates) > 0:
+			exitStatus(statusUpdates)
+		}
+		exitStatus(statusClean)
+	}
+
+	fmt.Printf("%d package(s) installed\n", len(packages))
+	if len(updates) > 0 {
+		fmt.Printf("%d update(s) available (run 'alloy upgrade <package>'):\n", len(updates))
+		for _, u := range updates {
+			fmt.Printf("  %s\n", u)
+		}
+	}
+	if len(issues) > 0 {
+		fmt.Printf("%d package(s) with issues (run 'alloy doctor' for details):\n", len(issues))
+		for _, name := range issues {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+	if len(updates) == 0 && len(issues) == 0 {
+		fmt.Println("Everything is up to date, no issues found")
+	}
+
+	// BackupDirSize totals the regular files under a directory, so with an
+	// empty package name it measures the whole backup directory or cache
+	backups, err := ledger.BackupDirSize(backupDir, "")
+	if err != nil {
+		errorf("Error: measuring backups: %v\n", err)
+		exit(1)
+	}
+	var cache int64
+	if cacheDir, err := paths.CacheDir(); err == nil {
+		if cache, err = ledger.BackupDirSize(cacheDir, ""); err != nil {
+			errorf("Error: measuring cache: %v\n", err)
+			exit(1)
+		}
+	}
+	fmt.Printf("Backups: %s, download cache: %s\n", installer.FormatSize(backups), installer.FormatSize(cache))
+}
+
+func cmdOutdated(args []string) {
+	fs := flag.NewFlagSet("outdated", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "Output as JSON")
+	fs.Parse(args)
 
-	// Then check if it's installed
-	ledgerDir, err := ledger.DefaultDir()
+	inst, err := installer.New()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		errorf("Error: %v\n", err)
+		exit(1)
 	}
 
-	var ledg *ledger.Ledger
-	if ledger.Exists(ledgerDir, packageName) {
-		ledg, err = ledger.Open(ledgerDir, packageName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading ledger: %v\n", err)
+	outdated, unavailable, err := inst.Outdated()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+
+	if *jsonOutput {
+		// Empty lists rather than null
+		report := struct {
+			Outdated    []installer.OutdatedPackage `json:"outdated"`
+			Unavailable []string                    `json:"unavailable"`
+		}{append([]installer.OutdatedPackage{}, outdated...), append([]string{}, unavailable...)}
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
 		}
+		return
 	}
 
-	if defErr != nil && ledg == nil {
-		fmt.Fprintf(os.Stderr, "Package %q not found\n", packageName)
-		os.Exit(1)
+	if len(outdated) == 0 {
+		fmt.Println("All installed packages are up to date")
+	} else {
+		fmt.Printf("%d package(s) have a newer version (run 'alloy upgrade <package>'):\n", len(outdated))
+		for _, p := range outdated {
+			mark := ""
+			if p.Pinned {
+				mark = " [pinned]"
+			}
+			fmt.Printf("  %s %s -> %s%s\n", p.Name, p.Installed, p.Available, mark)
+		}
+	}
+	if len(unavailable) > 0 {
+		fmt.Printf("%d installed package(s) have no definition to compare against:\n", len(unavailable))
+		for _, name := range unavailable {
+			fmt.Printf("  %s\n", name)
+		}
 	}
+}
 
-	fmt.Printf("Package: %s\n", packageName)
+func cmdDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	verbose := fs.Bool("verbose", false, "Show detailed output")
+	checkFiles := fs.Bool("check-files", false, "Verify installed files exist and have correct checksums")
+	checkSymlinks := fs.Bool("check-symlinks", false, "Verify installed symlinks point to existing targets")
+	checkHardLinks := fs.Bool("check-hardlinks", false, "Verify installed hard links still share their target's inode")
+	fix := fs.Bool("fix", false, "Apply automatic repair suggestions")
+	format := fs.String("format", "text", "Output format: text or json")
+	pkgName := fs.String("package", "", "Only check the ledger of this package, skipping system checks")
+	checkNetwork := fs.Bool("check-network", false, "Check the hosts of remotes and package sources are reachable")
+	fixPermissions := fs.Bool("fix-permissions", false, "Restore installed files' permissions from their ledgers")
+	checkOwnership := fs.Bool("check-ownership", false, "Verify installed files still have the owner and group recorded in their ledgers")
+	dryRun := fs.Bool("dry-run", false, "With --fix-permissions, only report the files that would be changed")
+	migrate := fs.Bool("migrate", false, "Rewrite ledgers written in an older format before checking")
+	checkShadowing := fs.Bool("check-shadowing", false, "Warn about installed binaries hidden by others earlier in PATH")
+	fs.Parse(args)
 
-	if pkgDef != nil {
-		fmt.Printf("Version: %s\n", pkgDef.Version)
-		if pkgDef.Description != "" {
-			fmt.Printf("Description: %s\n", pkgDef.Description)
+	if *format != "text" && *format != "json" {
+		errorf("Error: unknown format %q (want text or json)\n", *format)
+		exit(1)
+	}
+	if *dryRun && !*fixPermissions {
+		errorln("Error: --dry-run requires --fix-permissions")
+		exit(1)
+	}
+	if *fix && *format == "json" {
+		errorln("Error: --fix cannot be used with --format json")
+		exit(1)
+	}
+	if *migrate && *format == "json" {
+		errorln("Error: --migrate cannot be used with --format json")
+		exit(1)
+	}
+
+	dataDir, err := paths.DataDir()
+	if err != nil {
+		errorf("%s Cannot determine data directory: %v\n", stderr.Glyph(cli.StatusError), err)
+		exit(1)
+	}
+
+	if *pkgName != "" {
+		ledgerDir, err := ledger.DefaultDir()
+		if err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
 		}
-		if pkgDef.Homepage != "" {
-			fmt.Printf("Homepage: %s\n", pkgDef.Homepage)
+		if !ledger.Exists(ledgerDir, *pkgName) {
+			errorf("Package %q is not installed\n", *pkgName)
+			exit(1)
 		}
-		if pkgDef.License != "" {
-			fmt.Printf("License: %s\n", pkgDef.License)
+	}
+
+	if *format == "text" {
+		if *pkgName != "" {
+			fmt.Printf("Checking %s...\n", *pkgName)
+		} else {
+			fmt.Println("Running system health check...")
 		}
-		fmt.Printf("Source: %s (%s)\n", pkgDef.Source.Location(), pkgDef.Source.SourceType())
+		fmt.Println()
 	}
 
-	if ledg != nil {
-		fmt.Println("\nInstallation:")
-		fmt.Printf("  Status: installed\n")
-		fmt.Printf("  Installed at: %s\n", ledg.Header.InstalledAt.Format("2006-01-02 15:04:05"))
-		fmt.Printf("  Source: %s\n", ledg.Header.Source)
+	if *migrate {
+		migrateLedgers(*pkgName)
+	}
 
-		fileCreates := ledg.FilterByOp(ledger.OpFileCreate)
-		fileOverwrites := ledg.FilterByOp(ledger.OpFileOverwrite)
-		dirCreates := ledg.FilterByOp(ledger.OpDirCreate)
-		symlinkCreates := ledg.FilterByOp(ledger.OpSymlinkCreate)
+	report := runDoctorChecks(dataDir, *pkgName, ledger.DoctorOptions{
+		Verbose:        *verbose,
+		CheckFiles:     *checkFiles,
+		CheckSymlinks:  *checkSymlinks,
+		CheckHardLinks: *checkHardLinks,
+		CheckNetwork:   *checkNetwork,
+		FixPermissions: *fixPermissions,
+		CheckOwnership: *checkOwnership,
+		CheckShadowing: *checkShadowing,
+		DryRun:         *dryRun,
+	})
 
-		fmt.Printf("  Files created: %d\n", len(fileCreates))
-		fmt.Printf("  Files overwritten: %d\n", len(fileOverwrites))
-		fmt.Printf("  Directories created: %d\n", len(dirCreates))
-		fmt.Printf("  Symlinks created: %d\n", len(symlinkCreates))
+	if *format == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+		if report.HasErrors() {
+			exit(1)
+		}
+		return
+	}
+
+	if *checkOwnership && !ledger.OwnershipSupported {
+		fmt.Println(stdout.Mark(cli.StatusInfo), "File ownership isn't available on this platform, skipping --check-ownership")
+		fmt.Println()
+	}
+	printDoctorReport(report, *verbose)
+
+	if *fix {
+		var fixes []ledger.RepairSuggestion
+		for _, r := range report.Packages {
+			for _, s := range r.Suggestions {
+				if s.Automatic {
+					fixes = append(fixes, s)
+				}
+			}
+		}
+		if len(fixes) > 0 {
+			fmt.Println("=== Repairs ===")
+			for _, s := range fixes {
+				fmt.Printf("Running: %s\n", s.Command)
+				if err := runSuggestion(s); err != nil {
+					fmt.Printf("%s %s: %v\n", stdout.Mark(cli.StatusError), s.Description, err)
+				} else {
+					fmt.Printf("%s %s\n", stdout.Mark(cli.StatusOK), s.Description)
+				}
+			}
+			fmt.Println()
+		}
+	}
+
+	// Summary
+	fmt.Println("=== Summary ===")
+	if *fixPermissions {
+		var wrong, fixed int
+		for _, r := range report.Packages {
+			wrong += len(r.WrongPermissions)
+			fixed += len(r.WrongPermissions) - r.UnfixedPermissions()
+		}
+		switch {
+		case wrong == 0:
+			fmt.Println("All installed files have their recorded permissions")
+		case *dryRun:
+			fmt.Printf("[dry-run] Would restore the permissions of %d file(s)\n", wrong)
+		default:
+			fmt.Printf("Restored the permissions of %d of %d file(s)\n", fixed, wrong)
+		}
+	}
+	if report.HasErrors() {
+		fmt.Printf("Found %d error(s)", report.Issues)
+		if report.HasWarnings() {
+			fmt.Printf(" and %d warning(s)", report.Warnings)
+		}
+		fmt.Println()
+		exit(1)
+	} else if report.HasWarnings() {
+		fmt.Printf("Found %d warning(s), no errors\n", report.Warnings)
 	} else {
-		fmt.Println("\nStatus: not installed")
+		fmt.Println("All checks passed!")
 	}
 }
 
-func cmdDoctor(args []string) {
-	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
-	verbose := fs.Bool("verbose", false, "Show detailed output")
-	checkFiles := fs.Bool("check-files", false, "Verify installed files exist and have correct checksums")
-	fs.Parse(args)
+// migrateLedgers rewrites the ledgers in an older format, or only that of
+// pkgName if it is set, so the checks that follow see them as current.
+func migrateLedgers(pkgName string) {
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(1)
+	}
+	packages := []string{pkgName}
+	if pkgName == "" {
+		if packages, err = ledger.List(ledgerDir); err != nil {
+			errorf("Error: %v\n", err)
+			exit(1)
+		}
+	}
 
-	fmt.Println("Running system health check...")
+	fmt.Println("=== Migration ===")
+	migrated := 0
+	for _, name := range packages {
+		ok, err := ledger.Migrate(ledgerDir, name)
+		switch {
+		case err != nil:
+			fmt.Printf("%s %s: %v\n", stdout.Mark(cli.StatusError), name, err)
+		case ok:
+			fmt.Printf("%s %s: migrated to ledger format version %d\n", stdout.Mark(cli.StatusOK), name, ledger.CurrentVersion)
+			migrated++
+		}
+	}
+	if migrated == 0 {
+		fmt.Println(stdout.Mark(cli.StatusOK), "All ledgers use the current format")
+	}
 	fmt.Println()
+}
 
-	issues := 0
-	warnings := 0
-
-	// Get alloy directory
-	home, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Printf("✗ Cannot determine home directory: %v\n", err)
-		os.Exit(1)
-	}
-	alloyDir := filepath.Join(home, ".alloy")
+// runDoctorChecks runs every health check and collects the results. If
+// pkgName is set, only that package's ledger and the files it shares with
+// other packages are checked.
+func runDoctorChecks(dataDir, pkgName string, opts ledger.DoctorOptions) *ledger.DoctorReport {
+	report := &ledger.DoctorReport{CheckedAt: time.Now()}
 
-	// Check alloy directory permissions
-	fmt.Println("=== Directory Permissions ===")
-	dirResults := ledger.CheckDirectoryPermissions(alloyDir)
-	for _, r := range dirResults {
-		switch r.Status {
-		case "ok":
-			fmt.Printf("✓ %s: %s\n", r.Name, r.Message)
+	add := func(section *[]ledger.DiagnosticResult, name, status, message string) {
+		*section = append(*section, ledger.DiagnosticResult{Name: name, Status: status, Message: message})
+		switch status {
 		case "warning":
-			fmt.Printf("⚠ %s: %s\n", r.Name, r.Message)
-			warnings++
+			report.Warnings++
 		case "error":
-			fmt.Printf("✗ %s: %s\n", r.Name, r.Message)
-			issues++
+			report.Issues++
 		}
 	}
-	fmt.Println()
 
-	// Check ledger directory
 	ledgerDir, err := ledger.DefaultDir()
 	if err != nil {
-		fmt.Printf("✗ Cannot determine ledger directory: %v\n", err)
-		issues++
+		add(&report.Directories, "Ledger directory", "error", err.Error())
 	}
-
 	backupDir, err := ledger.DefaultBackupDir()
 	if err != nil {
-		fmt.Printf("✗ Cannot determine backup directory: %v\n", err)
-		issues++
+		add(&report.Directories, "Backup directory", "error", err.Error())
+	}
+
+	if pkgName != "" {
+		if ledgerDir != "" {
+			checkPackageLedger(report, ledgerDir, backupDir, pkgName, opts)
+		}
+		return report
+	}
+
+	// Check data directory permissions
+	for _, r := range ledger.CheckDirectoryPermissions(dataDir) {
+		add(&report.Directories, r.Name, r.Status, r.Message)
 	}
 
 	// Check packages directory
-	fmt.Println("=== Package Definitions ===")
-	packagesDir := "packages"
+	packagesDir := installer.DefaultPackagesDir()
 	if _, err := os.Stat(packagesDir); os.IsNotExist(err) {
-		fmt.Printf("⚠ Packages directory not found: %s\n", packagesDir)
-		warnings++
+		add(&report.Directories, "Packages directory", "warning", "not found: "+packagesDir)
 	} else if err != nil {
-		fmt.Printf("✗ Cannot access packages directory: %v\n", err)
-		issues++
+		add(&report.Directories, "Packages directory", "error", err.Error())
 	} else {
 		entries, _ := os.ReadDir(packagesDir)
 		count := 0
@@ -374,157 +2573,434 @@ func cmdDoctor(args []string) {
 				count++
 			}
 		}
-		fmt.Printf("✓ Packages directory: %s (%d definitions)\n", packagesDir, count)
+		add(&report.Directories, "Packages directory", "ok", fmt.Sprintf("%s (%d definitions)", packagesDir, count))
+	}
+
+	// Check the operation log isn't growing unbounded
+	if path := os.Getenv(log.FileEnv); path != "" {
+		if info, err := os.Stat(path); err == nil && info.Size() > log.MaxSize {
+			add(&report.Directories, "Log file", "warning", fmt.Sprintf("%s is %d MB (consider rotating it)", path, info.Size()>>20))
+		} else if err == nil {
+			add(&report.Directories, "Log file", "ok", path)
+		}
+	}
+
+	// Check remote indexes are fresh
+	var remoteURLs []string
+	if configPath, err := config.DefaultPath(); err == nil {
+		if cfg, err := config.Load(configPath); err == nil {
+			remotesDir, _ := config.DefaultRemotesDir()
+			for _, r := range cfg.Remotes {
+				remoteURLs = append(remoteURLs, r.URL)
+				name := "Remote " + r.Name
+				age, err := registry.IndexAge(filepath.Join(remotesDir, r.Name))
+				switch {
+				case os.IsNotExist(err):
+					add(&report.Remotes, name, "warning", fmt.Sprintf("never synced (run 'alloy sync --remote %s')", r.Name))
+				case err != nil:
+					add(&report.Remotes, name, "error", fmt.Sprintf("cannot read index: %v", err))
+				case age > registry.StaleIndexAge:
+					add(&report.Remotes, name, "warning", fmt.Sprintf("index is %d days old (run 'alloy sync')", int(age.Hours()/24)))
+				default:
+					add(&report.Remotes, name, "ok", "index is up to date")
+				}
+			}
+		}
+	}
+
+	// Check the hosts of remotes and installed packages' sources answer
+	if opts.CheckNetwork {
+		urls := remoteURLs
+		if ledgerDir != "" {
+			sources, err := ledger.SourceURLs(ledgerDir)
+			if err != nil {
+				add(&report.Network, "Package sources", "error", err.Error())
+			}
+			urls = append(urls, sources...)
+		}
+		results := ledger.CheckNetworkReachability(urls)
+		if len(results) == 0 {
+			add(&report.Network, "Network", "ok", "no remote hosts to check")
+		}
+		for _, r := range results {
+			add(&report.Network, r.Name, r.Status, r.Message)
+		}
 	}
-	fmt.Println()
 
 	// Check write permissions to common install paths
-	fmt.Println("=== Install Paths ===")
-	testPaths := []string{"/usr/local/bin", "/usr/local/lib", "/usr/local/share"}
-	for _, path := range testPaths {
+	for _, path := range []string{"/usr/local/bin", "/usr/local/lib", "/usr/local/share"} {
 		if _, err := os.Stat(path); os.IsNotExist(err) {
-			fmt.Printf("⚠ Path does not exist: %s\n", path)
-			warnings++
+			add(&report.InstallPaths, "Path does not exist", "warning", path)
 		} else if err != nil {
-			fmt.Printf("✗ Cannot access path: %s: %v\n", path, err)
-			issues++
+			add(&report.InstallPaths, "Cannot access path", "error", fmt.Sprintf("%s: %v", path, err))
 		} else {
 			// Check if writable by attempting to create a temp file
 			testFile := filepath.Join(path, ".alloy-test-"+fmt.Sprint(os.Getpid()))
 			if f, err := os.Create(testFile); err != nil {
-				fmt.Printf("⚠ Path not writable: %s (may need sudo)\n", path)
-				warnings++
+				add(&report.InstallPaths, "Path not writable", "warning", path+" (may need sudo)")
 			} else {
 				f.Close()
 				os.Remove(testFile)
-				fmt.Printf("✓ Path writable: %s\n", path)
+				add(&report.InstallPaths, "Path writable", "ok", path)
 			}
 		}
 	}
-	fmt.Println()
 
 	// Check for required tools
-	fmt.Println("=== Required Tools ===")
-	requiredTools := []string{"git", "tar"}
-	for _, tool := range requiredTools {
+	for _, tool := range []string{"git", "xz"} {
 		if _, err := findExecutable(tool); err != nil {
-			fmt.Printf("✗ Required tool not found: %s\n", tool)
-			issues++
+			add(&report.Tools, "Required tool not found", "error", tool)
 		} else {
-			fmt.Printf("✓ Tool available: %s\n", tool)
+			add(&report.Tools, "Tool available", "ok", tool)
+		}
+	}
+
+	// Check the download cache isn't growing unbounded
+	if cacheDir, err := paths.CacheDir(); err != nil {
+		add(&report.Cache, "Download cache", "error", err.Error())
+	} else {
+		for _, r := range ledger.CheckCacheSize(cacheDir, ledger.StaleCacheAge) {
+			add(&report.Cache, r.Name, r.Status, r.Message)
+		}
+	}
+
+	if ledgerDir == "" {
+		return report
+	}
+
+	if opts.CheckShadowing {
+		for _, r := range ledger.CheckPathShadowing(ledgerDir) {
+			add(&report.Shadowing, r.Name, r.Status, r.Message)
 		}
 	}
-	fmt.Println()
 
 	// Check ledger integrity
-	fmt.Println("=== Ledger Integrity ===")
-	if ledgerDir != "" {
-		packages, _ := ledger.List(ledgerDir)
-		if len(packages) == 0 {
-			fmt.Println("✓ No packages installed (nothing to check)")
-		} else {
-			opts := ledger.DoctorOptions{
-				Verbose:    *verbose,
-				CheckFiles: *checkFiles,
+	results, err := ledger.CheckAllLedgers(ledgerDir, backupDir, opts)
+	if err != nil {
+		add(&report.Directories, "Ledgers", "error", err.Error())
+	}
+	report.Packages = results
+	for _, r := range results {
+		issues, warnings := countLedgerProblems(r)
+		report.Issues += issues
+		report.Warnings += warnings
+	}
+	for _, r := range ledger.CheckLedgerVersions(ledgerDir) {
+		add(&report.LedgerVersions, r.Name, r.Status, r.Message)
+	}
+
+	// Check for orphaned backups
+	if orphaned, err := ledger.FindOrphanedBackups(ledgerDir, backupDir); err == nil && len(orphaned) > 0 {
+		report.OrphanedBackups = orphaned
+		report.Warnings++
+	}
+
+	// Check for files claimed by multiple packages
+	duplicates, err := ledger.FindDuplicateOwnership(ledgerDir)
+	if err != nil {
+		add(&report.Directories, "File ownership", "error", err.Error())
+	} else if len(duplicates) > 0 {
+		report.Conflicts = duplicates
+		report.Warnings += len(duplicates)
+	}
+
+	return report
+}
+
+// checkPackageLedger adds the integrity check of a single package's ledger
+// to report, along with any files it claims that other packages claim too.
+func checkPackageLedger(report *ledger.DoctorReport, ledgerDir, backupDir, pkgName string, opts ledger.DoctorOptions) {
+	r := ledger.CheckLedgerIntegrity(ledgerDir, backupDir, pkgName, opts)
+	report.Packages = []*ledger.LedgerIntegrityResult{r}
+	issues, warnings := countLedgerProblems(r)
+	report.Issues += issues
+	report.Warnings += warnings
+
+	duplicates, err := ledger.FindDuplicateOwnership(ledgerDir)
+	if err != nil {
+		report.Directories = append(report.Directories, ledger.DiagnosticResult{Name: "File ownership", Status: "error", Message: err.Error()})
+		report.Issues++
+		return
+	}
+	for path, owners := range duplicates {
+		if slices.Contains(owners, pkgName) {
+			if report.Conflicts == nil {
+				report.Conflicts = make(map[string][]string)
 			}
+			report.Conflicts[path] = owners
+			report.Warnings++
+		}
+	}
+}
 
-			results, err := ledger.CheckAllLedgers(ledgerDir, backupDir, opts)
-			if err != nil {
-				fmt.Printf("✗ Error checking ledgers: %v\n", err)
-				issues++
-			} else {
-				for _, r := range results {
-					if r.ParseError != nil {
-						fmt.Printf("✗ %s: ledger parse error: %v\n", r.Package, r.ParseError)
-						issues++
-						continue
-					}
-
-					if !r.HasIssues() {
-						if *verbose {
-							fmt.Printf("✓ %s: OK (%d entries)\n", r.Package, r.EntryCount)
-						}
-						continue
-					}
-
-					// Report issues
-					if len(r.MissingBackups) > 0 {
-						fmt.Printf("✗ %s: %d missing backup file(s)\n", r.Package, len(r.MissingBackups))
-						issues++
-						if *verbose {
-							for _, b := range r.MissingBackups {
-								fmt.Printf("    - %s\n", b)
-							}
-						}
-					}
-
-					if len(r.OrphanedFiles) > 0 {
-						fmt.Printf("⚠ %s: %d installed file(s) not found\n", r.Package, len(r.OrphanedFiles))
-						warnings++
-						if *verbose {
-							for _, f := range r.OrphanedFiles {
-								fmt.Printf("    - %s\n", f)
-							}
-						}
-					}
-
-					if len(r.ModifiedFiles) > 0 {
-						fmt.Printf("⚠ %s: %d installed file(s) modified externally\n", r.Package, len(r.ModifiedFiles))
-						warnings++
-						if *verbose {
-							for _, f := range r.ModifiedFiles {
-								fmt.Printf("    - %s\n", f)
-							}
-						}
-					}
-				}
+// countLedgerProblems returns the number of errors and warnings a ledger
+// check contributes to the doctor summary.
+func countLedgerProblems(r *ledger.LedgerIntegrityResult) (issues, warnings int) {
+	if r.ParseError != nil {
+		return 1, 0
+	}
+	if r.Incomplete {
+		issues++
+	}
+	if len(r.MissingBackups) > 0 {
+		issues++
+	}
+	for _, list := range [][]string{r.UnbackedFiles, r.OrphanedFiles, r.ModifiedFiles, r.DanglingSymlinks, r.BrokenHardLinks} {
+		if len(list) > 0 {
+			warnings++
+		}
+	}
+	if r.UnfixedPermissions() > 0 {
+		warnings++
+	}
+	if len(r.WrongOwnership) > 0 {
+		warnings++
+	}
+	return issues, warnings
+}
 
-				if !*verbose && len(results) > 0 {
-					okCount := 0
-					for _, r := range results {
-						if !r.HasIssues() {
-							okCount++
-						}
-					}
-					if okCount > 0 {
-						fmt.Printf("✓ %d package(s) OK\n", okCount)
-					}
-				}
+// printDoctorReport prints a doctor report as human-readable sections.
+func printDoctorReport(report *ledger.DoctorReport, verbose bool) {
+	printSection := func(title string, results []ledger.DiagnosticResult) {
+		// Sections are empty when doctor is scoped to one package
+		if len(results) == 0 {
+			return
+		}
+		fmt.Printf("=== %s ===\n", title)
+		for _, r := range results {
+			switch r.Status {
+			case "ok":
+				fmt.Printf("%s %s: %s\n", stdout.Mark(cli.StatusOK), r.Name, r.Message)
+			case "warning":
+				fmt.Printf("%s %s: %s\n", stdout.Mark(cli.StatusWarning), r.Name, r.Message)
+			case "error":
+				fmt.Printf("%s %s: %s\n", stdout.Mark(cli.StatusError), r.Name, r.Message)
+			}
+		}
+		fmt.Println()
+	}
+	printList := func(items []string) {
+		if verbose {
+			for _, item := range items {
+				fmt.Printf("    - %s\n", item)
 			}
+		}
+	}
 
-			// Check for orphaned backups
-			orphanedBackups, err := ledger.FindOrphanedBackups(ledgerDir, backupDir)
-			if err != nil {
-				if *verbose {
-					fmt.Printf("⚠ Could not check for orphaned backups: %v\n", err)
-				}
-			} else if len(orphanedBackups) > 0 {
-				fmt.Printf("⚠ %d orphaned backup file(s) found\n", len(orphanedBackups))
-				warnings++
-				if *verbose {
-					for _, b := range orphanedBackups {
-						fmt.Printf("    - %s\n", b)
-					}
+	printSection("Directories", report.Directories)
+	printSection("Remotes", report.Remotes)
+	printSection("Network", report.Network)
+	printSection("Install Paths", report.InstallPaths)
+	printSection("Required Tools", report.Tools)
+	printSection("Cache", report.Cache)
+	printSection("PATH Shadowing", report.Shadowing)
+
+	fmt.Println("=== Ledger Integrity ===")
+	if len(report.Packages) == 0 {
+		fmt.Println(stdout.Mark(cli.StatusOK), "No packages installed (nothing to check)")
+	}
+	for _, r := range report.LedgerVersions {
+		if r.Status != "ok" || verbose {
+			fmt.Printf("%s %s: %s\n", stdout.Mark(r.Status), r.Name, r.Message)
+		}
+	}
+	okCount := 0
+	for _, r := range report.Packages {
+		if r.ParseError != nil {
+			fmt.Printf("%s %s: ledger parse error: %v\n", stdout.Mark(cli.StatusError), r.Package, r.ParseError)
+			continue
+		}
+		if r.Incomplete {
+			fmt.Printf("%s %s: installation was interrupted (run 'alloy repair %s' to finish or 'alloy remove --force %s' to clean up)\n", stdout.Mark(cli.StatusError), r.Package, r.Package, r.Package)
+		}
+		for _, m := range r.WrongPermissions {
+			if m.Fixed {
+				fmt.Printf("%s %s: restored permissions of %s (%04o -> %04o)\n", stdout.Mark(cli.StatusOK), r.Package, m.Path, ledger.UnixMode(m.Actual), ledger.UnixMode(m.Expected))
+			}
+		}
+		if !r.HasIssues() {
+			okCount++
+			if verbose {
+				fmt.Printf("%s %s: OK (%d entries)\n", stdout.Mark(cli.StatusOK), r.Package, r.EntryCount)
+			}
+			continue
+		}
+
+		if len(r.MissingBackups) > 0 {
+			fmt.Printf("%s %s: %d missing backup file(s)\n", stdout.Mark(cli.StatusError), r.Package, len(r.MissingBackups))
+			printList(r.MissingBackups)
+		}
+		if len(r.UnbackedFiles) > 0 {
+			fmt.Printf("%s %s: %d overwritten file(s) have no backup\n", stdout.Mark(cli.StatusWarning), r.Package, len(r.UnbackedFiles))
+			printList(r.UnbackedFiles)
+		}
+		if len(r.OrphanedFiles) > 0 {
+			fmt.Printf("%s %s: %d installed file(s) not found\n", stdout.Mark(cli.StatusWarning), r.Package, len(r.OrphanedFiles))
+			printList(r.OrphanedFiles)
+		}
+		if len(r.ModifiedFiles) > 0 {
+			fmt.Printf("%s %s: %d installed file(s) modified externally\n", stdout.Mark(cli.StatusWarning), r.Package, len(r.ModifiedFiles))
+			printList(r.ModifiedFiles)
+		}
+		if len(r.DanglingSymlinks) > 0 {
+			fmt.Printf("%s %s: %d symlink(s) point to missing targets\n", stdout.Mark(cli.StatusWarning), r.Package, len(r.DanglingSymlinks))
+			printList(r.DanglingSymlinks)
+		}
+		if len(r.BrokenHardLinks) > 0 {
+			fmt.Printf("%s %s: %d hard link(s) no longer match their targets\n", stdout.Mark(cli.StatusWarning), r.Package, len(r.BrokenHardLinks))
+			printList(r.BrokenHardLinks)
+		}
+		if len(r.WrongOwnership) > 0 {
+			fmt.Printf("%s %s: %d installed file(s) have a different owner\n", stdout.Mark(cli.StatusWarning), r.Package, len(r.WrongOwnership))
+			printList(r.WrongOwnership)
+		}
+		if n := r.UnfixedPermissions(); n > 0 {
+			fmt.Printf("%s %s: %d installed file(s) have changed permissions\n", stdout.Mark(cli.StatusWarning), r.Package, n)
+			for _, m := range r.WrongPermissions {
+				if verbose && !m.Fixed {
+					fmt.Printf("    - %s: %04o, expected %04o\n", m.Path, ledger.UnixMode(m.Actual), ledger.UnixMode(m.Expected))
 				}
 			}
 		}
+		if verbose {
+			for _, s := range r.Suggestions {
+				fmt.Printf("%s %s: %s\n      %s\n", stdout.Mark(cli.StatusInfo), r.Package, s.Description, s.Command)
+			}
+		}
+	}
+	if !verbose && okCount > 0 {
+		fmt.Printf("%s %d package(s) OK\n", stdout.Mark(cli.StatusOK), okCount)
+	}
+	if len(report.OrphanedBackups) > 0 {
+		fmt.Printf("%s %d orphaned backup file(s) found\n", stdout.Mark(cli.StatusWarning), len(report.OrphanedBackups))
+		printList(report.OrphanedBackups)
 	}
 	fmt.Println()
 
-	// Summary
-	fmt.Println("=== Summary ===")
-	if issues > 0 {
-		fmt.Printf("Found %d error(s)", issues)
-		if warnings > 0 {
-			fmt.Printf(" and %d warning(s)", warnings)
+	fmt.Println("=== Ownership Conflicts ===")
+	if len(report.Conflicts) == 0 {
+		fmt.Println(stdout.Mark(cli.StatusOK), "No files owned by multiple packages")
+	}
+	for _, path := range slices.Sorted(maps.Keys(report.Conflicts)) {
+		fmt.Printf("%s %s: owned by %s\n", stdout.Mark(cli.StatusWarning), path, strings.Join(report.Conflicts[path], ", "))
+	}
+	fmt.Println()
+}
+
+// runSuggestion runs a repair suggestion's alloy command using the current executable.
+func runSuggestion(s ledger.RepairSuggestion) error {
+	args := strings.Fields(s.Command)
+	if len(args) > 0 && args[0] == "alloy" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate alloy executable: %w", err)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// purgeBackups deletes the backup files a removed package's ledger refers
+// to, then its backup directory if that leaves it empty. The backups of
+// files left in place because they were modified since install are kept,
+// as nothing else holds the originals.
+func purgeBackups(ledg *ledger.Ledger, result *ledger.ReplayResult, dryRun bool) {
+	paths := ledger.CollectBackupPaths(ledg)
+	kept := make(map[string]bool)
+	for _, path := range ledger.ModifiedBackupPaths(ledg, result.ModifiedFiles) {
+		kept[path] = true
+	}
+
+	var count int
+	var freed int64
+	for _, path := range paths {
+		if kept[path] {
+			fmt.Printf("Keeping backup %s of a file modified since install\n", path)
+			continue
 		}
-		fmt.Println()
-		os.Exit(1)
-	} else if warnings > 0 {
-		fmt.Printf("Found %d warning(s), no errors\n", warnings)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("[dry-run] Would delete backup %s\n", path)
+		} else if err := os.Remove(path); err != nil {
+			fmt.Printf("Warning: could not delete backup %s: %v\n", path, err)
+			continue
+		}
+		count++
+		freed += info.Size()
+	}
+
+	if !dryRun {
+		if backupDir, err := ledger.DefaultBackupDir(); err == nil {
+			// Fails harmlessly if other files remain
+			os.Remove(filepath.Join(backupDir, ledg.Header.Package))
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("[dry-run] Would delete %d backup file(s), freeing %d bytes\n", count, freed)
 	} else {
-		fmt.Println("All checks passed!")
+		fmt.Printf("Deleted %d backup file(s), freed %d bytes\n", count, freed)
+	}
+}
+
+// removalPlanRow is what a dry-run removal would do with one ledger entry.
+type removalPlanRow struct {
+	entry  ledger.Entry
+	action string
+}
+
+// printRemovalPlan prints a dry-run removal as a table of operation, path
+// and status. Modified files also show their recorded and current checksums
+// so the user can judge whether --force is safe.
+func printRemovalPlan(rows []removalPlanRow) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tPATH\tSTATUS")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", row.entry.Op, row.entry.Path, row.action)
+		if row.action != ledger.ActionSkipModified || row.entry.Checksum == "" {
+			continue
+		}
+		current, err := ledger.Checksum(row.entry.Path)
+		if err != nil {
+			current = "unreadable: " + err.Error()
+		}
+		fmt.Fprintf(w, "\t  recorded: %s\t\n", row.entry.Checksum)
+		fmt.Fprintf(w, "\t  current:  %s\t\n", current)
+	}
+	w.Flush()
+}
+
+// printModifiedFiles warns about installed files changed since install.
+func printModifiedFiles(files []string, force bool) {
+	fmt.Println("\n" + paintLine(stdout, "Warning: The following files were modified externally:"))
+	for _, f := range files {
+		fmt.Printf("  %s\n", f)
+	}
+	if !force {
+		fmt.Println("Use --force to remove anyway")
+	}
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
 	}
+	return false
 }
 
 // findExecutable looks for an executable in PATH.
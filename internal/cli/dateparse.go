@@ -0,0 +1,27 @@
+// Package cli provides helpers for parsing command-line arguments and
+// formatting output.
+package cli
+
+import (
+	"fmt"
+	"time"
+)
+
+// dateFormats are the layouts ParseDateArg accepts, most specific first.
+var dateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseDateArg parses a date given on the command line as an RFC3339
+// timestamp or a plain YYYY-MM-DD date. Dates and timestamps without a zone
+// are interpreted in UTC, so a date means midnight UTC at its start.
+func ParseDateArg(s string) (time.Time, error) {
+	for _, layout := range dateFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date (want YYYY-MM-DD or RFC3339)", s)
+}
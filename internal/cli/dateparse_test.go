@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateArg(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2026-03-01T10:30:00Z", time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"2026-03-01T10:30:00+02:00", time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC)},
+		{"2026-03-01T10:30:00", time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseDateArg(tt.in)
+		if err != nil {
+			t.Errorf("ParseDateArg(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseDateArg(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "yesterday", "03/01/2026", "2026-13-01"} {
+		if _, err := ParseDateArg(bad); err == nil {
+			t.Errorf("ParseDateArg(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseDateArgRange(t *testing.T) {
+	after, err := ParseDateArg("2026-01-01")
+	if err != nil {
+		t.Fatalf("ParseDateArg: %v", err)
+	}
+	before, err := ParseDateArg("2026-02-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("ParseDateArg: %v", err)
+	}
+
+	inRange := func(ts time.Time) bool { return ts.After(after) && ts.Before(before) }
+	if !inRange(time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)) {
+		t.Error("mid-January should be in range")
+	}
+	if inRange(time.Date(2025, 12, 31, 23, 59, 0, 0, time.UTC)) {
+		t.Error("December should be before the range")
+	}
+	if inRange(time.Date(2026, 2, 1, 0, 0, 1, 0, time.UTC)) {
+		t.Error("February should be after the range")
+	}
+}
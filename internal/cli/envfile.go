@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envKeyPattern matches valid environment variable names.
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envUnescaper expands the escapes understood in double-quoted values. A
+// backslash before a line break joins the lines.
+var envUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\r`, "\r", `\t`, "\t", "\\\n", "")
+
+// ParseEnvFile reads environment variables from a dotenv file: one
+// KEY=VALUE per line, optionally prefixed with "export ", with blank lines
+// and lines starting with # ignored. Single-quoted values are taken
+// literally. Double-quoted values may span lines and understand \n, \t, \r,
+// \" and \\ escapes. Unquoted values are trimmed and end at a " #" comment.
+func ParseEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for n := 0; n < len(lines); n++ {
+		lineNo := n + 1
+		line := strings.TrimSpace(lines[n])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNo)
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		var rest string
+		switch {
+		case strings.HasPrefix(value, `"`):
+			quoted := value[1:]
+			end := closingQuote(quoted)
+			for end < 0 {
+				n++
+				if n == len(lines) {
+					return nil, fmt.Errorf("%s:%d: unterminated quoted value for %s", path, lineNo, key)
+				}
+				quoted += "\n" + lines[n]
+				end = closingQuote(quoted)
+			}
+			value, rest = envUnescaper.Replace(quoted[:end]), quoted[end+1:]
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: unterminated quoted value for %s", path, lineNo, key)
+			}
+			value, rest = value[1:end+1], value[end+2:]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			value = strings.TrimSpace(value)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("%s:%d: unexpected %q after the value of %s", path, n+1, rest, key)
+		}
+
+		env[key] = value
+	}
+	return env, nil
+}
+
+// closingQuote returns the index of the first unescaped double quote in s,
+// or -1 if there is none.
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	data := `# build settings
+CFLAGS=-O2 -pipe  # optimize
+export API_TOKEN=secret
+
+SINGLE='literal \n $HOME'
+DOUBLE="tab\there \"quoted\" back\\slash"
+MULTI="first
+second"
+JOINED="one \
+two"
+ESCAPED_NEWLINE="a\nb"
+EMPTY=
+HASH=a#b
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+
+	got, err := ParseEnvFile(path)
+	if err != nil {
+		t.Fatalf("ParseEnvFile: %v", err)
+	}
+	want := map[string]string{
+		"CFLAGS":          "-O2 -pipe",
+		"API_TOKEN":       "secret",
+		"SINGLE":          `literal \n $HOME`,
+		"DOUBLE":          "tab\there \"quoted\" back\\slash",
+		"MULTI":           "first\nsecond",
+		"JOINED":          "one two",
+		"ESCAPED_NEWLINE": "a\nb",
+		"EMPTY":           "",
+		"HASH":            "a#b",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("ParseEnvFile =\n%q\nwant\n%q", got, want)
+	}
+
+	for _, bad := range []string{
+		"NO_EQUALS\n",
+		"1BAD=x\n",
+		"KEY=\"unterminated\n",
+		"KEY='unterminated\n",
+		"KEY=\"value\" trailing\n",
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatalf("write env file: %v", err)
+		}
+		if _, err := ParseEnvFile(path); err == nil {
+			t.Errorf("ParseEnvFile(%q) should fail", bad)
+		}
+	}
+
+	if _, err := ParseEnvFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing file should be an error")
+	}
+}
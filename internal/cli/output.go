@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// NoColorEnv is the environment variable that disables colored output when
+// set to a non-empty value, following https://no-color.org.
+const NoColorEnv = "NO_COLOR"
+
+// Statuses Mark and Paint understand. They match the statuses of doctor
+// diagnostics.
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning"
+	StatusError   = "error"
+	StatusInfo    = "info"
+)
+
+// ANSI escape sequences for each status.
+var colors = map[string]string{
+	StatusOK:      "\033[32m",
+	StatusWarning: "\033[33m",
+	StatusError:   "\033[31m",
+	StatusInfo:    "\033[36m",
+}
+
+const colorReset = "\033[0m"
+
+// Status glyphs, with the ASCII used where the terminal can't show them.
+var (
+	glyphs      = map[string]string{StatusOK: "✓", StatusWarning: "⚠", StatusError: "✗", StatusInfo: "ℹ"}
+	asciiGlyphs = map[string]string{StatusOK: "+", StatusWarning: "!", StatusError: "x", StatusInfo: "i"}
+)
+
+// asciiText replaces the box-drawing characters of trees and message boxes.
+var asciiText = strings.NewReplacer("├── ", "|-- ", "└── ", "`-- ", "│", "|", "─", "-", "→", "->")
+
+// Output decorates text written to one stream: status glyphs, colors, and
+// box-drawing characters, each falling back to plain text where the stream
+// can't show them.
+type Output struct {
+	// Color if true, wraps painted text in ANSI color codes.
+	Color bool
+
+	// ASCII if true, uses ASCII in place of glyphs and box-drawing
+	// characters.
+	ASCII bool
+}
+
+// NewOutput returns the Output suited to f: colored if f is a terminal and
+// NO_COLOR is unset, and ASCII-only if the locale isn't UTF-8.
+func NewOutput(f *os.File) *Output {
+	return &Output{
+		Color: os.Getenv(NoColorEnv) == "" && os.Getenv("TERM") != "dumb" && IsTerminal(f),
+		ASCII: !supportsUTF8(),
+	}
+}
+
+// Glyph returns the uncolored glyph for status.
+func (o *Output) Glyph(status string) string {
+	if o.ASCII {
+		return asciiGlyphs[status]
+	}
+	return glyphs[status]
+}
+
+// Mark returns the glyph for status, colored to match.
+func (o *Output) Mark(status string) string {
+	return o.Paint(status, o.Glyph(status))
+}
+
+// Paint returns s in the color of status, or unchanged without color.
+func (o *Output) Paint(status, s string) string {
+	color, ok := colors[status]
+	if !o.Color || !ok || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// Text returns s with box-drawing characters replaced if the output is
+// ASCII-only.
+func (o *Output) Text(s string) string {
+	if o.ASCII {
+		return asciiText.Replace(s)
+	}
+	return s
+}
+
+// IsTerminal reports whether f is an interactive terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// supportsUTF8 reports whether the terminal is likely to show UTF-8, going
+// by the first locale variable set. Without one, Unix terminals are assumed
+// to, and Windows consoles only inside Windows Terminal.
+func supportsUTF8() bool {
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := os.Getenv(env); locale != "" {
+			locale = strings.ToLower(locale)
+			return strings.Contains(locale, "utf-8") || strings.Contains(locale, "utf8")
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return os.Getenv("WT_SESSION") != ""
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutput(t *testing.T) {
+	plain := &Output{}
+	if got := plain.Mark(StatusOK); got != "✓" {
+		t.Errorf("Mark(ok) = %q, want ✓", got)
+	}
+	if got := plain.Paint(StatusError, "failed"); got != "failed" {
+		t.Errorf("Paint without color = %q, want it unchanged", got)
+	}
+
+	color := &Output{Color: true}
+	if got := color.Mark(StatusWarning); got != "\033[33m⚠\033[0m" {
+		t.Errorf("Mark(warning) with color = %q", got)
+	}
+	if got := color.Paint("unknown", "text"); got != "text" {
+		t.Errorf("Paint of an unknown status = %q, want it unchanged", got)
+	}
+
+	ascii := &Output{ASCII: true}
+	for status, want := range map[string]string{StatusOK: "+", StatusWarning: "!", StatusError: "x", StatusInfo: "i"} {
+		if got := ascii.Mark(status); got != want {
+			t.Errorf("ASCII Mark(%s) = %q, want %q", status, got, want)
+		}
+	}
+	if got := ascii.Text("│   └── tool ──"); got != "|   `-- tool --" {
+		t.Errorf("ASCII Text = %q", got)
+	}
+	if got := plain.Text("└── tool"); got != "└── tool" {
+		t.Errorf("Text = %q, want it unchanged", got)
+	}
+}
+
+func TestNewOutput(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	t.Setenv(NoColorEnv, "")
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_CTYPE", "")
+	t.Setenv("LANG", "en_US.UTF-8")
+	if out := NewOutput(f); out.Color || out.ASCII {
+		t.Errorf("NewOutput(file) = %+v, want no color for a file and UTF-8 glyphs", out)
+	}
+
+	for locale, ascii := range map[string]bool{"C": true, "POSIX": true, "de_DE.utf8": false, "en_US.UTF-8": false} {
+		t.Setenv("LC_ALL", locale)
+		if out := NewOutput(f); out.ASCII != ascii {
+			t.Errorf("LC_ALL=%s: ASCII = %v, want %v", locale, out.ASCII, ascii)
+		}
+	}
+}
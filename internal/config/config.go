@@ -0,0 +1,121 @@
+// Package config loads and saves the user configuration in config.toml.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/anthropics/alloy/internal/paths"
+)
+
+// Config holds user-level alloy settings.
+type Config struct {
+	// RegistryURL is the package registry used by `alloy publish`.
+	RegistryURL string `toml:"registry_url,omitempty"`
+
+	// Remotes are additional package repositories, searched in order
+	// after the local packages directory.
+	Remotes []Remote `toml:"remotes,omitempty"`
+}
+
+// Remote is a named package repository.
+type Remote struct {
+	Name string `toml:"name"`
+	URL  string `toml:"url"`
+}
+
+// FindRemote returns the remote with the given name, or nil.
+func (c *Config) FindRemote(name string) *Remote {
+	for i := range c.Remotes {
+		if c.Remotes[i].Name == name {
+			return &c.Remotes[i]
+		}
+	}
+	return nil
+}
+
+// AddRemote adds a remote. Names must be unique.
+func (c *Config) AddRemote(name, url string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid remote name %q", name)
+	}
+	if url == "" {
+		return fmt.Errorf("remote URL is required")
+	}
+	if c.FindRemote(name) != nil {
+		return fmt.Errorf("remote %q already exists", name)
+	}
+	c.Remotes = append(c.Remotes, Remote{Name: name, URL: url})
+	return nil
+}
+
+// RemoveRemote removes the remote with the given name.
+func (c *Config) RemoveRemote(name string) error {
+	for i, r := range c.Remotes {
+		if r.Name == name {
+			c.Remotes = append(c.Remotes[:i], c.Remotes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("remote %q not found", name)
+}
+
+// DefaultRemotesDir returns the directory for synced remote indexes
+// (remotes under paths.DataDir).
+func DefaultRemotesDir() (string, error) {
+	dir, err := paths.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "remotes"), nil
+}
+
+// DefaultPath returns the default config file path (config.toml under
+// paths.ConfigDir).
+func DefaultPath() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.toml"), nil
+}
+
+// Load reads the config file at path. A missing file yields an empty config.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	if err := toml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Save writes the config to path, creating parent directories as needed.
+func (c *Config) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create config directory: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMissing(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "config.toml"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.RegistryURL != "" {
+		t.Errorf("RegistryURL = %q, want empty", cfg.RegistryURL)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "config.toml")
+
+	cfg := &Config{RegistryURL: "https://registry.example.com"}
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.RegistryURL != cfg.RegistryURL {
+		t.Errorf("RegistryURL = %q, want %q", loaded.RegistryURL, cfg.RegistryURL)
+	}
+}
+
+func TestRemotes(t *testing.T) {
+	cfg := &Config{}
+
+	if err := cfg.AddRemote("work", "https://pkgs.example.com"); err != nil {
+		t.Fatalf("AddRemote: %v", err)
+	}
+	if err := cfg.AddRemote("work", "https://other.example.com"); err == nil {
+		t.Error("expected error adding duplicate remote")
+	}
+	if err := cfg.AddRemote("../escape", "https://evil.example.com"); err == nil {
+		t.Error("expected error for remote name with path separator")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if r := loaded.FindRemote("work"); r == nil || r.URL != "https://pkgs.example.com" {
+		t.Errorf("FindRemote(work) = %+v", r)
+	}
+
+	if err := loaded.RemoveRemote("work"); err != nil {
+		t.Fatalf("RemoveRemote: %v", err)
+	}
+	if len(loaded.Remotes) != 0 {
+		t.Errorf("expected no remotes, got %v", loaded.Remotes)
+	}
+	if err := loaded.RemoveRemote("work"); err == nil {
+		t.Error("expected error removing unknown remote")
+	}
+}
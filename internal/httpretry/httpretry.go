@@ -0,0 +1,80 @@
+// Package httpretry provides HTTP GET with rate-limit-aware retries.
+package httpretry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MaxRetries is the number of times a rate-limited request is retried.
+const MaxRetries = 3
+
+// MaxWait caps how long a single Retry-After delay may be.
+const MaxWait = 60 * time.Second
+
+// defaultWait is used when the server doesn't send a usable Retry-After.
+const defaultWait = 2 * time.Second
+
+// sleep is replaced in tests.
+var sleep = time.Sleep
+
+// Get issues a GET request, retrying on 429 Too Many Requests and
+// 503 Service Unavailable after the delay given by the Retry-After header.
+// If client is nil, http.DefaultClient is used.
+func Get(client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Do(client, req)
+}
+
+// Do is like Get, but sends req so callers can set headers. req must not
+// have a body, since it may be sent more than once.
+func Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if attempt == MaxRetries || !retryable(resp.StatusCode) {
+			return resp, nil
+		}
+
+		wait := RetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		resp.Body.Close()
+		sleep(wait)
+	}
+}
+
+// RetryAfter parses a Retry-After header value, which is either a number of
+// seconds or an HTTP date, into a delay relative to now. The result is
+// clamped to MaxWait; missing or invalid values yield a short default.
+func RetryAfter(value string, now time.Time) time.Duration {
+	var wait time.Duration
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		wait = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		wait = t.Sub(now)
+	} else {
+		return defaultWait
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > MaxWait {
+		wait = MaxWait
+	}
+	return wait
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
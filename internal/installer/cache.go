@@ -0,0 +1,106 @@
+package installer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cachePath returns where a download with the given checksums is cached,
+// or "" if it cannot be cached. Archives are keyed by their sha256, so
+// sources that only declare a sha512 are always downloaded.
+func (i *Installer) cachePath(expected checksums) string {
+	if i.NoCache || i.CacheDir == "" || expected.sha256 == "" {
+		return ""
+	}
+	return filepath.Join(i.CacheDir, strings.ToLower(expected.sha256))
+}
+
+// cachedArchive returns the path of a cached download matching the expected
+// checksums. A cached file that no longer matches is discarded.
+func (i *Installer) cachedArchive(expected checksums) (string, bool) {
+	path := i.cachePath(expected)
+	if path == "" {
+		return "", false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	digest := newDigester(expected)
+	if _, err := io.Copy(digest, f); err != nil || digest.verify() != nil {
+		os.Remove(path)
+		return "", false
+	}
+
+	// Mark the entry as recently used so CleanCache with an age keeps it
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true
+}
+
+// storeCache moves a verified download into the cache and returns its new
+// path. If the download cannot be cached, its original path is returned.
+func (i *Installer) storeCache(tmpPath string, expected checksums) string {
+	path := i.cachePath(expected)
+	if path == "" {
+		return tmpPath
+	}
+
+	if err := os.MkdirAll(i.CacheDir, 0755); err != nil {
+		return tmpPath
+	}
+	if err := os.Rename(tmpPath, path); err == nil {
+		return path
+	}
+
+	// The temp directory may be on another filesystem; copy instead, via a
+	// partial file so an interrupted copy is never mistaken for a hit.
+	partial := path + ".partial"
+	if err := copyFile(tmpPath, partial, 0644); err != nil {
+		os.Remove(partial)
+		return tmpPath
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return tmpPath
+	}
+	return path
+}
+
+// CleanCache deletes cached downloads not used for longer than olderThan,
+// or all of them if olderThan is zero, and returns the number of bytes freed.
+func (i *Installer) CleanCache(olderThan time.Duration) (int64, error) {
+	entries, err := os.ReadDir(i.CacheDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read cache: %w", err)
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var freed int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if olderThan > 0 && info.ModTime().After(cutoff) {
+			continue
+		}
+		if !e.IsDir() {
+			freed += info.Size()
+		}
+		if err := os.RemoveAll(filepath.Join(i.CacheDir, e.Name())); err != nil {
+			return freed, fmt.Errorf("remove %s: %w", e.Name(), err)
+		}
+	}
+	return freed, nil
+}
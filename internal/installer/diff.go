@@ -0,0 +1,77 @@
+package installer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// showDiffs reports the file-level changes each copy step would make.
+func (i *Installer) showDiffs(steps []pkg.InstallStep, srcDir string) error {
+	for _, step := range steps {
+		if step.Type != pkg.StepCopy {
+			continue
+		}
+
+		src := filepath.Join(srcDir, step.Src)
+		diff, err := diffFiles(step.Dest, src)
+		if err != nil {
+			return fmt.Errorf("diff %s: %w", step.Dest, err)
+		}
+		i.progress("%s", diff)
+	}
+	return nil
+}
+
+// diffFiles describes how installing src over dest would change dest.
+// Text files are compared with a unified diff; binary files by size.
+func diffFiles(dest, src string) (string, error) {
+	newData, err := os.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("read source: %w", err)
+	}
+
+	oldData, err := os.ReadFile(dest)
+	if os.IsNotExist(err) {
+		return fmt.Sprintf("[dry-run] new file: %s (%d bytes)", dest, len(newData)), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read destination: %w", err)
+	}
+
+	if bytes.Equal(oldData, newData) {
+		return fmt.Sprintf("[dry-run] unchanged: %s", dest), nil
+	}
+
+	if isBinary(oldData) || isBinary(newData) {
+		return fmt.Sprintf("[dry-run] binary file changed: %s (%d -> %d bytes)",
+			dest, len(oldData), len(newData)), nil
+	}
+
+	out, err := exec.Command("diff", "-u",
+		"--label", dest, "--label", dest+" (new)", dest, src).Output()
+	// diff exits with status 1 when the files differ
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return fmt.Sprintf("[dry-run] file changed: %s (%d -> %d bytes)",
+			dest, len(oldData), len(newData)), nil
+	}
+
+	return fmt.Sprintf("[dry-run] changes to %s:\n%s", dest, strings.TrimRight(string(out), "\n")), nil
+}
+
+// isBinary guesses whether data is binary by looking for NUL bytes
+// near the start, as git and diff do.
+func isBinary(data []byte) bool {
+	const sniffLen = 8000
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
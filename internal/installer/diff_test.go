@@ -0,0 +1,62 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	src := write("src.conf", []byte("a = 1\nb = 2\n"))
+
+	// Destination does not exist yet
+	out, err := diffFiles(filepath.Join(dir, "missing.conf"), src)
+	if err != nil {
+		t.Fatalf("diffFiles: %v", err)
+	}
+	if !strings.Contains(out, "new file") {
+		t.Errorf("expected new file report, got %q", out)
+	}
+
+	// Identical content
+	same := write("same.conf", []byte("a = 1\nb = 2\n"))
+	out, err = diffFiles(same, src)
+	if err != nil {
+		t.Fatalf("diffFiles: %v", err)
+	}
+	if !strings.Contains(out, "unchanged") {
+		t.Errorf("expected unchanged report, got %q", out)
+	}
+
+	// Text change
+	old := write("old.conf", []byte("a = 1\nb = 3\n"))
+	out, err = diffFiles(old, src)
+	if err != nil {
+		t.Fatalf("diffFiles: %v", err)
+	}
+	if !strings.Contains(out, old) {
+		t.Errorf("expected report to mention %s, got %q", old, out)
+	}
+
+	// Binary change reports sizes
+	binSrc := write("bin.new", []byte{0, 1, 2, 3})
+	binOld := write("bin.old", []byte{0, 1})
+	out, err = diffFiles(binOld, binSrc)
+	if err != nil {
+		t.Fatalf("diffFiles: %v", err)
+	}
+	if !strings.Contains(out, "binary") || !strings.Contains(out, "2 -> 4 bytes") {
+		t.Errorf("expected binary size report, got %q", out)
+	}
+}
@@ -0,0 +1,109 @@
+package installer
+
+import "time"
+
+// EventKind identifies what an Event reports.
+type EventKind string
+
+const (
+	// EventFetchStart is sent when downloading or cloning a source begins.
+	EventFetchStart EventKind = "fetch_start"
+
+	// EventFetchProgress is sent when a download finishes and its checksum
+	// has been verified, with the number of bytes fetched.
+	EventFetchProgress EventKind = "fetch_progress"
+
+	// EventDownloadProgress is sent about every ProgressInterval while a
+	// source downloads, and once when it completes, with the bytes
+	// downloaded so far, the expected total if known, and the rate.
+	EventDownloadProgress EventKind = "download_progress"
+
+	// EventStepStart is sent before an install step runs.
+	EventStepStart EventKind = "step_start"
+
+	// EventStepDone is sent after an install step completes, or is skipped
+	// because a resumed install already completed it.
+	EventStepDone EventKind = "step_done"
+
+	// EventRecord is sent for every operation recorded in the ledger.
+	EventRecord EventKind = "record"
+
+	// EventDone is sent when a package has been installed.
+	EventDone EventKind = "done"
+
+	// EventError is sent when an installation fails.
+	EventError EventKind = "error"
+)
+
+// Event is a machine-readable progress update. Only the fields relevant to
+// its Kind are set.
+type Event struct {
+	Kind    EventKind `json:"kind"`
+	Time    time.Time `json:"time"`
+	Package string    `json:"package,omitempty"`
+
+	// URL is the source location, for fetch events.
+	URL string `json:"url,omitempty"`
+
+	// Bytes is the size of the downloaded source, for fetch_progress, or
+	// how much of it has been downloaded, for download_progress.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// Total is the expected size of the download, if the server sent it,
+	// and Rate the average transfer rate in bytes per second, for
+	// download_progress.
+	Total int64   `json:"total,omitempty"`
+	Rate  float64 `json:"rate,omitempty"`
+
+	// Step is the 1-based step number and Steps the total, for step events.
+	Step  int `json:"step,omitempty"`
+	Steps int `json:"steps,omitempty"`
+
+	// Description describes the step, for step events.
+	Description string `json:"description,omitempty"`
+
+	// Skipped is true for a step_done event of a step a resumed install
+	// had already completed.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// Op and Path describe the recorded operation, for record events.
+	Op   string `json:"op,omitempty"`
+	Path string `json:"path,omitempty"`
+
+	// Version is the installed version, for done events.
+	Version string `json:"version,omitempty"`
+
+	// Message is the package's post-install message with its template
+	// variables expanded, for done events.
+	Message string `json:"message,omitempty"`
+
+	// Error is the failure message, for error events.
+	Error string `json:"error,omitempty"`
+}
+
+// track sets the package reported in events while an install of name runs
+// and returns a function, to be deferred, that sends an error event if the
+// install failed and restores the previous package.
+func (i *Installer) track(name string, err *error) func() {
+	prev := i.current
+	i.current = name
+	return func() {
+		if *err != nil {
+			i.emit(Event{Kind: EventError, Error: (*err).Error()})
+		}
+		i.current = prev
+	}
+}
+
+// emit sends e to OnEvent, filling in its time and the package being
+// installed.
+func (i *Installer) emit(e Event) {
+	if i.OnEvent == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	if e.Package == "" {
+		e.Package = i.current
+	}
+	i.OnEvent(e)
+}
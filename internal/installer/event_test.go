@@ -0,0 +1,120 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/anthropics/alloy/internal/ledger"
+)
+
+func TestInstallEvents(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "tool"), []byte("binary"), 0755)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	writeDef := func(name, command string) {
+		def := fmt.Sprintf(`
+name = %q
+version = "1.0.0"
+post_install_message = "Run {{name}} from {{bindir}}"
+post_remove_message = "Goodbye from {{name}}"
+
+[source]
+git = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/%s"
+
+[[install_steps]]
+type = "run"
+command = %q
+`, name, repo, prefix, name, command)
+		if err := os.WriteFile(filepath.Join(packagesDir, name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+	writeDef("good", "true")
+	writeDef("bad", "false")
+
+	var events []Event
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		OnEvent:     func(e Event) { events = append(events, e) },
+	}
+
+	if err := inst.Install("good"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	var kinds []EventKind
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+		if e.Package != "good" {
+			t.Errorf("%s event has package %q, want good", e.Kind, e.Package)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("%s event has no time", e.Kind)
+		}
+	}
+	want := []EventKind{
+		EventFetchStart,
+		EventStepStart, EventRecord, EventStepDone,
+		EventStepStart, EventStepDone,
+		EventDone,
+	}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("events = %v, want %v", kinds, want)
+	}
+	if last := events[len(events)-1]; last.Version != "1.0.0" {
+		t.Errorf("done event version = %q", last.Version)
+	} else if want := "Run good from " + filepath.Join(prefix, "bin"); last.Message != want {
+		t.Errorf("done event message = %q, want %q", last.Message, want)
+	}
+
+	// The remove message is kept in the ledger for 'alloy remove'
+	ledg, err := ledger.Open(inst.LedgerDir, "good")
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	if ledg.Header.PostRemoveMessage != "Goodbye from good" {
+		t.Errorf("ledger post-remove message = %q", ledg.Header.PostRemoveMessage)
+	}
+
+	events = nil
+	if err := inst.Install("bad"); err == nil {
+		t.Fatal("expected the failing step to fail the install")
+	}
+	last := events[len(events)-1]
+	if last.Kind != EventError || last.Package != "bad" || last.Error == "" {
+		t.Errorf("last event = %+v, want an error for bad", last)
+	}
+}
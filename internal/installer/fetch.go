@@ -3,110 +3,308 @@ package installer
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
+	"maps"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/anthropics/alloy/internal/httpretry"
+	"github.com/anthropics/alloy/internal/ledger"
 	"github.com/anthropics/alloy/internal/pkg"
 )
 
 // fetchSource downloads and extracts the package source.
-// Returns the path to the extracted source directory.
-func (i *Installer) fetchSource(p *pkg.Package) (string, error) {
+// Returns the path to the extracted source directory and the source checksum
+// to record in the ledger header (the commit SHA for git sources).
+func (i *Installer) fetchSource(p *pkg.Package) (string, string, error) {
 	source := p.ExpandedSource()
+	i.emit(Event{Kind: EventFetchStart, URL: source.Location()})
 
 	// Create temp directory for extraction
 	srcDir, err := os.MkdirTemp("", "alloy-"+p.Name+"-")
 	if err != nil {
-		return "", fmt.Errorf("create temp directory: %w", err)
+		return "", "", fmt.Errorf("create temp directory: %w", err)
 	}
 
+	sourceChecksum := source.Checksum()
+
 	switch source.SourceType() {
-	case "url":
-		if err := i.fetchURL(source.URL, source.SHA256, source.Strip, srcDir); err != nil {
+	case "url", "binary":
+		expected, err := i.checksumsOf(source)
+		if err != nil {
 			os.RemoveAll(srcDir)
-			return "", err
+			return "", "", err
 		}
-	case "binary":
-		if err := i.fetchBinary(source.Binary, source.SHA256, p.Name, srcDir); err != nil {
+		if b, ok := i.bundled(p); ok {
+			err = i.fetchBundled(b, source, expected, p.Name, srcDir)
+		} else if source.SourceType() == "url" {
+			err = i.fetchURL(source.URL, source.FetchHeaders, expected, source.Strip, srcDir)
+		} else {
+			err = i.fetchBinary(source.Binary, source.FetchHeaders, expected, source.Compression(), p.Name, srcDir)
+		}
+		if err != nil {
 			os.RemoveAll(srcDir)
-			return "", err
+			return "", "", err
 		}
 	case "git":
 		if err := i.fetchGit(source.Git, source.Ref, srcDir); err != nil {
 			os.RemoveAll(srcDir)
-			return "", err
+			return "", "", err
+		}
+		if source.ArchiveSHA256 != "" {
+			if err := verifyGitArchive(srcDir, source.ArchiveSHA256); err != nil {
+				os.RemoveAll(srcDir)
+				return "", "", err
+			}
+		}
+		commit, err := gitHead(srcDir)
+		if err != nil {
+			os.RemoveAll(srcDir)
+			return "", "", err
+		}
+		sourceChecksum = commit
+	case "path":
+		if err := i.fetchPath(source.Path, srcDir); err != nil {
+			os.RemoveAll(srcDir)
+			return "", "", err
 		}
 	default:
 		os.RemoveAll(srcDir)
-		return "", fmt.Errorf("unknown source type: %s", source.SourceType())
+		return "", "", fmt.Errorf("unknown source type: %s", source.SourceType())
 	}
 
-	return srcDir, nil
+	return srcDir, sourceChecksum, nil
+}
+
+// VerifySource downloads a package's source to a temporary directory to
+// confirm it is reachable and matches its checksum, then discards it.
+func (i *Installer) VerifySource(p *pkg.Package) error {
+	srcDir, _, err := i.fetchSource(p)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(srcDir)
+}
+
+// UserAgent is sent with downloads unless a package overrides it. main sets
+// it to include the alloy version.
+var UserAgent = "alloy"
+
+// download issues a GET request for url with the given extra headers.
+func download(url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return httpretry.Do(nil, req)
 }
 
 // fetchURL downloads and extracts an archive.
-func (i *Installer) fetchURL(url, expectedChecksum string, strip int, destDir string) error {
+func (i *Installer) fetchURL(url string, headers map[string]string, expected checksums, strip int, destDir string) error {
+	archive, cleanup, err := i.downloadArchive(url, headers, expected)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return i.extractArchive(archive, url, strip, destDir)
+}
+
+// downloadArchive downloads url and verifies it against expected, using the
+// cache when it holds a match. It returns the path of the verified file and
+// a function that removes it unless it is a cache entry.
+func (i *Installer) downloadArchive(url string, headers map[string]string, expected checksums) (string, func(), error) {
+	keep := func() {}
+	if cached, ok := i.cachedArchive(expected); ok {
+		i.progress("Using cached %s", url)
+		return cached, keep, nil
+	}
+
 	i.progress("Downloading %s", url)
 
+	if path := i.cachePath(expected); path != "" && i.EnableResume {
+		size, err := i.downloadResumable(url, headers, expected, path)
+		if err != nil {
+			return "", nil, err
+		}
+		i.progress("Downloaded %d bytes, checksum verified", size)
+		i.emit(Event{Kind: EventFetchProgress, URL: url, Bytes: size})
+		return path, keep, nil
+	}
+
 	// Download to temp file
 	tmpFile, err := os.CreateTemp("", "alloy-download-*")
 	if err != nil {
-		return fmt.Errorf("create temp file: %w", err)
+		return "", nil, fmt.Errorf("create temp file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
-	defer os.Remove(tmpPath)
+	fail := func(err error) (string, func(), error) {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return "", nil, err
+	}
 
 	// Download
-	resp, err := http.Get(url)
+	resp, err := download(url, headers)
 	if err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("download: %w", err)
+		return fail(fmt.Errorf("download: %w", err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		tmpFile.Close()
-		return fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
+		return fail(fmt.Errorf("download failed: HTTP %d", resp.StatusCode))
 	}
 
 	// Hash while downloading
-	hasher := sha256.New()
-	writer := io.MultiWriter(tmpFile, hasher)
+	digest := newDigester(expected)
+	progress := i.downloadProgress(io.MultiWriter(tmpFile, digest), url, 0, resp)
 
-	size, err := io.Copy(writer, resp.Body)
+	size, err := io.Copy(progress, resp.Body)
 	if err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("download: %w", err)
+		return fail(fmt.Errorf("download: %w", err))
 	}
+	progress.Finish()
 	tmpFile.Close()
 
-	// Verify checksum
-	actualChecksum := hex.EncodeToString(hasher.Sum(nil))
-	if actualChecksum != expectedChecksum {
-		return fmt.Errorf("checksum mismatch: expected %s, got %s", expectedChecksum, actualChecksum)
+	// Verify checksums
+	if err := digest.verify(); err != nil {
+		return fail(err)
 	}
 
 	i.progress("Downloaded %d bytes, checksum verified", size)
+	i.emit(Event{Kind: EventFetchProgress, URL: url, Bytes: size})
 
-	// Extract archive
-	return i.extractArchive(tmpPath, url, strip, destDir)
+	path := i.storeCache(tmpPath, expected)
+	if path == tmpPath {
+		return path, func() { os.Remove(path) }, nil
+	}
+	// Copied into the cache from another filesystem
+	os.Remove(tmpPath)
+	return path, keep, nil
+}
+
+// downloadResumable downloads url to the cache entry at path, via
+// path+".partial". If an earlier attempt left a partial file, only the rest
+// is requested with a Range header; a server that answers with the whole
+// file instead restarts the download. The partial file is kept if the
+// download fails so the next attempt can resume, and removed if the
+// finished download doesn't match its checksums. Returns the total size.
+func (i *Installer) downloadResumable(url string, headers map[string]string, expected checksums, path string) (int64, error) {
+	if err := os.MkdirAll(i.CacheDir, 0755); err != nil {
+		return 0, fmt.Errorf("create cache directory: %w", err)
+	}
+	partial := path + ".partial"
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("create partial download: %w", err)
+	}
+	defer f.Close()
+
+	// Hash what was already downloaded, leaving the file positioned at its end
+	digest := newDigester(expected)
+	offset, err := io.Copy(digest, f)
+	if err != nil {
+		return 0, fmt.Errorf("read partial download: %w", err)
+	}
+
+	reqHeaders := headers
+	if offset > 0 {
+		reqHeaders = make(map[string]string, len(headers)+1)
+		maps.Copy(reqHeaders, headers)
+		reqHeaders["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+	resp, err := download(url, reqHeaders)
+	if err != nil {
+		return 0, fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	restart := func() (int64, error) {
+		resp.Body.Close()
+		f.Close()
+		os.Remove(partial)
+		return i.downloadResumable(url, headers, expected, path)
+	}
+
+	var n int64
+	resumed := fmt.Sprintf("bytes %d-", offset)
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Either the partial file is already complete, or it is left over
+		// from a different file
+		if digest.verify() != nil {
+			return restart()
+		}
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), resumed):
+		i.progress("Resuming download from byte %d", offset)
+		progress := i.downloadProgress(io.MultiWriter(f, digest), url, offset, resp)
+		if n, err = io.Copy(progress, resp.Body); err == nil {
+			progress.Finish()
+		}
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		// Not the range that was requested
+		return restart()
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			// The server ignored the Range header and sent the whole file
+			i.progress("Server does not support resuming, restarting download")
+			if err := f.Truncate(0); err != nil {
+				return 0, fmt.Errorf("restart download: %w", err)
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return 0, fmt.Errorf("restart download: %w", err)
+			}
+			digest = newDigester(expected)
+			offset = 0
+		}
+		progress := i.downloadProgress(io.MultiWriter(f, digest), url, 0, resp)
+		if n, err = io.Copy(progress, resp.Body); err == nil {
+			progress.Finish()
+		}
+	default:
+		return 0, fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("download: %w (%d bytes kept to resume)", err, offset+n)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("write partial download: %w", err)
+	}
+
+	if err := digest.verify(); err != nil {
+		os.Remove(partial)
+		return 0, err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return 0, fmt.Errorf("store download: %w", err)
+	}
+	return offset + n, nil
 }
 
 // fetchBinary downloads a standalone binary.
-func (i *Installer) fetchBinary(url, expectedChecksum, name, destDir string) error {
+func (i *Installer) fetchBinary(url string, headers map[string]string, expected checksums, compression, name, destDir string) error {
 	i.progress("Downloading binary %s", url)
 
-	resp, err := http.Get(url)
+	resp, err := download(url, headers)
 	if err != nil {
 		return fmt.Errorf("download: %w", err)
 	}
@@ -124,14 +322,15 @@ func (i *Installer) fetchBinary(url, expectedChecksum, name, destDir string) err
 	}
 
 	// Hash while downloading
-	hasher := sha256.New()
-	writer := io.MultiWriter(f, hasher)
+	digest := newDigester(expected)
+	progress := i.downloadProgress(io.MultiWriter(f, digest), url, 0, resp)
 
-	size, err := io.Copy(writer, resp.Body)
+	size, err := io.Copy(progress, resp.Body)
 	if err != nil {
 		f.Close()
 		return fmt.Errorf("download: %w", err)
 	}
+	progress.Finish()
 	f.Close()
 
 	// Make executable
@@ -139,17 +338,191 @@ func (i *Installer) fetchBinary(url, expectedChecksum, name, destDir string) err
 		return fmt.Errorf("chmod: %w", err)
 	}
 
-	// Verify checksum
-	actualChecksum := hex.EncodeToString(hasher.Sum(nil))
-	if actualChecksum != expectedChecksum {
-		return fmt.Errorf("checksum mismatch: expected %s, got %s", expectedChecksum, actualChecksum)
+	// Verify checksums
+	if err := digest.verify(); err != nil {
+		return err
 	}
 
 	i.progress("Downloaded %d bytes, checksum verified", size)
+	i.emit(Event{Kind: EventFetchProgress, URL: url, Bytes: size})
+
+	if compression != "" {
+		return i.decompressBinary(binPath, compression)
+	}
+	return nil
+}
+
+// decompressBinary replaces the compressed binary at path, whose checksum
+// has already been verified, with its decompressed contents.
+func (i *Installer) decompressBinary(path, compression string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".decompressed"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("create binary file: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	budget := i.newExtractBudget()
+	var size int64
+	switch compression {
+	case "xz":
+		err := decompressXz(in, budget, filepath.Base(path), func(r io.Reader) error {
+			var err error
+			size, err = io.Copy(out, r)
+			return err
+		})
+		if err != nil {
+			out.Close()
+			return err
+		}
+	case "gz", "bz2":
+		var r io.Reader
+		if compression == "gz" {
+			gzr, err := gzip.NewReader(in)
+			if err != nil {
+				out.Close()
+				return fmt.Errorf("gzip reader: %w", err)
+			}
+			defer gzr.Close()
+			r = gzr
+		} else {
+			r = bzip2.NewReader(in)
+		}
+		// Stop one byte past the limit rather than fill the disk
+		if budget != nil {
+			r = io.LimitReader(r, budget.limit+1)
+		}
+		if size, err = io.Copy(out, r); err != nil {
+			out.Close()
+			return fmt.Errorf("decompress %s: %w", compression, err)
+		}
+	default:
+		out.Close()
+		return fmt.Errorf("unsupported compression: %s", compression)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := budget.reserve(filepath.Base(path), size); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	// The umask may have narrowed the mode on create
+	if err := os.Chmod(path, 0755); err != nil {
+		return fmt.Errorf("chmod: %w", err)
+	}
+	i.progress("Decompressed %s binary (%s)", compression, FormatSize(size))
+	return nil
+}
+
+// checksums are the expected digests of a download. Empty fields are not
+// checked.
+type checksums struct {
+	sha256 string
+	sha512 string
+}
+
+// checksumsOf returns the checksums a source declares. A digest pasted
+// into the wrong field, such as a SHA-512 in sha256, is detected from its
+// length and used as what it is, with a warning. SHA-1 digests and digests
+// of no recognized length are rejected.
+func (i *Installer) checksumsOf(s pkg.Source) (checksums, error) {
+	var c checksums
+	for _, declared := range []struct{ field, value string }{
+		{"sha256", s.SHA256},
+		{"sha512", s.SHA512},
+	} {
+		if declared.value == "" {
+			continue
+		}
+		algorithm, err := ledger.ChecksumAlgorithm(declared.value)
+		if err != nil {
+			return c, fmt.Errorf("source %s: %w", declared.field, err)
+		}
+		if algorithm != declared.field {
+			i.progress("Warning: source %s field holds a %s checksum, verifying it as one", declared.field, algorithm)
+		}
+
+		var slot *string
+		switch algorithm {
+		case ledger.AlgorithmSHA256:
+			slot = &c.sha256
+		case ledger.AlgorithmSHA512:
+			slot = &c.sha512
+		default:
+			return c, fmt.Errorf("source %s: %s checksums are not accepted; use sha256 or sha512", declared.field, algorithm)
+		}
+		if *slot != "" && !strings.EqualFold(*slot, declared.value) {
+			return c, fmt.Errorf("source declares two different %s checksums", algorithm)
+		}
+		*slot = declared.value
+	}
+	return c, nil
+}
+
+// digester hashes written data with every algorithm that has an expected
+// checksum.
+type digester struct {
+	expected checksums
+	sha256   hash.Hash
+	sha512   hash.Hash
+	w        io.Writer
+}
+
+func newDigester(expected checksums) *digester {
+	d := &digester{expected: expected}
+	var writers []io.Writer
+	if expected.sha256 != "" {
+		d.sha256 = sha256.New()
+		writers = append(writers, d.sha256)
+	}
+	if expected.sha512 != "" {
+		d.sha512 = sha512.New()
+		writers = append(writers, d.sha512)
+	}
+	d.w = io.MultiWriter(writers...)
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	return d.w.Write(p)
+}
+
+// verify checks every computed digest against its expected value.
+func (d *digester) verify() error {
+	if d.sha256 == nil && d.sha512 == nil {
+		return errors.New("no checksum to verify against")
+	}
+	if d.sha256 != nil {
+		if actual := hex.EncodeToString(d.sha256.Sum(nil)); !strings.EqualFold(actual, d.expected.sha256) {
+			return fmt.Errorf("sha256 checksum mismatch: expected %s, got %s", d.expected.sha256, actual)
+		}
+	}
+	if d.sha512 != nil {
+		if actual := hex.EncodeToString(d.sha512.Sum(nil)); !strings.EqualFold(actual, d.expected.sha512) {
+			return fmt.Errorf("sha512 checksum mismatch: expected %s, got %s", d.expected.sha512, actual)
+		}
+	}
 	return nil
 }
 
-// fetchGit clones a git repository.
+// gitCloneAttempts is how many times a failed clone is tried in total.
+const gitCloneAttempts = 3
+
+// gitRetryDelay is the pause between clone attempts; replaced in tests.
+var gitRetryDelay = 2 * time.Second
+
+// fetchGit clones a git repository, retrying a failed clone in case the
+// failure was a transient network error.
 func (i *Installer) fetchGit(repoURL, ref, destDir string) error {
 	i.progress("Cloning %s", repoURL)
 
@@ -159,17 +532,161 @@ func (i *Installer) fetchGit(repoURL, ref, destDir string) error {
 	}
 	args = append(args, repoURL, destDir)
 
-	cmd := exec.Command("git", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	var err error
+	for attempt := 1; ; attempt++ {
+		cmd := exec.Command("git", args...)
+		cmd.Stdout = i.commandOutput()
+		cmd.Stderr = os.Stderr
+
+		if err = cmd.Run(); err == nil {
+			return nil
+		}
+		if attempt == gitCloneAttempts {
+			break
+		}
+
+		i.progress("git clone failed (%v), retrying (%d/%d)", err, attempt+1, gitCloneAttempts)
+		time.Sleep(gitRetryDelay)
+
+		// git refuses to clone into a non-empty directory, so clear
+		// anything a partial clone left behind.
+		if err := os.RemoveAll(destDir); err != nil {
+			return fmt.Errorf("clean up failed clone: %w", err)
+		}
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return fmt.Errorf("clean up failed clone: %w", err)
+		}
+	}
+	return fmt.Errorf("git clone: %w", err)
+}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git clone: %w", err)
+// gitArchiveChecksum returns the SHA-256 of `git archive --format=tar HEAD`
+// in a cloned repository. The archive depends only on the committed tree
+// and commit time, so it is the same for every clone of a commit.
+func gitArchiveChecksum(repoDir string) (string, error) {
+	cmd := exec.Command("git", "-C", repoDir, "archive", "--format=tar", "HEAD")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("git archive: %w", err)
 	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("git archive: %w", err)
+	}
+	sum, readErr := ledger.ChecksumReader(out)
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("git archive: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if readErr != nil {
+		return "", fmt.Errorf("git archive: %w", readErr)
+	}
+	return sum, nil
+}
 
+// verifyGitArchive checks a cloned repository against the archive_sha256
+// declared by its package.
+func verifyGitArchive(repoDir, expected string) error {
+	actual, err := gitArchiveChecksum(repoDir)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("git archive checksum mismatch: expected %s, got %s", expected, actual)
+	}
 	return nil
 }
 
+// fetchPath copies a local source directory into destDir. Copying rather
+// than linking keeps run steps that build in {{srcdir}} from writing into
+// the developer's tree.
+func (i *Installer) fetchPath(dir, destDir string) error {
+	i.progress("Copying %s", dir)
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("source path: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("source path %s is not a directory", dir)
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, rel)
+
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return copyFile(path, target, info.Mode()&ledger.ModeMask)
+		default:
+			// Sockets, devices and the like have no place in a source tree
+			return nil
+		}
+	})
+}
+
+// gitHead returns the commit SHA checked out in a cloned repository.
+func gitHead(repoDir string) (string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitRemoteHead returns the commit SHA that ref (or HEAD if empty) points to
+// in a remote repository, without cloning it.
+func gitRemoteHead(repoURL, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	out, err := exec.Command("git", "ls-remote", repoURL, ref).Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote: %w", err)
+	}
+
+	// Output is "<sha>\t<ref>" per line; annotated tags also list a
+	// peeled "<ref>^{}" line pointing at the tagged commit, which wins.
+	var sha string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if sha == "" || strings.HasSuffix(fields[1], "^{}") {
+			sha = fields[0]
+		}
+	}
+
+	if sha == "" {
+		return "", fmt.Errorf("ref %q not found in %s", ref, repoURL)
+	}
+	return sha, nil
+}
+
 // extractArchive extracts an archive to the destination directory.
 func (i *Installer) extractArchive(archivePath, url string, strip int, destDir string) error {
 	// Determine archive type from URL
@@ -208,17 +725,61 @@ func (i *Installer) extractTarGz(archivePath string, strip int, destDir string)
 	return i.extractTarReader(tar.NewReader(gzr), strip, destDir)
 }
 
-// extractTarXz extracts a .tar.xz archive using external xz command.
+// extractTarXz extracts a .tar.xz archive, decompressing it with the
+// external xz command.
 func (i *Installer) extractTarXz(archivePath string, strip int, destDir string) error {
-	// Use external tar command for xz support
-	args := []string{"-xJf", archivePath, "-C", destDir}
-	if strip > 0 {
-		args = append(args, fmt.Sprintf("--strip-components=%d", strip))
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return decompressXz(f, i.newExtractBudget(), filepath.Base(archivePath), func(r io.Reader) error {
+		return i.extractTarReader(tar.NewReader(r), strip, destDir)
+	})
+}
+
+// decompressXz passes the output of the external xz command decompressing
+// in to consume. With a budget, at most one byte past its limit is read
+// rather than fill the disk, and more output than that is an error named
+// for name.
+func decompressXz(in io.Reader, budget *extractBudget, name string, consume func(io.Reader) error) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("xz", "-dc")
+	cmd.Stdin, cmd.Stderr = in, &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("xz: %w", err)
 	}
 
-	cmd := exec.Command("tar", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tar xz: %w: %s", err, output)
+	var r io.Reader = stdout
+	var limited *io.LimitedReader
+	if budget != nil {
+		limited = &io.LimitedReader{R: stdout, N: budget.limit + 1}
+		r = limited
+	}
+	err = consume(r)
+	if err == nil {
+		// Output consume leaves unread, such as the padding after a tar
+		// archive, still counts towards the limit
+		_, err = io.Copy(io.Discard, r)
+	}
+	if err == nil && limited != nil && limited.N == 0 {
+		err = budget.reserve(name, budget.limit+1)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return fmt.Errorf("xz: %s: %w", msg, err)
+		}
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("xz: %w: %s", err, stderr.Bytes())
 	}
 	return nil
 }
@@ -248,6 +809,7 @@ func (i *Installer) extractTar(archivePath string, strip int, destDir string) er
 
 // extractTarReader extracts from a tar.Reader.
 func (i *Installer) extractTarReader(tr *tar.Reader, strip int, destDir string) error {
+	budget := i.newExtractBudget()
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -287,7 +849,10 @@ func (i *Installer) extractTarReader(tr *tar.Reader, strip int, destDir string)
 			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
 				return fmt.Errorf("mkdir parent: %w", err)
 			}
-			if err := extractFile(tr, target, os.FileMode(header.Mode)); err != nil {
+			if err := budget.reserve(name, header.Size); err != nil {
+				return err
+			}
+			if err := extractFile(tr, target, ledger.FileMode(uint32(header.Mode)), header.Size, budget); err != nil {
 				return fmt.Errorf("extract %s: %w", target, err)
 			}
 		case tar.TypeSymlink:
@@ -327,6 +892,7 @@ func (i *Installer) extractZip(archivePath string, strip int, destDir string) er
 	}
 	defer r.Close()
 
+	budget := i.newExtractBudget()
 	for _, f := range r.File {
 		name := f.Name
 		if strip > 0 {
@@ -359,12 +925,20 @@ func (i *Installer) extractZip(archivePath string, strip int, destDir string) er
 			return fmt.Errorf("mkdir parent: %w", err)
 		}
 
+		size := int64(f.UncompressedSize64)
+		if f.UncompressedSize64 > math.MaxInt64 {
+			size = math.MaxInt64
+		}
+		if err := budget.reserve(name, size); err != nil {
+			return err
+		}
+
 		rc, err := f.Open()
 		if err != nil {
 			return fmt.Errorf("open zip entry: %w", err)
 		}
 
-		if err := extractFileFromReader(rc, target, f.Mode()); err != nil {
+		if err := extractFileFromReader(rc, target, f.Mode(), size, budget); err != nil {
 			rc.Close()
 			return fmt.Errorf("extract %s: %w", target, err)
 		}
@@ -375,12 +949,14 @@ func (i *Installer) extractZip(archivePath string, strip int, destDir string) er
 }
 
 // extractFile extracts a file from a reader to the target path.
-func extractFile(r io.Reader, target string, mode os.FileMode) error {
-	return extractFileFromReader(r, target, mode)
+func extractFile(r io.Reader, target string, mode os.FileMode, size int64, budget *extractBudget) error {
+	return extractFileFromReader(r, target, mode, size, budget)
 }
 
-// extractFileFromReader extracts a file from a reader.
-func extractFileFromReader(r io.Reader, target string, mode os.FileMode) error {
+// extractFileFromReader extracts a file of the size declared in its archive
+// from a reader, failing if it turns out to be larger, and deducts what was
+// written from budget.
+func extractFileFromReader(r io.Reader, target string, mode os.FileMode, size int64, budget *extractBudget) error {
 	// Ensure mode is valid
 	if mode == 0 {
 		mode = 0644
@@ -391,12 +967,66 @@ func extractFileFromReader(r io.Reader, target string, mode os.FileMode) error {
 		return err
 	}
 
-	if _, err := io.Copy(f, r); err != nil {
+	// Read one byte past the declared size to detect a mismatch
+	limit := size
+	if limit < math.MaxInt64 {
+		limit++
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit))
+	if budget != nil {
+		budget.remaining -= n
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if n > size {
 		f.Close()
+		return fmt.Errorf("larger than its declared size of %d bytes", size)
+	}
+	if err := f.Close(); err != nil {
 		return err
 	}
 
-	return f.Close()
+	// The umask may strip setuid, setgid and sticky bits on create, and
+	// writing to an existing file clears them
+	if mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+		return os.Chmod(target, mode)
+	}
+	return nil
+}
+
+// DefaultMaxExtractBytes is the extraction limit used when
+// Installer.MaxExtractBytes is zero.
+const DefaultMaxExtractBytes int64 = 16 << 30
+
+// extractBudget tracks how many more bytes an archive may extract, to stop
+// a decompression bomb from filling the disk.
+type extractBudget struct {
+	limit     int64
+	remaining int64
+}
+
+// newExtractBudget returns the budget for extracting one archive, or nil if
+// extraction is unlimited.
+func (i *Installer) newExtractBudget() *extractBudget {
+	limit := i.MaxExtractBytes
+	if limit == 0 {
+		limit = DefaultMaxExtractBytes
+	}
+	if limit < 0 {
+		return nil
+	}
+	return &extractBudget{limit: limit, remaining: limit}
+}
+
+// reserve checks that a file of the given size still fits in the budget.
+// A nil budget always has room.
+func (b *extractBudget) reserve(name string, size int64) error {
+	if b == nil || size <= b.remaining {
+		return nil
+	}
+	return fmt.Errorf("extracting %s would exceed the limit of %s (possible decompression bomb)", name, FormatSize(b.limit))
 }
 
 // verifyChecksum verifies a file's SHA256 checksum.
@@ -2,10 +2,21 @@ package installer
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
 )
 
 func TestExtractTarGz(t *testing.T) {
@@ -110,6 +121,42 @@ func TestExtractTarGz(t *testing.T) {
 	}
 }
 
+func TestExtractTarGzSetuid(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "test.tar.gz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("create archive file: %v", err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	content := []byte("#!/bin/sh\n")
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "helper",
+		Mode:     04755,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatalf("write file header: %v", err)
+	}
+	tw.Write(content)
+	tw.Close()
+	gw.Close()
+	f.Close()
+
+	destDir := t.TempDir()
+	if err := (&Installer{}).extractTarGz(archivePath, 0, destDir); err != nil {
+		t.Fatalf("extractTarGz: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(destDir, "helper"))
+	if err != nil {
+		t.Fatalf("stat extracted file: %v", err)
+	}
+	if got := info.Mode() & ledger.ModeMask; got != 0755|os.ModeSetuid {
+		t.Errorf("mode = %v, want %v", got, 0755|os.ModeSetuid)
+	}
+}
+
 func TestExtractTarGzNoStrip(t *testing.T) {
 	// Create a temp tar.gz file
 	archiveDir := t.TempDir()
@@ -276,3 +323,588 @@ func TestPathTraversalPrevention(t *testing.T) {
 		t.Error("expected error for path traversal, got nil")
 	}
 }
+
+func TestExtractSizeLimit(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "bomb.tar.gz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("create archive file: %v", err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"a.bin", "b.bin"} {
+		content := make([]byte, 600)
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatalf("write file header: %v", err)
+		}
+		tw.Write(content)
+	}
+	tw.Close()
+	gw.Close()
+	f.Close()
+
+	inst := &Installer{MaxExtractBytes: 1000}
+	err = inst.extractTarGz(archivePath, 0, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "decompression bomb") {
+		t.Errorf("expected the extraction limit to be exceeded, got %v", err)
+	}
+
+	inst.MaxExtractBytes = 1200
+	if err := inst.extractTarGz(archivePath, 0, t.TempDir()); err != nil {
+		t.Errorf("extract within the limit: %v", err)
+	}
+
+	inst.MaxExtractBytes = -1
+	if err := inst.extractTarGz(archivePath, 0, t.TempDir()); err != nil {
+		t.Errorf("extract without a limit: %v", err)
+	}
+}
+
+func TestExtractTarXzSizeLimit(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz not available")
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	content := bytes.Repeat([]byte("x"), 1<<20)
+	tw.WriteHeader(&tar.Header{Name: "bin/tool", Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write(content)
+	tw.Close()
+
+	archivePath := filepath.Join(t.TempDir(), "tool.tar.xz")
+	cmd := exec.Command("xz", "-c")
+	cmd.Stdin = &archive
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("xz: %v", err)
+	}
+	os.WriteFile(archivePath, compressed, 0644)
+
+	destDir := t.TempDir()
+	if err := (&Installer{}).extractTarXz(archivePath, 1, destDir); err != nil {
+		t.Fatalf("extractTarXz: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(destDir, "tool")); !bytes.Equal(got, content) {
+		t.Errorf("extracted %d bytes, want %d", len(got), len(content))
+	}
+
+	// Reading stops at the limit instead of decompressing everything
+	inst := &Installer{MaxExtractBytes: 4096}
+	destDir = t.TempDir()
+	err = inst.extractTarXz(archivePath, 0, destDir)
+	if err == nil || !strings.Contains(err.Error(), "decompression bomb") {
+		t.Errorf("expected the extraction limit to be exceeded, got %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(destDir, "bin", "tool")); err == nil && info.Size() > 4096 {
+		t.Errorf("wrote %d bytes past a limit of 4096", info.Size())
+	}
+}
+
+func TestExtractZipDeclaredSize(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "lying.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("create archive file: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	content := []byte("much more than three bytes")
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "file.txt",
+		Method:             zip.Store,
+		CompressedSize64:   uint64(len(content)),
+		UncompressedSize64: 3,
+	})
+	if err != nil {
+		t.Fatalf("create zip entry: %v", err)
+	}
+	w.Write(content)
+	zw.Close()
+	f.Close()
+
+	inst := &Installer{}
+	if err := inst.extractZip(archivePath, 0, t.TempDir()); err == nil {
+		t.Error("expected an entry larger than its declared size to be rejected")
+	}
+}
+
+func TestFetchBinaryMultiHash(t *testing.T) {
+	content := []byte("#!/bin/sh\necho hi\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	sum256 := ledger.ChecksumBytes(content)
+	sum512 := ledger.ChecksumBytesSHA512(content)
+
+	tests := []struct {
+		name     string
+		expected checksums
+		wantErr  string
+	}{
+		{"sha256 only", checksums{sha256: sum256}, ""},
+		{"sha512 only", checksums{sha512: sum512}, ""},
+		{"both", checksums{sha256: sum256, sha512: sum512}, ""},
+		{"bad sha512", checksums{sha256: sum256, sha512: "0000"}, "sha512 checksum mismatch"},
+		{"bad sha256", checksums{sha256: "0000", sha512: sum512}, "sha256 checksum mismatch"},
+	}
+
+	inst := &Installer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := inst.fetchBinary(srv.URL, nil, tt.expected, "", "tool", t.TempDir())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("fetchBinary: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFetchBinaryCompressed(t *testing.T) {
+	content := []byte("#!/bin/sh\necho hi\n")
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(content)
+	gw.Close()
+	compressed := map[string][]byte{"gz": gz.Bytes()}
+	for format, command := range map[string]string{"xz": "xz", "bz2": "bzip2"} {
+		cmd := exec.Command(command, "-c")
+		cmd.Stdin = bytes.NewReader(content)
+		if out, err := cmd.Output(); err == nil {
+			compressed[format] = out
+		}
+	}
+
+	for _, format := range pkg.Compressions {
+		t.Run(format, func(t *testing.T) {
+			data, ok := compressed[format]
+			if !ok {
+				t.Skipf("no %s command to compress the test binary", format)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(data)
+			}))
+			defer srv.Close()
+
+			// The checksum is of the download, not the binary inside
+			expected := checksums{sha256: ledger.ChecksumBytes(data)}
+			destDir := t.TempDir()
+			if err := (&Installer{}).fetchBinary(srv.URL, nil, expected, format, "tool", destDir); err != nil {
+				t.Fatalf("fetchBinary: %v", err)
+			}
+
+			binPath := filepath.Join(destDir, "tool")
+			got, err := os.ReadFile(binPath)
+			if err != nil {
+				t.Fatalf("read binary: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("binary = %q, want %q", got, content)
+			}
+			if info, _ := os.Stat(binPath); info.Mode().Perm() != 0755 {
+				t.Errorf("mode = %o, want 0755", info.Mode().Perm())
+			}
+			if entries, _ := os.ReadDir(destDir); len(entries) != 1 {
+				t.Errorf("expected only the binary in the source directory, got %d entries", len(entries))
+			}
+
+			inst := &Installer{MaxExtractBytes: 4}
+			err = inst.fetchBinary(srv.URL, nil, expected, format, "tool", t.TempDir())
+			if err == nil || !strings.Contains(err.Error(), "decompression bomb") {
+				t.Errorf("expected the extraction limit to apply, got %v", err)
+			}
+		})
+	}
+}
+
+func TestChecksumsOf(t *testing.T) {
+	content := []byte("archive")
+	sum256 := ledger.ChecksumBytes(content)
+	sum512 := ledger.ChecksumBytesSHA512(content)
+
+	tests := []struct {
+		name    string
+		source  pkg.Source
+		want    checksums
+		warn    bool
+		wantErr string
+	}{
+		{"sha256", pkg.Source{SHA256: sum256}, checksums{sha256: sum256}, false, ""},
+		{"both", pkg.Source{SHA256: sum256, SHA512: sum512}, checksums{sha256: sum256, sha512: sum512}, false, ""},
+		{"sha512 in sha256", pkg.Source{SHA256: sum512}, checksums{sha512: sum512}, true, ""},
+		{"sha256 in sha512", pkg.Source{SHA512: sum256}, checksums{sha256: sum256}, true, ""},
+		{"sha1", pkg.Source{SHA256: "0a0a9f2a6772942557ab5355d76af442f8f65e01"}, checksums{}, false, "sha1 checksums are not accepted"},
+		{"invalid length", pkg.Source{SHA256: "abc123"}, checksums{}, false, "matches no supported algorithm"},
+		{"conflicting", pkg.Source{SHA256: sum256, SHA512: ledger.ChecksumBytes([]byte("other"))}, checksums{}, false, "two different sha256 checksums"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var warnings []string
+			inst := &Installer{OnProgress: func(msg string) { warnings = append(warnings, msg) }}
+			got, err := inst.checksumsOf(tt.source)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checksumsOf: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("checksumsOf = %+v, want %+v", got, tt.want)
+			}
+			if warned := len(warnings) > 0; warned != tt.warn {
+				t.Errorf("warnings = %q, want warning: %v", warnings, tt.warn)
+			}
+		})
+	}
+}
+
+func TestFetchBinaryHeaders(t *testing.T) {
+	content := []byte("#!/bin/sh\necho hi\n")
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	inst := &Installer{}
+	expected := checksums{sha256: ledger.ChecksumBytes(content)}
+
+	if err := inst.fetchBinary(srv.URL, nil, expected, "", "tool", t.TempDir()); err != nil {
+		t.Fatalf("fetchBinary: %v", err)
+	}
+	if ua := got.Get("User-Agent"); ua != UserAgent {
+		t.Errorf("User-Agent = %q, want %q", ua, UserAgent)
+	}
+
+	headers := map[string]string{"Accept": "application/octet-stream", "User-Agent": "custom/1.0"}
+	if err := inst.fetchBinary(srv.URL, headers, expected, "", "tool", t.TempDir()); err != nil {
+		t.Fatalf("fetchBinary: %v", err)
+	}
+	if accept := got.Get("Accept"); accept != "application/octet-stream" {
+		t.Errorf("Accept = %q, want application/octet-stream", accept)
+	}
+	if ua := got.Get("User-Agent"); ua != "custom/1.0" {
+		t.Errorf("User-Agent = %q, want the package's override", ua)
+	}
+}
+
+func TestFetchURLCache(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("cached content")
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "file.txt",
+		Mode:     0644,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatalf("write file header: %v", err)
+	}
+	tw.Write(content)
+	tw.Close()
+	gw.Close()
+	archive := buf.Bytes()
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	url := srv.URL + "/pkg.tar.gz"
+	expected := checksums{sha256: ledger.ChecksumBytes(archive)}
+	inst := &Installer{CacheDir: t.TempDir()}
+
+	for range 2 {
+		destDir := t.TempDir()
+		if err := inst.fetchURL(url, nil, expected, 0, destDir); err != nil {
+			t.Fatalf("fetchURL: %v", err)
+		}
+		got, err := os.ReadFile(filepath.Join(destDir, "file.txt"))
+		if err != nil || string(got) != string(content) {
+			t.Fatalf("extracted content = %q, %v", got, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request with a warm cache, got %d", requests)
+	}
+
+	// A corrupted cache entry is discarded and re-downloaded
+	cached := filepath.Join(inst.CacheDir, expected.sha256)
+	if err := os.WriteFile(cached, []byte("corrupt"), 0644); err != nil {
+		t.Fatalf("corrupt cache: %v", err)
+	}
+	if err := inst.fetchURL(url, nil, expected, 0, t.TempDir()); err != nil {
+		t.Fatalf("fetchURL after corruption: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected corrupted cache to be re-downloaded, got %d requests", requests)
+	}
+
+	// NoCache always downloads
+	inst.NoCache = true
+	if err := inst.fetchURL(url, nil, expected, 0, t.TempDir()); err != nil {
+		t.Fatalf("fetchURL with NoCache: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected NoCache to download, got %d requests", requests)
+	}
+
+	// Recently used entries survive an age-limited clean
+	if freed, err := inst.CleanCache(time.Hour); err != nil || freed != 0 {
+		t.Errorf("CleanCache(1h) = %d, %v; want nothing removed", freed, err)
+	}
+
+	freed, err := inst.CleanCache(0)
+	if err != nil {
+		t.Fatalf("CleanCache: %v", err)
+	}
+	if freed != int64(len(archive)) {
+		t.Errorf("CleanCache freed %d bytes, want %d", freed, len(archive))
+	}
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Errorf("expected cache entry to be removed, got %v", err)
+	}
+}
+
+func TestFetchURLResume(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte(strings.Repeat("resumable content\n", 100))
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "file.txt",
+		Mode:     0644,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatalf("write file header: %v", err)
+	}
+	tw.Write(content)
+	tw.Close()
+	gw.Close()
+	archive := buf.Bytes()
+	half := len(archive) / 2
+
+	var ranges []string
+	interrupt, ignoreRange := true, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		switch {
+		case interrupt:
+			// Promise the whole archive but drop the connection halfway
+			w.Header().Set("Content-Length", fmt.Sprint(len(archive)))
+			w.Write(archive[:half])
+		case ignoreRange:
+			w.Write(archive)
+		default:
+			http.ServeContent(w, r, "pkg.tar.gz", time.Time{}, bytes.NewReader(archive))
+		}
+	}))
+	defer srv.Close()
+
+	url := srv.URL + "/pkg.tar.gz"
+	expected := checksums{sha256: ledger.ChecksumBytes(archive)}
+	inst := &Installer{CacheDir: t.TempDir(), EnableResume: true}
+	cached := filepath.Join(inst.CacheDir, expected.sha256)
+	partial := cached + ".partial"
+
+	if err := inst.fetchURL(url, nil, expected, 0, t.TempDir()); err == nil {
+		t.Fatal("expected the interrupted download to fail")
+	}
+	if info, err := os.Stat(partial); err != nil || info.Size() != int64(half) {
+		t.Fatalf("expected %d bytes kept in %s, got %v, %v", half, partial, info, err)
+	}
+
+	interrupt = false
+	destDir := t.TempDir()
+	if err := inst.fetchURL(url, nil, expected, 0, destDir); err != nil {
+		t.Fatalf("fetchURL resume: %v", err)
+	}
+	if want := fmt.Sprintf("bytes=%d-", half); ranges[1] != want {
+		t.Errorf("Range = %q, want %q", ranges[1], want)
+	}
+	if got, err := os.ReadFile(filepath.Join(destDir, "file.txt")); err != nil || string(got) != string(content) {
+		t.Fatalf("extracted content = %q, %v", got, err)
+	}
+	if _, err := os.Stat(cached); err != nil {
+		t.Errorf("expected the finished download to be cached: %v", err)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("expected the partial file to be removed, got %v", err)
+	}
+
+	// A server without Range support sends the whole archive again
+	os.Remove(cached)
+	os.WriteFile(partial, archive[:half], 0644)
+	ignoreRange = true
+	if err := inst.fetchURL(url, nil, expected, 0, t.TempDir()); err != nil {
+		t.Fatalf("fetchURL without Range support: %v", err)
+	}
+	if data, err := os.ReadFile(cached); err != nil || !bytes.Equal(data, archive) {
+		t.Errorf("expected the full archive to be cached, got %d bytes, %v", len(data), err)
+	}
+
+	// A partial file that doesn't match is discarded
+	os.Remove(cached)
+	os.WriteFile(partial, []byte("garbage"), 0644)
+	ignoreRange = false
+	if err := inst.fetchURL(url, nil, expected, 0, t.TempDir()); err == nil {
+		t.Error("expected a corrupt partial download to fail its checksum")
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("expected the corrupt partial file to be removed, got %v", err)
+	}
+}
+
+func TestDryRunCheckSource(t *testing.T) {
+	content := []byte("#!/bin/sh\necho hi\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	packagesDir := filepath.Join(dir, "packages")
+	if err := os.MkdirAll(packagesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeDef := func(sum string) {
+		def := fmt.Sprintf(`
+name = "tool"
+version = "1.0.0"
+
+[source]
+binary = %q
+sha256 = %q
+
+[[install_steps]]
+type = "copy"
+src = "{{srcdir}}/tool"
+dest = "%s/bin/tool"
+`, srv.URL+"/tool", sum, dir)
+		if err := os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(def), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   filepath.Join(dir, "ledgers"),
+		BackupDir:   filepath.Join(dir, "backups"),
+		DryRun:      true,
+		CheckSource: true,
+	}
+
+	writeDef(ledger.ChecksumBytes(content))
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if ledger.Exists(inst.LedgerDir, "tool") {
+		t.Error("dry run created a ledger")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bin", "tool")); !os.IsNotExist(err) {
+		t.Errorf("dry run installed files: %v", err)
+	}
+
+	writeDef(strings.Repeat("0", 64))
+	if err := inst.Install("tool"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Install with a wrong checksum: err = %v, want checksum mismatch", err)
+	}
+}
+
+func TestFetchGitArchiveChecksum(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "tool"), []byte("binary"), 0755)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	want, err := gitArchiveChecksum(repo)
+	if err != nil {
+		t.Fatalf("gitArchiveChecksum: %v", err)
+	}
+
+	newPkg := func(archiveSHA256 string) *pkg.Package {
+		return &pkg.Package{
+			Name:    "tool",
+			Version: "1.0.0",
+			Source:  pkg.Source{Git: repo, ArchiveSHA256: archiveSHA256},
+		}
+	}
+
+	inst := &Installer{}
+	srcDir, _, err := inst.fetchSource(newPkg(strings.ToUpper(want)))
+	if err != nil {
+		t.Fatalf("fetchSource with matching archive_sha256: %v", err)
+	}
+	os.RemoveAll(srcDir)
+
+	wrong := ledger.ChecksumBytes([]byte("tampered"))
+	_, _, err = inst.fetchSource(newPkg(wrong))
+	if err == nil || !strings.Contains(err.Error(), "git archive checksum mismatch") {
+		t.Fatalf("fetchSource with wrong archive_sha256: err = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestFetchGitRetries(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	oldDelay := gitRetryDelay
+	gitRetryDelay = 0
+	defer func() { gitRetryDelay = oldDelay }()
+
+	var output []string
+	inst := &Installer{OnProgress: func(msg string) { output = append(output, msg) }}
+	missing := filepath.Join(t.TempDir(), "missing.git")
+	err := inst.fetchGit(missing, "", filepath.Join(t.TempDir(), "src"))
+	if err == nil || !strings.HasPrefix(err.Error(), "git clone:") {
+		t.Fatalf("fetchGit of a missing repository: err = %v, want a git clone error", err)
+	}
+
+	var retries int
+	for _, line := range output {
+		if strings.Contains(line, "retrying") {
+			retries++
+		}
+	}
+	if retries != gitCloneAttempts-1 {
+		t.Errorf("got %d retries, want %d:\n%s", retries, gitCloneAttempts-1, strings.Join(output, "\n"))
+	}
+}
@@ -2,11 +2,20 @@
 package installer
 
 import (
+	"cmp"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/anthropics/alloy/internal/config"
 	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/paths"
 	"github.com/anthropics/alloy/internal/pkg"
 )
 
@@ -15,6 +24,13 @@ type Installer struct {
 	// PackagesDir is the directory containing package definitions.
 	PackagesDir string
 
+	// RemotesDir is the directory containing synced remote indexes.
+	RemotesDir string
+
+	// Remotes are the names of remotes searched, in order, for package
+	// definitions not found in PackagesDir.
+	Remotes []string
+
 	// LedgerDir is the directory for storing ledgers.
 	LedgerDir string
 
@@ -24,71 +40,329 @@ type Installer struct {
 	// CacheDir is the directory for downloaded sources.
 	CacheDir string
 
+	// NoCache if true, always downloads sources instead of reusing
+	// verified archives from CacheDir, and doesn't add new ones.
+	NoCache bool
+
+	// EnableResume if true, downloads cacheable archives via a partial file
+	// in CacheDir and resumes an interrupted download with an HTTP Range
+	// request instead of starting over. New enables it.
+	EnableResume bool
+
+	// MaxExtractBytes limits how many bytes extracting a source archive may
+	// write, guarding against decompression bombs. Zero means
+	// DefaultMaxExtractBytes; a negative value disables the limit.
+	MaxExtractBytes int64
+
 	// DryRun if true, doesn't actually make changes.
 	DryRun bool
 
+	// Diff if true with DryRun, fetches the source and shows how each
+	// copied file would change on disk.
+	Diff bool
+
+	// KeepPartial if true, leaves a failed installation in place instead of
+	// rolling it back, so it can be continued with Resume.
+	KeepPartial bool
+
+	// CheckSource if true with DryRun, downloads the source and verifies its
+	// checksum (storing it in the cache) without running any install steps.
+	CheckSource bool
+
 	// Verbose enables detailed output.
 	Verbose bool
 
+	// Force if true, upgrades packages even when they are up to date.
+	Force bool
+
+	// AllowConflicts if true, installs packages without checking whether
+	// another package owns their files.
+	AllowConflicts bool
+
+	// Reinstall if true, removes an installed package and installs it
+	// again from its current definition instead of failing because it is
+	// already installed.
+	Reinstall bool
+
+	// KeepBackups if true with Reinstall, keeps the backups of files
+	// restored while removing the old installation.
+	KeepBackups bool
+
+	// CompressBackups if true, gzips backups of overwritten files.
+	CompressBackups bool
+
+	// NoBackup if true, skips backing up overwritten files. Originals
+	// cannot be restored on removal; intended for ephemeral environments.
+	NoBackup bool
+
+	// StrictVersions if true, refuses to install packages, dependencies
+	// included, whose version is not valid semver.
+	StrictVersions bool
+
+	// SyncLedger if true, fsyncs the ledger after every recorded entry, so
+	// after a crash it still lists every change made before it and a
+	// rollback or removal can undo them. This slows installs down.
+	SyncLedger bool
+
+	// VerifyAfterInstall if true, checks every recorded file against its
+	// checksum once the steps finish, rolling back if any is missing or
+	// already modified.
+	VerifyAfterInstall bool
+
+	// GlobalTimeout if non-zero limits how long each installation may take,
+	// in addition to the package's own max_install_time.
+	GlobalTimeout time.Duration
+
+	// StagingDir if set makes installs atomic: copy and mkdir steps write
+	// below a temporary directory in it instead of their destinations, and
+	// only once every step has succeeded are the files moved into place and
+	// recorded in the ledger. Run steps see the destinations as they were
+	// before the install. A relative StagingDir is taken relative to the
+	// package's prefix, so with "." files are staged on the prefix's
+	// filesystem and can be renamed into place.
+	StagingDir string
+
+	// GitRef if set overrides the ref of the package's git source, so a
+	// different branch, tag or commit than its definition names is
+	// installed. Installing a package with another kind of source fails.
+	// Dependencies use their own refs.
+	GitRef string
+
+	// ExtraEnv is added to the environment of every run step after the
+	// step's own Env, e.g. secrets from an env file. It is never written to
+	// the ledger.
+	ExtraEnv map[string]string
+
+	// CommandOutput receives the standard output of run steps and of the
+	// commands alloy runs itself, such as git. Nil means os.Stdout.
+	CommandOutput io.Writer
+
 	// OnProgress is called with progress updates.
 	OnProgress func(msg string)
+
+	// OnEvent is called with machine-readable progress events, alongside
+	// the messages sent to OnProgress.
+	OnEvent func(Event)
+
+	// current is the package being installed, reported in events.
+	current string
+
+	// deadline is when the current installation must finish by, or zero if
+	// it has no time limit.
+	deadline time.Time
+
+	// stage collects the paths of the current installation while
+	// StagingDir is set.
+	stage *staging
+
+	// workDirs are run step working directories created outside the source
+	// tree, removed once the install finishes.
+	workDirs []string
+
+	// installReason and requestedBy are recorded in the ledger header. An
+	// empty reason means the user asked for the package.
+	installReason string
+	requestedBy   []string
+
+	// installing lists the packages whose dependencies are being installed
+	// by this install, outermost first, to detect dependency cycles.
+	installing []string
+
+	// upgrading is set by Upgrade: the installation it replaces is removed
+	// without Force, so files modified since are backed up and overwritten
+	// by the new version rather than removed.
+	upgrading bool
+
+	// previous is the installation being replaced by Reinstall or Upgrade.
+	previous *previousInstall
+
+	// bundle, when set by InstallBundle, supplies the definition and source
+	// of the package it holds.
+	bundle *bundle
+}
+
+// PackagesDirEnv is the environment variable that overrides the default
+// packages directory.
+const PackagesDirEnv = "ALLOY_PACKAGES_DIR"
+
+// DefaultPackagesDir returns the directory searched for package definitions:
+// $ALLOY_PACKAGES_DIR if set, otherwise "packages" in the working directory.
+func DefaultPackagesDir() string {
+	if dir := os.Getenv(PackagesDirEnv); dir != "" {
+		return dir
+	}
+	return "packages"
 }
 
 // New creates a new Installer with default directories.
 func New() (*Installer, error) {
-	home, err := os.UserHomeDir()
+	configPath, err := config.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	remotesDir, err := config.DefaultRemotesDir()
+	if err != nil {
+		return nil, err
+	}
+	ledgerDir, err := ledger.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	backupDir, err := ledger.DefaultBackupDir()
+	if err != nil {
+		return nil, err
+	}
+	cacheDir, err := paths.CacheDir()
 	if err != nil {
-		return nil, fmt.Errorf("get home directory: %w", err)
+		return nil, err
 	}
 
-	alloyDir := filepath.Join(home, ".alloy")
+	var remotes []string
+	for _, r := range cfg.Remotes {
+		remotes = append(remotes, r.Name)
+	}
 
 	return &Installer{
-		PackagesDir: "packages",
-		LedgerDir:   filepath.Join(alloyDir, "ledgers"),
-		BackupDir:   filepath.Join(alloyDir, "backups"),
-		CacheDir:    filepath.Join(alloyDir, "cache"),
+		PackagesDir:  DefaultPackagesDir(),
+		RemotesDir:   remotesDir,
+		Remotes:      remotes,
+		LedgerDir:    ledgerDir,
+		BackupDir:    backupDir,
+		CacheDir:     cacheDir,
+		EnableResume: true,
 	}, nil
 }
 
 // Install installs a package by name.
-func (i *Installer) Install(name string) error {
+func (i *Installer) Install(name string) (err error) {
+	defer i.track(name, &err)()
+
 	i.progress("Loading package definition for %s", name)
 
 	// Find and parse package definition
-	pkgDef, err := i.loadPackage(name)
+	pkgDef, err := i.LoadPackage(name)
 	if err != nil {
 		return fmt.Errorf("load package: %w", err)
 	}
+	if err := overrideGitRef(pkgDef, i.GitRef); err != nil {
+		return err
+	}
+
+	for _, w := range pkgDef.Lint() {
+		i.progress("Warning: %s", w)
+	}
 
 	// Check if already installed
-	if ledger.Exists(i.LedgerDir, name) {
+	installed := ledger.Exists(i.LedgerDir, name)
+	if installed && !i.Reinstall {
+		if i.installReason == "" {
+			promoted, err := i.markExplicit(name)
+			if err != nil || promoted {
+				return err
+			}
+		}
 		return fmt.Errorf("package %q is already installed", name)
 	}
 
+	if err := checkPlugins(pkgDef.ExpandedSteps("")); err != nil {
+		return err
+	}
+
+	if err := i.installDependencies(pkgDef); err != nil {
+		return err
+	}
+
+	if err := i.checkConflicts(pkgDef); err != nil {
+		return err
+	}
+
 	// In dry-run mode, only validate and show what would happen
 	if i.DryRun {
+		if installed {
+			if _, err := i.removeForReinstall(name); err != nil {
+				return err
+			}
+		}
 		return i.dryRunInstall(pkgDef)
 	}
 
+	defer i.startDeadline(pkgDef)()
+
 	// Fetch source
 	i.progress("Fetching source from %s", pkgDef.Source.Location())
-	srcDir, err := i.fetchSource(pkgDef)
+	srcDir, sourceChecksum, err := i.fetchSource(pkgDef)
 	if err != nil {
 		return fmt.Errorf("fetch source: %w", err)
 	}
 	defer os.RemoveAll(srcDir)
+	defer i.removeWorkDirs()
+
+	// The current installation stays in place until the new source has
+	// been downloaded and verified, and is put back if installing the new
+	// one fails and is rolled back. A reinstalled package keeps its pin.
+	var pinned bool
+	if installed {
+		previous, err := i.removeForReinstall(name)
+		if err != nil {
+			return err
+		}
+		pinned = previous.ledg.Header.Pinned
+		i.previous = previous
+		defer func() {
+			i.previous = nil
+			current, err := ledger.Open(i.LedgerDir, name)
+			switch {
+			case err != nil:
+				previous.restore(i)
+			case ledger.IsInProgress(i.LedgerDir, name):
+				// KeepPartial left the new installation half done
+				previous.keep(i)
+			default:
+				previous.discard(i, current)
+			}
+		}()
+	}
 
 	// Create ledger
 	source := pkgDef.ExpandedSource()
-	ledg, err := ledger.Create(i.LedgerDir, name, source.Location())
+	ledg, err := ledger.CreateWithHeader(i.LedgerDir, ledger.Header{
+		Package:        name,
+		PackageVersion: pkgDef.Version,
+		Source:         source.Location(),
+		SourceChecksum: sourceChecksum,
+		NoBackup:       i.NoBackup,
+
+		PostRemoveMessage: pkgDef.ExpandedPostRemoveMessage(),
+		InstallReason:     cmp.Or(i.installReason, ledger.ReasonExplicit),
+		RequestedBy:       i.requestedBy,
+		Pinned:            pinned,
+		SourceRef:         i.GitRef,
+	})
 	if err != nil {
 		return fmt.Errorf("create ledger: %w", err)
 	}
 	defer ledg.Close()
+	ledg.SyncEachWrite = i.SyncLedger
 
 	// Create recorder
-	recorder := ledger.NewRecorder(ledg, i.BackupDir)
+	recorder := i.newRecorder(ledg)
+
+	if i.StagingDir != "" {
+		stageDir, err := i.createStage(pkgDef)
+		if err != nil {
+			ledg.Delete()
+			return fmt.Errorf("create staging directory: %w", err)
+		}
+		i.stage = &staging{dir: stageDir}
+		defer func() {
+			os.RemoveAll(stageDir)
+			i.stage = nil
+		}()
+	}
 
 	// Execute install steps
 	steps := pkgDef.ExpandedSteps(srcDir)
@@ -96,20 +370,249 @@ func (i *Installer) Install(name string) error {
 
 	for idx, step := range steps {
 		i.progress("Step %d/%d: %s", idx+1, len(steps), describeStep(step))
+		i.emit(Event{Kind: EventStepStart, Step: idx + 1, Steps: len(steps), Description: describeStep(step)})
+
+		// Staged steps aren't complete until they are committed
+		err := i.executeStep(step, srcDir, recorder)
+		if err == nil && i.stage == nil {
+			err = ledg.MarkStepComplete(idx + 1)
+		}
+		if err != nil {
+			if i.KeepPartial {
+				i.progress("Error during installation, leaving completed steps in place")
+				i.progress("Run 'alloy install --resume %s' to continue", name)
+				return fmt.Errorf("step %d (%s): %w", idx+1, step.Type, err)
+			}
 
-		if err := i.executeStep(step, srcDir, recorder); err != nil {
 			// Try to rollback
 			i.progress("Error during installation, rolling back...")
 			i.rollback(ledg)
 			ledg.Delete()
 			return fmt.Errorf("step %d (%s): %w", idx+1, step.Type, err)
 		}
+		i.emit(Event{Kind: EventStepDone, Step: idx + 1, Steps: len(steps), Description: describeStep(step)})
+	}
+
+	if i.stage != nil {
+		i.progress("Moving %d staged path(s) into place", len(i.stage.paths))
+		err := i.commitStaged(recorder)
+		if err == nil {
+			err = ledg.MarkStepComplete(len(steps))
+		}
+		if err != nil {
+			if i.KeepPartial {
+				i.progress("Error while moving staged files into place, leaving those already moved")
+				i.progress("Run 'alloy install --resume %s' to continue", name)
+				return fmt.Errorf("commit staged files: %w", err)
+			}
+			i.progress("Error while moving staged files into place, rolling back...")
+			i.rollback(ledg)
+			ledg.Delete()
+			return fmt.Errorf("commit staged files: %w", err)
+		}
+	}
+
+	if i.VerifyAfterInstall {
+		i.progress("Verifying installed files")
+		if err := i.verifyInstall(name); err != nil {
+			i.progress("Verification failed, rolling back...")
+			i.rollback(ledg)
+			ledg.Delete()
+			return err
+		}
 	}
 
+	if err := ledg.MarkComplete(); err != nil {
+		return err
+	}
+
+	stats := recorder.Stats()
+	i.progress("Installed %d files (%s)", stats.FilesCreated, FormatSize(stats.BytesTracked))
 	i.progress("Successfully installed %s@%s", pkgDef.Name, pkgDef.Version)
+	i.emit(Event{Kind: EventDone, Version: pkgDef.Version, Message: pkgDef.ExpandedPostInstallMessage()})
 	return nil
 }
 
+// Resume continues an installation that was interrupted partway through,
+// appending to its existing ledger. Steps up to the last one the ledger
+// marked complete are skipped. Ledgers without step markers fall back to
+// skipping copy and template steps whose destination already holds the
+// file the ledger recorded; every other step is re-run against the freshly
+// fetched source, as run steps leave no record and the remaining steps are
+// idempotent.
+//
+// Unlike Install, a failed step is not rolled back, so Resume can be run
+// again once the problem is fixed.
+func (i *Installer) Resume(name string) (err error) {
+	defer i.track(name, &err)()
+
+	if !ledger.IsInProgress(i.LedgerDir, name) {
+		return fmt.Errorf("package %q has no interrupted installation", name)
+	}
+
+	pkgDef, err := i.LoadPackage(name)
+	if err != nil {
+		return fmt.Errorf("load package: %w", err)
+	}
+
+	if err := checkPlugins(pkgDef.ExpandedSteps("")); err != nil {
+		return err
+	}
+
+	ledg, err := ledger.Append(i.LedgerDir, name)
+	if err != nil {
+		return fmt.Errorf("open ledger: %w", err)
+	}
+	defer ledg.Close()
+	ledg.SyncEachWrite = i.SyncLedger
+	if err := overrideGitRef(pkgDef, ledg.Header.SourceRef); err != nil {
+		return err
+	}
+
+	if ledg.Header.PackageVersion != "" && ledg.Header.PackageVersion != pkgDef.Version {
+		return fmt.Errorf("interrupted installation is of %s@%s, but the definition is now %s",
+			name, ledg.Header.PackageVersion, pkgDef.Version)
+	}
+
+	lastDone := ledger.LastCompletedStep(ledg)
+	done := func(idx int, step pkg.InstallStep) bool {
+		if lastDone > 0 {
+			return idx < lastDone
+		}
+		return stepDone(step, ledg)
+	}
+
+	if i.DryRun {
+		steps := pkgDef.ExpandedSteps("/tmp/source")
+		for idx, step := range steps {
+			if done(idx, step) {
+				i.progress("[dry-run]   Step %d: %s (done)", idx+1, describeStep(step))
+			} else {
+				i.progress("[dry-run]   Step %d: %s", idx+1, describeStep(step))
+			}
+		}
+		i.progress("[dry-run] Dry run complete, no changes made")
+		return nil
+	}
+
+	defer i.startDeadline(pkgDef)()
+
+	i.progress("Fetching source from %s", pkgDef.Source.Location())
+	srcDir, _, err := i.fetchSource(pkgDef)
+	if err != nil {
+		return fmt.Errorf("fetch source: %w", err)
+	}
+	defer os.RemoveAll(srcDir)
+	defer i.removeWorkDirs()
+
+	recorder := i.newRecorder(ledg)
+
+	steps := pkgDef.ExpandedSteps(srcDir)
+	skipped := 0
+	for idx, step := range steps {
+		if done(idx, step) {
+			i.progress("Step %d/%d: %s (already done)", idx+1, len(steps), describeStep(step))
+			i.emit(Event{Kind: EventStepDone, Step: idx + 1, Steps: len(steps), Description: describeStep(step), Skipped: true})
+			skipped++
+			continue
+		}
+
+		i.progress("Step %d/%d: %s", idx+1, len(steps), describeStep(step))
+		i.emit(Event{Kind: EventStepStart, Step: idx + 1, Steps: len(steps), Description: describeStep(step)})
+		if err := i.executeStep(step, srcDir, recorder); err != nil {
+			return fmt.Errorf("step %d (%s): %w", idx+1, step.Type, err)
+		}
+		if err := ledg.MarkStepComplete(idx + 1); err != nil {
+			return err
+		}
+		i.emit(Event{Kind: EventStepDone, Step: idx + 1, Steps: len(steps), Description: describeStep(step)})
+	}
+
+	if err := ledg.MarkComplete(); err != nil {
+		return err
+	}
+
+	i.progress("Successfully installed %s@%s (%d step(s) already done)", pkgDef.Name, pkgDef.Version, skipped)
+	i.emit(Event{Kind: EventDone, Version: pkgDef.Version, Message: pkgDef.ExpandedPostInstallMessage()})
+	return nil
+}
+
+// stepDone reports whether a copy or template step already completed in an
+// interrupted installation: the ledger recorded its destination and the file
+// there still has the recorded checksum.
+func stepDone(step pkg.InstallStep, ledg *ledger.Ledger) bool {
+	if step.Type != pkg.StepCopy && step.Type != pkg.StepTemplate {
+		return false
+	}
+
+	var recorded string
+	for _, e := range ledg.Entries {
+		if e.Path == step.Dest && (e.Op == ledger.OpFileCreate || e.Op == ledger.OpFileOverwrite) {
+			recorded = e.Checksum
+		}
+	}
+	if recorded == "" {
+		return false
+	}
+
+	actual, err := ledger.Checksum(step.Dest)
+	return err == nil && actual == recorded
+}
+
+// newRecorder returns a Recorder for ledg configured from the installer's
+// options. In verbose mode every recorded entry is reported as progress.
+func (i *Installer) newRecorder(ledg *ledger.Ledger) *ledger.Recorder {
+	recorder := ledger.NewRecorder(ledg, i.BackupDir)
+	recorder.CompressBackups = i.CompressBackups
+	if i.Verbose || i.OnEvent != nil {
+		recorder.OnRecord = func(entry ledger.Entry) {
+			if i.Verbose {
+				i.progress("  recorded %s %s", entry.Op, entry.Path)
+			}
+			i.emit(Event{Kind: EventRecord, Op: string(entry.Op), Path: entry.Path})
+		}
+	}
+	return recorder
+}
+
+// verifyInstall checks that every file the ledger recorded for a package is
+// on disk with its recorded checksum.
+func (i *Installer) verifyInstall(name string) error {
+	result := ledger.CheckLedgerIntegrity(i.LedgerDir, i.BackupDir, name, ledger.DoctorOptions{CheckFiles: true})
+	if result.ParseError != nil {
+		return fmt.Errorf("verify: %w", result.ParseError)
+	}
+
+	var problems []string
+	for _, path := range result.OrphanedFiles {
+		problems = append(problems, path+" is missing")
+	}
+	for _, path := range result.ModifiedFiles {
+		problems = append(problems, path+" does not match its checksum")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("verify: %d file(s) failed: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// startDeadline sets the deadline for installing pkgDef from the tighter of
+// GlobalTimeout and the package's max_install_time, and returns a function
+// that clears it.
+func (i *Installer) startDeadline(pkgDef *pkg.Package) func() {
+	limit := i.GlobalTimeout
+	if pkgDef.MaxInstallTime != "" {
+		// Validated when the package was parsed
+		if d, err := time.ParseDuration(pkgDef.MaxInstallTime); err == nil && (limit == 0 || d < limit) {
+			limit = d
+		}
+	}
+	if limit > 0 {
+		i.deadline = time.Now().Add(limit)
+	}
+	return func() { i.deadline = time.Time{} }
+}
+
 // dryRunInstall simulates an installation without making any changes.
 func (i *Installer) dryRunInstall(pkgDef *pkg.Package) error {
 	source := pkgDef.ExpandedSource()
@@ -123,20 +626,253 @@ func (i *Installer) dryRunInstall(pkgDef *pkg.Package) error {
 		i.progress("[dry-run]   Step %d: %s", idx+1, describeStep(step))
 	}
 
+	if i.Diff || i.CheckSource {
+		// Diffs need the real files, so fetch despite the dry run
+		if i.Diff {
+			i.progress("[dry-run] Fetching source to compute file changes")
+		} else {
+			i.progress("[dry-run] Fetching source to check it")
+		}
+		srcDir, sourceChecksum, err := i.fetchSource(pkgDef)
+		if err != nil {
+			return fmt.Errorf("fetch source: %w", err)
+		}
+		defer os.RemoveAll(srcDir)
+
+		switch source.SourceType() {
+		case "git":
+			i.progress("[dry-run] Source is reachable (commit %s)", sourceChecksum)
+		case "path":
+			i.progress("[dry-run] Source directory is readable")
+		default:
+			i.progress("[dry-run] Source is reachable and matches its checksum")
+		}
+
+		if i.Diff {
+			if err := i.showDiffs(pkgDef.ExpandedSteps(srcDir), srcDir); err != nil {
+				return err
+			}
+		}
+	}
+
 	i.progress("[dry-run] Dry run complete, no changes made")
 	return nil
 }
 
-// loadPackage finds and parses a package definition.
-func (i *Installer) loadPackage(name string) (*pkg.Package, error) {
+// checkConflicts refuses to install pkgDef if one of its conflict_files or
+// a copy, template, or symlink step targets a path another installed package
+// owns, as the packages would clobber each other and removing one would
+// break the other. With AllowConflicts they aren't looked for.
+func (i *Installer) checkConflicts(pkgDef *pkg.Package) error {
+	if i.AllowConflicts {
+		return nil
+	}
+
+	paths := pkgDef.ExpandedConflictFiles()
+	for _, step := range pkgDef.ExpandedSteps("") {
+		switch step.Type {
+		case pkg.StepCopy, pkg.StepTemplate, pkg.StepSymlink:
+			paths = append(paths, step.Dest)
+		}
+	}
+
+	conflicts, err := ledger.FindConflicts(i.LedgerDir, pkgDef.Name, paths)
+	if err != nil {
+		return fmt.Errorf("check conflicts: %w", err)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	paths = slices.Sorted(maps.Keys(conflicts))
+	for _, path := range paths {
+		i.progress("Conflict: %s is owned by %s", path, conflicts[path])
+	}
+	return fmt.Errorf("%s is owned by %s (%d conflicting path(s), use --allow-conflicts to install anyway)", paths[0], conflicts[paths[0]], len(paths))
+}
+
+// removeForReinstall removes the current installation of a package so
+// Reinstall can install it afresh, first saving it so it can be restored
+// if the new installation fails. Files modified since the install are
+// removed too, unless upgrading. In a dry run it only reports what would be
+// undone.
+func (i *Installer) removeForReinstall(name string) (*previousInstall, error) {
+	ledg, err := ledger.Open(i.LedgerDir, name)
+	if err != nil {
+		return nil, fmt.Errorf("open ledger: %w", err)
+	}
+
+	prefix := ""
+	if i.DryRun {
+		prefix = "[dry-run] "
+	}
+	previous := &previousInstall{ledg: ledg}
+	if !i.DryRun {
+		if previous, err = savePrevious(ledg, i.LedgerDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Backups are kept until the new installation has succeeded, as
+	// restoring the previous one needs them
+	i.progress("%sRemoving current installation of %s", prefix, name)
+	result, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{
+		DryRun:      i.DryRun,
+		Force:       !i.upgrading,
+		KeepBackups: true,
+		OnEntry: func(entry ledger.Entry, action string) {
+			if i.DryRun || i.Verbose {
+				i.progress("%s  %s %s -> %s", prefix, entry.Op, entry.Path, action)
+			}
+		},
+	})
+	if err == nil && result.HasErrors() {
+		err = fmt.Errorf("%d error(s), first: %v", len(result.Errors), &result.Errors[0])
+	}
+	if err != nil {
+		if !i.DryRun {
+			previous.restore(i)
+		}
+		return nil, fmt.Errorf("remove %s: %w", name, err)
+	}
+	if i.DryRun {
+		return previous, nil
+	}
+	previous.modifiedBackups = ledger.ModifiedBackupPaths(ledg, result.ModifiedFiles)
+	if err := ledg.Delete(); err != nil {
+		previous.restore(i)
+		return nil, fmt.Errorf("delete ledger: %w", err)
+	}
+	return previous, nil
+}
+
+// requiredByMu serializes recording installed dependencies as required,
+// which rewrites their ledgers, between the installs of InstallAll.
+var requiredByMu sync.Mutex
+
+// installDependencies installs the dependencies of pkgDef that are not
+// installed yet, recording each as pulled in by pkgDef. Dependencies that
+// are already installed record pkgDef as requiring them too.
+func (i *Installer) installDependencies(pkgDef *pkg.Package) error {
+	for _, dep := range pkgDef.Dependencies {
+		if ledger.Exists(i.LedgerDir, dep) {
+			if i.DryRun {
+				continue
+			}
+			requiredByMu.Lock()
+			_, err := ledger.AddRequestedBy(i.LedgerDir, dep, pkgDef.Name)
+			requiredByMu.Unlock()
+			if err != nil {
+				return fmt.Errorf("record dependency %s: %w", dep, err)
+			}
+			continue
+		}
+		if dep == pkgDef.Name || slices.Contains(i.installing, dep) {
+			return fmt.Errorf("dependency cycle involving %q", dep)
+		}
+
+		i.progress("Installing %s, required by %s", dep, pkgDef.Name)
+		inst := *i
+		inst.workDirs = nil
+		inst.installReason = ledger.ReasonDependency
+		inst.requestedBy = []string{pkgDef.Name}
+		inst.GitRef = ""
+		inst.installing = append(slices.Clone(i.installing), pkgDef.Name)
+		if err := inst.Install(dep); err != nil {
+			return fmt.Errorf("install dependency %s: %w", dep, err)
+		}
+	}
+	return nil
+}
+
+// overrideGitRef replaces the ref of pkgDef's git source with ref, if it is
+// set. Other kinds of source have no ref to replace, and a source with an
+// archive_sha256 only verifies the tree of its own ref.
+func overrideGitRef(pkgDef *pkg.Package, ref string) error {
+	if ref == "" {
+		return nil
+	}
+	if t := pkgDef.Source.SourceType(); t != "git" {
+		return fmt.Errorf("cannot install %s at ref %q: it has a %s source, not a git one", pkgDef.Name, ref, t)
+	}
+	if pkgDef.Source.ArchiveSHA256 != "" {
+		return fmt.Errorf("cannot install %s at ref %q: its archive_sha256 is the checksum of the tree at its own ref", pkgDef.Name, ref)
+	}
+	pkgDef.Source.Ref = ref
+	return nil
+}
+
+// markExplicit records that an installed package pulled in as a dependency
+// is now wanted by the user, so autoremove keeps it. It reports whether the
+// package was a dependency.
+func (i *Installer) markExplicit(name string) (bool, error) {
+	ledg, err := ledger.Open(i.LedgerDir, name)
+	if err != nil {
+		return false, fmt.Errorf("open ledger: %w", err)
+	}
+	if ledg.Header.Explicit() {
+		return false, nil
+	}
+
+	if i.DryRun {
+		i.progress("[dry-run] Would mark %s as explicitly installed", name)
+		return true, nil
+	}
+	ledg.Header.InstallReason = ledger.ReasonExplicit
+	if err := ledg.Rewrite(); err != nil {
+		return false, fmt.Errorf("update ledger: %w", err)
+	}
+	i.progress("%s is already installed, marked as explicitly installed", name)
+	return true, nil
+}
+
+// LoadPackage finds and parses a package definition, searching the local
+// packages directory first and then each synced remote in order. The
+// package of an InstallBundle comes from its bundle. With StrictVersions, a
+// version that is not valid semver is an error.
+func (i *Installer) LoadPackage(name string) (*pkg.Package, error) {
+	pkgDef, err := i.findPackage(name)
+	if err != nil {
+		return nil, err
+	}
+	if i.StrictVersions {
+		if err := pkgDef.ValidateWith(pkg.ValidateOptions{StrictVersions: true}); err != nil {
+			return nil, err
+		}
+	}
+	return pkgDef, nil
+}
+
+// findPackage parses the definition of the package name.
+func (i *Installer) findPackage(name string) (*pkg.Package, error) {
+	if err := ledger.ValidateName(name); err != nil {
+		return nil, err
+	}
+	if i.bundle != nil && i.bundle.pkg.Name == name {
+		return i.bundle.pkg, nil
+	}
 	path := filepath.Join(i.PackagesDir, name+".toml")
-	return pkg.ParseFile(path)
+	if _, err := os.Stat(path); err == nil || len(i.Remotes) == 0 {
+		return pkg.ParseFile(path)
+	}
+
+	for _, remote := range i.Remotes {
+		remotePath := filepath.Join(i.RemotesDir, remote, "packages", name+".toml")
+		if _, err := os.Stat(remotePath); err == nil {
+			return pkg.ParseFile(remotePath)
+		}
+	}
+
+	return nil, fmt.Errorf("package %q not found in %s or any remote", name, i.PackagesDir)
 }
 
 // rollback attempts to undo a partial installation.
 func (i *Installer) rollback(ledg *ledger.Ledger) {
+	// Backups shared with an installation being replaced must outlive the
+	// rollback, so it can be restored
 	result, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{
-		Force: true,
+		Force:       true,
+		KeepBackups: i.previous != nil,
 		OnEntry: func(entry ledger.Entry, action string) {
 			if i.Verbose {
 				i.progress("  Rollback: %s %s -> %s", entry.Op, entry.Path, action)
@@ -151,6 +887,17 @@ func (i *Installer) rollback(ledg *ledger.Ledger) {
 			i.progress("  Rollback failed for %s: %v", e.Entry.Path, e.Err)
 		}
 	}
+	if i.previous != nil {
+		removeUnsharedBackups(ledg, i.previous.ledg, nil)
+	}
+}
+
+// commandOutput returns where the standard output of commands goes.
+func (i *Installer) commandOutput() io.Writer {
+	if i.CommandOutput != nil {
+		return i.CommandOutput
+	}
+	return os.Stdout
 }
 
 // progress reports progress if a handler is set.
@@ -160,6 +907,20 @@ func (i *Installer) progress(format string, args ...any) {
 	}
 }
 
+// FormatSize formats a byte count using binary units (e.g., "12.3 MB").
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func describeStep(step pkg.InstallStep) string {
 	switch step.Type {
 	case pkg.StepRun:
@@ -170,6 +931,12 @@ func describeStep(step pkg.InstallStep) string {
 		return fmt.Sprintf("mkdir: %s", step.Path)
 	case pkg.StepSymlink:
 		return fmt.Sprintf("symlink: %s -> %s", step.Src, step.Dest)
+	case pkg.StepTemplate:
+		return fmt.Sprintf("template: %s -> %s", step.Src, step.Dest)
+	case pkg.StepPlugin:
+		return fmt.Sprintf("plugin: %s %s", step.Plugin, strings.Join(step.Args, " "))
+	case pkg.StepAppend:
+		return fmt.Sprintf("append: %s", step.Dest)
 	default:
 		return fmt.Sprintf("%s", step.Type)
 	}
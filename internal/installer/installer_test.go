@@ -1,9 +1,17 @@
 package installer
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/anthropics/alloy/internal/ledger"
 	"github.com/anthropics/alloy/internal/pkg"
@@ -80,6 +88,251 @@ func TestExecuteCopy(t *testing.T) {
 	}
 }
 
+func TestExecuteCopyPreservesSetgid(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	ledgerDir := t.TempDir()
+	backupDir := t.TempDir()
+
+	// A setgid helper, as installed by packages such as mail or games
+	srcPath := filepath.Join(srcDir, "helper")
+	if err := os.WriteFile(srcPath, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+	if err := os.Chmod(srcPath, 0755|os.ModeSetgid); err != nil {
+		t.Fatalf("chmod source file: %v", err)
+	}
+	if info, err := os.Stat(srcPath); err != nil || info.Mode()&os.ModeSetgid == 0 {
+		t.Skip("filesystem does not keep the setgid bit")
+	}
+
+	ledg, err := ledger.Create(ledgerDir, "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+	recorder := ledger.NewRecorder(ledg, backupDir)
+
+	destPath := filepath.Join(destDir, "helper")
+	step := pkg.InstallStep{Type: pkg.StepCopy, Src: "helper", Dest: destPath}
+	if err := (&Installer{}).executeCopy(step, srcDir, recorder); err != nil {
+		t.Fatalf("executeCopy: %v", err)
+	}
+
+	info, err := os.Stat(destPath)
+	if err != nil {
+		t.Fatalf("stat dest file: %v", err)
+	}
+	if got := info.Mode() & ledger.ModeMask; got != 0755|os.ModeSetgid {
+		t.Errorf("mode = %v, want %v", got, 0755|os.ModeSetgid)
+	}
+	if len(ledg.Entries) != 1 {
+		t.Fatalf("expected 1 ledger entry, got %d", len(ledg.Entries))
+	}
+	if got := ledg.Entries[0].Mode; got != 02755 {
+		t.Errorf("recorded mode = %04o, want 2755", got)
+	}
+
+	// An explicit mode sets the bits in chmod notation
+	step.Mode = "4750"
+	destPath = filepath.Join(destDir, "setuid")
+	step.Dest = destPath
+	if err := (&Installer{}).executeCopy(step, srcDir, recorder); err != nil {
+		t.Fatalf("executeCopy: %v", err)
+	}
+	info, err = os.Stat(destPath)
+	if err != nil {
+		t.Fatalf("stat dest file: %v", err)
+	}
+	if got := info.Mode() & ledger.ModeMask; got != 0750|os.ModeSetuid {
+		t.Errorf("mode = %v, want %v", got, 0750|os.ModeSetuid)
+	}
+
+	// Restoring a previous installation after a failed reinstall keeps
+	// the bits too
+	previous, err := savePrevious(ledg, ledgerDir)
+	if err != nil {
+		t.Fatalf("savePrevious: %v", err)
+	}
+	for _, name := range []string{"helper", "setuid"} {
+		os.Remove(filepath.Join(destDir, name))
+	}
+	previous.restore(&Installer{})
+	for name, want := range map[string]os.FileMode{"helper": 0755 | os.ModeSetgid, "setuid": 0750 | os.ModeSetuid} {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatalf("stat restored %s: %v", name, err)
+		}
+		if got := info.Mode() & ledger.ModeMask; got != want {
+			t.Errorf("restored %s mode = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestExecuteCopyIdenticalIsPreexisting(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	backupDir := t.TempDir()
+
+	content := []byte("same content")
+	os.WriteFile(filepath.Join(srcDir, "tool"), content, 0755)
+	destPath := filepath.Join(destDir, "tool")
+	os.WriteFile(destPath, content, 0755)
+
+	ledg, err := ledger.Create(t.TempDir(), "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+	recorder := ledger.NewRecorder(ledg, backupDir)
+
+	inst := &Installer{}
+	step := pkg.InstallStep{Type: pkg.StepCopy, Src: "tool", Dest: destPath}
+	if err := inst.executeCopy(step, srcDir, recorder); err != nil {
+		t.Fatalf("executeCopy: %v", err)
+	}
+
+	// The package owns the identical file without having put it there
+	if len(ledg.Entries) != 1 || ledg.Entries[0].Op != ledger.OpFileCreate || !ledg.Entries[0].Preexisting {
+		t.Fatalf("expected one preexisting create entry, got %+v", ledg.Entries)
+	}
+	if backups, _ := os.ReadDir(filepath.Join(backupDir, "test-pkg")); len(backups) != 0 {
+		t.Errorf("expected no backups, got %d", len(backups))
+	}
+
+	// Removing the package leaves it in place
+	if _, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{}); err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+	if got, err := os.ReadFile(destPath); err != nil || string(got) != string(content) {
+		t.Errorf("preexisting file after removal = %q, %v; want it kept", got, err)
+	}
+
+	// Different content is still backed up and recorded as an overwrite
+	os.WriteFile(filepath.Join(srcDir, "tool"), []byte("new content"), 0755)
+	if err := inst.executeCopy(step, srcDir, recorder); err != nil {
+		t.Fatalf("executeCopy: %v", err)
+	}
+	if len(ledg.Entries) != 2 || ledg.Entries[1].Op != ledger.OpFileOverwrite {
+		t.Fatalf("expected an overwrite entry, got %+v", ledg.Entries)
+	}
+	if _, err := os.Stat(ledg.Entries[1].Original.BackupPath); err != nil {
+		t.Errorf("expected backup of changed file: %v", err)
+	}
+}
+
+func TestExecuteCopyReadOnlyDestination(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(srcDir, "tool.conf"), []byte("new content"), 0644)
+	destPath := filepath.Join(destDir, "tool.conf")
+	os.WriteFile(destPath, []byte("read-only content"), 0444)
+	// WriteFile leaves an existing file's mode alone
+	os.Chmod(destPath, 0444)
+
+	ledg, err := ledger.Create(t.TempDir(), "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+	recorder := ledger.NewRecorder(ledg, t.TempDir())
+
+	inst := &Installer{}
+	step := pkg.InstallStep{Type: pkg.StepCopy, Src: "tool.conf", Dest: destPath}
+	if err := inst.executeCopy(step, srcDir, recorder); err != nil {
+		t.Fatalf("executeCopy: %v", err)
+	}
+
+	if data, _ := os.ReadFile(destPath); string(data) != "new content" {
+		t.Errorf("destination = %q, want the new content", data)
+	}
+	if info, err := os.Stat(destPath); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("destination mode = %v, %v, want 0644", info, err)
+	}
+	if len(ledg.Entries) != 1 || ledg.Entries[0].Op != ledger.OpFileOverwrite {
+		t.Fatalf("expected one overwrite entry, got %+v", ledg.Entries)
+	}
+	orig := ledg.Entries[0].Original
+	if orig.Mode != 0444 {
+		t.Errorf("original mode = %o, want 0444", orig.Mode)
+	}
+	if backup, err := os.ReadFile(orig.BackupPath); err != nil || string(backup) != "read-only content" {
+		t.Errorf("backup = %q, %v, want the read-only file", backup, err)
+	}
+
+	// A read-only mode is applied after writing, so copying again works
+	os.WriteFile(filepath.Join(srcDir, "tool.conf"), []byte("newer content"), 0644)
+	step.Mode = "0444"
+	if err := inst.executeCopy(step, srcDir, recorder); err != nil {
+		t.Fatalf("executeCopy with mode 0444: %v", err)
+	}
+	if info, err := os.Stat(destPath); err != nil || info.Mode().Perm() != 0444 {
+		t.Errorf("destination mode = %v, %v, want 0444", info, err)
+	}
+	if err := inst.executeCopy(pkg.InstallStep{Type: pkg.StepCopy, Src: "tool.conf", Dest: destPath}, srcDir, recorder); err != nil {
+		t.Fatalf("executeCopy over the 0444 copy: %v", err)
+	}
+}
+
+func TestExecuteAppend(t *testing.T) {
+	destDir := t.TempDir()
+	ledgerDir := t.TempDir()
+
+	rc := filepath.Join(destDir, ".bashrc")
+	original := "alias ll='ls -l'" // no trailing newline
+	if err := os.WriteFile(rc, []byte(original), 0644); err != nil {
+		t.Fatalf("write rc: %v", err)
+	}
+
+	ledg, err := ledger.Create(ledgerDir, "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+	recorder := ledger.NewRecorder(ledg, t.TempDir())
+
+	inst := &Installer{}
+	step := pkg.InstallStep{Type: pkg.StepAppend, Dest: rc, Content: `eval "$(tool init bash)"`}
+	if err := inst.executeAppend(step, recorder); err != nil {
+		t.Fatalf("executeAppend: %v", err)
+	}
+	// Appending the same content again is a no-op
+	if err := inst.executeAppend(step, recorder); err != nil {
+		t.Fatalf("executeAppend again: %v", err)
+	}
+	// A missing destination is created
+	created := filepath.Join(destDir, "conf.d", "tool.sh")
+	if err := inst.executeAppend(pkg.InstallStep{Type: pkg.StepAppend, Dest: created, Content: "export TOOL=1"}, recorder); err != nil {
+		t.Fatalf("executeAppend to a missing file: %v", err)
+	}
+
+	data, _ := os.ReadFile(rc)
+	if want := original + "\neval \"$(tool init bash)\"\n"; string(data) != want {
+		t.Errorf("content = %q, want %q", data, want)
+	}
+	if len(ledg.Entries) != 2 || ledg.Entries[0].Op != ledger.OpFileAppend || ledg.Entries[1].Op != ledger.OpFileCreate {
+		t.Fatalf("expected a file_append and a file_create entry, got %+v", ledg.Entries)
+	}
+
+	// The user adds a line after installing; removal keeps it
+	f, _ := os.OpenFile(rc, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString("export PATH=$PATH:~/bin\n")
+	f.Close()
+
+	result, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{})
+	if err != nil || result.HasErrors() {
+		t.Fatalf("ReverseReplay: %v %v", err, result.Errors)
+	}
+	data, _ = os.ReadFile(rc)
+	if want := original + "\nexport PATH=$PATH:~/bin\n"; string(data) != want {
+		t.Errorf("content after removal = %q, want %q", data, want)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("created file should be removed, got %v", err)
+	}
+}
+
 func TestExecuteMkdir(t *testing.T) {
 	destDir := t.TempDir()
 	ledgerDir := t.TempDir()
@@ -194,6 +447,50 @@ func TestExecuteSymlink(t *testing.T) {
 	}
 }
 
+func TestExecuteSymlinkRelative(t *testing.T) {
+	prefix := t.TempDir()
+	ledg, err := ledger.Create(t.TempDir(), "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+
+	targetPath := filepath.Join(prefix, "lib", "libfoo.so.1")
+	os.MkdirAll(filepath.Dir(targetPath), 0755)
+	os.WriteFile(targetPath, []byte("lib"), 0644)
+
+	linkPath := filepath.Join(prefix, "bin", "libfoo.so")
+	step := pkg.InstallStep{
+		Type:     pkg.StepSymlink,
+		Src:      targetPath,
+		Dest:     linkPath,
+		Relative: true,
+	}
+	inst := &Installer{}
+	if err := inst.executeSymlink(step, ledger.NewRecorder(ledg, t.TempDir())); err != nil {
+		t.Fatalf("executeSymlink: %v", err)
+	}
+
+	want := filepath.Join("..", "lib", "libfoo.so.1")
+	if target, err := os.Readlink(linkPath); err != nil || target != want {
+		t.Errorf("Readlink = %q, %v; want %q", target, err, want)
+	}
+	if got := ledg.Entries[0].Target; got != want {
+		t.Errorf("recorded target = %q, want %q", got, want)
+	}
+	if data, err := os.ReadFile(linkPath); err != nil || string(data) != "lib" {
+		t.Errorf("read through link = %q, %v", data, err)
+	}
+
+	// Re-running with the link in place is a no-op
+	if err := inst.executeSymlink(step, ledger.NewRecorder(ledg, t.TempDir())); err != nil {
+		t.Fatalf("executeSymlink again: %v", err)
+	}
+	if len(ledg.Entries) != 1 {
+		t.Errorf("expected 1 ledger entry after re-run, got %d", len(ledg.Entries))
+	}
+}
+
 func TestMkdirAllRecording(t *testing.T) {
 	destDir := t.TempDir()
 
@@ -255,3 +552,1362 @@ func TestCopyFile(t *testing.T) {
 		t.Errorf("mode mismatch: got %o, want %o", info.Mode().Perm(), 0600)
 	}
 }
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{1536, "1.5 KB"},
+		{12897485, "12.3 MB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatSize(tt.n); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultPackagesDir(t *testing.T) {
+	t.Setenv(PackagesDirEnv, "")
+	if got := DefaultPackagesDir(); got != "packages" {
+		t.Errorf("DefaultPackagesDir() = %q, want %q", got, "packages")
+	}
+
+	t.Setenv(PackagesDirEnv, "/srv/alloy-packages")
+	if got := DefaultPackagesDir(); got != "/srv/alloy-packages" {
+		t.Errorf("DefaultPackagesDir() = %q, want %q", got, "/srv/alloy-packages")
+	}
+}
+
+func TestLoadPackageFromRemote(t *testing.T) {
+	packagesDir := t.TempDir()
+	remotesDir := t.TempDir()
+
+	def := []byte(`
+name = "tool"
+version = "1.0.0"
+
+[source]
+git = "https://example.com/tool.git"
+
+[[install_steps]]
+type = "run"
+command = "make install"
+`)
+
+	remotePkgDir := filepath.Join(remotesDir, "work", "packages")
+	if err := os.MkdirAll(remotePkgDir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(remotePkgDir, "tool.toml"), def, 0644); err != nil {
+		t.Fatalf("write definition: %v", err)
+	}
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		RemotesDir:  remotesDir,
+		Remotes:     []string{"empty", "work"},
+	}
+
+	p, err := inst.LoadPackage("tool")
+	if err != nil {
+		t.Fatalf("LoadPackage: %v", err)
+	}
+	if p.Name != "tool" {
+		t.Errorf("Name = %q, want tool", p.Name)
+	}
+
+	if _, err := inst.LoadPackage("missing"); err == nil {
+		t.Error("expected error for unknown package")
+	}
+
+	// Crafted names must not resolve definitions outside the package dirs
+	if _, err := inst.LoadPackage("../work/packages/tool"); err == nil || !strings.Contains(err.Error(), "invalid package name") {
+		t.Errorf("LoadPackage with traversal: err = %v, want invalid name error", err)
+	}
+}
+
+func TestLoadPackageStrictVersions(t *testing.T) {
+	packagesDir := t.TempDir()
+	def := []byte(`
+name = "tool"
+version = "20241201"
+
+[source]
+git = "https://example.com/tool.git"
+
+[[install_steps]]
+type = "run"
+command = "make install"
+`)
+	if err := os.WriteFile(filepath.Join(packagesDir, "tool.toml"), def, 0644); err != nil {
+		t.Fatalf("write definition: %v", err)
+	}
+
+	inst := &Installer{PackagesDir: packagesDir}
+	if _, err := inst.LoadPackage("tool"); err != nil {
+		t.Fatalf("LoadPackage: %v", err)
+	}
+
+	inst.StrictVersions = true
+	if _, err := inst.LoadPackage("tool"); err == nil || !strings.Contains(err.Error(), "semantic version") {
+		t.Errorf("LoadPackage with StrictVersions: err = %v, want a semver error", err)
+	}
+	if os.Getenv(pkg.StrictVersionsEnv) != "" {
+		t.Errorf("StrictVersions set %s, which run steps would inherit", pkg.StrictVersionsEnv)
+	}
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	tmpl := "name = {{.name}}\nbin = {{.bindir}}\nport = {{.port}}\n"
+	if err := os.WriteFile(filepath.Join(srcDir, "config.tmpl"), []byte(tmpl), 0644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	ledg, err := ledger.Create(t.TempDir(), "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+	recorder := ledger.NewRecorder(ledg, t.TempDir())
+
+	p, err := pkg.Parse([]byte(fmt.Sprintf(`
+name = "myapp"
+version = "1.0.0"
+
+[source]
+git = "https://example.com/myapp.git"
+
+[install_paths]
+prefix = "/opt"
+
+[[install_steps]]
+type = "template"
+src = "config.tmpl"
+dest = %q
+vars = { port = "8080" }
+`, filepath.Join(destDir, "config"))))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	inst := &Installer{}
+	steps := p.ExpandedSteps(srcDir)
+	if err := inst.executeStep(steps[0], srcDir, recorder); err != nil {
+		t.Fatalf("executeStep: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(destDir, "config"))
+	if err != nil {
+		t.Fatalf("read rendered file: %v", err)
+	}
+	want := "name = myapp\nbin = /opt/bin\nport = 8080\n"
+	if string(content) != want {
+		t.Errorf("rendered %q, want %q", content, want)
+	}
+
+	if len(ledg.Entries) != 1 || ledg.Entries[0].Op != ledger.OpFileCreate {
+		t.Fatalf("expected one OpFileCreate entry, got %+v", ledg.Entries)
+	}
+
+	// Rendering over an existing file records an overwrite
+	if err := inst.executeStep(steps[0], srcDir, recorder); err != nil {
+		t.Fatalf("executeStep again: %v", err)
+	}
+	if ledg.Entries[1].Op != ledger.OpFileOverwrite {
+		t.Errorf("expected OpFileOverwrite, got %s", ledg.Entries[1].Op)
+	}
+}
+
+func TestInstallTemplateSyntaxErrorRollsBack(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	// Source repo with a good file and a broken template
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "tool"), []byte("binary"), 0755)
+	os.WriteFile(filepath.Join(repo, "config.tmpl"), []byte("port = {{.port"), 0644)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	def := fmt.Sprintf(`
+name = "broken"
+version = "1.0.0"
+
+[source]
+git = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "template"
+src = "config.tmpl"
+dest = "{{datadir}}/broken/config"
+`, repo, prefix)
+	if err := os.WriteFile(filepath.Join(packagesDir, "broken.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+	if err := inst.Install("broken"); err == nil {
+		t.Fatal("expected install to fail on template syntax error")
+	}
+
+	if _, err := os.Stat(filepath.Join(prefix, "bin", "tool")); !os.IsNotExist(err) {
+		t.Error("copied file should have been rolled back")
+	}
+	if _, err := os.Stat(filepath.Join(prefix, "share", "broken", "config")); !os.IsNotExist(err) {
+		t.Error("template output should not exist")
+	}
+	if ledger.Exists(inst.LedgerDir, "broken") {
+		t.Error("ledger should be deleted after rollback")
+	}
+}
+
+func TestResumeSkipsCompletedSteps(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "tool"), []byte("new binary"), 0755)
+	os.WriteFile(filepath.Join(repo, "helper"), []byte("helper"), 0755)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	def := fmt.Sprintf(`
+name = "partial"
+version = "1.0.0"
+
+[source]
+git = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "copy"
+src = "helper"
+dest = "{{bindir}}/helper"
+`, repo, prefix)
+	if err := os.WriteFile(filepath.Join(packagesDir, "partial.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+
+	// Simulate an install interrupted after the first step; its recorded
+	// content differs from the source so a re-run would be visible
+	ledg, err := ledger.CreateWithHeader(inst.LedgerDir, ledger.Header{Package: "partial", PackageVersion: "1.0.0"})
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	toolPath := filepath.Join(prefix, "bin", "tool")
+	os.MkdirAll(filepath.Dir(toolPath), 0755)
+	os.WriteFile(toolPath, []byte("old binary"), 0755)
+	if err := ledger.NewRecorder(ledg, inst.BackupDir).RecordFileCreate(toolPath); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	ledg.Close()
+
+	if err := inst.Resume("partial"); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+
+	if data, _ := os.ReadFile(toolPath); string(data) != "old binary" {
+		t.Errorf("completed step was re-run: tool = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(prefix, "bin", "helper")); string(data) != "helper" {
+		t.Errorf("remaining step not run: helper = %q", data)
+	}
+	if ledger.IsInProgress(inst.LedgerDir, "partial") {
+		t.Error("expected resumed install to be marked complete")
+	}
+
+	resumed, err := ledger.Open(inst.LedgerDir, "partial")
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	// Two files plus the marker for the resumed step
+	if len(resumed.Entries) != 3 {
+		t.Errorf("expected 3 ledger entries, got %d", len(resumed.Entries))
+	}
+
+	if err := inst.Resume("partial"); err == nil {
+		t.Error("expected error resuming a completed install")
+	}
+}
+
+func TestExecuteRunEnv(t *testing.T) {
+	t.Setenv("ALLOY_TEST_CC", "clang")
+	t.Setenv("ALLOY_TEST_SECRET", "hunter2")
+
+	srcDir := t.TempDir()
+	p, err := pkg.Parse([]byte(`
+name = "envtest"
+version = "1.0.0"
+
+[source]
+git = "https://example.com/envtest.git"
+
+[install_paths]
+prefix = "/opt"
+
+[[install_steps]]
+type = "run"
+command = "env > env.txt"
+inherit_env = ["ALLOY_TEST_CC"]
+env = { MODE = "release", DEST = "{{bindir}}" }
+
+[[install_steps]]
+type = "run"
+command = "/usr/bin/env > clear.txt"
+clear_env = true
+env = { ONLY = "this" }
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	inst := &Installer{}
+	for _, step := range p.ExpandedSteps(srcDir) {
+		if err := inst.executeRun(step, srcDir); err != nil {
+			t.Fatalf("executeRun: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(srcDir, "env.txt"))
+	if err != nil {
+		t.Fatalf("read env output: %v", err)
+	}
+	env := string(data)
+	for _, want := range []string{"MODE=release", "DEST=/opt/bin", "ALLOY_TEST_CC=clang", "ALLOY_PREFIX=/opt", "ALLOY_NAME=envtest"} {
+		if !strings.Contains(env, want+"\n") {
+			t.Errorf("environment missing %s", want)
+		}
+	}
+	if strings.Contains(env, "ALLOY_TEST_SECRET") {
+		t.Error("unlisted parent variable leaked into environment")
+	}
+
+	data, err = os.ReadFile(filepath.Join(srcDir, "clear.txt"))
+	if err != nil {
+		t.Fatalf("read clear_env output: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// sh may export a few of its own variables
+		name, _, _ := strings.Cut(line, "=")
+		if name == "ONLY" || name == "PWD" || name == "SHLVL" || name == "_" {
+			continue
+		}
+		t.Errorf("unexpected variable with clear_env: %s", line)
+	}
+}
+
+func TestExecuteRunExtraEnv(t *testing.T) {
+	srcDir := t.TempDir()
+	p, err := pkg.Parse([]byte(`
+name = "envtest"
+version = "1.0.0"
+
+[source]
+git = "https://example.com/envtest.git"
+
+[[install_steps]]
+type = "run"
+command = "env > env.txt"
+env = { MODE = "release" }
+
+[[install_steps]]
+type = "run"
+command = "env > clear.txt"
+clear_env = true
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	inst := &Installer{ExtraEnv: map[string]string{"MODE": "debug", "API_TOKEN": "secret", "ALLOY_PREFIX": "/srv"}}
+	for _, step := range p.ExpandedSteps(srcDir) {
+		if err := inst.executeRun(step, srcDir); err != nil {
+			t.Fatalf("executeRun: %v", err)
+		}
+	}
+
+	for file, wants := range map[string][]string{
+		"env.txt":   {"MODE=debug", "API_TOKEN=secret", "ALLOY_PREFIX=/srv"},
+		"clear.txt": {"API_TOKEN=secret"},
+	} {
+		data, err := os.ReadFile(filepath.Join(srcDir, file))
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		for _, want := range wants {
+			if !strings.Contains(string(data), want+"\n") {
+				t.Errorf("%s missing %s", file, want)
+			}
+		}
+	}
+}
+
+func TestExecuteRunWorkDirCreate(t *testing.T) {
+	srcDir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "build", "out")
+
+	inst := &Installer{}
+	steps := []pkg.InstallStep{
+		{Type: pkg.StepRun, Command: "pwd > pwd.txt", WorkDir: "nested/dir", WorkDirCreate: true},
+		{Type: pkg.StepRun, Command: "touch artifact", WorkDir: outside, WorkDirCreate: true},
+	}
+	for _, step := range steps {
+		if err := inst.executeRun(step, srcDir); err != nil {
+			t.Fatalf("executeRun: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(srcDir, "nested", "dir", "pwd.txt")); err != nil {
+		t.Errorf("relative workdir not created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "artifact")); err != nil {
+		t.Errorf("absolute workdir not used: %v", err)
+	}
+
+	inst.removeWorkDirs()
+	if _, err := os.Stat(filepath.Dir(outside)); !os.IsNotExist(err) {
+		t.Errorf("expected created workdir outside the source tree to be removed, got %v", err)
+	}
+
+	missing := pkg.InstallStep{Type: pkg.StepRun, Command: "true", WorkDir: "absent"}
+	if err := inst.executeRun(missing, srcDir); err == nil {
+		t.Error("expected error for missing workdir without workdir_create")
+	}
+}
+
+func TestExecuteRunCommandOutput(t *testing.T) {
+	var out strings.Builder
+	inst := &Installer{CommandOutput: &out}
+	step := pkg.InstallStep{Type: pkg.StepRun, Command: "echo built"}
+	if err := inst.executeRun(step, t.TempDir()); err != nil {
+		t.Fatalf("executeRun: %v", err)
+	}
+	if out.String() != "built\n" {
+		t.Errorf("command output = %q, want it in CommandOutput", out.String())
+	}
+}
+
+func TestExecuteRunTimeoutKillsChildren(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups")
+	}
+	srcDir := t.TempDir()
+	marker := filepath.Join(srcDir, "marker")
+
+	// The shell waits for a child that would outlive it if only the shell
+	// were killed
+	step := pkg.InstallStep{Type: pkg.StepRun, Command: "(sleep 1; touch marker) & wait", Timeout: "100ms"}
+	start := time.Now()
+	if err := (&Installer{}).executeRun(step, srcDir); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("executeRun: err = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("executeRun returned after %s", elapsed)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("the command's child kept running after the timeout")
+	}
+}
+
+func TestInstallTimeoutRollsBack(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "tool"), []byte("binary"), 0755)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	tests := []struct {
+		name          string
+		stepTimeout   string
+		globalTimeout time.Duration
+	}{
+		{"step timeout", `timeout = "100ms"`, 0},
+		{"global timeout", "", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := t.TempDir()
+			packagesDir := t.TempDir()
+			def := fmt.Sprintf(`
+name = "slow"
+version = "1.0.0"
+
+[source]
+git = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "run"
+command = "sleep 5"
+%s
+`, repo, prefix, tt.stepTimeout)
+			if err := os.WriteFile(filepath.Join(packagesDir, "slow.toml"), []byte(def), 0644); err != nil {
+				t.Fatalf("write package: %v", err)
+			}
+
+			inst := &Installer{
+				PackagesDir:   packagesDir,
+				LedgerDir:     t.TempDir(),
+				BackupDir:     t.TempDir(),
+				GlobalTimeout: tt.globalTimeout,
+			}
+
+			start := time.Now()
+			err := inst.Install("slow")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected DeadlineExceeded, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "step 2 (run)") {
+				t.Errorf("error should identify the step: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 4*time.Second {
+				t.Errorf("command was not killed (took %s)", elapsed)
+			}
+
+			if _, err := os.Stat(filepath.Join(prefix, "bin", "tool")); !os.IsNotExist(err) {
+				t.Error("copied file should have been rolled back")
+			}
+			if ledger.Exists(inst.LedgerDir, "slow") {
+				t.Error("ledger should be deleted after rollback")
+			}
+		})
+	}
+}
+
+func TestInstallVerifyAfterInstall(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "tool"), []byte("binary"), 0755)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	for _, verify := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verify=%v", verify), func(t *testing.T) {
+			prefix := t.TempDir()
+			packagesDir := t.TempDir()
+			// The run step removes the copied file behind the ledger's back
+			def := fmt.Sprintf(`
+name = "buggy"
+version = "1.0.0"
+
+[source]
+git = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "run"
+command = "rm {{bindir}}/tool"
+`, repo, prefix)
+			if err := os.WriteFile(filepath.Join(packagesDir, "buggy.toml"), []byte(def), 0644); err != nil {
+				t.Fatalf("write package: %v", err)
+			}
+
+			inst := &Installer{
+				PackagesDir:        packagesDir,
+				LedgerDir:          t.TempDir(),
+				BackupDir:          t.TempDir(),
+				VerifyAfterInstall: verify,
+			}
+			err := inst.Install("buggy")
+			if !verify {
+				if err != nil {
+					t.Fatalf("Install: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), "tool is missing") {
+				t.Fatalf("expected verification failure, got %v", err)
+			}
+			if ledger.Exists(inst.LedgerDir, "buggy") {
+				t.Error("ledger should be deleted after rollback")
+			}
+		})
+	}
+}
+
+func TestInstallVerifyLaterWrites(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "tool.conf"), []byte("setting = 1\n"), 0644)
+	os.WriteFile(filepath.Join(src, "tool-v1"), []byte("v1"), 0755)
+	os.WriteFile(filepath.Join(src, "tool-v2"), []byte("v2"), 0755)
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	// Later steps append to and overwrite files earlier steps copied
+	def := fmt.Sprintf(`
+name = "layered"
+version = "1.0.0"
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool.conf"
+dest = "{{prefix}}/etc/tool.conf"
+
+[[install_steps]]
+type = "append"
+dest = "{{prefix}}/etc/tool.conf"
+content = "extra = 2"
+
+[[install_steps]]
+type = "copy"
+src = "tool-v1"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "copy"
+src = "tool-v2"
+dest = "{{bindir}}/tool"
+`, src, prefix)
+	if err := os.WriteFile(filepath.Join(packagesDir, "layered.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	inst := &Installer{
+		PackagesDir:        packagesDir,
+		LedgerDir:          t.TempDir(),
+		BackupDir:          t.TempDir(),
+		VerifyAfterInstall: true,
+	}
+	if err := inst.Install("layered"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(prefix, "bin", "tool")); string(data) != "v2" {
+		t.Errorf("tool = %q, want v2", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(prefix, "etc", "tool.conf")); !strings.Contains(string(data), "extra = 2") {
+		t.Errorf("tool.conf = %q, want the appended line", data)
+	}
+}
+
+func TestInstallKeepPartialResume(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "tool"), []byte("binary"), 0755)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	writeDef := func(command string) {
+		def := fmt.Sprintf(`
+name = "flaky"
+version = "1.0.0"
+
+[source]
+git = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "run"
+command = %q
+`, repo, prefix, command)
+		if err := os.WriteFile(filepath.Join(packagesDir, "flaky.toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		KeepPartial: true,
+	}
+
+	writeDef("false")
+	if err := inst.Install("flaky"); err == nil {
+		t.Fatal("expected the failing step to fail the install")
+	}
+
+	toolPath := filepath.Join(prefix, "bin", "tool")
+	if _, err := os.Stat(toolPath); err != nil {
+		t.Fatalf("completed step was rolled back: %v", err)
+	}
+	if !ledger.IsInProgress(inst.LedgerDir, "flaky") {
+		t.Fatal("expected the partial install to stay in progress")
+	}
+	ledg, err := ledger.Open(inst.LedgerDir, "flaky")
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	if last := ledger.LastCompletedStep(ledg); last != 1 {
+		t.Errorf("LastCompletedStep = %d, want 1", last)
+	}
+
+	// Once fixed, resuming skips the completed copy even though its
+	// destination no longer matches the ledger
+	os.WriteFile(toolPath, []byte("changed after install"), 0755)
+	writeDef("true")
+	if err := inst.Resume("flaky"); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if data, _ := os.ReadFile(toolPath); string(data) != "changed after install" {
+		t.Errorf("completed step was re-run: tool = %q", data)
+	}
+	if ledger.IsInProgress(inst.LedgerDir, "flaky") {
+		t.Error("expected resumed install to be marked complete")
+	}
+
+	// Step markers don't get in the way of removal
+	ledg, err = ledger.Open(inst.LedgerDir, "flaky")
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	result, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{Force: true})
+	if err != nil || len(result.Errors) > 0 {
+		t.Fatalf("ReverseReplay: %v %v", err, result.Errors)
+	}
+}
+
+func TestInstallFromPathSource(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "tool"), []byte("local build"), 0755)
+	os.Symlink("tool", filepath.Join(src, "tool-link"))
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	def := fmt.Sprintf(`
+name = "local"
+version = "0.1.0"
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "run"
+command = "echo built > {{srcdir}}/stamp"
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+`, src, prefix)
+	if err := os.WriteFile(filepath.Join(packagesDir, "local.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+	if err := inst.Install("local"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(prefix, "bin", "tool"))
+	if err != nil || string(data) != "local build" {
+		t.Errorf("installed tool = %q, %v; want %q", data, err, "local build")
+	}
+	if _, err := os.Stat(filepath.Join(src, "stamp")); !os.IsNotExist(err) {
+		t.Error("run steps should work on a copy, not the source directory")
+	}
+
+	pkgDef, err := inst.LoadPackage("local")
+	if err != nil {
+		t.Fatalf("LoadPackage: %v", err)
+	}
+	ledg, err := ledger.Open(inst.LedgerDir, "local")
+	if err != nil {
+		t.Fatalf("Open ledger: %v", err)
+	}
+	if upToDate, err := inst.isUpToDate(pkgDef, ledg.Header); err != nil || upToDate {
+		t.Errorf("isUpToDate = %v, %v; path sources should always be reinstalled", upToDate, err)
+	}
+}
+
+func TestInstallReinstall(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "tool"), []byte("v1"), 0755)
+
+	prefix := t.TempDir()
+	os.MkdirAll(filepath.Join(prefix, "etc"), 0755)
+	os.WriteFile(filepath.Join(prefix, "etc", "tool.conf"), []byte("system config"), 0644)
+
+	packagesDir := t.TempDir()
+	def := fmt.Sprintf(`
+name = "tool"
+version = "1.0.0"
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{prefix}}/etc/tool.conf"
+`, src, prefix)
+	if err := os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	var output []string
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		OnProgress:  func(msg string) { output = append(output, msg) },
+	}
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	ledg, err := ledger.Open(inst.LedgerDir, "tool")
+	if err != nil {
+		t.Fatalf("Open ledger: %v", err)
+	}
+	backup := ledg.FilterByOp(ledger.OpFileOverwrite)[0].Original.BackupPath
+
+	os.WriteFile(filepath.Join(src, "tool"), []byte("v2"), 0755)
+	// Modified files are removed regardless
+	os.WriteFile(filepath.Join(prefix, "bin", "tool"), []byte("edited"), 0755)
+	if err := inst.Install("tool"); err == nil || !strings.Contains(err.Error(), "already installed") {
+		t.Fatalf("Install without Reinstall: err = %v, want already installed", err)
+	}
+
+	inst.Reinstall = true
+	inst.DryRun = true
+	output = nil
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("dry-run Reinstall: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(prefix, "bin", "tool")); string(data) != "edited" {
+		t.Errorf("dry run changed the installed file to %q", data)
+	}
+	joined := strings.Join(output, "\n")
+	for _, want := range []string{"[dry-run] Removing current installation of tool", "[dry-run] Would execute 2 install steps"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("dry-run output lacks %q:\n%s", want, joined)
+		}
+	}
+
+	// The new definition no longer overwrites the config, so only
+	// KeepBackups saves the backup of the original
+	def = def[:strings.LastIndex(def, "[[install_steps]]")]
+	if err := os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+	if _, err := ledger.SetPinned(inst.LedgerDir, "tool", true); err != nil {
+		t.Fatalf("SetPinned: %v", err)
+	}
+	inst.DryRun = false
+	inst.KeepBackups = true
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Reinstall: %v", err)
+	}
+	if ledg, err := ledger.Open(inst.LedgerDir, "tool"); err != nil || !ledg.Header.Pinned {
+		t.Errorf("a reinstalled package should stay pinned (err %v)", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(prefix, "bin", "tool")); string(data) != "v2" {
+		t.Errorf("reinstalled tool = %q, want v2", data)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("KeepBackups should keep the old backup: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(prefix, "etc", "tool.conf")); string(data) != "system config" {
+		t.Errorf("tool.conf = %q, want the restored original", data)
+	}
+
+	// A source that can't be fetched leaves the installation alone
+	os.RemoveAll(src)
+	if err := inst.Install("tool"); err == nil {
+		t.Fatal("Reinstall from a missing source should fail")
+	}
+	if !ledger.Exists(inst.LedgerDir, "tool") {
+		t.Error("a failed fetch removed the installed package's ledger")
+	}
+	if data, _ := os.ReadFile(filepath.Join(prefix, "bin", "tool")); string(data) != "v2" {
+		t.Errorf("after a failed fetch tool = %q, want v2", data)
+	}
+}
+
+func TestInstallReinstallKeepsOriginals(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "tool.conf"), []byte("packaged config"), 0644)
+
+	prefix := t.TempDir()
+	conf := filepath.Join(prefix, "etc", "tool.conf")
+	os.MkdirAll(filepath.Dir(conf), 0755)
+	os.WriteFile(conf, []byte("system config"), 0644)
+
+	packagesDir := t.TempDir()
+	def := fmt.Sprintf(`
+name = "tool"
+version = "1.0.0"
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool.conf"
+dest = "{{prefix}}/etc/tool.conf"
+`, src, prefix)
+	if err := os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	var output []string
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		OnProgress:  func(msg string) { output = append(output, msg) },
+	}
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	ledg, err := ledger.Open(inst.LedgerDir, "tool")
+	if err != nil {
+		t.Fatalf("Open ledger: %v", err)
+	}
+	original := ledg.FilterByOp(ledger.OpFileOverwrite)[0].Original
+
+	// The reinstall leaves the edited config in place, so the backup of
+	// the system config is the only copy left
+	os.WriteFile(conf, []byte("edited config"), 0644)
+	inst.Reinstall = true
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Reinstall: %v", err)
+	}
+	if ok, err := ledger.VerifyBackup(original.BackupPath, original.Checksum); !ok || err != nil {
+		t.Errorf("the backup of the original config should be kept (err %v)", err)
+	}
+	if joined := strings.Join(output, "\n"); !strings.Contains(joined, "Kept "+original.BackupPath) {
+		t.Errorf("output doesn't report the kept backup:\n%s", joined)
+	}
+
+	// A reinstall left partially installed keeps the previous installation
+	ledg, err = ledger.Open(inst.LedgerDir, "tool")
+	if err != nil {
+		t.Fatalf("Open ledger: %v", err)
+	}
+	backup := ledg.FilterByOp(ledger.OpFileOverwrite)[0].Original.BackupPath
+	def += `
+[[install_steps]]
+type = "copy"
+src = "missing"
+dest = "{{bindir}}/tool"
+`
+	if err := os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+	inst.KeepPartial = true
+	output = nil
+	if err := inst.Install("tool"); err == nil {
+		t.Fatal("Reinstall with a failing step should fail")
+	}
+	if !ledger.IsInProgress(inst.LedgerDir, "tool") {
+		t.Error("KeepPartial should leave the new installation in progress")
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("a partial reinstall removed the previous installation's backup: %v", err)
+	}
+	var saved string
+	for _, msg := range output {
+		if dir, ok := strings.CutPrefix(msg, "The previous installation of tool is saved in "); ok {
+			saved = dir
+		}
+	}
+	if saved == "" {
+		t.Fatalf("output doesn't report the saved installation:\n%s", strings.Join(output, "\n"))
+	}
+	defer os.RemoveAll(saved)
+	if prev, err := ledger.Open(saved, "tool"); err != nil || len(prev.FilterByOp(ledger.OpFileOverwrite)) != 1 {
+		t.Errorf("saved ledger: %v", err)
+	}
+}
+
+func TestInstallConflict(t *testing.T) {
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	for _, name := range []string{"first", "second"} {
+		src := t.TempDir()
+		os.WriteFile(filepath.Join(src, "tool"), []byte(name), 0755)
+		def := fmt.Sprintf(`
+name = %q
+version = "1.0.0"
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+`, name, src, prefix)
+		if err := os.WriteFile(filepath.Join(packagesDir, name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+	if err := inst.Install("first"); err != nil {
+		t.Fatalf("Install first: %v", err)
+	}
+
+	tool := filepath.Join(prefix, "bin", "tool")
+	err := inst.Install("second")
+	if err == nil || !strings.Contains(err.Error(), tool) || !strings.Contains(err.Error(), "first") {
+		t.Fatalf("Install second: err = %v, want a conflict naming %s and first", err, tool)
+	}
+	if ledger.Exists(inst.LedgerDir, "second") {
+		t.Error("a refused install should leave no ledger")
+	}
+	if data, _ := os.ReadFile(tool); string(data) != "first" {
+		t.Errorf("tool = %q, want it untouched", data)
+	}
+
+	// Force only concerns upgrades, so it doesn't bypass the check
+	inst.Force = true
+	if err := inst.Install("second"); err == nil {
+		t.Fatal("Install second with Force: expected a conflict")
+	}
+
+	inst.AllowConflicts = true
+	if err := inst.Install("second"); err != nil {
+		t.Fatalf("Install second with AllowConflicts: %v", err)
+	}
+	if data, _ := os.ReadFile(tool); string(data) != "second" {
+		t.Errorf("tool = %q, want second's", data)
+	}
+}
+
+func TestInstallConflictFiles(t *testing.T) {
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	for _, name := range []string{"first", "second"} {
+		src := t.TempDir()
+		os.WriteFile(filepath.Join(src, name), []byte(name), 0755)
+		def := fmt.Sprintf(`
+name = %q
+version = "1.0.0"
+conflict_files = ["{{bindir}}/first"]
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = %q
+dest = "{{bindir}}/%s"
+`, name, src, prefix, name, name)
+		if err := os.WriteFile(filepath.Join(packagesDir, name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+
+	var messages []string
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		OnProgress:  func(msg string) { messages = append(messages, msg) },
+	}
+	// A package may list its own files
+	if err := inst.Install("first"); err != nil {
+		t.Fatalf("Install first: %v", err)
+	}
+
+	// second installs nothing first owns, but declares the conflict
+	err := inst.Install("second")
+	if err == nil || !strings.Contains(err.Error(), "first") || !strings.Contains(err.Error(), "--allow-conflicts") {
+		t.Fatalf("Install second: err = %v, want a conflict with first", err)
+	}
+
+	messages = nil
+	inst.AllowConflicts = true
+	if err := inst.Install("second"); err != nil {
+		t.Fatalf("Install second with AllowConflicts: %v", err)
+	}
+	if slices.ContainsFunc(messages, func(m string) bool { return strings.HasPrefix(m, "Conflict: ") }) {
+		t.Errorf("AllowConflicts should skip the check, got %v", messages)
+	}
+}
+
+func TestInstallStaging(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "tool"), []byte("tool"), 0755)
+	os.WriteFile(filepath.Join(src, "tool.conf"), []byte("new"), 0644)
+
+	prefix := t.TempDir()
+	bin := filepath.Join(prefix, "bin", "tool")
+	conf := filepath.Join(prefix, "etc", "tool.conf")
+	os.MkdirAll(filepath.Dir(conf), 0755)
+	os.WriteFile(conf, []byte("old"), 0644)
+
+	packagesDir := t.TempDir()
+	writeDef := func(name, command string) {
+		def := fmt.Sprintf(`
+name = %q
+version = "1.0.0"
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "mkdir"
+path = "{{datadir}}/cache"
+
+[[install_steps]]
+type = "copy"
+src = "tool.conf"
+dest = "{{prefix}}/etc/tool.conf"
+
+[[install_steps]]
+type = "run"
+command = %q
+`, name, src, prefix, command)
+		if err := os.WriteFile(filepath.Join(packagesDir, name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+	// Run steps see the destinations as they were before the install
+	writeDef("tool", fmt.Sprintf("test ! -e %s && grep -q old %s", bin, conf))
+	// and copies staged on the prefix's filesystem
+	staged := filepath.Join(t.TempDir(), "staged")
+	writeDef("broken", fmt.Sprintf("test -f %s/.alloy-stage-broken-*%s && touch %s; false", prefix, bin, staged))
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		StagingDir:  ".",
+	}
+
+	if err := inst.Install("broken"); err == nil {
+		t.Fatal("expected the failing run step to fail the install")
+	}
+	if _, err := os.Stat(bin); !os.IsNotExist(err) {
+		t.Error("a failed staged install should not copy anything into place")
+	}
+	if _, err := os.Stat(staged); err != nil {
+		t.Error("files should be staged in a directory in the prefix")
+	}
+	if data, _ := os.ReadFile(conf); string(data) != "old" {
+		t.Errorf("a failed staged install changed %s to %q", conf, data)
+	}
+
+	// Staging directories left by an interrupted install are removed by
+	// the next, but not those of another package
+	stale := filepath.Join(prefix, ".alloy-stage-tool-123")
+	other := filepath.Join(prefix, ".alloy-stage-tool-extra-456")
+	for _, dir := range []string{stale, other} {
+		if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale staging directory was not removed: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("another package's staging directory was removed: %v", err)
+	}
+	os.RemoveAll(other)
+	if data, _ := os.ReadFile(bin); string(data) != "tool" {
+		t.Errorf("%s = %q, want the staged copy", bin, data)
+	}
+	if info, err := os.Stat(bin); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("%s mode = %v, %v, want 0755", bin, info, err)
+	}
+	if data, _ := os.ReadFile(conf); string(data) != "new" {
+		t.Errorf("%s = %q, want the staged copy", conf, data)
+	}
+	if info, err := os.Stat(filepath.Join(prefix, "share", "cache")); err != nil || !info.IsDir() {
+		t.Errorf("staged directory was not created: %v", err)
+	}
+	// Files are staged in the prefix, and the staging directory is removed
+	if matches, _ := filepath.Glob(filepath.Join(prefix, ".alloy-stage-*")); len(matches) != 0 {
+		t.Errorf("staging directory should be cleaned up: %v", matches)
+	}
+
+	ledg, err := ledger.Open(inst.LedgerDir, "tool")
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	ops := make(map[string]ledger.Op)
+	for _, e := range ledg.Entries {
+		ops[e.Path] = e.Op
+	}
+	if ops[bin] != ledger.OpFileCreate || ops[conf] != ledger.OpFileOverwrite || ops[filepath.Join(prefix, "share", "cache")] != ledger.OpDirCreate {
+		t.Errorf("ledger ops = %v", ops)
+	}
+	if ledger.LastCompletedStep(ledg) != 4 {
+		t.Errorf("last completed step = %d, want 4", ledger.LastCompletedStep(ledg))
+	}
+
+	// Removal restores the overwritten file
+	if _, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{}); err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+	if data, _ := os.ReadFile(conf); string(data) != "old" {
+		t.Errorf("after removal %s = %q, want the original", conf, data)
+	}
+}
@@ -0,0 +1,172 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// InstallAll installs several packages, running up to jobs installs at a
+// time. Packages are started in dependency order, and a package waits for
+// any of its dependencies in names to finish; if one fails, its dependents
+// are not installed. Every failure is collected into the returned error.
+//
+// Each install runs on its own copy of the Installer, since a Recorder is
+// not safe for concurrent use, and progress messages are prefixed with the
+// package name so interleaved output stays readable.
+func (i *Installer) InstallAll(names []string, jobs int) error {
+	if len(names) == 1 {
+		return i.Install(names[0])
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// Install missing dependencies as part of the batch, so packages
+	// sharing one wait for a single install of it instead of racing
+	pulled, err := i.missingDependencies(names)
+	if err != nil {
+		return err
+	}
+	names = append(slices.Clone(names), slices.Sorted(maps.Keys(pulled))...)
+
+	order, err := pkg.SortByDependencies(names, i.LoadPackage)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		done chan struct{}
+		err  error
+	}
+	results := make(map[string]*result, len(order))
+	for _, name := range order {
+		results[name] = &result{done: make(chan struct{})}
+	}
+
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for _, name := range order {
+		// Loaded successfully while sorting
+		pkgDef, _ := i.LoadPackage(name)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := results[name]
+			defer close(res.done)
+
+			for _, dep := range pkgDef.Dependencies {
+				if depRes, ok := results[dep]; ok {
+					<-depRes.done
+					if depRes.err != nil {
+						res.err = fmt.Errorf("%s: dependency %s failed", name, dep)
+						i.emit(Event{Kind: EventError, Package: name, Error: res.err.Error()})
+						return
+					}
+				}
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			inst := *i
+			inst.workDirs = nil
+			inst.OnProgress = func(msg string) { i.progress("[%s] %s", name, msg) }
+			if by, ok := pulled[name]; ok {
+				inst.installReason = ledger.ReasonDependency
+				inst.requestedBy = by
+			}
+			if err := inst.Install(name); err != nil {
+				res.err = fmt.Errorf("%s: %w", name, err)
+			}
+		}()
+
+		// Without parallelism, install strictly in dependency order
+		if jobs == 1 {
+			<-results[name].done
+		}
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, name := range order {
+		if err := results[name].err; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InstallDependencies installs the missing dependencies of the named
+// packages, and theirs, but not the packages themselves, e.g. to build them
+// from source. The dependencies the packages name are recorded as installed
+// explicitly, since no installed package needs them and autoremove would
+// otherwise remove them; the ones those pull in are recorded as
+// dependencies. It returns the packages installed (or, with DryRun, that
+// would be) in dependency order, along with any install errors.
+func (i *Installer) InstallDependencies(names []string, jobs int) ([]string, error) {
+	pulled, err := i.missingDependencies(names)
+	if err != nil {
+		return nil, err
+	}
+	if len(pulled) == 0 {
+		return nil, nil
+	}
+	all, err := pkg.SortByDependencies(slices.Sorted(maps.Keys(pulled)), i.LoadPackage)
+	if err != nil {
+		return nil, err
+	}
+
+	var direct []string
+	for _, dep := range all {
+		if slices.ContainsFunc(pulled[dep], func(by string) bool { return slices.Contains(names, by) }) {
+			direct = append(direct, dep)
+		}
+	}
+	installErr := i.InstallAll(direct, jobs)
+
+	if i.DryRun {
+		return all, installErr
+	}
+	var installed []string
+	for _, dep := range all {
+		if ledger.Exists(i.LedgerDir, dep) {
+			installed = append(installed, dep)
+		}
+	}
+	return installed, installErr
+}
+
+// missingDependencies finds the dependencies of names, transitively, that
+// are neither installed nor in names, mapped to the packages that need them.
+func (i *Installer) missingDependencies(names []string) (map[string][]string, error) {
+	pulled := make(map[string][]string)
+	queue := slices.Clone(names)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		pkgDef, err := i.LoadPackage(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range pkgDef.Dependencies {
+			if slices.Contains(names, dep) || ledger.Exists(i.LedgerDir, dep) {
+				continue
+			}
+			if _, seen := pulled[dep]; !seen {
+				queue = append(queue, dep)
+			}
+			if !slices.Contains(pulled[dep], name) {
+				pulled[dep] = append(pulled[dep], name)
+			}
+		}
+	}
+	return pulled, nil
+}
@@ -0,0 +1,255 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/alloy/internal/ledger"
+)
+
+func TestInstallAll(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "file"), []byte("content"), 0644)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	writeDef := func(name, deps, step string) {
+		def := fmt.Sprintf(`
+name = %q
+version = "1.0.0"
+dependencies = [%s]
+
+[source]
+git = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+%s
+`, name, deps, repo, prefix, step)
+		if err := os.WriteFile(filepath.Join(packagesDir, name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+	copyStep := func(name string) string {
+		return fmt.Sprintf("type = \"copy\"\nsrc = \"file\"\ndest = \"{{prefix}}/%s\"", name)
+	}
+	writeDef("base", "", copyStep("base"))
+	writeDef("app", `"base"`, copyStep("app"))
+	writeDef("broken", "", `type = "run"
+command = "false"`)
+	writeDef("plugin", `"broken"`, copyStep("plugin"))
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+
+	err := inst.InstallAll([]string{"plugin", "app", "broken", "base"}, 2)
+	if err == nil {
+		t.Fatal("expected an error for the failing package")
+	}
+	for _, want := range []string{"broken: step 1", "plugin: dependency broken failed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	for _, name := range []string{"base", "app"} {
+		if !ledger.Exists(inst.LedgerDir, name) {
+			t.Errorf("%s was not installed", name)
+		}
+		if _, err := os.Stat(filepath.Join(prefix, name)); err != nil {
+			t.Errorf("%s files missing: %v", name, err)
+		}
+	}
+	for _, name := range []string{"broken", "plugin"} {
+		if ledger.Exists(inst.LedgerDir, name) {
+			t.Errorf("%s should not be installed", name)
+		}
+	}
+}
+
+func TestInstallDependencies(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "file"), []byte("content"), 0644)
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	writeDef := func(name, deps string) {
+		def := fmt.Sprintf(`
+name = %q
+version = "1.0.0"
+dependencies = [%s]
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "file"
+dest = "{{prefix}}/%s"
+`, name, deps, src, prefix, name)
+		if err := os.WriteFile(filepath.Join(packagesDir, name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+	writeDef("base", "")
+	writeDef("lib", `"base"`)
+	writeDef("app", `"lib"`)
+	writeDef("shared", "")
+	writeDef("one", `"shared"`)
+	writeDef("two", `"shared"`)
+	writeDef("three", `"shared"`)
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+
+	header := func(name string) ledger.Header {
+		t.Helper()
+		l, err := ledger.Open(inst.LedgerDir, name)
+		if err != nil {
+			t.Fatalf("Open ledger %s: %v", name, err)
+		}
+		return l.Header
+	}
+	checkReason := func(name, reason string, requestedBy ...string) {
+		t.Helper()
+		h := header(name)
+		if h.InstallReason != reason || strings.Join(h.RequestedBy, ",") != strings.Join(requestedBy, ",") {
+			t.Errorf("%s: reason %q requested by %v; want %q requested by %v",
+				name, h.InstallReason, h.RequestedBy, reason, requestedBy)
+		}
+	}
+
+	if err := inst.Install("app"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	checkReason("app", ledger.ReasonExplicit)
+	checkReason("lib", ledger.ReasonDependency, "app")
+	checkReason("base", ledger.ReasonDependency, "lib")
+
+	if err := inst.InstallAll([]string{"one", "two"}, 2); err != nil {
+		t.Fatalf("InstallAll: %v", err)
+	}
+	checkReason("shared", ledger.ReasonDependency, "one", "two")
+
+	// A package reusing an installed dependency is recorded as needing it
+	if err := inst.Install("three"); err != nil {
+		t.Fatalf("Install of three: %v", err)
+	}
+	checkReason("shared", ledger.ReasonDependency, "one", "two", "three")
+
+	// Asking for an installed dependency by name keeps it for good
+	if err := inst.Install("lib"); err != nil {
+		t.Fatalf("Install of a dependency: %v", err)
+	}
+	checkReason("lib", ledger.ReasonExplicit, "app")
+	if err := inst.Install("lib"); err == nil || !strings.Contains(err.Error(), "already installed") {
+		t.Errorf("second Install of lib: err = %v, want already installed", err)
+	}
+}
+
+func TestInstallOnlyDependencies(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "file"), []byte("content"), 0644)
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	writeDef := func(name, deps string) {
+		def := fmt.Sprintf(`
+name = %q
+version = "1.0.0"
+dependencies = [%s]
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "file"
+dest = "{{prefix}}/%s"
+`, name, deps, src, prefix, name)
+		if err := os.WriteFile(filepath.Join(packagesDir, name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+	writeDef("base", "")
+	writeDef("lib", `"base"`)
+	writeDef("tool", "")
+	writeDef("app", `"lib", "tool"`)
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		DryRun:      true,
+	}
+	planned, err := inst.InstallDependencies([]string{"app"}, 1)
+	if err != nil {
+		t.Fatalf("InstallDependencies (dry run): %v", err)
+	}
+	if got := strings.Join(planned, ","); got != "base,lib,tool" {
+		t.Errorf("dry run would install %s, want base,lib,tool", got)
+	}
+	if names, _ := ledger.List(inst.LedgerDir); len(names) != 0 {
+		t.Errorf("dry run installed %v", names)
+	}
+
+	inst.DryRun = false
+	installed, err := inst.InstallDependencies([]string{"app"}, 1)
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	if got := strings.Join(installed, ","); got != "base,lib,tool" {
+		t.Errorf("installed %s, want base,lib,tool", got)
+	}
+	if ledger.Exists(inst.LedgerDir, "app") {
+		t.Error("the package itself should not be installed")
+	}
+	for name, reason := range map[string]string{"lib": ledger.ReasonExplicit, "tool": ledger.ReasonExplicit, "base": ledger.ReasonDependency} {
+		l, err := ledger.Open(inst.LedgerDir, name)
+		if err != nil {
+			t.Fatalf("Open ledger %s: %v", name, err)
+		}
+		if l.Header.InstallReason != reason {
+			t.Errorf("%s install reason = %q, want %q", name, l.Header.InstallReason, reason)
+		}
+	}
+
+	if installed, err := inst.InstallDependencies([]string{"app"}, 1); err != nil || len(installed) != 0 {
+		t.Errorf("with everything installed: %v, %v, want nothing to do", installed, err)
+	}
+}
@@ -0,0 +1,253 @@
+package installer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// BundleExt is the file extension of package bundles written by Pack.
+const BundleExt = ".alloy"
+
+// Names of the files inside a bundle.
+const (
+	bundleDefinition = "package.toml"
+	bundleSourceDir  = "source/"
+)
+
+// bundle is a package definition and its source, read from a bundle by
+// InstallBundle.
+type bundle struct {
+	pkg    *pkg.Package
+	source []byte
+}
+
+// BundleName returns the file name Pack output is saved under by default,
+// "<name>-<version>.alloy".
+func BundleName(p *pkg.Package) string {
+	return p.Name + "-" + p.Version + BundleExt
+}
+
+// Pack writes a bundle for offline installs to output: a gzipped tar
+// holding the definition at package.toml and the source archive or binary
+// at source/<filename>. Only url and binary sources can be bundled.
+func Pack(pkgDef *pkg.Package, sourceArchive []byte, output io.Writer) error {
+	name, err := bundleSourceName(pkgDef.ExpandedSource())
+	if err != nil {
+		return err
+	}
+	def, err := pkg.Format(pkgDef)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(output)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{bundleDefinition, def},
+		{bundleSourceDir + name, sourceArchive},
+	} {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0644,
+			Size:    int64(len(f.data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write bundle: %w", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf("write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	return nil
+}
+
+// Unpack reads a bundle written by Pack, returning the parsed definition
+// and the source. The source is not verified against the definition's
+// checksums; InstallBundle does that before using it.
+func Unpack(r io.Reader) (*pkg.Package, []byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read bundle: %w", err)
+	}
+	defer gz.Close()
+
+	var def, source []byte
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch {
+		case hdr.Name == bundleDefinition:
+			def, err = io.ReadAll(tr)
+		case strings.HasPrefix(hdr.Name, bundleSourceDir):
+			if source != nil {
+				return nil, nil, errors.New("bundle holds more than one source file")
+			}
+			source, err = io.ReadAll(tr)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read bundle: %w", err)
+		}
+	}
+
+	if def == nil {
+		return nil, nil, fmt.Errorf("bundle has no %s", bundleDefinition)
+	}
+	if source == nil {
+		return nil, nil, errors.New("bundle has no source")
+	}
+	p, err := pkg.Parse(def)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, source, nil
+}
+
+// bundleSourceName returns the file name a source is stored under in a
+// bundle: the last element of its URL path.
+func bundleSourceName(source pkg.Source) (string, error) {
+	var location string
+	switch source.SourceType() {
+	case "url":
+		location = source.URL
+	case "binary":
+		location = source.Binary
+	default:
+		return "", fmt.Errorf("%s sources cannot be bundled, only url and binary sources", source.SourceType())
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("source url: %w", err)
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return "", fmt.Errorf("source url %s has no file name", location)
+	}
+	return name, nil
+}
+
+// SourceArchive downloads a package's source and returns its contents once
+// verified against the declared checksums, for Pack.
+func (i *Installer) SourceArchive(p *pkg.Package) ([]byte, error) {
+	source := p.ExpandedSource()
+	if _, err := bundleSourceName(source); err != nil {
+		return nil, err
+	}
+	expected, err := i.checksumsOf(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.SourceType() == "binary" {
+		dir, err := os.MkdirTemp("", "alloy-pack-")
+		if err != nil {
+			return nil, fmt.Errorf("create temp directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		// Bundles hold the download as is, so it can be verified again
+		if err := i.fetchBinary(source.Binary, source.FetchHeaders, expected, "", p.Name, dir); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(filepath.Join(dir, p.Name))
+	}
+
+	archive, cleanup, err := i.downloadArchive(source.URL, source.FetchHeaders, expected)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return os.ReadFile(archive)
+}
+
+// InstallBundle installs the package in a bundle written by Pack, using
+// its definition and source instead of the packages directory and the
+// network. Missing dependencies are still installed from their usual
+// definitions.
+func (i *Installer) InstallBundle(r io.Reader) error {
+	p, source, err := Unpack(r)
+	if err != nil {
+		return err
+	}
+
+	inst := *i
+	inst.bundle = &bundle{pkg: p, source: source}
+	return inst.Install(p.Name)
+}
+
+// bundled returns the bundle holding the source of p, if there is one.
+func (i *Installer) bundled(p *pkg.Package) (*bundle, bool) {
+	if i.bundle == nil || i.bundle.pkg.Name != p.Name {
+		return nil, false
+	}
+	return i.bundle, true
+}
+
+// fetchBundled verifies a bundled source and puts it in destDir: archives
+// are extracted and binaries written as name.
+func (i *Installer) fetchBundled(b *bundle, source pkg.Source, expected checksums, name, destDir string) error {
+	i.progress("Using bundled source for %s", name)
+
+	digest := newDigester(expected)
+	digest.Write(b.source)
+	if err := digest.verify(); err != nil {
+		return err
+	}
+
+	if source.SourceType() == "binary" {
+		binPath := filepath.Join(destDir, name)
+		if err := os.WriteFile(binPath, b.source, 0755); err != nil {
+			return fmt.Errorf("write binary: %w", err)
+		}
+		if compression := source.Compression(); compression != "" {
+			return i.decompressBinary(binPath, compression)
+		}
+		return nil
+	}
+
+	tmp, err := os.CreateTemp("", "alloy-bundle-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, bytes.NewReader(b.source))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write source archive: %w", err)
+	}
+	return i.extractArchive(tmp.Name(), source.URL, source.Strip, destDir)
+}
@@ -0,0 +1,134 @@
+package installer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+func TestPackInstallBundle(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("bundled tool")
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "tool-1.0.0/tool",
+		Mode:     0755,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatalf("write file header: %v", err)
+	}
+	tw.Write(content)
+	tw.Close()
+	gw.Close()
+	archive := buf.Bytes()
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	prefix := t.TempDir()
+	def := fmt.Sprintf(`
+name = "tool"
+version = "1.0.0"
+
+[source]
+url = "%s/tool-{{version}}.tar.gz"
+sha256 = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+`, srv.URL, ledger.ChecksumBytes(archive), prefix)
+	pkgDef, err := pkg.Parse([]byte(def))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	inst := &Installer{
+		PackagesDir: t.TempDir(),
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+	source, err := inst.SourceArchive(pkgDef)
+	if err != nil {
+		t.Fatalf("SourceArchive: %v", err)
+	}
+	var bundle bytes.Buffer
+	if err := Pack(pkgDef, source, &bundle); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	got, gotSource, err := Unpack(bytes.NewReader(bundle.Bytes()))
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if got.Name != "tool" || !bytes.Equal(gotSource, archive) {
+		t.Fatalf("Unpack = %s with %d source bytes, want tool with %d", got.Name, len(gotSource), len(archive))
+	}
+
+	// The bundle installs without a definition in PackagesDir or the network
+	srv.Close()
+	if err := inst.InstallBundle(bytes.NewReader(bundle.Bytes())); err != nil {
+		t.Fatalf("InstallBundle: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(prefix, "bin", "tool"))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("installed tool = %q, %v; want %q", data, err, content)
+	}
+	if requests != 1 {
+		t.Errorf("expected only the pack to download, got %d requests", requests)
+	}
+}
+
+func TestUnpackRejectsTamperedSource(t *testing.T) {
+	pkgDef := &pkg.Package{
+		Name:    "tool",
+		Version: "1.0.0",
+		Source: pkg.Source{
+			Binary: "https://example.com/tool",
+			SHA256: ledger.ChecksumBytes([]byte("original")),
+		},
+		InstallSteps: []pkg.InstallStep{{Type: "copy", Src: "tool", Dest: "{{bindir}}/tool"}},
+	}
+	var bundle bytes.Buffer
+	if err := Pack(pkgDef, []byte("tampered"), &bundle); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	inst := &Installer{
+		PackagesDir: t.TempDir(),
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+	err := inst.InstallBundle(&bundle)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("InstallBundle of a tampered bundle: err = %v, want a checksum mismatch", err)
+	}
+	if ledger.Exists(inst.LedgerDir, "tool") {
+		t.Error("a failed bundle install should leave no ledger")
+	}
+
+	gitPkg := &pkg.Package{Name: "x", Source: pkg.Source{Git: "https://example.com/x.git"}}
+	if err := Pack(gitPkg, nil, &bundle); err == nil {
+		t.Error("Pack of a git source: expected an error")
+	}
+}
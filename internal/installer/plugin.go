@@ -0,0 +1,139 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// pluginRequest is the JSON document a step plugin receives on stdin.
+type pluginRequest struct {
+	Plugin string            `json:"plugin"`
+	Args   []string          `json:"args,omitempty"`
+	Src    string            `json:"src,omitempty"`
+	Dest   string            `json:"dest,omitempty"`
+	Path   string            `json:"path,omitempty"`
+	Mode   string            `json:"mode,omitempty"`
+	SrcDir string            `json:"srcdir"`
+	Vars   map[string]string `json:"vars,omitempty"`
+}
+
+// pluginOp is an operation a step plugin reports having performed.
+// Plugins answer with a JSON array of these on stdout.
+type pluginOp struct {
+	Op     ledger.Op `json:"op"`
+	Path   string    `json:"path"`
+	Target string    `json:"target,omitempty"`
+}
+
+// pluginPath returns the executable for a plugin step, searching PATH.
+func pluginPath(name string) (string, error) {
+	path, err := exec.LookPath(pkg.PluginPrefix + name)
+	if err != nil {
+		return "", fmt.Errorf("plugin %q: %s%s not found in PATH", name, pkg.PluginPrefix, name)
+	}
+	return path, nil
+}
+
+// checkPlugins confirms every plugin step has an executable, so a missing
+// plugin fails before anything is installed.
+func checkPlugins(steps []pkg.InstallStep) error {
+	for _, step := range steps {
+		if step.Type != pkg.StepPlugin {
+			continue
+		}
+		if _, err := pluginPath(step.Plugin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executePlugin runs an external step plugin and records the operations
+// it reports. Plugins may only create files, directories, and links;
+// alloy can't back up anything a plugin overwrites or deletes.
+func (i *Installer) executePlugin(step pkg.InstallStep, srcDir string, recorder *ledger.Recorder) error {
+	exe, err := pluginPath(step.Plugin)
+	if err != nil {
+		return err
+	}
+
+	input, err := json.Marshal(pluginRequest{
+		Plugin: step.Plugin,
+		Args:   step.Args,
+		Src:    step.Src,
+		Dest:   step.Dest,
+		Path:   step.Path,
+		Mode:   step.Mode,
+		SrcDir: srcDir,
+		Vars:   step.Vars,
+	})
+	if err != nil {
+		return fmt.Errorf("encode plugin input: %w", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(exe)
+	cmd.Dir = srcDir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	// Record whatever the plugin reports even if it failed, so rollback
+	// can undo its partial work
+	runErr := cmd.Run()
+
+	var ops []pluginOp
+	if stdout.Len() > 0 {
+		if err := json.Unmarshal(stdout.Bytes(), &ops); err != nil {
+			if runErr != nil {
+				return fmt.Errorf("plugin %s failed: %w", step.Plugin, runErr)
+			}
+			return fmt.Errorf("plugin %s: parse output: %w", step.Plugin, err)
+		}
+	}
+
+	for _, op := range ops {
+		if err := recordPluginOp(recorder, op); err != nil {
+			return fmt.Errorf("plugin %s: %w", step.Plugin, err)
+		}
+	}
+
+	if runErr != nil {
+		return fmt.Errorf("plugin %s failed: %w", step.Plugin, runErr)
+	}
+	return nil
+}
+
+// recordPluginOp records one operation reported by a plugin.
+func recordPluginOp(recorder *ledger.Recorder, op pluginOp) error {
+	if !filepath.IsAbs(op.Path) {
+		return fmt.Errorf("reported path %q is not absolute", op.Path)
+	}
+
+	switch op.Op {
+	case ledger.OpFileCreate:
+		return recorder.RecordFileCreate(op.Path)
+	case ledger.OpDirCreate:
+		return recorder.RecordDirCreate(op.Path)
+	case ledger.OpSymlinkCreate:
+		target := op.Target
+		if target == "" {
+			var err error
+			if target, err = os.Readlink(op.Path); err != nil {
+				return fmt.Errorf("read symlink: %w", err)
+			}
+		}
+		return recorder.RecordSymlinkCreate(op.Path, target)
+	case ledger.OpHardlinkCreate:
+		return recorder.RecordHardlinkCreate(op.Path, op.Target)
+	default:
+		return fmt.Errorf("unsupported operation %q for %s", op.Op, op.Path)
+	}
+}
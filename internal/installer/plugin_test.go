@@ -0,0 +1,87 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// installPlugin writes a shell script plugin to a directory on PATH.
+func installPlugin(t *testing.T, name, script string) {
+	t.Helper()
+	binDir := t.TempDir()
+	path := filepath.Join(binDir, pkg.PluginPrefix+name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("write plugin: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestExecutePlugin(t *testing.T) {
+	destDir := t.TempDir()
+	dest := filepath.Join(destDir, "out.txt")
+
+	// The plugin saves its input next to the file it creates
+	installPlugin(t, "touch", `cat > "$ALLOY_TEST_DEST.json"
+echo hi > "$ALLOY_TEST_DEST"
+printf '[{"op":"file_create","path":"%s"}]' "$ALLOY_TEST_DEST"
+`)
+	t.Setenv("ALLOY_TEST_DEST", dest)
+
+	ledg, err := ledger.Create(t.TempDir(), "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+	recorder := ledger.NewRecorder(ledg, t.TempDir())
+
+	step := pkg.InstallStep{Type: pkg.StepPlugin, Plugin: "touch", Args: []string{"--fast"}}
+	inst := &Installer{}
+	if err := inst.executeStep(step, t.TempDir(), recorder); err != nil {
+		t.Fatalf("executeStep: %v", err)
+	}
+
+	if len(ledg.Entries) != 1 || ledg.Entries[0].Op != ledger.OpFileCreate || ledg.Entries[0].Path != dest {
+		t.Fatalf("unexpected ledger entries: %+v", ledg.Entries)
+	}
+	if ledg.Entries[0].Checksum == "" {
+		t.Error("plugin-created file should be checksummed")
+	}
+
+	input, err := os.ReadFile(dest + ".json")
+	if err != nil {
+		t.Fatalf("read plugin input: %v", err)
+	}
+	if !strings.Contains(string(input), `"args":["--fast"]`) {
+		t.Errorf("plugin input missing args: %s", input)
+	}
+}
+
+func TestExecutePluginUnsupportedOp(t *testing.T) {
+	installPlugin(t, "clobber", `printf '[{"op":"file_overwrite","path":"/etc/hosts"}]'`)
+
+	ledg, err := ledger.Create(t.TempDir(), "test-pkg", "test://source")
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	defer ledg.Close()
+	recorder := ledger.NewRecorder(ledg, t.TempDir())
+
+	step := pkg.InstallStep{Type: pkg.StepPlugin, Plugin: "clobber"}
+	err = (&Installer{}).executeStep(step, t.TempDir(), recorder)
+	if err == nil || !strings.Contains(err.Error(), "unsupported operation") {
+		t.Errorf("expected unsupported operation error, got %v", err)
+	}
+}
+
+func TestCheckPluginsMissing(t *testing.T) {
+	steps := []pkg.InstallStep{{Type: pkg.StepPlugin, Plugin: "does-not-exist"}}
+	err := checkPlugins(steps)
+	if err == nil || !strings.Contains(err.Error(), "alloy-step-does-not-exist not found") {
+		t.Errorf("expected missing plugin error, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/anthropics/alloy/internal/ledger"
+)
+
+// previousInstall is the installation of a package that Reinstall or
+// Upgrade replaces. Before it is removed, whatever is at each path its
+// ledger records is saved, so it can be put back if the new installation
+// fails.
+type previousInstall struct {
+	ledg *ledger.Ledger
+
+	// ledgerPath and data are the ledger file and its contents, and
+	// inProgress whether it was marked in progress.
+	ledgerPath string
+	data       []byte
+	inProgress bool
+
+	// dir holds copies of the saved files.
+	dir   string
+	paths []savedPath
+
+	// modifiedBackups are the backups of originals that removing the
+	// installation didn't restore, as the files had been modified since.
+	// They are kept even once the new installation has succeeded.
+	modifiedBackups []string
+}
+
+// savedPath is what was at a path before the previous installation was
+// removed: a file copied to saved, a symlink to target, a directory, or
+// nothing.
+type savedPath struct {
+	path   string
+	saved  string
+	target string
+	dir    bool
+	absent bool
+	mode   os.FileMode
+}
+
+// savePrevious saves the installation recorded in ledg, whose file is in
+// ledgerDir.
+func savePrevious(ledg *ledger.Ledger, ledgerDir string) (*previousInstall, error) {
+	name := ledg.Header.Package
+	p := &previousInstall{
+		ledg:       ledg,
+		ledgerPath: ledger.Path(ledgerDir, name),
+		inProgress: ledger.IsInProgress(ledgerDir, name),
+	}
+	data, err := os.ReadFile(p.ledgerPath)
+	if err != nil {
+		return nil, fmt.Errorf("read ledger: %w", err)
+	}
+	p.data = data
+
+	if p.dir, err = os.MkdirTemp("", "alloy-previous-"+name+"-"); err != nil {
+		return nil, fmt.Errorf("create directory for the current installation: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range ledg.Entries {
+		if entry.Path == "" || seen[entry.Path] {
+			continue
+		}
+		seen[entry.Path] = true
+
+		saved := savedPath{path: entry.Path}
+		info, err := os.Lstat(entry.Path)
+		switch {
+		case os.IsNotExist(err):
+			saved.absent = true
+		case err != nil:
+			os.RemoveAll(p.dir)
+			return nil, err
+		case info.Mode()&os.ModeSymlink != 0:
+			if saved.target, err = os.Readlink(entry.Path); err != nil {
+				os.RemoveAll(p.dir)
+				return nil, err
+			}
+		case info.IsDir():
+			saved.dir = true
+			saved.mode = info.Mode() & ledger.ModeMask
+		default:
+			saved.mode = info.Mode() & ledger.ModeMask
+			saved.saved = filepath.Join(p.dir, strconv.Itoa(len(p.paths)))
+			if err := copyFile(entry.Path, saved.saved, saved.mode); err != nil {
+				os.RemoveAll(p.dir)
+				return nil, fmt.Errorf("save %s: %w", entry.Path, err)
+			}
+		}
+		p.paths = append(p.paths, saved)
+	}
+	return p, nil
+}
+
+// restore puts the previous installation and its ledger back once the new
+// installation has been rolled back.
+func (p *previousInstall) restore(i *Installer) {
+	i.progress("Restoring the previous installation of %s", p.ledg.Header.Package)
+	for _, saved := range p.paths {
+		if err := saved.restore(); err != nil {
+			i.progress("  Restore failed for %s: %v", saved.path, err)
+		}
+	}
+	if p.inProgress {
+		if err := os.WriteFile(p.ledgerPath+".inprogress", nil, 0644); err != nil {
+			i.progress("  Restore failed for the in-progress marker: %v", err)
+		}
+	}
+	if err := os.WriteFile(p.ledgerPath, p.data, 0644); err != nil {
+		i.progress("  Restore failed for the ledger: %v", err)
+	}
+	os.RemoveAll(p.dir)
+}
+
+// restore puts back what was at s.path.
+func (s savedPath) restore() error {
+	if s.dir {
+		return os.MkdirAll(s.path, s.mode)
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if s.absent {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if s.saved == "" {
+		return os.Symlink(s.target, s.path)
+	}
+	return copyFile(s.saved, s.path, s.mode)
+}
+
+// discard drops the saved installation once the new one has succeeded,
+// removing the backups only its ledger refers to unless KeepBackups is
+// set.
+func (p *previousInstall) discard(i *Installer, current *ledger.Ledger) {
+	os.RemoveAll(p.dir)
+	if i.KeepBackups {
+		return
+	}
+	removeUnsharedBackups(p.ledg, current, p.modifiedBackups)
+	for _, path := range p.modifiedBackups {
+		i.progress("Kept %s, the backup of a file modified since the previous installation", path)
+	}
+}
+
+// keep leaves the saved installation and its backups in place when the
+// new one was left partially installed, writing its ledger next to the
+// saved files so it can still be recovered.
+func (p *previousInstall) keep(i *Installer) {
+	path := filepath.Join(p.dir, filepath.Base(p.ledgerPath))
+	if err := os.WriteFile(path, p.data, 0644); err != nil {
+		i.progress("Saving the previous ledger failed: %v", err)
+		return
+	}
+	i.progress("The previous installation of %s is saved in %s", p.ledg.Header.Package, p.dir)
+}
+
+// removeUnsharedBackups removes the backups from refers to that keep, if
+// set, doesn't, other than those in except. Backups are named by
+// checksum, so the ledgers of two installations of a package can share
+// them.
+func removeUnsharedBackups(from, keep *ledger.Ledger, except []string) {
+	shared := make(map[string]bool)
+	if keep != nil {
+		for _, path := range ledger.CollectBackupPaths(keep) {
+			shared[path] = true
+		}
+	}
+	for _, path := range except {
+		shared[path] = true
+	}
+	for _, path := range ledger.CollectBackupPaths(from) {
+		if !shared[path] {
+			os.Remove(path)
+		}
+	}
+}
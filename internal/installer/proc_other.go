@@ -0,0 +1,9 @@
+//go:build !unix
+
+package installer
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as it is on systems without process groups,
+// where canceling its context only kills the shell.
+func killProcessGroup(cmd *exec.Cmd) {}
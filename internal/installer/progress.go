@@ -0,0 +1,158 @@
+package installer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressInterval is how often a ProgressWriter reports.
+const ProgressInterval = 100 * time.Millisecond
+
+// ProgressWriter passes writes through to another writer, counting the
+// bytes, and reports the count, the expected total, and the transfer rate at
+// most every ProgressInterval.
+type ProgressWriter struct {
+	w      io.Writer
+	report func(written, total int64, rate float64)
+
+	// written counts from offset, the bytes already there before the
+	// writer was created; the rate only counts what was written through it
+	offset  int64
+	written int64
+	total   int64
+	start   time.Time
+	last    time.Time
+}
+
+// NewProgressWriter returns a ProgressWriter writing to w. offset is how
+// many bytes were written before, e.g. by an interrupted download being
+// resumed, and total is the expected final size, or 0 if it is unknown.
+func NewProgressWriter(w io.Writer, offset, total int64, report func(written, total int64, rate float64)) *ProgressWriter {
+	now := time.Now()
+	return &ProgressWriter{w: w, report: report, offset: offset, written: offset, total: total, start: now, last: now}
+}
+
+// Write writes b to the underlying writer, reporting progress if
+// ProgressInterval has passed since the last report.
+func (p *ProgressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= ProgressInterval {
+		p.last = now
+		p.report(p.written, p.total, p.rate(now))
+	}
+	return n, err
+}
+
+// Finish reports the final count, so a progress display ends complete.
+func (p *ProgressWriter) Finish() {
+	p.report(p.written, p.total, p.rate(time.Now()))
+}
+
+// rate returns the average bytes per second written through p.
+func (p *ProgressWriter) rate(now time.Time) float64 {
+	elapsed := now.Sub(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.written-p.offset) / elapsed
+}
+
+// downloadProgress returns a ProgressWriter for the body of resp written to
+// w, sending download_progress events for url. offset is the size of a
+// partial download being resumed.
+func (i *Installer) downloadProgress(w io.Writer, url string, offset int64, resp *http.Response) *ProgressWriter {
+	var total int64
+	if resp.ContentLength > 0 {
+		total = offset + resp.ContentLength
+	}
+	return NewProgressWriter(w, offset, total, func(written, total int64, rate float64) {
+		i.emit(Event{Kind: EventDownloadProgress, URL: url, Bytes: written, Total: total, Rate: rate})
+	})
+}
+
+// progressBarWidth is the number of cells in a TextProgress bar.
+const progressBarWidth = 30
+
+// TextProgress renders download_progress events for people. On a terminal
+// it redraws one line such as "[=====     ] 42% 3.2 MB/s ETA 12s"; otherwise
+// it prints a line at every 10% of a download whose size is known, so logs
+// stay short. It is safe for concurrent use.
+type TextProgress struct {
+	mu  sync.Mutex
+	w   io.Writer
+	tty bool
+
+	url   string // the download being shown
+	tenth int64  // the last 10% step printed without a terminal
+	drawn bool   // whether a bar is on the current line
+}
+
+// NewTextProgress returns a TextProgress writing to w, redrawing a bar in
+// place if tty is true.
+func NewTextProgress(w io.Writer, tty bool) *TextProgress {
+	return &TextProgress{w: w, tty: tty}
+}
+
+// Event renders e if it is a download_progress event and ignores it
+// otherwise, so it can be used as Installer.OnEvent.
+func (p *TextProgress) Event(e Event) {
+	if e.Kind != EventDownloadProgress {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e.URL != p.url {
+		p.url, p.tenth = e.URL, 0
+	}
+
+	if !p.tty {
+		if e.Total <= 0 {
+			return
+		}
+		if tenth := e.Bytes * 10 / e.Total; tenth > p.tenth {
+			p.tenth = tenth
+			fmt.Fprintf(p.w, "  %d%% of %s downloaded\n", tenth*10, FormatSize(e.Total))
+		}
+		return
+	}
+
+	fmt.Fprintf(p.w, "\r\033[K%s", progressLine(e))
+	p.drawn = true
+}
+
+// Clear erases a bar drawn on the current line, so other output can be
+// printed in its place.
+func (p *TextProgress) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.drawn {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// progressLine formats the bar, percentage, rate, and time remaining of a
+// download_progress event. Without a known total only the bytes downloaded
+// and the rate are shown.
+func progressLine(e Event) string {
+	rate := FormatSize(int64(e.Rate)) + "/s"
+	if e.Total <= 0 {
+		return fmt.Sprintf("%s %s", FormatSize(e.Bytes), rate)
+	}
+
+	done := min(e.Bytes, e.Total)
+	filled := int(done * progressBarWidth / e.Total)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	line := fmt.Sprintf("[%s] %d%% %s", bar, done*100/e.Total, rate)
+	if e.Rate > 0 && done < e.Total {
+		eta := time.Duration(float64(e.Total-done) / e.Rate * float64(time.Second))
+		line += " ETA " + eta.Round(time.Second).String()
+	}
+	return line
+}
@@ -0,0 +1,99 @@
+package installer
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthropics/alloy/internal/ledger"
+)
+
+func TestProgressWriter(t *testing.T) {
+	type report struct{ written, total int64 }
+	var reports []report
+	var buf bytes.Buffer
+	pw := NewProgressWriter(&buf, 10, 40, func(written, total int64, rate float64) {
+		reports = append(reports, report{written, total})
+	})
+
+	// Writes within the interval are passed through without a report
+	pw.Write([]byte("abcde"))
+	pw.Write([]byte("fghij"))
+	if len(reports) != 0 {
+		t.Errorf("reported %v before the interval passed", reports)
+	}
+	pw.last = pw.last.Add(-ProgressInterval)
+	pw.Write([]byte("klmno"))
+	pw.Finish()
+
+	if buf.String() != "abcdefghijklmno" {
+		t.Errorf("written = %q", buf.String())
+	}
+	want := []report{{25, 40}, {25, 40}}
+	if len(reports) != len(want) || reports[0] != want[0] || reports[1] != want[1] {
+		t.Errorf("reports = %v, want %v", reports, want)
+	}
+	// The rate only counts bytes written through the writer, not the offset
+	pw.start = time.Now().Add(-time.Second)
+	if rate := pw.rate(time.Now()); rate < 14 || rate > 15 {
+		t.Errorf("rate = %v, want about 15 bytes/s", rate)
+	}
+}
+
+func TestTextProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewTextProgress(&buf, false)
+	for _, n := range []int64{50, 100, 150, 999, 1000} {
+		p.Event(Event{Kind: EventDownloadProgress, URL: "u", Bytes: n, Total: 1000})
+	}
+	p.Event(Event{Kind: EventFetchStart, URL: "u"})
+	p.Event(Event{Kind: EventDownloadProgress, URL: "v", Bytes: 5, Total: 0})
+	want := "  10% of 1000 B downloaded\n  90% of 1000 B downloaded\n  100% of 1000 B downloaded\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output without a terminal =\n%s\nwant\n%s", got, want)
+	}
+
+	buf.Reset()
+	p = NewTextProgress(&buf, true)
+	p.Event(Event{Kind: EventDownloadProgress, URL: "u", Bytes: 500, Total: 1000, Rate: 100})
+	p.Clear()
+	p.Clear()
+	want = "\r\033[K[===============               ] 50% 100 B/s ETA 5s\r\033[K"
+	if got := buf.String(); got != want {
+		t.Errorf("terminal output = %q, want %q", got, want)
+	}
+
+	if got := progressLine(Event{Bytes: 2048, Rate: 1024}); got != "2.0 KB 1.0 KB/s" {
+		t.Errorf("progress line without a total = %q", got)
+	}
+}
+
+func TestFetchBinaryDownloadProgress(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 4096)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4096")
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	var events []Event
+	inst := &Installer{OnEvent: func(e Event) {
+		if e.Kind == EventDownloadProgress {
+			events = append(events, e)
+		}
+	}}
+	expected := checksums{sha256: ledger.ChecksumBytes(content)}
+	if err := inst.fetchBinary(srv.URL, nil, expected, "", "tool", t.TempDir()); err != nil {
+		t.Fatalf("fetchBinary: %v", err)
+	}
+
+	if len(events) == 0 {
+		t.Fatal("no download_progress events")
+	}
+	last := events[len(events)-1]
+	if last.URL != srv.URL || last.Bytes != 4096 || last.Total != 4096 {
+		t.Errorf("last event = %+v, want the complete download", last)
+	}
+}
@@ -0,0 +1,210 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// staging collects the files and directories copy and mkdir steps would
+// create while Installer.StagingDir is set, so nothing reaches its final
+// destination until every step has succeeded.
+type staging struct {
+	// dir holds the staged files, each at its destination's path below it.
+	dir string
+
+	// paths are the staged files and directories in the order their steps
+	// ran, at most one per destination.
+	paths []stagedPath
+}
+
+// stagedPath is a file or directory waiting to be moved into place.
+type stagedPath struct {
+	dest string
+
+	// staged is the staged copy of a file, or empty for a directory,
+	// which is only created when committed, or a preexisting file.
+	staged string
+
+	// preexisting marks a file already at dest with the staged content,
+	// which is recorded but not moved.
+	preexisting bool
+
+	mode os.FileMode
+}
+
+// add stages p, replacing an earlier path staged for the same destination.
+func (s *staging) add(p stagedPath) {
+	for idx := range s.paths {
+		if s.paths[idx].dest == p.dest {
+			s.paths[idx] = p
+			return
+		}
+	}
+	s.paths = append(s.paths, p)
+}
+
+// stageCopy copies src into the staging area in place of dest.
+func (i *Installer) stageCopy(src, dest string, mode os.FileMode) error {
+	// As with an unstaged copy, an identical file is left alone but still
+	// recorded when the staged paths are committed
+	identical, err := sameFile(src, dest, mode)
+	if err != nil {
+		return err
+	}
+	if identical {
+		if i.Verbose {
+			i.progress("  %s is already up to date", dest)
+		}
+		i.stage.add(stagedPath{dest: dest, preexisting: true, mode: mode})
+		return nil
+	}
+
+	staged := filepath.Join(i.stage.dir, dest)
+	if err := os.MkdirAll(filepath.Dir(staged), 0755); err != nil {
+		return fmt.Errorf("create staging directory: %w", err)
+	}
+	if err := copyFile(src, staged, mode); err != nil {
+		return err
+	}
+	i.stage.add(stagedPath{dest: dest, staged: staged, mode: mode})
+	return nil
+}
+
+// stageMkdir stages the creation of the directory at path, unless it
+// already exists.
+func (i *Installer) stageMkdir(path string, mode os.FileMode) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return
+	}
+	i.stage.add(stagedPath{dest: path, mode: mode})
+}
+
+// commitStaged moves the staged files and directories to their
+// destinations, recording each in the ledger once it is in place. If a move
+// fails, the ledger holds exactly the paths committed before it, so a
+// rollback undoes them.
+func (i *Installer) commitStaged(recorder *ledger.Recorder) error {
+	for _, p := range i.stage.paths {
+		if p.preexisting {
+			if err := recorder.RecordFilePreexisting(p.dest); err != nil {
+				return fmt.Errorf("record file create: %w", err)
+			}
+			continue
+		}
+		if p.staged == "" {
+			created, err := mkdirAllRecording(p.dest, p.mode)
+			if err != nil {
+				return err
+			}
+			for _, dir := range created {
+				if err := recorder.RecordDirCreate(dir); err != nil {
+					return fmt.Errorf("record dir create: %w", err)
+				}
+			}
+			continue
+		}
+
+		destDir := filepath.Dir(p.dest)
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return fmt.Errorf("create directory %s: %w", destDir, err)
+		}
+		orig, err := recorder.PrepareOverwrite(p.dest)
+		if err != nil {
+			return fmt.Errorf("prepare overwrite: %w", err)
+		}
+		if err := moveFile(p.staged, p.dest, p.mode); err != nil {
+			return fmt.Errorf("commit %s: %w", p.dest, err)
+		}
+		if err := recordWrite(recorder, p.dest, orig, p.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dest. When they are on different filesystems, src
+// is copied to a temporary file beside dest which is then renamed, so dest
+// is never left half written.
+func moveFile(src, dest string, mode os.FileMode) error {
+	err := os.Rename(src, dest)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	tmp := dest + ".alloy-tmp"
+	if err := copyFile(src, tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// existingAncestor returns path, or its closest parent that exists.
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// createStage creates the staging directory for an installation of
+// pkgDef, first removing any that interrupted installations of it left
+// behind. A relative StagingDir is resolved against the package's prefix,
+// or the closest parent of it that exists: creating the prefix here would
+// leave it unrecorded.
+func (i *Installer) createStage(pkgDef *pkg.Package) (string, error) {
+	parent := i.StagingDir
+	if !filepath.IsAbs(parent) {
+		parent = filepath.Join(existingAncestor(pkgDef.ExpandedPaths().Prefix), parent)
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return "", err
+		}
+	}
+	i.removeStaleStages(parent, pkgDef.Name)
+	return os.MkdirTemp(parent, stagePrefix(pkgDef.Name))
+}
+
+// stagePrefix is the name prefix of the staging directories of a package's
+// installations.
+func stagePrefix(name string) string {
+	return ".alloy-stage-" + name + "-"
+}
+
+// removeStaleStages removes the staging directories in parent that
+// installations of the package name left behind when they were
+// interrupted before cleaning up.
+func (i *Installer) removeStaleStages(parent, name string) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		// The suffix os.MkdirTemp adds is all digits, which tells these
+		// apart from those of a package whose name has name- as a prefix
+		suffix, ok := strings.CutPrefix(entry.Name(), stagePrefix(name))
+		if !ok || !entry.IsDir() || strings.Trim(suffix, "0123456789") != "" {
+			continue
+		}
+		path := filepath.Join(parent, entry.Name())
+		i.progress("Removing the staging directory %s left by an interrupted install", path)
+		if err := os.RemoveAll(path); err != nil {
+			i.progress("Warning: could not remove %s: %v", path, err)
+		}
+	}
+}
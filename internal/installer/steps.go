@@ -1,12 +1,21 @@
 package installer
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/anthropics/alloy/internal/ledger"
 	"github.com/anthropics/alloy/internal/pkg"
@@ -14,6 +23,10 @@ import (
 
 // executeStep executes a single install step and records it to the ledger.
 func (i *Installer) executeStep(step pkg.InstallStep, srcDir string, recorder *ledger.Recorder) error {
+	if !i.deadline.IsZero() && time.Now().After(i.deadline) {
+		return fmt.Errorf("install time limit exceeded: %w", context.DeadlineExceeded)
+	}
+
 	switch step.Type {
 	case pkg.StepRun:
 		return i.executeRun(step, srcDir)
@@ -23,6 +36,12 @@ func (i *Installer) executeStep(step pkg.InstallStep, srcDir string, recorder *l
 		return i.executeMkdir(step, recorder)
 	case pkg.StepSymlink:
 		return i.executeSymlink(step, recorder)
+	case pkg.StepTemplate:
+		return i.executeTemplate(step, srcDir, recorder)
+	case pkg.StepPlugin:
+		return i.executePlugin(step, srcDir, recorder)
+	case pkg.StepAppend:
+		return i.executeAppend(step, recorder)
 	default:
 		return fmt.Errorf("unknown step type: %s", step.Type)
 	}
@@ -31,22 +50,134 @@ func (i *Installer) executeStep(step pkg.InstallStep, srcDir string, recorder *l
 // executeRun executes a shell command.
 func (i *Installer) executeRun(step pkg.InstallStep, srcDir string) error {
 	workDir := srcDir
-	if step.WorkDir != "" {
+	if filepath.IsAbs(step.WorkDir) {
+		workDir = step.WorkDir
+	} else if step.WorkDir != "" {
 		workDir = filepath.Join(srcDir, step.WorkDir)
 	}
 
-	cmd := exec.Command("sh", "-c", step.Command)
+	if step.WorkDirCreate {
+		if err := i.createWorkDir(workDir, srcDir); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel, err := i.runContext(step)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// The context kills the command and everything it started once the
+	// step's timeout or the install deadline passes
+	cmd := exec.CommandContext(ctx, "sh", "-c", step.Command)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = runWaitDelay
 	cmd.Dir = workDir
-	cmd.Stdout = os.Stdout
+	cmd.Env = runEnv(step, i.ExtraEnv)
+	cmd.Stdout = i.commandOutput()
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("command %q timed out: %w", step.Command, ctx.Err())
+		}
 		return fmt.Errorf("command failed: %w", err)
 	}
 
 	return nil
 }
 
+// runWaitDelay is how long a killed run step's output may stay open, e.g.
+// held by a process that left its process group, before Wait stops waiting
+// for it.
+const runWaitDelay = 5 * time.Second
+
+// runContext returns a context that expires at the earlier of the step's
+// timeout and the install deadline.
+func (i *Installer) runContext(step pkg.InstallStep) (context.Context, context.CancelFunc, error) {
+	ctx := context.Background()
+	var cancels []context.CancelFunc
+
+	if step.Timeout != "" {
+		timeout, err := time.ParseDuration(step.Timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid timeout %q: %w", step.Timeout, err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		cancels = append(cancels, cancel)
+	}
+	if !i.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, i.deadline)
+		cancels = append(cancels, cancel)
+	}
+
+	return ctx, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}, nil
+}
+
+// createWorkDir creates a run step's missing working directory. Directories
+// created outside srcDir are remembered so removeWorkDirs can clean them up;
+// those inside it go away with the source tree.
+func (i *Installer) createWorkDir(workDir, srcDir string) error {
+	created, err := mkdirAllRecording(workDir, 0755)
+	if err != nil {
+		return fmt.Errorf("create workdir: %w", err)
+	}
+	if len(created) > 0 && !strings.HasPrefix(workDir, srcDir+string(filepath.Separator)) {
+		// The first created directory is the topmost one
+		i.workDirs = append(i.workDirs, created[0])
+	}
+	return nil
+}
+
+// removeWorkDirs removes the working directories created by run steps.
+func (i *Installer) removeWorkDirs() {
+	for _, dir := range i.workDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			i.progress("Warning: remove workdir %s: %v", dir, err)
+		}
+	}
+	i.workDirs = nil
+}
+
+// baseEnv lists the parent environment variables every run step receives
+// unless ClearEnv is set.
+var baseEnv = []string{"PATH", "HOME", "TERM"}
+
+// runEnv builds the environment for a run step. Commands get a minimal set
+// of parent variables plus any the step inherits, the package variables as
+// ALLOY_<NAME> (e.g. ALLOY_PREFIX), the step's Env, and finally extra. With
+// ClearEnv only Env and extra are passed.
+func runEnv(step pkg.InstallStep, extra map[string]string) []string {
+	env := make(map[string]string)
+
+	if !step.ClearEnv {
+		for _, name := range slices.Concat(baseEnv, step.InheritEnv) {
+			if v, ok := os.LookupEnv(name); ok {
+				env[name] = v
+			}
+		}
+		for k, v := range step.Vars {
+			env["ALLOY_"+strings.ToUpper(k)] = v
+		}
+	}
+	maps.Copy(env, step.Env)
+	maps.Copy(env, extra)
+
+	// Non-nil even when empty, as a nil Env inherits everything
+	list := make([]string, 0, len(env))
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		list = append(list, k+"="+env[k])
+	}
+	return list
+}
+
 // executeCopy copies a file from source to destination.
 func (i *Installer) executeCopy(step pkg.InstallStep, srcDir string, recorder *ledger.Recorder) error {
 	src := filepath.Join(srcDir, step.Src)
@@ -55,24 +186,42 @@ func (i *Installer) executeCopy(step pkg.InstallStep, srcDir string, recorder *l
 	// Determine file mode
 	mode := os.FileMode(0644)
 	if step.Mode != "" {
-		parsed, err := strconv.ParseUint(step.Mode, 8, 32)
+		parsed, err := parseMode(step.Mode)
 		if err != nil {
-			return fmt.Errorf("invalid mode %q: %w", step.Mode, err)
+			return err
 		}
-		mode = os.FileMode(parsed)
+		mode = parsed
 	} else {
-		// Preserve source mode
+		// Preserve source mode, setuid and setgid bits included
 		if info, err := os.Stat(src); err == nil {
-			mode = info.Mode().Perm()
+			mode = info.Mode() & ledger.ModeMask
 		}
 	}
 
+	if i.stage != nil {
+		return i.stageCopy(src, dest, mode)
+	}
+
 	// Ensure destination directory exists
 	destDir := filepath.Dir(dest)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return fmt.Errorf("create directory %s: %w", destDir, err)
 	}
 
+	// An identical file is left alone: there is nothing to back up, and
+	// removing the package must not delete a file it didn't put there. It is
+	// still recorded, as the package owns it.
+	identical, err := sameFile(src, dest, mode)
+	if err != nil {
+		return err
+	}
+	if identical {
+		if i.Verbose {
+			i.progress("  %s is already up to date", dest)
+		}
+		return recorder.RecordFilePreexisting(dest)
+	}
+
 	// Check if destination already exists
 	orig, err := recorder.PrepareOverwrite(dest)
 	if err != nil {
@@ -84,6 +233,98 @@ func (i *Installer) executeCopy(step pkg.InstallStep, srcDir string, recorder *l
 		return err
 	}
 
+	return recordWrite(recorder, dest, orig, mode)
+}
+
+// sameFile reports whether dest is a regular file with the same content as
+// src and the given mode.
+func sameFile(src, dest string, mode os.FileMode) (bool, error) {
+	info, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat destination: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode()&ledger.ModeMask != mode {
+		return false, nil
+	}
+
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false, fmt.Errorf("stat source: %w", err)
+	}
+	if srcInfo.Size() != info.Size() {
+		return false, nil
+	}
+
+	srcSum, err := ledger.Checksum(src)
+	if err != nil {
+		return false, fmt.Errorf("checksum source: %w", err)
+	}
+	destSum, err := ledger.Checksum(dest)
+	if err != nil {
+		return false, fmt.Errorf("checksum destination: %w", err)
+	}
+	return srcSum == destSum, nil
+}
+
+// executeTemplate renders a text/template from the source to its
+// destination. The template sees the step's variables as fields, e.g.
+// {{.prefix}} or {{.version}}.
+func (i *Installer) executeTemplate(step pkg.InstallStep, srcDir string, recorder *ledger.Recorder) error {
+	src := filepath.Join(srcDir, step.Src)
+	dest := step.Dest
+
+	mode := os.FileMode(0644)
+	if step.Mode != "" {
+		parsed, err := parseMode(step.Mode)
+		if err != nil {
+			return err
+		}
+		mode = parsed
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("read template: %w", err)
+	}
+
+	// Render fully before touching the destination so a bad template
+	// leaves nothing behind
+	tmpl, err := template.New(step.Src).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, step.Vars); err != nil {
+		return fmt.Errorf("render template: %w", err)
+	}
+
+	destDir := filepath.Dir(dest)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("create directory %s: %w", destDir, err)
+	}
+
+	orig, err := recorder.PrepareOverwrite(dest)
+	if err != nil {
+		return fmt.Errorf("prepare overwrite: %w", err)
+	}
+
+	if err := os.WriteFile(dest, buf.Bytes(), mode); err != nil {
+		return fmt.Errorf("write %s: %w", dest, err)
+	}
+	// WriteFile leaves the mode of an existing file alone
+	if err := os.Chmod(dest, mode); err != nil {
+		return fmt.Errorf("chmod %s: %w", dest, err)
+	}
+
+	return recordWrite(recorder, dest, orig, mode)
+}
+
+// recordWrite records a file written to dest, as an overwrite if orig
+// describes a file that was there before.
+func recordWrite(recorder *ledger.Recorder, dest string, orig *ledger.OriginalFile, mode os.FileMode) error {
 	// Compute checksum of new file
 	checksum, err := ledger.Checksum(dest)
 	if err != nil {
@@ -105,6 +346,80 @@ func (i *Installer) executeCopy(step pkg.InstallStep, srcDir string, recorder *l
 	return recorder.RecordFileCreate(dest)
 }
 
+// parseMode parses an octal file mode such as "0755" or "4755".
+func parseMode(s string) (os.FileMode, error) {
+	parsed, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q: %w", s, err)
+	}
+	return ledger.FileMode(uint32(parsed)), nil
+}
+
+// executeAppend appends a step's content to the end of its destination,
+// such as a line sourcing completions in a shell rc file. Only the appended
+// data is recorded, so removal takes it out again and leaves the rest of the
+// file alone. A missing destination is created like a copied file.
+func (i *Installer) executeAppend(step pkg.InstallStep, recorder *ledger.Recorder) error {
+	dest := step.Dest
+	content := step.Content
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	existing, err := os.ReadFile(dest)
+	if os.IsNotExist(err) {
+		mode := os.FileMode(0644)
+		if step.Mode != "" {
+			if mode, err = parseMode(step.Mode); err != nil {
+				return err
+			}
+		}
+		destDir := filepath.Dir(dest)
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return fmt.Errorf("create directory %s: %w", destDir, err)
+		}
+		if err := os.WriteFile(dest, []byte(content), mode); err != nil {
+			return fmt.Errorf("write %s: %w", dest, err)
+		}
+		return recorder.RecordFileCreate(dest)
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", dest, err)
+	}
+
+	// Content that is already there, e.g. added by hand, is left alone and
+	// unrecorded so removal doesn't take it away
+	if bytes.Contains(existing, []byte(content)) {
+		if i.Verbose {
+			i.progress("  %s already contains the content", dest)
+		}
+		return nil
+	}
+
+	// Start on a new line. The newline ends the file's last line and stays
+	// after removal, so it isn't recorded as appended
+	offset := int64(len(existing))
+	data := []byte(content)
+	if offset > 0 && existing[offset-1] != '\n' {
+		data = append([]byte("\n"), data...)
+		offset++
+	}
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dest, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("append to %s: %w", dest, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", dest, err)
+	}
+
+	return recorder.RecordFileAppend(dest, offset, []byte(content))
+}
+
 // executeMkdir creates a directory.
 func (i *Installer) executeMkdir(step pkg.InstallStep, recorder *ledger.Recorder) error {
 	path := step.Path
@@ -119,6 +434,11 @@ func (i *Installer) executeMkdir(step pkg.InstallStep, recorder *ledger.Recorder
 		mode = os.FileMode(parsed)
 	}
 
+	if i.stage != nil {
+		i.stageMkdir(path, mode)
+		return nil
+	}
+
 	// Check if directory already exists
 	if info, err := os.Stat(path); err == nil && info.IsDir() {
 		// Directory already exists, nothing to do
@@ -148,6 +468,14 @@ func (i *Installer) executeSymlink(step pkg.InstallStep, recorder *ledger.Record
 	target := step.Src
 	linkPath := step.Dest
 
+	if step.Relative && filepath.IsAbs(target) {
+		rel, err := filepath.Rel(filepath.Dir(linkPath), target)
+		if err != nil {
+			return fmt.Errorf("relative target: %w", err)
+		}
+		target = rel
+	}
+
 	// Ensure parent directory exists
 	linkDir := filepath.Dir(linkPath)
 	if err := os.MkdirAll(linkDir, 0755); err != nil {
@@ -180,7 +508,9 @@ func (i *Installer) executeSymlink(step pkg.InstallStep, recorder *ledger.Record
 	return recorder.RecordSymlinkCreate(linkPath, target)
 }
 
-// copyFile copies a file from src to dest with the given mode.
+// copyFile copies a file from src to dest with the given mode. A read-only
+// file at dest is made writable, or replaced if that isn't allowed, so
+// callers must have backed it up first.
 func copyFile(src, dest string, mode os.FileMode) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -188,7 +518,7 @@ func copyFile(src, dest string, mode os.FileMode) error {
 	}
 	defer srcFile.Close()
 
-	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	destFile, err := openDestination(dest, mode)
 	if err != nil {
 		return fmt.Errorf("create destination: %w", err)
 	}
@@ -215,6 +545,32 @@ func copyFile(src, dest string, mode os.FileMode) error {
 	return nil
 }
 
+// openDestination opens dest for writing, truncating it. An existing file
+// that can't be opened because it is read-only, such as one with mode 0444,
+// is first made writable by its owner, or removed if it can't be changed.
+// The caller sets the final mode once it has written the file.
+func openDestination(dest string, mode os.FileMode) (*os.File, error) {
+	const flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	f, err := os.OpenFile(dest, flags, mode)
+	if !errors.Is(err, fs.ErrPermission) {
+		return f, err
+	}
+	info, statErr := os.Lstat(dest)
+	if statErr != nil || !info.Mode().IsRegular() {
+		return nil, err
+	}
+
+	if os.Chmod(dest, info.Mode().Perm()|0200) == nil {
+		if f, err := os.OpenFile(dest, flags, mode); err == nil {
+			return f, nil
+		}
+	}
+	if rmErr := os.Remove(dest); rmErr != nil {
+		return nil, err
+	}
+	return os.OpenFile(dest, flags, mode)
+}
+
 // mkdirAllRecording creates a directory and all parents, returning the list
 // of directories that were actually created (in order from parent to child).
 func mkdirAllRecording(path string, mode os.FileMode) ([]string, error) {
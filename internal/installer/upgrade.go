@@ -0,0 +1,174 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// Upgrade reinstalls an installed package if its source has changed.
+// Pinned packages are left alone. If installing the new version fails, the
+// current one is put back.
+//
+// Versioned sources are compared by location and checksum. Git sources have no
+// meaningful version, so the commit recorded at install time is compared
+// against the remote's current commit for the configured ref, or the one the
+// package was installed at with GitRef.
+func (i *Installer) Upgrade(name string) error {
+	pkgDef, err := i.LoadPackage(name)
+	if err != nil {
+		return fmt.Errorf("load package: %w", err)
+	}
+
+	if !ledger.Exists(i.LedgerDir, name) {
+		return fmt.Errorf("package %q is not installed", name)
+	}
+
+	ledg, err := ledger.Open(i.LedgerDir, name)
+	if err != nil {
+		return fmt.Errorf("open ledger: %w", err)
+	}
+
+	if ledg.Header.Pinned {
+		i.progress("%s is pinned at %s, skipping (run 'alloy unpin %s' to allow upgrades)", name, ledg.Header.PackageVersion, name)
+		return nil
+	}
+
+	// A package installed at another ref keeps following it, unless its
+	// definition no longer has a git source
+	ref := i.GitRef
+	if ref == "" && pkgDef.Source.SourceType() == "git" {
+		ref = ledg.Header.SourceRef
+	}
+	if err := overrideGitRef(pkgDef, ref); err != nil {
+		return err
+	}
+
+	upToDate, err := i.isUpToDate(pkgDef, ledg.Header)
+	if err != nil {
+		return err
+	}
+	if upToDate && !i.Force {
+		i.progress("%s is already up to date", name)
+		return nil
+	}
+
+	if v := ledg.Header.PackageVersion; v != pkgDef.Version {
+		i.showReleaseNotes(pkgDef, v)
+	}
+
+	// Refuse before removing anything, so a conflict leaves the current
+	// installation in place
+	if err := i.checkConflicts(pkgDef); err != nil {
+		return err
+	}
+
+	if i.DryRun {
+		i.progress("[dry-run] Would upgrade %s to %s", name, pkgDef.Version)
+		return nil
+	}
+
+	// Install fetches the new version before removing the current one, and
+	// restores it if the new one fails. Why the package was installed is
+	// kept across the reinstall.
+	inst := *i
+	inst.Reinstall = true
+	inst.upgrading = true
+	inst.installReason = ledg.Header.InstallReason
+	inst.requestedBy = ledg.Header.RequestedBy
+	inst.GitRef = ref
+	return inst.Install(name)
+}
+
+// showReleaseNotes reports the release notes and changelog link of the
+// version being upgraded to, if the package has any.
+func (i *Installer) showReleaseNotes(pkgDef *pkg.Package, from string) {
+	if pkgDef.Notes == "" && pkgDef.ChangelogURL == "" {
+		return
+	}
+	if from == "" {
+		from = "the installed version"
+	}
+	i.progress("What's new in %s %s (upgrading from %s):", pkgDef.Name, pkgDef.Version, from)
+	if pkgDef.Notes != "" {
+		for _, line := range strings.Split(strings.TrimRight(pkgDef.Notes, "\n"), "\n") {
+			i.progress("  %s", line)
+		}
+	}
+	if pkgDef.ChangelogURL != "" {
+		i.progress("  Changelog: %s", pkgDef.ChangelogURL)
+	}
+}
+
+// isUpToDate reports whether the installed package matches its definition.
+func (i *Installer) isUpToDate(pkgDef *pkg.Package, header ledger.Header) (bool, error) {
+	source := pkgDef.ExpandedSource()
+
+	if source.SourceType() == "path" {
+		// A local directory has no version to compare and is usually
+		// being edited, so always reinstall from it.
+		return false, nil
+	}
+
+	if source.SourceType() == "git" {
+		i.progress("Checking %s for updates", source.Git)
+		head, err := gitRemoteHead(source.Git, source.Ref)
+		if err != nil {
+			return false, fmt.Errorf("check remote: %w", err)
+		}
+		return header.SourceChecksum != "" && head == header.SourceChecksum, nil
+	}
+
+	return source.Location() == header.Source && source.Checksum() == header.SourceChecksum, nil
+}
+
+// OutdatedPackage is an installed package whose definition has a newer
+// version.
+type OutdatedPackage struct {
+	Name      string `json:"name"`
+	Installed string `json:"installed"`
+	Available string `json:"available"`
+
+	// Pinned is true if the package is pinned, so upgrades skip it.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// Outdated compares the version of every installed package with the one in
+// its definition, from PackagesDir or a remote, without touching the
+// network. It returns the packages with a newer version available and,
+// separately, those whose definition can't be found or read. Ledgers that
+// can't be read are left to doctor.
+func (i *Installer) Outdated() ([]OutdatedPackage, []string, error) {
+	names, err := ledger.List(i.LedgerDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var outdated []OutdatedPackage
+	var unavailable []string
+	for _, name := range names {
+		stream, err := ledger.OpenStream(i.LedgerDir, name)
+		if err != nil {
+			continue
+		}
+		header := stream.Header()
+		stream.Close()
+
+		pkgDef, err := i.LoadPackage(name)
+		if err != nil {
+			unavailable = append(unavailable, name)
+			continue
+		}
+		if pkg.CompareVersions(pkgDef.Version, header.PackageVersion) > 0 {
+			outdated = append(outdated, OutdatedPackage{
+				Name:      name,
+				Installed: header.PackageVersion,
+				Available: pkgDef.Version,
+				Pinned:    header.Pinned,
+			})
+		}
+	}
+	return outdated, unavailable, nil
+}
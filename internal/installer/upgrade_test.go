@@ -0,0 +1,432 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/alloy/internal/ledger"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+func TestIsUpToDateURL(t *testing.T) {
+	pkgDef := &pkg.Package{
+		Name:    "test-pkg",
+		Version: "1.0.0",
+		Source: pkg.Source{
+			URL:    "https://example.com/test-{{version}}.tar.gz",
+			SHA256: "abc123",
+		},
+	}
+
+	inst := &Installer{}
+
+	upToDate, err := inst.isUpToDate(pkgDef, ledger.Header{
+		Source:         "https://example.com/test-1.0.0.tar.gz",
+		SourceChecksum: "abc123",
+	})
+	if err != nil {
+		t.Fatalf("isUpToDate: %v", err)
+	}
+	if !upToDate {
+		t.Error("expected package to be up to date")
+	}
+
+	pkgDef.Version = "1.1.0"
+	upToDate, err = inst.isUpToDate(pkgDef, ledger.Header{
+		Source:         "https://example.com/test-1.0.0.tar.gz",
+		SourceChecksum: "abc123",
+	})
+	if err != nil {
+		t.Fatalf("isUpToDate: %v", err)
+	}
+	if upToDate {
+		t.Error("expected package with new version to need upgrade")
+	}
+}
+
+func TestUpgradeShowsReleaseNotes(t *testing.T) {
+	ledgerDir := t.TempDir()
+	ledg, err := ledger.CreateWithHeader(ledgerDir, ledger.Header{
+		Package:        "test-pkg",
+		PackageVersion: "1.0.0",
+		Source:         "https://example.com/test-1.0.0.tar.gz",
+		SourceChecksum: "abc123",
+	})
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	ledg.MarkComplete()
+	ledg.Close()
+
+	packagesDir := t.TempDir()
+	def := `
+name = "test-pkg"
+version = "1.1.0"
+notes = """
+Faster startup.
+Fixes a crash on empty input."""
+changelog_url = "https://example.com/CHANGELOG.md"
+
+[source]
+url = "https://example.com/test-{{version}}.tar.gz"
+sha256 = "def456"
+
+[[install_steps]]
+type = "mkdir"
+path = "{{datadir}}"
+`
+	if err := os.WriteFile(filepath.Join(packagesDir, "test-pkg.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	var output []string
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   ledgerDir,
+		DryRun:      true,
+		OnProgress:  func(msg string) { output = append(output, msg) },
+	}
+	if err := inst.Upgrade("test-pkg"); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+
+	got := strings.Join(output, "\n")
+	for _, want := range []string{
+		"What's new in test-pkg 1.1.0 (upgrading from 1.0.0):",
+		"  Faster startup.\n  Fixes a crash on empty input.",
+		"  Changelog: https://example.com/CHANGELOG.md",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestUpgradeSkipsPinned(t *testing.T) {
+	ledgerDir := t.TempDir()
+	ledg, err := ledger.CreateWithHeader(ledgerDir, ledger.Header{
+		Package:        "test-pkg",
+		PackageVersion: "1.0.0",
+		Source:         "https://example.com/test-1.0.0.tar.gz",
+		Pinned:         true,
+	})
+	if err != nil {
+		t.Fatalf("create ledger: %v", err)
+	}
+	ledg.MarkComplete()
+	ledg.Close()
+
+	packagesDir := t.TempDir()
+	def := `
+name = "test-pkg"
+version = "1.1.0"
+
+[source]
+url = "https://example.com/test-{{version}}.tar.gz"
+sha256 = "def456"
+
+[[install_steps]]
+type = "mkdir"
+path = "{{datadir}}"
+`
+	if err := os.WriteFile(filepath.Join(packagesDir, "test-pkg.toml"), []byte(def), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+
+	var output []string
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   ledgerDir,
+		Force:       true,
+		OnProgress:  func(msg string) { output = append(output, msg) },
+	}
+	// The source is unreachable, so any attempt to upgrade would fail
+	if err := inst.Upgrade("test-pkg"); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	if got := strings.Join(output, "\n"); !strings.Contains(got, "test-pkg is pinned at 1.0.0") {
+		t.Errorf("output should say the package is pinned:\n%s", got)
+	}
+	if ledg, err := ledger.Open(ledgerDir, "test-pkg"); err != nil || ledg.Header.PackageVersion != "1.0.0" {
+		t.Errorf("pinned package should stay at 1.0.0 (err %v)", err)
+	}
+}
+
+func TestUpgradeFailureRestoresPrevious(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "tool"), []byte("v1"), 0755)
+
+	prefix := t.TempDir()
+	conf := filepath.Join(prefix, "etc", "tool.conf")
+	os.MkdirAll(filepath.Dir(conf), 0755)
+	os.WriteFile(conf, []byte("system config"), 0644)
+
+	packagesDir := t.TempDir()
+	writeDef := func(version, extra string) {
+		t.Helper()
+		def := fmt.Sprintf(`
+name = "tool"
+version = %q
+
+[source]
+path = %q
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{prefix}}/etc/tool.conf"
+%s`, version, src, prefix, extra)
+		if err := os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+	writeDef("1.0.0", "")
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+	}
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	// The new version fails after replacing both files
+	os.WriteFile(filepath.Join(src, "tool"), []byte("v2"), 0755)
+	writeDef("2.0.0", `
+[[install_steps]]
+type = "run"
+command = "exit 1"
+`)
+	if err := inst.Upgrade("tool"); err == nil {
+		t.Fatal("Upgrade with a failing step should fail")
+	}
+
+	ledg, err := ledger.Open(inst.LedgerDir, "tool")
+	if err != nil || ledg.Header.PackageVersion != "1.0.0" {
+		t.Fatalf("after a failed upgrade the ledger should be back at 1.0.0 (err %v)", err)
+	}
+	for _, path := range []string{filepath.Join(prefix, "bin", "tool"), conf} {
+		if data, _ := os.ReadFile(path); string(data) != "v1" {
+			t.Errorf("%s = %q, want the previous version's v1", path, data)
+		}
+	}
+
+	// The previous installation's backup survived, so removing it still
+	// restores the original
+	result, err := ledger.ReverseReplay(ledg, ledger.ReplayOptions{})
+	if err != nil || result.HasErrors() {
+		t.Fatalf("ReverseReplay: %v %+v", err, result)
+	}
+	if data, _ := os.ReadFile(conf); string(data) != "system config" {
+		t.Errorf("tool.conf = %q, want the restored original", data)
+	}
+}
+
+func TestOutdated(t *testing.T) {
+	ledgerDir := t.TempDir()
+	packagesDir := t.TempDir()
+	remotesDir := t.TempDir()
+	for _, p := range []struct {
+		name, installed, available, dir string
+		pinned                          bool
+	}{
+		{"newer", "1.2.0", "1.10.0", packagesDir, false},
+		{"current", "2.0.0", "2.0.0", packagesDir, false},
+		{"older", "3.0.0", "3.0.0-rc.1", packagesDir, false},
+		{"pinned", "1.0.0", "1.1.0", packagesDir, true},
+		{"from-remote", "0.9.0", "1.0.0", filepath.Join(remotesDir, "main", "packages"), false},
+		{"gone", "1.0.0", "", "", false},
+	} {
+		ledg, err := ledger.CreateWithHeader(ledgerDir, ledger.Header{Package: p.name, PackageVersion: p.installed, Pinned: p.pinned})
+		if err != nil {
+			t.Fatalf("create ledger: %v", err)
+		}
+		ledg.MarkComplete()
+		ledg.Close()
+		if p.dir == "" {
+			continue
+		}
+		def := fmt.Sprintf("name = %q\nversion = %q\n[source]\ngit = \"https://example.com/%s.git\"\n[[install_steps]]\ntype = \"mkdir\"\npath = \"/tmp\"\n", p.name, p.available, p.name)
+		os.MkdirAll(p.dir, 0755)
+		if err := os.WriteFile(filepath.Join(p.dir, p.name+".toml"), []byte(def), 0644); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+
+	inst := &Installer{PackagesDir: packagesDir, LedgerDir: ledgerDir, RemotesDir: remotesDir, Remotes: []string{"main"}}
+	outdated, unavailable, err := inst.Outdated()
+	if err != nil {
+		t.Fatalf("Outdated: %v", err)
+	}
+	want := []OutdatedPackage{
+		{Name: "from-remote", Installed: "0.9.0", Available: "1.0.0"},
+		{Name: "newer", Installed: "1.2.0", Available: "1.10.0"},
+		{Name: "pinned", Installed: "1.0.0", Available: "1.1.0", Pinned: true},
+	}
+	if !slices.Equal(outdated, want) {
+		t.Errorf("outdated = %+v, want %+v", outdated, want)
+	}
+	if !slices.Equal(unavailable, []string{"gone"}) {
+		t.Errorf("unavailable = %v, want [gone]", unavailable)
+	}
+}
+
+func TestGitRemoteHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+
+	local, err := gitHead(repo)
+	if err != nil {
+		t.Fatalf("gitHead: %v", err)
+	}
+
+	remote, err := gitRemoteHead(repo, "")
+	if err != nil {
+		t.Fatalf("gitRemoteHead: %v", err)
+	}
+	if remote != local {
+		t.Errorf("gitRemoteHead = %s, want %s", remote, local)
+	}
+
+	if _, err := gitRemoteHead(repo, "refs/heads/does-not-exist"); err == nil {
+		t.Error("expected error for unknown ref")
+	}
+}
+
+func TestInstallGitRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	commit := func(content string) {
+		t.Helper()
+		os.WriteFile(filepath.Join(repo, "tool"), []byte(content), 0755)
+		git("add", ".")
+		git("commit", "-q", "-m", content)
+	}
+	git("init", "-q", "-b", "main")
+	commit("main")
+	git("checkout", "-q", "-b", "feature")
+	commit("feature")
+	git("checkout", "-q", "main")
+
+	prefix := t.TempDir()
+	packagesDir := t.TempDir()
+	def := fmt.Sprintf(`
+name = "tool"
+version = "1.0.0"
+
+[source]
+git = %q
+ref = "main"
+
+[install_paths]
+prefix = %q
+
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+`, repo, prefix)
+	os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(def), 0644)
+	os.WriteFile(filepath.Join(packagesDir, "archive.toml"), []byte(`
+name = "archive"
+version = "1.0.0"
+
+[source]
+url = "https://example.com/archive.tar.gz"
+sha256 = "abc123"
+
+[[install_steps]]
+type = "run"
+command = "true"
+`), 0644)
+
+	inst := &Installer{
+		PackagesDir: packagesDir,
+		LedgerDir:   t.TempDir(),
+		BackupDir:   t.TempDir(),
+		GitRef:      "feature",
+	}
+	if err := inst.Install("tool"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	installed := filepath.Join(prefix, "bin", "tool")
+	if data, _ := os.ReadFile(installed); string(data) != "feature" {
+		t.Errorf("installed %q, want the feature branch", data)
+	}
+	ledg, err := ledger.Open(inst.LedgerDir, "tool")
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	if ledg.Header.SourceRef != "feature" {
+		t.Errorf("ledger ref = %q, want feature", ledg.Header.SourceRef)
+	}
+
+	// Upgrades follow the installed ref rather than the definition's
+	commit("main 2")
+	git("checkout", "-q", "feature")
+	commit("feature 2")
+	upgrader := &Installer{PackagesDir: packagesDir, LedgerDir: inst.LedgerDir, BackupDir: inst.BackupDir}
+	if err := upgrader.Upgrade("tool"); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	if data, _ := os.ReadFile(installed); string(data) != "feature 2" {
+		t.Errorf("upgraded to %q, want the feature branch", data)
+	}
+
+	if err := inst.Install("archive"); err == nil || !strings.Contains(err.Error(), "not a git one") {
+		t.Errorf("installing a url source at a ref: err = %v", err)
+	}
+
+	// A pinned tree can't match another ref, so that fails before cloning
+	pinned := strings.Replace(def, `ref = "main"`, `ref = "main"`+"\narchive_sha256 = \""+strings.Repeat("0", 64)+`"`, 1)
+	os.WriteFile(filepath.Join(packagesDir, "tool.toml"), []byte(pinned), 0644)
+	inst.Reinstall = true
+	if err := inst.Install("tool"); err == nil || !strings.Contains(err.Error(), "archive_sha256") {
+		t.Errorf("installing a source with archive_sha256 at a ref: err = %v", err)
+	}
+	if data, _ := os.ReadFile(installed); string(data) != "feature 2" {
+		t.Errorf("installed %q after a refused install, want it untouched", data)
+	}
+}
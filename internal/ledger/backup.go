@@ -0,0 +1,212 @@
+package ledger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompressedBackupSuffix is appended to the names of gzip-compressed backups.
+// The rest of the name is still the checksum of the uncompressed content.
+const CompressedBackupSuffix = ".gz"
+
+// IsCompressedBackup reports whether a backup path refers to a compressed backup.
+func IsCompressedBackup(path string) bool {
+	return strings.HasSuffix(path, CompressedBackupSuffix)
+}
+
+// OpenBackup opens a backup file for reading, transparently decompressing
+// it if it was stored compressed.
+func OpenBackup(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsCompressedBackup(path) {
+		return f, nil
+	}
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &backupReader{Reader: gzr, file: f}, nil
+}
+
+// backupReader closes both the gzip stream and the underlying file.
+type backupReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (b *backupReader) Close() error {
+	gzErr := b.Reader.Close()
+	if err := b.file.Close(); err != nil {
+		return err
+	}
+	return gzErr
+}
+
+// ChecksumBackup computes the SHA-256 checksum of a backup's original
+// (uncompressed) content.
+func ChecksumBackup(path string) (string, error) {
+	r, err := OpenBackup(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	return ChecksumReader(r)
+}
+
+// VerifyBackup checks if a backup's uncompressed content matches the expected checksum.
+func VerifyBackup(path, expected string) (bool, error) {
+	actual, err := ChecksumBackup(path)
+	if err != nil {
+		return false, err
+	}
+	return actual == expected, nil
+}
+
+// CollectBackupPaths returns the distinct backup files referenced by a
+// ledger's overwrite and delete entries, in ledger order.
+func CollectBackupPaths(l *Ledger) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, entry := range l.Entries {
+		if entry.Op != OpFileOverwrite && entry.Op != OpFileDelete {
+			continue
+		}
+		if entry.Original == nil || entry.Original.BackupPath == "" {
+			continue
+		}
+		if path := entry.Original.BackupPath; !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// ModifiedBackupPaths returns the distinct backup files referenced by a
+// ledger's overwrite and delete entries for paths, typically the
+// ModifiedFiles of a forced ReverseReplay. Those files weren't restored,
+// so their backups hold the only copy of the originals.
+func ModifiedBackupPaths(l *Ledger, paths []string) []string {
+	modified := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		modified[path] = true
+	}
+	seen := make(map[string]bool)
+	var backups []string
+	for _, entry := range l.Entries {
+		if (entry.Op != OpFileOverwrite && entry.Op != OpFileDelete) || !modified[entry.Path] {
+			continue
+		}
+		if entry.Original == nil || entry.Original.BackupPath == "" {
+			continue
+		}
+		if path := entry.Original.BackupPath; !seen[path] {
+			seen[path] = true
+			backups = append(backups, path)
+		}
+	}
+	return backups
+}
+
+// BackupDirSize returns the total size on disk of the backups stored for a
+// package in backupDir/<pkg>/. A package without backups has size 0.
+func BackupDirSize(backupDir, pkg string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(filepath.Join(backupDir, pkg), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == filepath.Join(backupDir, pkg) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// BackupRepair is the outcome of RepairBackups.
+type BackupRepair struct {
+	// Recreated lists the files whose backups were (or, in a dry run,
+	// would be) re-created.
+	Recreated []string
+
+	// Unrecoverable lists files whose original content is gone: the file
+	// was removed, or no longer matches the checksum of the original.
+	Unrecoverable []string
+}
+
+// RepairBackups re-creates missing backups for a ledger's overwrite and
+// delete entries, including those recorded with --no-backup. A backup can
+// only be re-created while the file at the entry's path still has the
+// original's checksum, e.g. because the package installed identical
+// content or the user restored it, since anything else would save the
+// wrong content as the original. Entries whose backup path changed are
+// rewritten in the ledger. If dryRun is true, nothing is written.
+func RepairBackups(l *Ledger, backupDir string, dryRun bool) (*BackupRepair, error) {
+	result := &BackupRepair{}
+	recorder := NewRecorder(l, backupDir)
+	changed := false
+
+	for i := range l.Entries {
+		entry := &l.Entries[i]
+		if entry.Reverted || (entry.Op != OpFileOverwrite && entry.Op != OpFileDelete) {
+			continue
+		}
+		orig := entry.Original
+		// Symlinks are restored from their target, not a backup
+		if orig == nil || orig.Target != "" || orig.Checksum == "" {
+			continue
+		}
+		if orig.BackupPath != "" {
+			if _, err := os.Stat(orig.BackupPath); err == nil {
+				continue
+			}
+		}
+
+		if match, err := VerifyChecksum(entry.Path, orig.Checksum); err != nil || !match {
+			result.Unrecoverable = append(result.Unrecoverable, entry.Path)
+			continue
+		}
+		result.Recreated = append(result.Recreated, entry.Path)
+		if dryRun {
+			continue
+		}
+
+		recorder.CompressBackups = IsCompressedBackup(orig.BackupPath)
+		backupPath, err := recorder.createBackup(entry.Path, orig.Checksum)
+		if err != nil {
+			return result, fmt.Errorf("back up %s: %w", entry.Path, err)
+		}
+		if backupPath != orig.BackupPath {
+			orig.BackupPath = backupPath
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := l.Rewrite(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package ledger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectBackupPaths(t *testing.T) {
+	l := &Ledger{Entries: []Entry{
+		{Op: OpFileCreate, Path: "/a"},
+		{Op: OpFileOverwrite, Path: "/b", Original: &OriginalFile{BackupPath: "/backups/pkg/111"}},
+		{Op: OpFileDelete, Path: "/c", Original: &OriginalFile{BackupPath: "/backups/pkg/222.gz"}},
+		// Same content as /b, deduplicated to the same backup
+		{Op: OpFileOverwrite, Path: "/d", Original: &OriginalFile{BackupPath: "/backups/pkg/111"}},
+		// Installed with --no-backup
+		{Op: OpFileOverwrite, Path: "/e", Original: &OriginalFile{}},
+	}}
+
+	got := CollectBackupPaths(l)
+	if s := fmt.Sprint(got); s != "[/backups/pkg/111 /backups/pkg/222.gz]" {
+		t.Errorf("CollectBackupPaths = %s", s)
+	}
+
+	got = ModifiedBackupPaths(l, []string{"/a", "/c", "/e"})
+	if s := fmt.Sprint(got); s != "[/backups/pkg/222.gz]" {
+		t.Errorf("ModifiedBackupPaths = %s", s)
+	}
+}
+
+func TestBackupDirSize(t *testing.T) {
+	backupDir := t.TempDir()
+
+	size, err := BackupDirSize(backupDir, "pkg")
+	if err != nil || size != 0 {
+		t.Errorf("BackupDirSize without backups = %d, %v; want 0, nil", size, err)
+	}
+
+	pkgDir := filepath.Join(backupDir, "pkg")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(pkgDir, "111"), make([]byte, 100), 0644)
+	os.WriteFile(filepath.Join(pkgDir, "222.gz"), make([]byte, 20), 0644)
+	// Another package's backups are not counted
+	os.MkdirAll(filepath.Join(backupDir, "other"), 0755)
+	os.WriteFile(filepath.Join(backupDir, "other", "333"), make([]byte, 50), 0644)
+
+	size, err = BackupDirSize(backupDir, "pkg")
+	if err != nil {
+		t.Fatalf("BackupDirSize: %v", err)
+	}
+	if size != 120 {
+		t.Errorf("BackupDirSize = %d, want 120", size)
+	}
+}
+
+func TestRepairBackups(t *testing.T) {
+	ledgerDir := t.TempDir()
+	backupDir := t.TempDir()
+	dir := t.TempDir()
+
+	matching := filepath.Join(dir, "matching")
+	changed := filepath.Join(dir, "changed")
+	restored := filepath.Join(dir, "restored")
+	removed := filepath.Join(dir, "removed")
+	os.WriteFile(matching, []byte("original a"), 0644)
+	os.WriteFile(changed, []byte("installed b"), 0644)
+	os.WriteFile(restored, []byte("original c"), 0644)
+
+	missingBackup := func(content string) *OriginalFile {
+		sum := ChecksumBytes([]byte(content))
+		return &OriginalFile{Checksum: sum, BackupPath: filepath.Join(backupDir, "test-pkg", sum)}
+	}
+
+	l, err := Create(ledgerDir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	l.Record(Entry{Op: OpFileOverwrite, Path: matching, Original: missingBackup("original a")})
+	l.Record(Entry{Op: OpFileOverwrite, Path: changed, Original: missingBackup("original b")})
+	// Recorded with --no-backup
+	l.Record(Entry{Op: OpFileDelete, Path: restored, Original: &OriginalFile{Checksum: ChecksumBytes([]byte("original c"))}})
+	l.Record(Entry{Op: OpFileOverwrite, Path: removed, Original: missingBackup("original d")})
+	l.Close()
+
+	l, err = Open(ledgerDir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	result, err := RepairBackups(l, backupDir, true)
+	if err != nil {
+		t.Fatalf("RepairBackups dry run: %v", err)
+	}
+	if got := fmt.Sprint(result.Recreated); got != fmt.Sprint([]string{matching, restored}) {
+		t.Errorf("Recreated = %s", got)
+	}
+	if got := fmt.Sprint(result.Unrecoverable); got != fmt.Sprint([]string{changed, removed}) {
+		t.Errorf("Unrecoverable = %s", got)
+	}
+	if size, _ := BackupDirSize(backupDir, "test-pkg"); size != 0 {
+		t.Errorf("dry run wrote %d bytes of backups", size)
+	}
+
+	if _, err := RepairBackups(l, backupDir, false); err != nil {
+		t.Fatalf("RepairBackups: %v", err)
+	}
+
+	l, err = Open(ledgerDir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, i := range []int{0, 2} {
+		orig := l.Entries[i].Original
+		if match, err := VerifyBackup(orig.BackupPath, orig.Checksum); err != nil || !match {
+			t.Errorf("backup of %s at %q: match=%v, err=%v", l.Entries[i].Path, orig.BackupPath, match, err)
+		}
+	}
+}
@@ -1,10 +1,18 @@
 package ledger
 
 import (
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // Checksum computes the SHA-256 checksum of a file and returns it as a
@@ -43,3 +51,203 @@ func VerifyChecksum(path, expected string) (bool, error) {
 	}
 	return actual == expected, nil
 }
+
+// ChecksumSHA512 computes the SHA-512 checksum of a file and returns it as a
+// hex-encoded string. Returns an error if the file cannot be read.
+func ChecksumSHA512(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return ChecksumReaderSHA512(f)
+}
+
+// ChecksumReaderSHA512 computes the SHA-512 checksum from a reader.
+func ChecksumReaderSHA512(r io.Reader) (string, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ChecksumBytesSHA512 computes the SHA-512 checksum of a byte slice.
+func ChecksumBytesSHA512(data []byte) string {
+	h := sha512.Sum512(data)
+	return hex.EncodeToString(h[:])
+}
+
+// VerifyChecksumSHA512 checks if a file's current SHA-512 checksum matches
+// the expected value.
+func VerifyChecksumSHA512(path, expected string) (bool, error) {
+	actual, err := ChecksumSHA512(path)
+	if err != nil {
+		return false, err
+	}
+	return actual == expected, nil
+}
+
+// checksumBoth computes the SHA-256 and SHA-512 checksums of a file in a
+// single read.
+func checksumBoth(path string) (sum256, sum512 string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	h256, h512 := sha256.New(), sha512.New()
+	if _, err := io.Copy(io.MultiWriter(h256, h512), f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(h256.Sum(nil)), hex.EncodeToString(h512.Sum(nil)), nil
+}
+
+// VerifyBothChecksums checks a file against both its SHA-256 and SHA-512
+// checksums, reading it once. It returns true only if both match; an empty
+// expected checksum is not checked.
+func VerifyBothChecksums(path, sha256Sum, sha512Sum string) (bool, error) {
+	actual256, actual512, err := checksumBoth(path)
+	if err != nil {
+		return false, err
+	}
+	return (sha256Sum == "" || actual256 == sha256Sum) && (sha512Sum == "" || actual512 == sha512Sum), nil
+}
+
+// Checksum algorithms recognized by ChecksumAlgorithm.
+const (
+	AlgorithmSHA1   = "sha1"
+	AlgorithmSHA256 = "sha256"
+	AlgorithmSHA512 = "sha512"
+)
+
+// ChecksumAlgorithm detects the algorithm of a hex-encoded checksum from
+// its length: 40 digits for SHA-1, 64 for SHA-256 and 128 for SHA-512.
+// Any other length, or a non-hex digit, is an error.
+func ChecksumAlgorithm(checksum string) (string, error) {
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return "", fmt.Errorf("checksum %q is not hex-encoded", checksum)
+	}
+	switch len(checksum) {
+	case sha1.Size * 2:
+		return AlgorithmSHA1, nil
+	case sha256.Size * 2:
+		return AlgorithmSHA256, nil
+	case sha512.Size * 2:
+		return AlgorithmSHA512, nil
+	default:
+		return "", fmt.Errorf("checksum has %d hex digits, which matches no supported algorithm (40 for sha1, 64 for sha256, 128 for sha512)", len(checksum))
+	}
+}
+
+// VerifyAnyChecksum checks a file against a SHA-256 or SHA-512 checksum,
+// telling which from its length with ChecksumAlgorithm. Hex digits may be
+// in either case. SHA-1 checksums and unrecognized lengths are errors.
+func VerifyAnyChecksum(path, checksum string) (bool, error) {
+	algorithm, err := ChecksumAlgorithm(checksum)
+	if err != nil {
+		return false, err
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case AlgorithmSHA256:
+		h = sha256.New()
+	case AlgorithmSHA512:
+		h = sha512.New()
+	default:
+		return false, fmt.Errorf("%s checksums are not accepted; use sha256 or sha512", algorithm)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return strings.EqualFold(hex.EncodeToString(h.Sum(nil)), checksum), nil
+}
+
+// ChecksumDir computes the SHA-256 checksum of every regular file under
+// dir. The returned manifest maps each file's slash-separated path,
+// relative to dir, to its checksum. Symlinks and other special files are
+// skipped.
+func ChecksumDir(dir string) (map[string]string, error) {
+	manifest := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		sum, err := Checksum(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		manifest[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Kinds of ManifestDiscrepancy.
+const (
+	DiscrepancyModified = "modified"
+	DiscrepancyMissing  = "missing"
+	DiscrepancyExtra    = "extra"
+)
+
+// ManifestDiscrepancy describes a file whose state differs from a
+// directory manifest. Expected is empty for extra files and Actual is
+// empty for missing ones.
+type ManifestDiscrepancy struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Kind     string `json:"kind"`
+}
+
+// VerifyManifest compares dir against a manifest produced by ChecksumDir
+// and returns every file that was modified, is missing, or is present but
+// not in the manifest, sorted by path. A directory that matches the
+// manifest returns no discrepancies.
+func VerifyManifest(dir string, manifest map[string]string) ([]ManifestDiscrepancy, error) {
+	actual, err := ChecksumDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var discrepancies []ManifestDiscrepancy
+	for path, expected := range manifest {
+		sum, ok := actual[path]
+		switch {
+		case !ok:
+			discrepancies = append(discrepancies, ManifestDiscrepancy{Path: path, Expected: expected, Kind: DiscrepancyMissing})
+		case !strings.EqualFold(sum, expected):
+			discrepancies = append(discrepancies, ManifestDiscrepancy{Path: path, Expected: expected, Actual: sum, Kind: DiscrepancyModified})
+		}
+	}
+	for path, sum := range actual {
+		if _, ok := manifest[path]; !ok {
+			discrepancies = append(discrepancies, ManifestDiscrepancy{Path: path, Actual: sum, Kind: DiscrepancyExtra})
+		}
+	}
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		return discrepancies[i].Path < discrepancies[j].Path
+	})
+	return discrepancies, nil
+}
@@ -90,3 +90,191 @@ func TestChecksumNonexistent(t *testing.T) {
 		t.Error("expected error for nonexistent file")
 	}
 }
+
+func TestChecksumSHA512(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.txt")
+	content := []byte("Hello, World!")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// echo -n "Hello, World!" | sha512sum
+	expected := "374d794a95cdcfd8b35993185fef9ba368f160d8daf432d08ba9f1ed1e5abe6cc69291e0fa2fe0006a52570ef18c19def4e617c33ce52ef0a6e5fbe318cb0387"
+
+	checksum, err := ChecksumSHA512(path)
+	if err != nil {
+		t.Fatalf("ChecksumSHA512: %v", err)
+	}
+	if checksum != expected {
+		t.Errorf("ChecksumSHA512 = %s, want %s", checksum, expected)
+	}
+	if got := ChecksumBytesSHA512(content); got != expected {
+		t.Errorf("ChecksumBytesSHA512 = %s, want %s", got, expected)
+	}
+
+	match, err := VerifyChecksumSHA512(path, expected)
+	if err != nil {
+		t.Fatalf("VerifyChecksumSHA512: %v", err)
+	}
+	if !match {
+		t.Error("VerifyChecksumSHA512 should match")
+	}
+}
+
+func TestVerifyBothChecksums(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool")
+	content := []byte("Hello, World!")
+	if err := os.WriteFile(path, content, 0755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sum256, sum512 := ChecksumBytes(content), ChecksumBytesSHA512(content)
+
+	got256, got512, err := checksumBoth(path)
+	if err != nil {
+		t.Fatalf("checksumBoth: %v", err)
+	}
+	if got256 != sum256 || got512 != sum512 {
+		t.Errorf("checksumBoth = %s, %s; want %s, %s", got256, got512, sum256, sum512)
+	}
+	if match, err := VerifyBothChecksums(path, sum256, sum512); err != nil || !match {
+		t.Errorf("VerifyBothChecksums = %v, %v; want a match", match, err)
+	}
+	if match, _ := VerifyBothChecksums(path, sum256, ChecksumBytesSHA512([]byte("other"))); match {
+		t.Error("VerifyBothChecksums should fail when only the SHA-256 matches")
+	}
+
+	// Flip a single bit: both checksums must change
+	content[0] ^= 1
+	if err := os.WriteFile(path, content, 0755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if match, _ := VerifyChecksum(path, sum256); match {
+		t.Error("SHA-256 should not match after a bit flip")
+	}
+	if match, _ := VerifyChecksumSHA512(path, sum512); match {
+		t.Error("SHA-512 should not match after a bit flip")
+	}
+	if match, err := VerifyBothChecksums(path, sum256, sum512); err != nil || match {
+		t.Errorf("VerifyBothChecksums after a bit flip = %v, %v; want a mismatch", match, err)
+	}
+}
+
+func TestVerifyAnyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+	content := []byte("Hello, World!")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	for _, checksum := range []string{
+		ChecksumBytes(content),
+		strings.ToUpper(ChecksumBytes(content)),
+		ChecksumBytesSHA512(content),
+	} {
+		match, err := VerifyAnyChecksum(path, checksum)
+		if err != nil || !match {
+			t.Errorf("VerifyAnyChecksum(%s) = %v, %v; want match", checksum, match, err)
+		}
+	}
+
+	for _, checksum := range []string{ChecksumBytes([]byte("other")), ChecksumBytesSHA512([]byte("other"))} {
+		if match, err := VerifyAnyChecksum(path, checksum); err != nil || match {
+			t.Errorf("VerifyAnyChecksum of a different file = %v, %v; want mismatch", match, err)
+		}
+	}
+
+	// SHA-1 is recognized but not accepted, and other lengths aren't recognized
+	sha1sum := "0a0a9f2a6772942557ab5355d76af442f8f65e01"
+	if _, err := VerifyAnyChecksum(path, sha1sum); err == nil || !strings.Contains(err.Error(), "sha1") {
+		t.Errorf("VerifyAnyChecksum(sha1) = %v, want an error naming sha1", err)
+	}
+	for _, checksum := range []string{"abc123", sha1sum + "00", strings.Repeat("z", 64)} {
+		if _, err := VerifyAnyChecksum(path, checksum); err == nil {
+			t.Errorf("VerifyAnyChecksum(%s): expected an error", checksum)
+		}
+	}
+}
+
+func TestChecksumDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bin", "tool"), []byte("tool"), 0755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("readme"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Symlink("bin/tool", filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+
+	manifest, err := ChecksumDir(dir)
+	if err != nil {
+		t.Fatalf("ChecksumDir: %v", err)
+	}
+	want := map[string]string{
+		"bin/tool": ChecksumBytes([]byte("tool")),
+		"README":   ChecksumBytes([]byte("readme")),
+	}
+	if len(manifest) != len(want) {
+		t.Fatalf("ChecksumDir = %v, want %v", manifest, want)
+	}
+	for path, sum := range want {
+		if manifest[path] != sum {
+			t.Errorf("manifest[%s] = %s, want %s", path, manifest[path], sum)
+		}
+	}
+
+	if _, err := ChecksumDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ChecksumDir of a missing directory: expected an error")
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a": "a", "b": "b", "c": "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	manifest, err := ChecksumDir(dir)
+	if err != nil {
+		t.Fatalf("ChecksumDir: %v", err)
+	}
+	if got, err := VerifyManifest(dir, manifest); err != nil || len(got) != 0 {
+		t.Fatalf("VerifyManifest of an unchanged directory = %v, %v; want none", got, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "b")); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "d"), []byte("d"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got, err := VerifyManifest(dir, manifest)
+	if err != nil {
+		t.Fatalf("VerifyManifest: %v", err)
+	}
+	want := []ManifestDiscrepancy{
+		{Path: "a", Expected: manifest["a"], Actual: ChecksumBytes([]byte("changed")), Kind: DiscrepancyModified},
+		{Path: "b", Expected: manifest["b"], Kind: DiscrepancyMissing},
+		{Path: "d", Actual: ChecksumBytes([]byte("d")), Kind: DiscrepancyExtra},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("VerifyManifest = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("discrepancy %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
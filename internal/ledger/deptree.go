@@ -0,0 +1,146 @@
+package ledger
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// BuildDepTree builds the dependency tree of the given installed packages,
+// using pkgLoader to read each package's dependencies. Packages whose
+// definition can no longer be loaded are treated as having none. Packages
+// that only depend on each other in a cycle, so that none is a root, are
+// added at the top level too. The root has no name; its children are the
+// installed packages nothing else requires. Dependencies that aren't
+// installed are marked Missing.
+func BuildDepTree(packages []string, ledgerDir string, pkgLoader func(string) (*pkg.Package, error)) (*pkg.DepNode, error) {
+	loaded := make(map[string]*pkg.Package, len(packages))
+	required := make(map[string]bool)
+	for _, name := range packages {
+		if !Exists(ledgerDir, name) {
+			return nil, fmt.Errorf("package %q is not installed", name)
+		}
+		if p, err := pkgLoader(name); err == nil {
+			loaded[name] = p
+			for _, dep := range p.Dependencies {
+				required[dep] = true
+			}
+		}
+	}
+
+	root := &pkg.DepNode{}
+	seen := make(map[string]bool)
+	var build func(name string, path map[string]bool) *pkg.DepNode
+	build = func(name string, path map[string]bool) *pkg.DepNode {
+		seen[name] = true
+		node := &pkg.DepNode{Name: name, Package: loaded[name]}
+		if path[name] {
+			node.Cycle = true
+			return node
+		}
+		if !Exists(ledgerDir, name) {
+			node.Missing = true
+			return node
+		}
+
+		path[name] = true
+		if p := loaded[name]; p != nil {
+			for _, dep := range p.Dependencies {
+				node.Children = append(node.Children, build(dep, path))
+			}
+		}
+		delete(path, name)
+		return node
+	}
+
+	for _, name := range packages {
+		if !required[name] {
+			root.Children = append(root.Children, build(name, make(map[string]bool)))
+		}
+	}
+	for _, name := range packages {
+		if !seen[name] {
+			root.Children = append(root.Children, build(name, make(map[string]bool)))
+		}
+	}
+	return root, nil
+}
+
+// Unneeded returns the installed packages that were pulled in as
+// dependencies but are no longer required, directly or indirectly, by any
+// explicitly installed package or any package in keep. A package is
+// required if a required package's definition lists it as a dependency or
+// it records a required package in its RequestedBy; the latter keeps
+// dependencies of packages whose definition can no longer be loaded.
+//
+// The packages are in removal order: each comes before the packages it
+// requires, so removing them in turn never leaves one missing a dependency.
+func Unneeded(ledgerDir string, pkgLoader func(string) (*pkg.Package, error), keep ...string) ([]string, error) {
+	packages, err := ListSorted(ledgerDir, SortByName)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]Header, len(packages))
+	for _, name := range packages {
+		s, err := OpenStream(ledgerDir, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		headers[name] = s.Header()
+		s.Close()
+	}
+
+	// requires maps each package to the installed packages it keeps
+	requires := make(map[string][]string)
+	for _, name := range packages {
+		if p, err := pkgLoader(name); err == nil {
+			requires[name] = append(requires[name], p.Dependencies...)
+		}
+		for _, parent := range headers[name].RequestedBy {
+			requires[parent] = append(requires[parent], name)
+		}
+	}
+
+	needed := make(map[string]bool)
+	var mark func(name string)
+	mark = func(name string) {
+		if needed[name] {
+			return
+		}
+		needed[name] = true
+		for _, dep := range requires[name] {
+			if _, ok := headers[dep]; ok {
+				mark(dep)
+			}
+		}
+	}
+	for _, name := range packages {
+		if headers[name].Explicit() || slices.Contains(keep, name) {
+			mark(name)
+		}
+	}
+
+	// Order by depth-first post-order over the requires edges, reversed
+	var order []string
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] || needed[name] {
+			return
+		}
+		visited[name] = true
+		for _, dep := range slices.Sorted(slices.Values(requires[name])) {
+			if _, ok := headers[dep]; ok {
+				visit(dep)
+			}
+		}
+		order = append(order, name)
+	}
+	for _, name := range slices.Backward(packages) {
+		visit(name)
+	}
+	slices.Reverse(order)
+	return order, nil
+}
@@ -0,0 +1,132 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+func TestBuildDepTree(t *testing.T) {
+	dir := t.TempDir()
+	defs := map[string]*pkg.Package{
+		"app":  {Name: "app", Dependencies: []string{"lib", "gone"}},
+		"lib":  {Name: "lib", Dependencies: []string{"base"}},
+		"base": {Name: "base"},
+		"a":    {Name: "a", Dependencies: []string{"b"}},
+		"b":    {Name: "b", Dependencies: []string{"a"}},
+	}
+	for _, name := range []string{"a", "app", "b", "base", "lib"} {
+		l, err := Create(dir, name, "")
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		l.Close()
+	}
+	load := func(name string) (*pkg.Package, error) {
+		if p, ok := defs[name]; ok {
+			return p, nil
+		}
+		return nil, fmt.Errorf("package %q not found", name)
+	}
+
+	root, err := BuildDepTree([]string{"a", "app", "b", "base", "lib"}, dir, load)
+	if err != nil {
+		t.Fatalf("BuildDepTree: %v", err)
+	}
+
+	var got []string
+	var walk func(n *pkg.DepNode, depth int)
+	walk = func(n *pkg.DepNode, depth int) {
+		line := fmt.Sprintf("%d:%s", depth, n.Name)
+		if n.Cycle {
+			line += "*"
+		}
+		if n.Missing {
+			line += "?"
+		}
+		got = append(got, line)
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	for _, c := range root.Children {
+		walk(c, 0)
+	}
+
+	// app is the only root; a and b only require each other
+	want := []string{"0:app", "1:lib", "2:base", "1:gone?", "0:a", "1:b", "2:a*"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("tree = %v, want %v", got, want)
+	}
+
+	dot := root.ToDOT()
+	for _, want := range []string{`"app" -> "lib";`, `"lib" -> "base";`, `"gone" [style=dashed];`, `"b" -> "a";`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("ToDOT missing %s:\n%s", want, dot)
+		}
+	}
+	if strings.Count(dot, `"a" -> "b";`) != 1 {
+		t.Errorf("ToDOT repeats edges:\n%s", dot)
+	}
+
+	if _, err := BuildDepTree([]string{"nope"}, dir, load); err == nil {
+		t.Error("expected error for a package that isn't installed")
+	}
+}
+
+func TestUnneeded(t *testing.T) {
+	dir := t.TempDir()
+	defs := map[string]*pkg.Package{
+		"app":    {Name: "app", Dependencies: []string{"lib"}},
+		"lib":    {Name: "lib", Dependencies: []string{"base"}},
+		"base":   {Name: "base"},
+		"old":    {Name: "old"},
+		"orphan": {Name: "orphan", Dependencies: []string{"leaf"}},
+		"leaf":   {Name: "leaf"},
+	}
+	for _, h := range []Header{
+		{Package: "app", InstallReason: ReasonExplicit},
+		{Package: "lib", InstallReason: ReasonDependency, RequestedBy: []string{"app"}},
+		{Package: "base", InstallReason: ReasonDependency, RequestedBy: []string{"lib"}},
+		// Installed before reasons were recorded
+		{Package: "old"},
+		// Kept by a package whose definition is gone
+		{Package: "kept", InstallReason: ReasonDependency, RequestedBy: []string{"old"}},
+		// Its parent was removed
+		{Package: "orphan", InstallReason: ReasonDependency, RequestedBy: []string{"gone"}},
+		{Package: "leaf", InstallReason: ReasonDependency, RequestedBy: []string{"orphan"}},
+	} {
+		l, err := CreateWithHeader(dir, h)
+		if err != nil {
+			t.Fatalf("CreateWithHeader: %v", err)
+		}
+		l.Close()
+	}
+	load := func(name string) (*pkg.Package, error) {
+		if p, ok := defs[name]; ok {
+			return p, nil
+		}
+		return nil, fmt.Errorf("package %q not found", name)
+	}
+
+	got, err := Unneeded(dir, load)
+	if err != nil {
+		t.Fatalf("Unneeded: %v", err)
+	}
+	// orphan requires leaf, so it is removed first
+	if want := "orphan leaf"; strings.Join(got, " ") != want {
+		t.Errorf("Unneeded = %v, want %s", got, want)
+	}
+
+	// A kept package, such as one that failed to be removed, keeps its
+	// dependencies too
+	got, err = Unneeded(dir, load, "orphan")
+	if err != nil {
+		t.Fatalf("Unneeded: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Unneeded keeping orphan = %v, want none", got)
+	}
+}
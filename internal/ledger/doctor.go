@@ -1,50 +1,183 @@
 package ledger
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // DiagnosticResult represents the result of a diagnostic check.
 type DiagnosticResult struct {
 	// Name is a short description of the check.
-	Name string
+	Name string `json:"name"`
 
 	// Status is the result: "ok", "warning", or "error".
-	Status string
+	Status string `json:"status"`
 
 	// Message provides details about the check result.
-	Message string
+	Message string `json:"message"`
+}
+
+// DoctorReport collects the results of a full health check.
+type DoctorReport struct {
+	// CheckedAt is when the check ran.
+	CheckedAt time.Time `json:"checked_at"`
+
+	// Issues and Warnings count the errors and warnings found.
+	Issues   int `json:"issues"`
+	Warnings int `json:"warnings"`
+
+	Directories  []DiagnosticResult       `json:"directories"`
+	Remotes      []DiagnosticResult       `json:"remotes,omitempty"`
+	Network      []DiagnosticResult       `json:"network,omitempty"`
+	InstallPaths []DiagnosticResult       `json:"install_paths"`
+	Tools        []DiagnosticResult       `json:"tools"`
+	Cache        []DiagnosticResult       `json:"cache"`
+	Shadowing    []DiagnosticResult       `json:"shadowing,omitempty"`
+	Packages     []*LedgerIntegrityResult `json:"packages"`
+
+	// LedgerVersions reports ledgers written in another format version.
+	LedgerVersions []DiagnosticResult `json:"ledger_versions,omitempty"`
+
+	// OrphanedBackups lists backup files no ledger references.
+	OrphanedBackups []string `json:"orphaned_backups,omitempty"`
+
+	// Conflicts maps files to the packages that all claim them.
+	Conflicts map[string][]string `json:"conflicts,omitempty"`
+}
+
+// HasErrors returns true if the check found any errors.
+func (r *DoctorReport) HasErrors() bool {
+	return r.Issues > 0
+}
+
+// HasWarnings returns true if the check found any warnings.
+func (r *DoctorReport) HasWarnings() bool {
+	return r.Warnings > 0
 }
 
 // LedgerIntegrityResult contains the results of checking a single ledger.
 type LedgerIntegrityResult struct {
 	// Package is the name of the package.
-	Package string
+	Package string `json:"package"`
 
 	// ParseError is set if the ledger couldn't be parsed.
-	ParseError error
+	ParseError error `json:"-"`
+
+	// Incomplete is true if the installation was interrupted before it finished.
+	Incomplete bool `json:"incomplete,omitempty"`
 
 	// MissingBackups lists backup files referenced but not found.
-	MissingBackups []string
+	MissingBackups []string `json:"missing_backups,omitempty"`
+
+	// UnbackedFiles lists overwritten or deleted files recorded without a
+	// backup in a ledger that was not installed with --no-backup.
+	UnbackedFiles []string `json:"unbacked_files,omitempty"`
 
 	// OrphanedFiles lists files that should exist but don't.
-	OrphanedFiles []string
+	OrphanedFiles []string `json:"orphaned_files,omitempty"`
 
 	// ModifiedFiles lists files with checksum mismatches.
-	ModifiedFiles []string
+	ModifiedFiles []string `json:"modified_files,omitempty"`
+
+	// DanglingSymlinks lists recorded symlinks whose target no longer exists.
+	DanglingSymlinks []string `json:"dangling_symlinks,omitempty"`
+
+	// BrokenHardLinks lists recorded hard links that no longer share an
+	// inode with their target, or whose target is gone.
+	BrokenHardLinks []string `json:"broken_hard_links,omitempty"`
+
+	// WrongPermissions lists installed files whose permissions no longer
+	// match the ledger, including those FixPermissions restored.
+	WrongPermissions []PermissionMismatch `json:"wrong_permissions,omitempty"`
+
+	// WrongOwnership lists installed files whose owner or group no longer
+	// match the UID and GID recorded in the ledger, annotated with both.
+	WrongOwnership []string `json:"wrong_ownership,omitempty"`
 
 	// EntryCount is the total number of ledger entries.
-	EntryCount int
+	EntryCount int `json:"entry_count"`
+
+	// Suggestions lists actions that may resolve the issues found.
+	Suggestions []RepairSuggestion `json:"suggestions,omitempty"`
+
+	// chowns are the commands restoring the ownership of WrongOwnership,
+	// added to Suggestions by suggestRepairs.
+	chowns []RepairSuggestion
+}
+
+// MarshalJSON encodes the result with ParseError as a string.
+func (r LedgerIntegrityResult) MarshalJSON() ([]byte, error) {
+	type plain LedgerIntegrityResult
+	out := struct {
+		plain
+		ParseError string `json:"parse_error,omitempty"`
+	}{plain: plain(r)}
+	if r.ParseError != nil {
+		out.ParseError = r.ParseError.Error()
+	}
+	return json.Marshal(out)
+}
+
+// PermissionMismatch is an installed file whose permission bits differ from
+// the ones its ledger recorded.
+type PermissionMismatch struct {
+	Path     string      `json:"path"`
+	Expected os.FileMode `json:"expected"`
+	Actual   os.FileMode `json:"actual"`
+
+	// Fixed is true if the recorded permissions were restored.
+	Fixed bool `json:"fixed,omitempty"`
+}
+
+// UnfixedPermissions returns how many of the files with wrong permissions
+// were not restored.
+func (r *LedgerIntegrityResult) UnfixedPermissions() int {
+	n := 0
+	for _, m := range r.WrongPermissions {
+		if !m.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+// RepairSuggestion describes an action the user can take to fix an issue.
+type RepairSuggestion struct {
+	// Description explains what the suggestion fixes.
+	Description string `json:"description"`
+
+	// Command is the alloy command to run.
+	Command string `json:"command"`
+
+	// Automatic is true if the suggestion is safe to apply without
+	// confirmation (e.g., by `alloy doctor --fix`).
+	Automatic bool `json:"automatic"`
 }
 
 // HasIssues returns true if any issues were found.
 func (r *LedgerIntegrityResult) HasIssues() bool {
 	return r.ParseError != nil ||
+		r.Incomplete ||
 		len(r.MissingBackups) > 0 ||
+		len(r.UnbackedFiles) > 0 ||
 		len(r.OrphanedFiles) > 0 ||
-		len(r.ModifiedFiles) > 0
+		len(r.ModifiedFiles) > 0 ||
+		len(r.DanglingSymlinks) > 0 ||
+		len(r.BrokenHardLinks) > 0 ||
+		r.UnfixedPermissions() > 0 ||
+		len(r.WrongOwnership) > 0
 }
 
 // DoctorOptions configures the diagnostic checks.
@@ -55,6 +188,38 @@ type DoctorOptions struct {
 	// CheckFiles enables checking installed files exist and have correct checksums.
 	// This can be slow for packages with many files.
 	CheckFiles bool
+
+	// CheckSymlinks enables checking recorded symlinks still exist and point
+	// to existing targets. It is implied by CheckFiles.
+	CheckSymlinks bool
+
+	// CheckHardLinks enables checking recorded hard links still refer to
+	// the same file as their target. It is implied by CheckFiles.
+	CheckHardLinks bool
+
+	// FixPermissions enables checking installed files still have the
+	// permissions recorded in their ledger, restoring any that changed.
+	// CheckFiles checks permissions too, but only reports mismatches.
+	FixPermissions bool
+
+	// CheckOwnership enables checking installed files are still owned by
+	// the UID and GID recorded in their ledger. Entries recorded as owned by
+	// root (or without ownership) are skipped, as are platforms without
+	// Unix ownership; see OwnershipSupported.
+	CheckOwnership bool
+
+	// DryRun if true with FixPermissions, reports wrong permissions without
+	// changing them.
+	DryRun bool
+
+	// CheckNetwork enables checking the hosts of package sources and
+	// remotes are reachable. It makes network requests, so it is off by
+	// default.
+	CheckNetwork bool
+
+	// CheckShadowing enables checking installed binaries aren't hidden by
+	// files of the same name in directories earlier in PATH.
+	CheckShadowing bool
 }
 
 // CheckDirectoryPermissions checks read/write permissions on the alloy directory.
@@ -179,7 +344,10 @@ func CheckDirectoryPermissions(alloyDir string) []DiagnosticResult {
 
 // CheckLedgerIntegrity checks the integrity of a single package ledger.
 func CheckLedgerIntegrity(ledgerDir, backupDir, pkg string, opts DoctorOptions) *LedgerIntegrityResult {
-	result := &LedgerIntegrityResult{Package: pkg}
+	result := &LedgerIntegrityResult{
+		Package:    pkg,
+		Incomplete: IsInProgress(ledgerDir, pkg),
+	}
 
 	// Try to open and parse the ledger
 	ledg, err := Open(ledgerDir, pkg)
@@ -190,43 +358,86 @@ func CheckLedgerIntegrity(ledgerDir, backupDir, pkg string, opts DoctorOptions)
 
 	result.EntryCount = len(ledg.Entries)
 
+	// Files are checked against the mode of their last entry, as a later
+	// step may have overwritten an earlier one
+	modes := make(map[string]os.FileMode)
+	var modePaths []string
+	owners := make(map[string][2]uint32)
+	var ownerPaths []string
+
+	// Likewise only a path's last create or overwrite says what it should
+	// hold, unless data was appended to it since
+	lastWrite := make(map[string]int)
+	appended := make(map[string]bool)
+	for idx, entry := range ledg.Entries {
+		if entry.Reverted {
+			continue
+		}
+		switch entry.Op {
+		case OpFileCreate, OpFileOverwrite:
+			lastWrite[entry.Path] = idx
+			appended[entry.Path] = false
+		case OpFileAppend:
+			appended[entry.Path] = true
+		}
+	}
+
 	// Check for missing backup files and orphaned installed files
-	for _, entry := range ledg.Entries {
+	for idx, entry := range ledg.Entries {
+		// Reverted entries no longer describe the state on disk
+		if entry.Reverted {
+			continue
+		}
+
+		if (entry.Op == OpFileCreate || entry.Op == OpFileOverwrite) && entry.Mode != 0 {
+			if _, ok := modes[entry.Path]; !ok {
+				modePaths = append(modePaths, entry.Path)
+			}
+			modes[entry.Path] = FileMode(entry.Mode)
+		}
+		if entry.Path != "" && (entry.UID != 0 || entry.GID != 0) {
+			if _, ok := owners[entry.Path]; !ok {
+				ownerPaths = append(ownerPaths, entry.Path)
+			}
+			owners[entry.Path] = [2]uint32{entry.UID, entry.GID}
+		}
+
 		// Check backup references
 		if entry.Original != nil && entry.Original.BackupPath != "" {
 			if _, err := os.Stat(entry.Original.BackupPath); os.IsNotExist(err) {
 				result.MissingBackups = append(result.MissingBackups, entry.Original.BackupPath)
 			}
+		} else if entry.Original != nil && entry.Original.Target == "" && !ledg.Header.NoBackup {
+			// Regular files (not symlinks) should have been backed up
+			result.UnbackedFiles = append(result.UnbackedFiles, entry.Path)
 		}
 
 		// Check installed files if requested
 		if opts.CheckFiles {
 			switch entry.Op {
 			case OpFileCreate, OpFileOverwrite:
+				if lastWrite[entry.Path] != idx {
+					break
+				}
 				info, err := os.Lstat(entry.Path)
 				if os.IsNotExist(err) {
 					result.OrphanedFiles = append(result.OrphanedFiles, entry.Path)
-				} else if err == nil && info.Mode().IsRegular() && entry.Checksum != "" {
+				} else if err == nil && info.Mode().IsRegular() && entry.Checksum != "" && !appended[entry.Path] {
 					// Verify checksum
 					match, err := VerifyChecksum(entry.Path, entry.Checksum)
 					if err == nil && !match {
 						result.ModifiedFiles = append(result.ModifiedFiles, entry.Path)
 					}
 				}
-			case OpSymlinkCreate:
-				info, err := os.Lstat(entry.Path)
-				if os.IsNotExist(err) {
-					result.OrphanedFiles = append(result.OrphanedFiles, entry.Path)
-				} else if err == nil {
-					if info.Mode()&os.ModeSymlink == 0 {
-						result.ModifiedFiles = append(result.ModifiedFiles, entry.Path+" (not a symlink)")
-					} else if entry.Target != "" {
-						target, err := os.Readlink(entry.Path)
-						if err == nil && target != entry.Target {
-							result.ModifiedFiles = append(result.ModifiedFiles, entry.Path)
-						}
-					}
+			case OpFileAppend:
+				// The file is shared, so only the appended data is ours
+				if data, err := os.ReadFile(entry.Path); err == nil && findAppended(data, entry) < 0 {
+					result.ModifiedFiles = append(result.ModifiedFiles, entry.Path+" (appended data was changed or removed)")
 				}
+			case OpSymlinkCreate:
+				checkSymlink(entry, result)
+			case OpHardlinkCreate:
+				checkHardLink(entry, result)
 			case OpDirCreate:
 				info, err := os.Stat(entry.Path)
 				if os.IsNotExist(err) {
@@ -235,12 +446,172 @@ func CheckLedgerIntegrity(ledgerDir, backupDir, pkg string, opts DoctorOptions)
 					result.ModifiedFiles = append(result.ModifiedFiles, entry.Path+" (not a directory)")
 				}
 			}
+		} else if opts.CheckSymlinks && entry.Op == OpSymlinkCreate {
+			checkSymlink(entry, result)
+		} else if opts.CheckHardLinks && entry.Op == OpHardlinkCreate {
+			checkHardLink(entry, result)
+		}
+	}
+
+	if opts.CheckFiles || opts.FixPermissions {
+		for _, path := range modePaths {
+			checkPermissions(path, modes[path], result, opts.FixPermissions && !opts.DryRun)
 		}
 	}
 
+	if opts.CheckOwnership && OwnershipSupported {
+		for _, path := range ownerPaths {
+			checkOwnership(path, owners[path][0], owners[path][1], result)
+		}
+	}
+
+	result.Suggestions = suggestRepairs(result)
 	return result
 }
 
+// checkOwnership records path in result, with a chown command to restore
+// it, if its owner or group differ from uid and gid. Missing files are
+// reported elsewhere.
+func checkOwnership(path string, uid, gid uint32, result *LedgerIntegrityResult) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	actualUID, actualGID := getOwnership(info)
+	if actualUID == uid && actualGID == gid {
+		return
+	}
+	result.WrongOwnership = append(result.WrongOwnership,
+		fmt.Sprintf("%s (owned by %d:%d, recorded %d:%d)", path, actualUID, actualGID, uid, gid))
+	result.chowns = append(result.chowns, RepairSuggestion{
+		Description: fmt.Sprintf("Restore the ownership of %s", path),
+		Command:     fmt.Sprintf("chown -h %d:%d %s", uid, gid, shellQuote(path)),
+	})
+}
+
+// shellQuote quotes s for a POSIX shell, so a suggested command can be
+// pasted even if a path in it has spaces or other special characters.
+func shellQuote(s string) string {
+	const safe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789/._+-:@%,="
+	if s != "" && strings.Trim(s, safe) == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// checkPermissions records path in result if it is a regular file whose
+// mode differs from expected, restoring it if fix is set. The setuid,
+// setgid and sticky bits count along with the permission bits.
+// Missing files and files replaced by another type are reported elsewhere.
+func checkPermissions(path string, expected os.FileMode, result *LedgerIntegrityResult, fix bool) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode()&ModeMask == expected {
+		return
+	}
+	mismatch := PermissionMismatch{Path: path, Expected: expected, Actual: info.Mode() & ModeMask}
+	if fix {
+		mismatch.Fixed = os.Chmod(path, expected) == nil
+	}
+	result.WrongPermissions = append(result.WrongPermissions, mismatch)
+}
+
+// checkSymlink records a symlink entry in result if the link is missing,
+// was replaced or repointed, or its target no longer exists.
+func checkSymlink(entry Entry, result *LedgerIntegrityResult) {
+	info, err := os.Lstat(entry.Path)
+	if os.IsNotExist(err) {
+		result.OrphanedFiles = append(result.OrphanedFiles, entry.Path)
+		return
+	}
+	if err != nil {
+		return
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		result.ModifiedFiles = append(result.ModifiedFiles, entry.Path+" (not a symlink)")
+		return
+	}
+	if entry.Target != "" {
+		target, err := os.Readlink(entry.Path)
+		if err == nil && target != entry.Target {
+			result.ModifiedFiles = append(result.ModifiedFiles, entry.Path)
+		}
+	}
+	// Follow the link to detect a missing target
+	if _, err := os.Stat(entry.Path); os.IsNotExist(err) {
+		result.DanglingSymlinks = append(result.DanglingSymlinks, entry.Path)
+	}
+}
+
+// checkHardLink records a hard link entry in result if the link is missing,
+// or if it and its target are no longer the same file, as happens when
+// either is replaced by a copy or the target is removed.
+func checkHardLink(entry Entry, result *LedgerIntegrityResult) {
+	info, err := os.Lstat(entry.Path)
+	if os.IsNotExist(err) {
+		result.OrphanedFiles = append(result.OrphanedFiles, entry.Path)
+		return
+	}
+	if err != nil {
+		return
+	}
+	targetInfo, err := os.Lstat(entry.Target)
+	if err != nil || !os.SameFile(info, targetInfo) {
+		result.BrokenHardLinks = append(result.BrokenHardLinks, entry.Path)
+	}
+}
+
+// suggestRepairs builds repair suggestions for the issues in a result.
+func suggestRepairs(r *LedgerIntegrityResult) []RepairSuggestion {
+	var suggestions []RepairSuggestion
+
+	if r.Incomplete {
+		suggestions = append(suggestions, RepairSuggestion{
+			Description: "Clean up the partially installed package",
+			Command:     fmt.Sprintf("alloy remove --force %s", r.Package),
+		})
+		suggestions = append(suggestions, RepairSuggestion{
+			Description: "Finish the interrupted installation",
+			Command:     fmt.Sprintf("alloy repair %s", r.Package),
+		})
+	}
+
+	// Reinstalling puts back every missing file, and the packaged content
+	// of every modified one
+	if n := len(r.OrphanedFiles) + len(r.ModifiedFiles); n > 0 {
+		suggestions = append(suggestions, RepairSuggestion{
+			Description: fmt.Sprintf("Reinstall the package to restore %d missing or modified file(s), discarding any changes", n),
+			Command:     fmt.Sprintf("alloy install --reinstall %s", r.Package),
+		})
+	}
+	if len(r.OrphanedFiles) > 0 {
+		suggestions = append(suggestions, RepairSuggestion{
+			Description: "Remove the package, ignoring missing files",
+			Command:     fmt.Sprintf("alloy remove --force %s", r.Package),
+		})
+	}
+
+	if len(r.MissingBackups) > 0 {
+		suggestions = append(suggestions, RepairSuggestion{
+			Description: "Re-create missing backups from files that still match their originals",
+			Command:     fmt.Sprintf("alloy repair-backups %s", r.Package),
+			Automatic:   true,
+		})
+	}
+
+	// chown isn't an alloy command, so these are never run by doctor --fix
+	suggestions = append(suggestions, r.chowns...)
+
+	if r.UnfixedPermissions() > 0 {
+		suggestions = append(suggestions, RepairSuggestion{
+			Description: "Restore the recorded permissions of installed files",
+			Command:     fmt.Sprintf("alloy doctor --fix-permissions --package %s", r.Package),
+			Automatic:   true,
+		})
+	}
+
+	return suggestions
+}
+
 // CheckAllLedgers checks integrity of all package ledgers.
 func CheckAllLedgers(ledgerDir, backupDir string, opts DoctorOptions) ([]*LedgerIntegrityResult, error) {
 	packages, err := List(ledgerDir)
@@ -257,6 +628,254 @@ func CheckAllLedgers(ledgerDir, backupDir string, opts DoctorOptions) ([]*Ledger
 	return results, nil
 }
 
+// CheckLedgerVersions reads the header of each ledger in ledgerDir and
+// reports a warning for ledgers in an older format, which may lack fields
+// added since, and an error for ledgers in a newer format than this version
+// of alloy understands. If every ledger is current a single "ok" result is
+// returned. Ledgers whose header can't be read are left to
+// CheckLedgerIntegrity.
+func CheckLedgerVersions(ledgerDir string) []DiagnosticResult {
+	packages, err := List(ledgerDir)
+	if err != nil {
+		return []DiagnosticResult{{Name: "Ledger versions", Status: "error", Message: err.Error()}}
+	}
+
+	var results []DiagnosticResult
+	for _, pkg := range packages {
+		s, err := OpenStream(ledgerDir, pkg)
+		if err != nil {
+			continue
+		}
+		version := s.Header().Version
+		s.Close()
+
+		switch {
+		case version < CurrentVersion:
+			results = append(results, DiagnosticResult{
+				Name:    pkg,
+				Status:  "warning",
+				Message: fmt.Sprintf("ledger format version %d is older than %d (run 'alloy doctor --migrate')", version, CurrentVersion),
+			})
+		case version > CurrentVersion:
+			results = append(results, DiagnosticResult{
+				Name:    pkg,
+				Status:  "error",
+				Message: fmt.Sprintf("ledger format version %d is newer than supported version %d (upgrade alloy)", version, CurrentVersion),
+			})
+		}
+	}
+
+	if len(results) == 0 {
+		return []DiagnosticResult{{
+			Name:    "Ledger versions",
+			Status:  "ok",
+			Message: fmt.Sprintf("%d ledger(s) use format version %d", len(packages), CurrentVersion),
+		}}
+	}
+	return results
+}
+
+// CheckPathShadowing reports a warning for each file installed in a bin
+// directory that runs something else by name, because a directory before
+// its own in $PATH has a file with the same name, e.g. a package's
+// /usr/local/bin/foo hidden by /usr/bin/foo. Binaries in directories that
+// aren't in PATH, and links in PATH to the installed file itself, are not
+// reported. If nothing is shadowed a single "ok" result is returned.
+func CheckPathShadowing(ledgerDir string) []DiagnosticResult {
+	packages, err := ListSorted(ledgerDir, SortByName)
+	if err != nil {
+		return []DiagnosticResult{{Name: "PATH shadowing", Status: "error", Message: err.Error()}}
+	}
+	pathDirs := filepath.SplitList(os.Getenv("PATH"))
+
+	var results []DiagnosticResult
+	checked := 0
+	for _, pkg := range packages {
+		l, err := Open(ledgerDir, pkg)
+		if err != nil {
+			continue // reported by CheckLedgerIntegrity
+		}
+		for _, entry := range l.Entries {
+			if entry.Op != OpFileCreate || entry.Reverted || filepath.Base(filepath.Dir(entry.Path)) != "bin" {
+				continue
+			}
+			installed, err := os.Stat(entry.Path)
+			if err != nil {
+				continue
+			}
+			checked++
+			if shadow := findShadow(entry.Path, installed, pathDirs); shadow != "" {
+				results = append(results, DiagnosticResult{
+					Name:    pkg,
+					Status:  "warning",
+					Message: fmt.Sprintf("binary %s is shadowed by %s, which comes first in PATH", filepath.Base(entry.Path), shadow),
+				})
+			}
+		}
+	}
+
+	if len(results) == 0 {
+		return []DiagnosticResult{{
+			Name:    "PATH shadowing",
+			Status:  "ok",
+			Message: fmt.Sprintf("checked %d installed binaries, none shadowed", checked),
+		}}
+	}
+	return results
+}
+
+// findShadow returns the first file named like path in the directories of
+// pathDirs before path's own, or "" if there is none or path's directory
+// isn't among them.
+func findShadow(path string, installed os.FileInfo, pathDirs []string) string {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	var shadow string
+	for _, d := range pathDirs {
+		if d == "" {
+			continue
+		}
+		if filepath.Clean(d) == dir {
+			return shadow
+		}
+		if shadow != "" {
+			continue
+		}
+		candidate := filepath.Join(d, name)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && !os.SameFile(info, installed) {
+			shadow = candidate
+		}
+	}
+	return ""
+}
+
+// FindDuplicateOwnership finds installed files claimed by more than one package.
+// Returns a map from file path to the names of the packages that created or
+// overwrote it. Paths owned by a single package are omitted.
+func FindDuplicateOwnership(ledgerDir string) (map[string][]string, error) {
+	owners, err := fileOwners(ledgerDir)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := make(map[string][]string)
+	for path, pkgs := range owners {
+		if len(pkgs) > 1 {
+			duplicates[path] = pkgs
+		}
+	}
+	return duplicates, nil
+}
+
+// AllFiles returns a map from every installed file path to its owning package.
+// If several packages claim a path, the last one listed wins.
+func AllFiles(ledgerDir string) (map[string]string, error) {
+	owners, err := fileOwners(ledgerDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string, len(owners))
+	for path, pkgs := range owners {
+		files[path] = pkgs[len(pkgs)-1]
+	}
+	return files, nil
+}
+
+// FindOwner returns the installed package whose ledger records creating,
+// overwriting, or linking path. The boolean is false if no package owns it.
+func FindOwner(ledgerDir, path string) (string, bool, error) {
+	return findOwner(ledgerDir, path, "")
+}
+
+// findOwner is FindOwner ignoring the ledger of the package skip.
+func findOwner(ledgerDir, path, skip string) (string, bool, error) {
+	owners, err := pathOwners(ledgerDir, skip)
+	if err != nil {
+		return "", false, err
+	}
+	owner, ok := owners[filepath.Clean(path)]
+	return owner, ok, nil
+}
+
+// FindConflicts maps each of paths that another installed package owns to
+// that package, ignoring the ledger of pkg itself so a package never
+// conflicts with its own installation.
+func FindConflicts(ledgerDir, pkg string, paths []string) (map[string]string, error) {
+	owners, err := pathOwners(ledgerDir, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := make(map[string]string)
+	for _, path := range paths {
+		if owner, ok := owners[filepath.Clean(path)]; ok {
+			conflicts[path] = owner
+		}
+	}
+	return conflicts, nil
+}
+
+// pathOwners maps each path a package created, overwrote, or linked to the
+// first package listed that did, ignoring the ledger of the package skip.
+func pathOwners(ledgerDir, skip string) (map[string]string, error) {
+	packages, err := List(ledgerDir)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]string)
+	for _, pkg := range packages {
+		if pkg == skip {
+			continue
+		}
+		ledg, err := Open(ledgerDir, pkg)
+		if err != nil {
+			continue // Skip problematic ledgers
+		}
+
+		for _, entry := range ledg.Entries {
+			if entry.Reverted {
+				continue
+			}
+			if _, ok := owners[entry.Path]; ok {
+				continue
+			}
+			switch entry.Op {
+			case OpFileCreate, OpFileOverwrite, OpSymlinkCreate, OpHardlinkCreate:
+				owners[entry.Path] = pkg
+			}
+		}
+	}
+	return owners, nil
+}
+
+// fileOwners maps each created or overwritten file to the packages recording it.
+func fileOwners(ledgerDir string) (map[string][]string, error) {
+	packages, err := List(ledgerDir)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string][]string)
+	for _, pkg := range packages {
+		ledg, err := Open(ledgerDir, pkg)
+		if err != nil {
+			continue // Skip problematic ledgers
+		}
+
+		for _, entry := range ledg.Entries {
+			if entry.Op != OpFileCreate && entry.Op != OpFileOverwrite {
+				continue
+			}
+			if !slices.Contains(owners[entry.Path], pkg) {
+				owners[entry.Path] = append(owners[entry.Path], pkg)
+			}
+		}
+	}
+
+	return owners, nil
+}
+
 // FindOrphanedBackups finds backup files not referenced by any ledger.
 func FindOrphanedBackups(ledgerDir, backupDir string) ([]string, error) {
 	// First, collect all backup paths referenced by ledgers
@@ -305,3 +924,169 @@ func FindOrphanedBackups(ledgerDir, backupDir string) ([]string, error) {
 
 	return orphans, nil
 }
+
+// StaleCacheAge is how old a cached download can get before doctor suggests
+// cleaning it up.
+const StaleCacheAge = 30 * 24 * time.Hour
+
+// Cache size thresholds for CheckCacheSize.
+const (
+	cacheWarnSize  = 1 << 30
+	cacheErrorSize = 5 << 30
+)
+
+// CheckCacheSize reports how much space the download cache uses and how many
+// cached files are older than maxAge. It warns above 1 GB or if stale files
+// exist, and reports an error above 5 GB.
+func CheckCacheSize(cacheDir string, maxAge time.Duration) []DiagnosticResult {
+	var total int64
+	var files, stale int
+	cutoff := time.Now().Add(-maxAge)
+
+	err := filepath.WalkDir(cacheDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		total += info.Size()
+		if info.ModTime().Before(cutoff) {
+			stale++
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return []DiagnosticResult{{Name: "Download cache", Status: "ok", Message: "empty"}}
+	}
+	if err != nil {
+		return []DiagnosticResult{{Name: "Download cache", Status: "error", Message: fmt.Sprintf("cannot read %s: %v", cacheDir, err)}}
+	}
+
+	days := int(maxAge.Hours() / 24)
+	message := fmt.Sprintf("%.1f MB in %d file(s)", float64(total)/(1<<20), files)
+	if stale > 0 {
+		message += fmt.Sprintf(", %d older than %d days", stale, days)
+	}
+
+	status := "ok"
+	switch {
+	case total > cacheErrorSize:
+		status = "error"
+	case total > cacheWarnSize || stale > 0:
+		status = "warning"
+	}
+	if status != "ok" {
+		message += fmt.Sprintf(" (run 'alloy clean --older-than %dd')", days)
+	}
+
+	return []DiagnosticResult{{Name: "Download cache", Status: status, Message: message}}
+}
+
+// ReachabilityTimeout bounds each request made by CheckNetworkReachability.
+const ReachabilityTimeout = 5 * time.Second
+
+// SourceURLs returns the source locations recorded in the headers of all
+// installed packages, in package name order. Packages without a source
+// are skipped.
+func SourceURLs(ledgerDir string) ([]string, error) {
+	packages, err := ListSorted(ledgerDir, SortByName)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, name := range packages {
+		s, err := OpenStream(ledgerDir, name)
+		if err != nil {
+			continue
+		}
+		if source := s.Header().Source; source != "" {
+			urls = append(urls, source)
+		}
+		s.Close()
+	}
+	return urls, nil
+}
+
+// CheckNetworkReachability sends a HEAD request to the root of each unique
+// http or https host among remotes, which may be any source or remote
+// URLs, and reports one result per host in sorted order. 2xx and 3xx
+// responses count as reachable; redirects are not followed. Other
+// responses are warnings and network errors are errors. Local paths and
+// other schemes, such as ssh git remotes, are skipped.
+func CheckNetworkReachability(remotes []string) []DiagnosticResult {
+	hosts := make(map[string]string)
+	for _, remote := range remotes {
+		u, err := url.Parse(remote)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if _, ok := hosts[u.Host]; !ok {
+			hosts[u.Host] = u.Scheme + "://" + u.Host + "/"
+		}
+	}
+
+	client := &http.Client{
+		Timeout: ReachabilityTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	names := slices.Sorted(maps.Keys(hosts))
+	results := make([]DiagnosticResult, len(names))
+	var wg sync.WaitGroup
+	for i, host := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = checkHost(client, host, hosts[host])
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// checkHost sends a HEAD request to root and describes the outcome.
+func checkHost(client *http.Client, host, root string) DiagnosticResult {
+	resp, err := client.Head(root)
+	if err != nil {
+		return DiagnosticResult{Name: "Unreachable", Status: "error", Message: fmt.Sprintf("%s (%s)", host, networkErrorReason(err))}
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return DiagnosticResult{Name: "Server error", Status: "warning", Message: fmt.Sprintf("%s (HTTP %d)", host, resp.StatusCode)}
+	case resp.StatusCode < 200 || resp.StatusCode >= 400:
+		return DiagnosticResult{Name: "Request rejected", Status: "warning", Message: fmt.Sprintf("%s (HTTP %d)", host, resp.StatusCode)}
+	}
+	return DiagnosticResult{Name: "Reachable", Status: "ok", Message: host}
+}
+
+// networkErrorReason summarizes a request error, such as "connection
+// refused", without the method and URL that wrap it.
+func networkErrorReason(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return "host not found"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
@@ -1,9 +1,17 @@
 package ledger
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCheckDirectoryPermissions(t *testing.T) {
@@ -110,6 +118,7 @@ func TestCheckLedgerIntegrity_CorrectChecksum(t *testing.T) {
 	}); err != nil {
 		t.Fatalf("failed to record entry: %v", err)
 	}
+	ledg.MarkComplete()
 	ledg.Close()
 
 	// Check integrity with CheckFiles enabled
@@ -287,3 +296,611 @@ func TestLedgerIntegrityResult_HasIssues(t *testing.T) {
 		})
 	}
 }
+
+func TestSuggestRepairs(t *testing.T) {
+	result := &LedgerIntegrityResult{
+		Package:        "test",
+		MissingBackups: []string{"/backup/abc"},
+		OrphanedFiles:  []string{"/usr/local/bin/gone"},
+		ModifiedFiles:  []string{"/usr/local/bin/link (not a symlink)", "/opt/my tool/it's.conf"},
+	}
+
+	suggestions := suggestRepairs(result)
+
+	commands := make(map[string]bool)
+	automatic := 0
+	for _, s := range suggestions {
+		commands[s.Command] = true
+		if s.Automatic {
+			automatic++
+		}
+	}
+
+	for _, want := range []string{
+		"alloy install --reinstall test",
+		"alloy remove --force test",
+		"alloy repair-backups test",
+	} {
+		if !commands[want] {
+			t.Errorf("missing suggestion %q", want)
+		}
+	}
+	// Every suggested alloy command is one that exists
+	for command := range commands {
+		if fields := strings.Fields(command); !slices.Contains([]string{"install", "remove", "repair", "repair-backups", "doctor"}, fields[1]) {
+			t.Errorf("suggestion %q runs an unknown command", command)
+		}
+	}
+	if len(suggestions) != len(commands) {
+		t.Errorf("suggestions repeat a command: %v", suggestions)
+	}
+
+	if automatic != 1 {
+		t.Errorf("expected 1 automatic suggestion, got %d", automatic)
+	}
+}
+
+func TestSuggestRepairs_NoIssues(t *testing.T) {
+	if s := suggestRepairs(&LedgerIntegrityResult{Package: "test"}); len(s) != 0 {
+		t.Errorf("expected no suggestions, got %d", len(s))
+	}
+}
+
+func TestCheckLedgerIntegrity_DanglingSymlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	ledgerDir := filepath.Join(tmpDir, "ledgers")
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	target := filepath.Join(tmpDir, "target")
+	if err := os.WriteFile(target, []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to write target: %v", err)
+	}
+	live := filepath.Join(tmpDir, "live")
+	dangling := filepath.Join(tmpDir, "dangling")
+	if err := os.Symlink(target, live); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(tmpDir, "gone"), dangling); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	ledg, err := Create(ledgerDir, "test-pkg", "test-source")
+	if err != nil {
+		t.Fatalf("failed to create ledger: %v", err)
+	}
+	ledg.Record(Entry{Op: OpSymlinkCreate, Path: live, Target: target})
+	ledg.Record(Entry{Op: OpSymlinkCreate, Path: dangling, Target: filepath.Join(tmpDir, "gone")})
+	ledg.Close()
+
+	result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{CheckFiles: true})
+
+	if len(result.DanglingSymlinks) != 1 || result.DanglingSymlinks[0] != dangling {
+		t.Errorf("expected dangling symlink %s, got %v", dangling, result.DanglingSymlinks)
+	}
+	if len(result.ModifiedFiles) != 0 {
+		t.Errorf("expected no modified files, got %v", result.ModifiedFiles)
+	}
+	if !result.HasIssues() {
+		t.Error("dangling symlink should be reported as an issue")
+	}
+}
+
+func TestFindDuplicateOwnership(t *testing.T) {
+	ledgerDir := t.TempDir()
+
+	record := func(pkg string, entries ...Entry) {
+		t.Helper()
+		ledg, err := Create(ledgerDir, pkg, "test-source")
+		if err != nil {
+			t.Fatalf("failed to create ledger: %v", err)
+		}
+		for _, e := range entries {
+			if err := ledg.Record(e); err != nil {
+				t.Fatalf("failed to record entry: %v", err)
+			}
+		}
+		ledg.Close()
+	}
+
+	record("pkg-a",
+		Entry{Op: OpFileCreate, Path: "/usr/local/bin/shared"},
+		Entry{Op: OpFileCreate, Path: "/usr/local/bin/a"},
+		Entry{Op: OpDirCreate, Path: "/usr/local/share/common"},
+	)
+	record("pkg-b",
+		Entry{Op: OpFileOverwrite, Path: "/usr/local/bin/shared"},
+		Entry{Op: OpDirCreate, Path: "/usr/local/share/common"},
+	)
+
+	duplicates, err := FindDuplicateOwnership(ledgerDir)
+	if err != nil {
+		t.Fatalf("FindDuplicateOwnership failed: %v", err)
+	}
+
+	if len(duplicates) != 1 {
+		t.Fatalf("expected 1 duplicate, got %v", duplicates)
+	}
+	owners := duplicates["/usr/local/bin/shared"]
+	if len(owners) != 2 || owners[0] != "pkg-a" || owners[1] != "pkg-b" {
+		t.Errorf("expected owners [pkg-a pkg-b], got %v", owners)
+	}
+
+	files, err := AllFiles(ledgerDir)
+	if err != nil {
+		t.Fatalf("AllFiles failed: %v", err)
+	}
+	if files["/usr/local/bin/a"] != "pkg-a" {
+		t.Errorf("expected /usr/local/bin/a owned by pkg-a, got %q", files["/usr/local/bin/a"])
+	}
+	if _, ok := files["/usr/local/share/common"]; ok {
+		t.Error("directories should not be included in AllFiles")
+	}
+
+	record("pkg-c",
+		Entry{Op: OpSymlinkCreate, Path: "/usr/local/bin/c", Target: "/opt/c"},
+		Entry{Op: OpFileCreate, Path: "/usr/local/bin/gone", Reverted: true},
+	)
+	for path, want := range map[string]string{
+		"/usr/local/bin/a":        "pkg-a",
+		"/usr/local/bin/c":        "pkg-c",
+		"/usr/local/bin/../bin/c": "pkg-c",
+		"/usr/local/bin/gone":     "",
+		"/usr/local/share/common": "",
+	} {
+		owner, ok, err := FindOwner(ledgerDir, path)
+		if err != nil {
+			t.Fatalf("FindOwner(%s): %v", path, err)
+		}
+		if owner != want || ok != (want != "") {
+			t.Errorf("FindOwner(%s) = %q, %v; want %q", path, owner, ok, want)
+		}
+	}
+
+	conflicts, err := FindConflicts(ledgerDir, "pkg-a", []string{"/usr/local/bin/a", "/usr/local/bin/shared"})
+	if err != nil {
+		t.Fatalf("FindConflicts: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts["/usr/local/bin/shared"] != "pkg-b" {
+		t.Errorf("FindConflicts ignoring pkg-a = %v, want only shared owned by pkg-b", conflicts)
+	}
+}
+
+func TestCheckLedgerIntegrity_Incomplete(t *testing.T) {
+	tmpDir := t.TempDir()
+	ledgerDir := filepath.Join(tmpDir, "ledgers")
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	// Simulate a crash: ledger created but never marked complete
+	ledg, err := Create(ledgerDir, "test-pkg", "test-source")
+	if err != nil {
+		t.Fatalf("failed to create ledger: %v", err)
+	}
+	ledg.Close()
+
+	result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{})
+	if !result.Incomplete {
+		t.Error("expected interrupted install to be reported as incomplete")
+	}
+	if !result.HasIssues() {
+		t.Error("incomplete install should be reported as an issue")
+	}
+	if len(result.Suggestions) == 0 || result.Suggestions[0].Command != "alloy remove --force test-pkg" {
+		t.Errorf("expected remove suggestion, got %+v", result.Suggestions)
+	}
+	if len(result.Suggestions) < 2 || result.Suggestions[1].Command != "alloy repair test-pkg" {
+		t.Errorf("expected repair suggestion, got %+v", result.Suggestions)
+	}
+}
+
+func TestCheckLedgerIntegrity_FixPermissions(t *testing.T) {
+	tmpDir := t.TempDir()
+	ledgerDir := filepath.Join(tmpDir, "ledgers")
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	tool := filepath.Join(tmpDir, "tool")
+	os.WriteFile(tool, []byte("tool"), 0755)
+	ledg, err := Create(ledgerDir, "test-pkg", "test-source")
+	if err != nil {
+		t.Fatalf("failed to create ledger: %v", err)
+	}
+	recorder := NewRecorder(ledg, backupDir)
+	if err := recorder.RecordFileCreate(tool); err != nil {
+		t.Fatalf("RecordFileCreate: %v", err)
+	}
+	ledg.MarkComplete()
+	ledg.Close()
+
+	os.Chmod(tool, 0600)
+
+	// Without a permissions or file check, the mode isn't looked at
+	if result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{}); len(result.WrongPermissions) != 0 {
+		t.Errorf("expected no permission check, got %+v", result.WrongPermissions)
+	}
+
+	for _, opts := range []DoctorOptions{{CheckFiles: true}, {FixPermissions: true, DryRun: true}} {
+		result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", opts)
+		want := []PermissionMismatch{{Path: tool, Expected: 0755, Actual: 0600}}
+		if !slices.Equal(result.WrongPermissions, want) {
+			t.Errorf("%+v: WrongPermissions = %+v, want %+v", opts, result.WrongPermissions, want)
+		}
+		if !result.HasIssues() {
+			t.Errorf("%+v: unfixed permissions should be an issue", opts)
+		}
+		if info, _ := os.Stat(tool); info.Mode().Perm() != 0600 {
+			t.Errorf("%+v: mode changed to %04o without fixing", opts, info.Mode().Perm())
+		}
+	}
+
+	result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{FixPermissions: true})
+	if len(result.WrongPermissions) != 1 || !result.WrongPermissions[0].Fixed {
+		t.Fatalf("WrongPermissions = %+v, want one fixed mismatch", result.WrongPermissions)
+	}
+	if result.HasIssues() {
+		t.Error("fixed permissions should not be an issue")
+	}
+	if info, _ := os.Stat(tool); info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %04o after fixing, want 0755", info.Mode().Perm())
+	}
+}
+
+func TestCheckLedgerIntegrity_CheckOwnership(t *testing.T) {
+	if !OwnershipSupported {
+		t.Skip("file ownership is not available on this platform")
+	}
+	tmpDir := t.TempDir()
+	ledgerDir := filepath.Join(tmpDir, "ledgers")
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	same := filepath.Join(tmpDir, "same")
+	changed := filepath.Join(tmpDir, "changed")
+	unrecorded := filepath.Join(tmpDir, "unrecorded")
+	for _, path := range []string{same, changed, unrecorded} {
+		os.WriteFile(path, []byte("x"), 0644)
+	}
+
+	ledg, err := Create(ledgerDir, "test-pkg", "test-source")
+	if err != nil {
+		t.Fatalf("failed to create ledger: %v", err)
+	}
+	ledg.Record(Entry{Op: OpFileCreate, Path: same, UID: uid, GID: gid})
+	ledg.Record(Entry{Op: OpFileCreate, Path: changed, UID: uid + 1, GID: gid})
+	ledg.Record(Entry{Op: OpFileCreate, Path: unrecorded})
+	ledg.MarkComplete()
+	ledg.Close()
+
+	if result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{}); len(result.WrongOwnership) != 0 {
+		t.Errorf("ownership should only be checked with CheckOwnership, got %v", result.WrongOwnership)
+	}
+
+	result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{CheckOwnership: true})
+	if len(result.WrongOwnership) != 1 || !strings.HasPrefix(result.WrongOwnership[0], changed+" (owned by") {
+		t.Fatalf("WrongOwnership = %v, want only %s", result.WrongOwnership, changed)
+	}
+	if !result.HasIssues() {
+		t.Error("wrong ownership should be an issue")
+	}
+	want := fmt.Sprintf("chown -h %d:%d %s", uid+1, gid, changed)
+	if !slices.ContainsFunc(result.Suggestions, func(s RepairSuggestion) bool { return s.Command == want && !s.Automatic }) {
+		t.Errorf("expected a manual %q suggestion, got %+v", want, result.Suggestions)
+	}
+}
+
+func TestCheckLedgerIntegrity_UnbackedFiles(t *testing.T) {
+	for _, noBackup := range []bool{false, true} {
+		ledgerDir := t.TempDir()
+
+		ledg, err := CreateWithHeader(ledgerDir, Header{Package: "test-pkg", NoBackup: noBackup})
+		if err != nil {
+			t.Fatalf("failed to create ledger: %v", err)
+		}
+		ledg.Record(Entry{Op: OpFileOverwrite, Path: "/etc/config", Original: &OriginalFile{Checksum: "abc"}})
+		ledg.MarkComplete()
+		ledg.Close()
+
+		result := CheckLedgerIntegrity(ledgerDir, t.TempDir(), "test-pkg", DoctorOptions{})
+		if noBackup && len(result.UnbackedFiles) != 0 {
+			t.Errorf("NoBackup ledger should not report unbacked files, got %v", result.UnbackedFiles)
+		}
+		if !noBackup && len(result.UnbackedFiles) != 1 {
+			t.Errorf("expected 1 unbacked file, got %v", result.UnbackedFiles)
+		}
+	}
+}
+
+func TestDoctorReportJSON(t *testing.T) {
+	report := &DoctorReport{
+		Issues:   1,
+		Warnings: 2,
+		Packages: []*LedgerIntegrityResult{
+			{Package: "broken", ParseError: errors.New("bad header")},
+			{Package: "ok", EntryCount: 3},
+		},
+	}
+	if !report.HasErrors() || !report.HasWarnings() {
+		t.Error("expected report to have errors and warnings")
+	}
+	if (&DoctorReport{}).HasErrors() || (&DoctorReport{}).HasWarnings() {
+		t.Error("empty report should have no errors or warnings")
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var decoded struct {
+		Issues   int `json:"issues"`
+		Packages []struct {
+			Package    string `json:"package"`
+			ParseError string `json:"parse_error"`
+			EntryCount int    `json:"entry_count"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if decoded.Issues != 1 {
+		t.Errorf("issues = %d, want 1", decoded.Issues)
+	}
+	if len(decoded.Packages) != 2 {
+		t.Fatalf("got %d packages, want 2", len(decoded.Packages))
+	}
+	if decoded.Packages[0].ParseError != "bad header" {
+		t.Errorf("parse_error = %q, want %q", decoded.Packages[0].ParseError, "bad header")
+	}
+	if decoded.Packages[1].ParseError != "" || decoded.Packages[1].EntryCount != 3 {
+		t.Errorf("unexpected second package: %+v", decoded.Packages[1])
+	}
+}
+
+func TestCheckCacheSize(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	results := CheckCacheSize(cacheDir, StaleCacheAge)
+	if len(results) != 1 || results[0].Status != "ok" {
+		t.Fatalf("missing cache: got %+v, want ok", results)
+	}
+
+	os.MkdirAll(cacheDir, 0755)
+	fresh := filepath.Join(cacheDir, "fresh")
+	os.WriteFile(fresh, []byte("fresh"), 0644)
+
+	results = CheckCacheSize(cacheDir, StaleCacheAge)
+	if results[0].Status != "ok" {
+		t.Errorf("small fresh cache: got %+v, want ok", results[0])
+	}
+
+	old := filepath.Join(cacheDir, "old")
+	os.WriteFile(old, []byte("old"), 0644)
+	past := time.Now().Add(-2 * StaleCacheAge)
+	os.Chtimes(old, past, past)
+
+	results = CheckCacheSize(cacheDir, StaleCacheAge)
+	if results[0].Status != "warning" {
+		t.Errorf("stale cache: got %+v, want warning", results[0])
+	}
+	if !strings.Contains(results[0].Message, "1 older than 30 days") || !strings.Contains(results[0].Message, "alloy clean --older-than 30d") {
+		t.Errorf("unexpected message: %s", results[0].Message)
+	}
+}
+
+func TestCheckLedgerIntegrity_CheckSymlinksOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	ledgerDir := filepath.Join(tmpDir, "ledgers")
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	dangling := filepath.Join(tmpDir, "dangling")
+	if err := os.Symlink(filepath.Join(tmpDir, "gone"), dangling); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	ledg, err := Create(ledgerDir, "test-pkg", "test-source")
+	if err != nil {
+		t.Fatalf("failed to create ledger: %v", err)
+	}
+	ledg.Record(Entry{Op: OpSymlinkCreate, Path: dangling, Target: filepath.Join(tmpDir, "gone")})
+	ledg.Record(Entry{Op: OpFileCreate, Path: filepath.Join(tmpDir, "missing-file")})
+	ledg.Close()
+
+	result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{})
+	if len(result.DanglingSymlinks) != 0 {
+		t.Errorf("symlinks checked without CheckSymlinks: %v", result.DanglingSymlinks)
+	}
+
+	result = CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{CheckSymlinks: true})
+	if len(result.DanglingSymlinks) != 1 || result.DanglingSymlinks[0] != dangling {
+		t.Errorf("expected dangling symlink %s, got %v", dangling, result.DanglingSymlinks)
+	}
+	if len(result.OrphanedFiles) != 0 {
+		t.Errorf("CheckSymlinks should not check regular files, got orphaned %v", result.OrphanedFiles)
+	}
+}
+
+func TestCheckLedgerIntegrity_CheckHardLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	ledgerDir := filepath.Join(tmpDir, "ledgers")
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	target := filepath.Join(tmpDir, "target")
+	os.WriteFile(target, []byte("content"), 0644)
+	intact := filepath.Join(tmpDir, "intact")
+	broken := filepath.Join(tmpDir, "broken")
+	for _, link := range []string{intact, broken} {
+		if err := os.Link(target, link); err != nil {
+			t.Fatalf("failed to create hard link: %v", err)
+		}
+	}
+	// Replace one link with a copy, as an editor saving in place might
+	os.Remove(broken)
+	os.WriteFile(broken, []byte("content"), 0644)
+
+	ledg, err := Create(ledgerDir, "test-pkg", "test-source")
+	if err != nil {
+		t.Fatalf("failed to create ledger: %v", err)
+	}
+	ledg.Record(Entry{Op: OpHardlinkCreate, Path: intact, Target: target})
+	ledg.Record(Entry{Op: OpHardlinkCreate, Path: broken, Target: target})
+	ledg.Close()
+
+	result := CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{})
+	if len(result.BrokenHardLinks) != 0 {
+		t.Errorf("hard links checked without CheckHardLinks: %v", result.BrokenHardLinks)
+	}
+
+	result = CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{CheckHardLinks: true})
+	if len(result.BrokenHardLinks) != 1 || result.BrokenHardLinks[0] != broken {
+		t.Errorf("expected broken hard link %s, got %v", broken, result.BrokenHardLinks)
+	}
+	if !result.HasIssues() {
+		t.Error("expected a broken hard link to be reported as an issue")
+	}
+
+	// Removing the target breaks the remaining link too
+	os.Remove(target)
+	result = CheckLedgerIntegrity(ledgerDir, backupDir, "test-pkg", DoctorOptions{CheckFiles: true})
+	if len(result.BrokenHardLinks) != 2 {
+		t.Errorf("expected both hard links broken, got %v", result.BrokenHardLinks)
+	}
+}
+
+func TestCheckNetworkReachability(t *testing.T) {
+	var heads int
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+		}
+		http.Redirect(w, r, "https://elsewhere.invalid/", http.StatusFound)
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	results := CheckNetworkReachability([]string{
+		ok.URL + "/pkg-1.0.tar.gz",
+		ok.URL + "/pkg-2.0.tar.gz",
+		failing.URL + "/tool.git",
+		forbidden.URL + "/private.tar.gz",
+		closedURL + "/bin",
+		"/home/user/src",
+		"git@github.com:user/repo.git",
+	})
+
+	want := map[string]DiagnosticResult{
+		strings.TrimPrefix(ok.URL, "http://"):        {Name: "Reachable", Status: "ok"},
+		strings.TrimPrefix(failing.URL, "http://"):   {Name: "Server error", Status: "warning"},
+		strings.TrimPrefix(forbidden.URL, "http://"): {Name: "Request rejected", Status: "warning"},
+		strings.TrimPrefix(closedURL, "http://"):     {Name: "Unreachable", Status: "error"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for _, r := range results {
+		host, _, _ := strings.Cut(r.Message, " ")
+		w, found := want[host]
+		if !found {
+			t.Errorf("unexpected result %+v", r)
+			continue
+		}
+		if r.Name != w.Name || r.Status != w.Status {
+			t.Errorf("%s: got %s/%s, want %s/%s", host, r.Name, r.Status, w.Name, w.Status)
+		}
+		if r.Status == "error" && !strings.Contains(r.Message, "connection refused") {
+			t.Errorf("unreachable message = %q, want the reason", r.Message)
+		}
+	}
+	if heads != 1 {
+		t.Errorf("expected one HEAD request to the duplicated host, got %d", heads)
+	}
+}
+
+func TestCheckLedgerVersions(t *testing.T) {
+	dir := t.TempDir()
+	if results := CheckLedgerVersions(dir); len(results) != 1 || results[0].Status != "ok" {
+		t.Errorf("no ledgers: %+v, want one ok result", results)
+	}
+
+	current, err := Create(dir, "current", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	current.Close()
+	for name, version := range map[string]int{"old": CurrentVersion - 1, "future": CurrentVersion + 1} {
+		header := fmt.Sprintf(`{"version":%d,"package":%q}`+"\n", version, name)
+		if err := os.WriteFile(Path(dir, name), []byte(header), 0644); err != nil {
+			t.Fatalf("write ledger: %v", err)
+		}
+	}
+	// Unreadable headers are reported by the integrity check instead
+	if err := os.WriteFile(Path(dir, "broken"), []byte("not json\n"), 0644); err != nil {
+		t.Fatalf("write ledger: %v", err)
+	}
+
+	statuses := make(map[string]string)
+	for _, r := range CheckLedgerVersions(dir) {
+		statuses[r.Name] = r.Status
+	}
+	want := map[string]string{"old": "warning", "future": "error"}
+	if len(statuses) != len(want) || statuses["old"] != want["old"] || statuses["future"] != want["future"] {
+		t.Errorf("CheckLedgerVersions statuses = %v, want %v", statuses, want)
+	}
+}
+
+func TestCheckPathShadowing(t *testing.T) {
+	root := t.TempDir()
+	usrBin := filepath.Join(root, "usr", "bin")
+	localBin := filepath.Join(root, "usr", "local", "bin")
+	optBin := filepath.Join(root, "opt", "bin")
+	for _, dir := range []string{usrBin, localBin, optBin} {
+		os.MkdirAll(dir, 0755)
+	}
+
+	// foo is shadowed, bar is only a link to the installed file, baz is
+	// later in PATH than the installed one, and qux's directory isn't in
+	// PATH at all
+	for _, path := range []string{
+		filepath.Join(localBin, "foo"), filepath.Join(localBin, "bar"), filepath.Join(localBin, "baz"),
+		filepath.Join(usrBin, "foo"), filepath.Join(optBin, "baz"), filepath.Join(root, "bin", "qux"),
+	} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("#!/bin/sh\n"), 0755)
+	}
+	os.Symlink(filepath.Join(localBin, "bar"), filepath.Join(usrBin, "bar"))
+	os.WriteFile(filepath.Join(usrBin, "qux"), []byte("#!/bin/sh\n"), 0755)
+
+	ledgerDir := t.TempDir()
+	l, err := Create(ledgerDir, "tools", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, name := range []string{"foo", "bar", "baz"} {
+		l.Record(Entry{Op: OpFileCreate, Path: filepath.Join(localBin, name)})
+	}
+	l.Record(Entry{Op: OpFileCreate, Path: filepath.Join(root, "bin", "qux")})
+	l.Record(Entry{Op: OpFileCreate, Path: filepath.Join(root, "usr", "local", "share", "foo")})
+	l.Close()
+
+	t.Setenv("PATH", strings.Join([]string{usrBin, localBin, optBin}, string(os.PathListSeparator)))
+	results := CheckPathShadowing(ledgerDir)
+	if len(results) != 1 {
+		t.Fatalf("CheckPathShadowing = %+v, want one warning", results)
+	}
+	want := "binary foo is shadowed by " + filepath.Join(usrBin, "foo") + ", which comes first in PATH"
+	if r := results[0]; r.Name != "tools" || r.Status != "warning" || r.Message != want {
+		t.Errorf("result = %+v, want a warning that %s", r, want)
+	}
+
+	t.Setenv("PATH", strings.Join([]string{localBin, usrBin}, string(os.PathListSeparator)))
+	if results := CheckPathShadowing(ledgerDir); len(results) != 1 || results[0].Status != "ok" {
+		t.Errorf("with the install directory first: %+v, want one ok result", results)
+	}
+}
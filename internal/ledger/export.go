@@ -0,0 +1,72 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ExportJSON streams a package's ledger to w as JSON Lines: the header
+// followed by one entry per line, in the same format as the ledger file.
+func ExportJSON(w io.Writer, dir, pkg string) error {
+	return ExportJSONRedacted(w, dir, pkg, "")
+}
+
+// ExportJSONRedacted is like ExportJSON, but replaces home at the start of
+// any path with "~" so the output can be shared without revealing the
+// user's home directory. An empty home exports paths unchanged.
+func ExportJSONRedacted(w io.Writer, dir, pkg, home string) error {
+	s, err := OpenStream(dir, pkg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	redact := func(path string) string { return redactHome(path, home) }
+
+	enc := json.NewEncoder(w)
+	header := s.Header()
+	header.Source = redact(header.Source)
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	for {
+		entry, err := s.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry.Path = redact(entry.Path)
+		entry.Target = redact(entry.Target)
+		if entry.Original != nil {
+			orig := *entry.Original
+			orig.BackupPath = redact(orig.BackupPath)
+			orig.Target = redact(orig.Target)
+			entry.Original = &orig
+		}
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("write entry: %w", err)
+		}
+	}
+}
+
+// redactHome replaces a leading home directory in path with "~".
+func redactHome(path, home string) string {
+	if home == "" || path == "" {
+		return path
+	}
+	home = filepath.Clean(home)
+	if path == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(path, home+string(filepath.Separator)); ok {
+		return "~" + string(filepath.Separator) + rest
+	}
+	return path
+}
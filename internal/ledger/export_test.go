@@ -0,0 +1,80 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExportJSON(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Create(dir, "test-pkg", "/home/alice/src/pkg")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	l.Record(Entry{Op: OpFileCreate, Path: "/home/alice/.local/bin/tool"})
+	l.Record(Entry{
+		Op:       OpFileOverwrite,
+		Path:     "/usr/local/bin/tool",
+		Original: &OriginalFile{BackupPath: "/home/alice/.alloy/backups/test-pkg/abc"},
+	})
+	l.Record(Entry{Op: OpFileCreate, Path: "/home/alicex/file"})
+	l.Close()
+
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf, dir, "test-pkg"); err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("exported %d lines, want 4", len(lines))
+	}
+	var header Header
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("parse header: %v", err)
+	}
+	if header.Package != "test-pkg" || header.Source != "/home/alice/src/pkg" {
+		t.Errorf("header = %+v", header)
+	}
+
+	buf.Reset()
+	if err := ExportJSONRedacted(&buf, dir, "test-pkg", "/home/alice"); err != nil {
+		t.Fatalf("ExportJSONRedacted: %v", err)
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("parse header: %v", err)
+	}
+	if header.Source != "~/src/pkg" {
+		t.Errorf("redacted Source = %q, want %q", header.Source, "~/src/pkg")
+	}
+
+	var entries []Entry
+	for _, line := range lines[1:] {
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("parse entry: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	if entries[0].Path != "~/.local/bin/tool" {
+		t.Errorf("redacted Path = %q", entries[0].Path)
+	}
+	if entries[1].Original.BackupPath != "~/.alloy/backups/test-pkg/abc" {
+		t.Errorf("redacted BackupPath = %q", entries[1].Original.BackupPath)
+	}
+	if entries[2].Path != "/home/alicex/file" {
+		t.Errorf("Path sharing a prefix with home was redacted: %q", entries[2].Path)
+	}
+
+	// The ledger itself is untouched
+	reopened, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if reopened.Entries[1].Original.BackupPath != "/home/alice/.alloy/backups/test-pkg/abc" {
+		t.Errorf("ledger was modified: %q", reopened.Entries[1].Original.BackupPath)
+	}
+}
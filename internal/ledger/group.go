@@ -0,0 +1,53 @@
+package ledger
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// GroupBy groups packages by the key groupFn returns for each package's
+// ledger header. Only headers are read, so this stays fast for packages with
+// large ledgers. Packages keep their relative order within each group.
+func GroupBy(packages []string, ledgerDir string, groupFn func(Header) string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	for _, name := range packages {
+		s, err := OpenStream(ledgerDir, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		key := groupFn(s.Header())
+		s.Close()
+		groups[key] = append(groups[key], name)
+	}
+	return groups, nil
+}
+
+// SourceHost returns the host a package was installed from, or its source
+// unchanged if that isn't a URL (e.g. a local path or scp-style git remote).
+func SourceHost(h Header) string {
+	if h.Source == "" {
+		return "(unknown)"
+	}
+	if u, err := url.Parse(h.Source); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return h.Source
+}
+
+// InstallPeriod returns a grouping function that keys packages by the day
+// (2006-01-02), ISO week (2006-W01) or month (2006-01) they were installed.
+func InstallPeriod(period string) (func(Header) string, error) {
+	switch period {
+	case "day":
+		return func(h Header) string { return h.InstalledAt.Format("2006-01-02") }, nil
+	case "week":
+		return func(h Header) string {
+			year, week := h.InstalledAt.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}, nil
+	case "month":
+		return func(h Header) string { return h.InstalledAt.Format("2006-01") }, nil
+	default:
+		return nil, fmt.Errorf("unknown period %q (want day, week, or month)", period)
+	}
+}
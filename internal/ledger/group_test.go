@@ -0,0 +1,63 @@
+package ledger
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGroupBy(t *testing.T) {
+	dir := t.TempDir()
+
+	day := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	headers := []Header{
+		{Package: "a", Source: "https://github.com/a/a.tar.gz", InstalledAt: day},
+		{Package: "b", Source: "https://example.com/b.tar.gz", InstalledAt: day.AddDate(0, 0, 1)},
+		{Package: "c", Source: "https://github.com/c/c.tar.gz", InstalledAt: day.AddDate(0, 1, 0)},
+		{Package: "d", Source: "git@github.com:d/d.git", InstalledAt: day.AddDate(0, 1, 0)},
+	}
+	var packages []string
+	for _, h := range headers {
+		l, err := CreateWithHeader(dir, h)
+		if err != nil {
+			t.Fatalf("CreateWithHeader: %v", err)
+		}
+		l.Close()
+		packages = append(packages, h.Package)
+	}
+
+	bySource, err := GroupBy(packages, dir, SourceHost)
+	if err != nil {
+		t.Fatalf("GroupBy: %v", err)
+	}
+	if got := bySource["github.com"]; !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("github.com group = %v, want [a c]", got)
+	}
+	if got := bySource["git@github.com:d/d.git"]; !slices.Equal(got, []string{"d"}) {
+		t.Errorf("scp-style source group = %v, want [d]", got)
+	}
+
+	byMonth, err := InstallPeriod("month")
+	if err != nil {
+		t.Fatalf("InstallPeriod: %v", err)
+	}
+	groups, err := GroupBy(packages, dir, byMonth)
+	if err != nil {
+		t.Fatalf("GroupBy: %v", err)
+	}
+	if got := groups["2026-01"]; !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("2026-01 group = %v, want [a b]", got)
+	}
+	if got := groups["2026-02"]; !slices.Equal(got, []string{"c", "d"}) {
+		t.Errorf("2026-02 group = %v, want [c d]", got)
+	}
+
+	byWeek, _ := InstallPeriod("week")
+	if got := byWeek(headers[0]); got != "2026-W02" {
+		t.Errorf("week key = %q, want 2026-W02", got)
+	}
+
+	if _, err := InstallPeriod("year"); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
@@ -0,0 +1,59 @@
+package ledger
+
+import (
+	"os"
+	"sort"
+)
+
+// HistoryEntry pairs a ledger entry with the current state of its path on disk.
+type HistoryEntry struct {
+	Entry
+
+	// CurrentExists is true if the path still exists.
+	CurrentExists bool
+
+	// CurrentSize is the current size of the path in bytes.
+	CurrentSize int64
+
+	// CurrentChecksum is the current SHA-256 checksum of the path.
+	// Only computed for regular files.
+	CurrentChecksum string
+}
+
+// Modified returns true if the file still exists but its contents differ
+// from what was recorded.
+func (h HistoryEntry) Modified() bool {
+	return h.CurrentExists && h.Checksum != "" &&
+		h.CurrentChecksum != "" && h.CurrentChecksum != h.Checksum
+}
+
+// FileHistory returns the ledger entries for path, sorted by timestamp, each
+// annotated with the current state of the file. If path is empty, the history
+// of every entry in the ledger other than step markers is returned.
+func FileHistory(l *Ledger, path string) []HistoryEntry {
+	var history []HistoryEntry
+	for _, entry := range l.Entries {
+		// Step markers have no path to report on
+		if entry.Op == OpStepComplete {
+			continue
+		}
+		if path != "" && entry.Path != path {
+			continue
+		}
+
+		h := HistoryEntry{Entry: entry}
+		if info, err := os.Lstat(entry.Path); err == nil {
+			h.CurrentExists = true
+			h.CurrentSize = info.Size()
+			if info.Mode().IsRegular() {
+				h.CurrentChecksum, _ = Checksum(entry.Path)
+			}
+		}
+		history = append(history, h)
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp.Before(history[j].Timestamp)
+	})
+	return history
+}
@@ -0,0 +1,72 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileHistory(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	unchanged := filepath.Join(targetDir, "unchanged.txt")
+	modified := filepath.Join(targetDir, "modified.txt")
+	missing := filepath.Join(targetDir, "missing.txt")
+
+	content := []byte("installed")
+	for _, p := range []string{unchanged, modified} {
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	now := time.Now().UTC()
+	checksum := ChecksumBytes(content)
+	// Record out of order to verify sorting
+	l.Record(Entry{Op: OpFileCreate, Path: missing, Checksum: checksum, Size: 9, Timestamp: now.Add(2 * time.Second)})
+	l.Record(Entry{Op: OpFileCreate, Path: unchanged, Checksum: checksum, Size: 9, Timestamp: now})
+	l.Record(Entry{Op: OpFileCreate, Path: modified, Checksum: checksum, Size: 9, Timestamp: now.Add(time.Second)})
+	l.Record(Entry{Op: OpStepComplete, Step: 1, Timestamp: now.Add(3 * time.Second)})
+	l.Close()
+
+	if err := os.WriteFile(modified, []byte("changed by user"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	history := FileHistory(l, "")
+	if len(history) != 3 {
+		t.Fatalf("expected 3 history entries, got %d", len(history))
+	}
+
+	wantOrder := []string{unchanged, modified, missing}
+	for i, want := range wantOrder {
+		if history[i].Path != want {
+			t.Errorf("history[%d].Path = %s, want %s", i, history[i].Path, want)
+		}
+	}
+
+	if !history[0].CurrentExists || history[0].Modified() {
+		t.Errorf("unchanged file: exists=%v modified=%v", history[0].CurrentExists, history[0].Modified())
+	}
+	if !history[1].Modified() {
+		t.Error("modified file should be reported as modified")
+	}
+	if history[1].CurrentSize != int64(len("changed by user")) {
+		t.Errorf("CurrentSize = %d, want %d", history[1].CurrentSize, len("changed by user"))
+	}
+	if history[2].CurrentExists {
+		t.Error("missing file should not exist")
+	}
+
+	single := FileHistory(l, modified)
+	if len(single) != 1 || single[0].Path != modified {
+		t.Errorf("FileHistory(path) = %+v, want single entry for %s", single, modified)
+	}
+}
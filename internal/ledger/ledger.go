@@ -8,25 +8,32 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"time"
+
+	"github.com/anthropics/alloy/internal/paths"
 )
 
-// DefaultDir returns the default ledger directory (~/.alloy/ledgers).
+// DefaultDir returns the default ledger directory (ledgers under
+// paths.DataDir).
 func DefaultDir() (string, error) {
-	home, err := os.UserHomeDir()
+	dir, err := paths.DataDir()
 	if err != nil {
-		return "", fmt.Errorf("get home directory: %w", err)
+		return "", err
 	}
-	return filepath.Join(home, ".alloy", "ledgers"), nil
+	return filepath.Join(dir, "ledgers"), nil
 }
 
-// DefaultBackupDir returns the default backup directory (~/.alloy/backups).
+// DefaultBackupDir returns the default backup directory (backups under
+// paths.DataDir).
 func DefaultBackupDir() (string, error) {
-	home, err := os.UserHomeDir()
+	dir, err := paths.DataDir()
 	if err != nil {
-		return "", fmt.Errorf("get home directory: %w", err)
+		return "", err
 	}
-	return filepath.Join(home, ".alloy", "backups"), nil
+	return filepath.Join(dir, "backups"), nil
 }
 
 // Ledger tracks file system operations for a single package installation.
@@ -37,11 +44,38 @@ type Ledger struct {
 	// Entries contains all recorded operations in chronological order.
 	Entries []Entry
 
+	// SyncEachWrite if true, fsyncs the ledger file after every recorded
+	// entry so a crash never loses an entry for a completed operation.
+	// This is slower; otherwise the file is only synced on Close.
+	SyncEachWrite bool
+
 	// path is the file path where this ledger is persisted.
 	path string
 
 	// file is the open file handle for appending entries.
 	file *os.File
+
+	// validSize, if positive, is the size of the ledger file without an
+	// incomplete last entry, which Append cuts off before writing.
+	validSize int64
+}
+
+// Warnf reports problems the ledger package works around rather than
+// failing on, such as an incomplete last entry. By default they are
+// discarded; main sets it to print them.
+var Warnf = func(format string, args ...any) {}
+
+// validName matches package names that are safe to use as file names: they
+// start with a letter or digit and contain no path separators, so names such
+// as "../evil" can never reach outside the ledger directory.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// ValidateName returns an error if pkg is not a valid package name.
+func ValidateName(pkg string) error {
+	if !validName.MatchString(pkg) {
+		return fmt.Errorf("invalid package name %q (use letters, digits, '.', '_', '+' and '-')", pkg)
+	}
+	return nil
 }
 
 // Path returns the file path for a package's ledger.
@@ -49,13 +83,40 @@ func Path(dir, pkg string) string {
 	return filepath.Join(dir, pkg+".jsonl")
 }
 
+// InProgressPath returns the path of the marker file that flags a package's
+// installation as in progress.
+func InProgressPath(dir, pkg string) string {
+	return Path(dir, pkg) + ".inprogress"
+}
+
+// IsInProgress reports whether a package's installation was started but never
+// marked complete, e.g. because alloy crashed or was killed mid-install.
+func IsInProgress(dir, pkg string) bool {
+	_, err := os.Stat(InProgressPath(dir, pkg))
+	return err == nil
+}
+
 // Create creates a new ledger for a package installation.
 // The ledger file is created immediately and the header is written.
+// The ledger is marked in progress until MarkComplete is called.
 func Create(dir, pkg, source string) (*Ledger, error) {
+	return CreateWithHeader(dir, Header{
+		Package: pkg,
+		Source:  source,
+	})
+}
+
+// CreateWithHeader creates a new ledger using a caller-supplied header.
+// Version and InstalledAt are filled in if unset.
+func CreateWithHeader(dir string, header Header) (*Ledger, error) {
+	if err := ValidateName(header.Package); err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("create ledger directory: %w", err)
 	}
 
+	pkg := header.Package
 	path := Path(dir, pkg)
 
 	// Check if ledger already exists
@@ -63,16 +124,24 @@ func Create(dir, pkg, source string) (*Ledger, error) {
 		return nil, fmt.Errorf("ledger already exists for package %q", pkg)
 	}
 
+	// Write the marker before the ledger so a crash never leaves an
+	// unmarked ledger for an unfinished install
+	marker := InProgressPath(dir, pkg)
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return nil, fmt.Errorf("create in-progress marker: %w", err)
+	}
+
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
 	if err != nil {
+		os.Remove(marker)
 		return nil, fmt.Errorf("create ledger file: %w", err)
 	}
 
-	header := Header{
-		Version:     CurrentVersion,
-		Package:     pkg,
-		InstalledAt: time.Now().UTC(),
-		Source:      source,
+	if header.Version == 0 {
+		header.Version = CurrentVersion
+	}
+	if header.InstalledAt.IsZero() {
+		header.InstalledAt = time.Now().UTC()
 	}
 
 	l := &Ledger{
@@ -85,6 +154,7 @@ func Create(dir, pkg, source string) (*Ledger, error) {
 	if err := l.writeJSON(header); err != nil {
 		f.Close()
 		os.Remove(path)
+		os.Remove(marker)
 		return nil, fmt.Errorf("write header: %w", err)
 	}
 
@@ -94,6 +164,9 @@ func Create(dir, pkg, source string) (*Ledger, error) {
 // Open opens an existing ledger for reading.
 // The entire ledger is loaded into memory.
 func Open(dir, pkg string) (*Ledger, error) {
+	if err := ValidateName(pkg); err != nil {
+		return nil, err
+	}
 	path := Path(dir, pkg)
 	return OpenPath(path)
 }
@@ -108,12 +181,18 @@ func OpenPath(path string) (*Ledger, error) {
 
 	l := &Ledger{path: path}
 
-	scanner := bufio.NewScanner(f)
+	r := newLineReader(f)
 	lineNum := 0
 
-	for scanner.Scan() {
+	for {
+		line, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read ledger (line %d): %w", lineNum+1, err)
+		}
 		lineNum++
-		line := scanner.Bytes()
 
 		if lineNum == 1 {
 			// First line is the header
@@ -129,15 +208,27 @@ func OpenPath(path string) (*Ledger, error) {
 
 		var entry Entry
 		if err := json.Unmarshal(line, &entry); err != nil {
-			return nil, fmt.Errorf("parse entry (line %d): %w", lineNum, err)
+			// A crash while an entry was being written leaves it at the end
+			// of the file without its newline, after entries that are all
+			// intact. Any other line that doesn't parse is corrupt.
+			n := int64(len(line))
+			if _, nextErr := r.next(); nextErr != io.EOF {
+				return nil, fmt.Errorf("parse entry (line %d): %w", lineNum, err)
+			}
+			size, torn, tailErr := tornTail(f)
+			if tailErr != nil {
+				return nil, tailErr
+			}
+			if !torn {
+				return nil, fmt.Errorf("parse entry (line %d): %w", lineNum, err)
+			}
+			l.validSize = size - n
+			Warnf("%s: ignoring incomplete last entry (line %d): %v", path, lineNum, err)
+			break
 		}
 		l.Entries = append(l.Entries, entry)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("read ledger: %w", err)
-	}
-
 	if lineNum == 0 {
 		return nil, errors.New("ledger file is empty")
 	}
@@ -145,8 +236,29 @@ func OpenPath(path string) (*Ledger, error) {
 	return l, nil
 }
 
+// tornTail returns the size of the ledger file f and reports whether its
+// last line lacks a newline. Every line is written with one, so such a line
+// was cut short by a crash.
+func tornTail(f *os.File) (size int64, torn bool, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, fmt.Errorf("stat ledger file: %w", err)
+	}
+	if info.Size() == 0 {
+		return 0, false, nil
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return 0, false, fmt.Errorf("read ledger file: %w", err)
+	}
+	return info.Size(), last[0] != '\n', nil
+}
+
 // Append opens an existing ledger for appending new entries.
 func Append(dir, pkg string) (*Ledger, error) {
+	if err := ValidateName(pkg); err != nil {
+		return nil, err
+	}
 	path := Path(dir, pkg)
 
 	// First, read the existing ledger
@@ -161,6 +273,15 @@ func Append(dir, pkg string) (*Ledger, error) {
 		return nil, fmt.Errorf("open ledger for append: %w", err)
 	}
 
+	// Drop an incomplete last entry, so the next one starts on its own line
+	if l.validSize > 0 {
+		if err := f.Truncate(l.validSize); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("truncate incomplete entry: %w", err)
+		}
+		l.validSize = 0
+	}
+
 	l.file = f
 	return l, nil
 }
@@ -181,10 +302,32 @@ func (l *Ledger) Record(entry Entry) error {
 		return fmt.Errorf("write entry: %w", err)
 	}
 
+	if l.SyncEachWrite {
+		if err := l.file.Sync(); err != nil {
+			return fmt.Errorf("sync ledger: %w", err)
+		}
+	}
+
 	l.Entries = append(l.Entries, entry)
 	return nil
 }
 
+// MarkStepComplete records that install step number step (1-based) finished.
+func (l *Ledger) MarkStepComplete(step int) error {
+	return l.Record(Entry{Op: OpStepComplete, Step: step})
+}
+
+// LastCompletedStep returns the number of the last install step marked
+// complete, or 0 if the ledger has no step markers.
+func LastCompletedStep(l *Ledger) int {
+	for idx := len(l.Entries) - 1; idx >= 0; idx-- {
+		if l.Entries[idx].Op == OpStepComplete {
+			return l.Entries[idx].Step
+		}
+	}
+	return 0
+}
+
 // Close closes the ledger file.
 func (l *Ledger) Close() error {
 	if l.file != nil {
@@ -200,23 +343,184 @@ func (l *Ledger) Close() error {
 	return nil
 }
 
-// Delete removes the ledger file from disk.
+// MarkComplete clears the in-progress marker once an installation has finished.
+func (l *Ledger) MarkComplete() error {
+	if err := os.Remove(l.path + ".inprogress"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove in-progress marker: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the ledger file and its in-progress marker from disk.
 func (l *Ledger) Delete() error {
 	if l.file != nil {
 		l.file.Close()
 		l.file = nil
 	}
+	os.Remove(l.path + ".inprogress")
 	return os.Remove(l.path)
 }
 
+// Migrate rewrites the ledger of pkg in the current format if it was
+// written in an older one, and reports whether it did. Ledgers from before
+// format versions were recorded have version 0; fields added since are
+// optional, so migrating only records the current version.
+func Migrate(dir, pkg string) (bool, error) {
+	l, err := Open(dir, pkg)
+	if err != nil {
+		return false, err
+	}
+	if l.Header.Version >= CurrentVersion {
+		return false, nil
+	}
+	l.Header.Version = CurrentVersion
+	if err := l.Rewrite(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rewrite atomically replaces the ledger file with the in-memory header and
+// entries. Used when existing entries are updated rather than appended.
+func (l *Ledger) Rewrite() error {
+	tmpPath := l.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create temp ledger: %w", err)
+	}
+
+	if err := writeJSONLine(f, l.Header); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, entry := range l.Entries {
+		if err := writeJSONLine(f, entry); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("write entry: %w", err)
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("sync ledger: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("close ledger: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("replace ledger: %w", err)
+	}
+
+	// Reopen the append handle on the new file
+	if l.file != nil {
+		l.file.Close()
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			l.file = nil
+			return fmt.Errorf("open ledger for append: %w", err)
+		}
+		l.file = f
+	}
+
+	return nil
+}
+
+// SetPinned pins or unpins an installed package, rewriting its ledger
+// header. It reports whether the pin state changed.
+func SetPinned(dir, pkg string, pinned bool) (bool, error) {
+	l, err := Open(dir, pkg)
+	if err != nil {
+		return false, err
+	}
+	if l.Header.Pinned == pinned {
+		return false, nil
+	}
+	l.Header.Pinned = pinned
+	if err := l.Rewrite(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AddRequestedBy records in the ledger header of an installed package that
+// dependent requires it, so it isn't seen as unneeded while dependent is
+// installed. It reports whether the header changed.
+func AddRequestedBy(dir, pkg, dependent string) (bool, error) {
+	l, err := Open(dir, pkg)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(l.Header.RequestedBy, dependent) {
+		return false, nil
+	}
+	l.Header.RequestedBy = append(l.Header.RequestedBy, dependent)
+	if err := l.Rewrite(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TruncateTo atomically rewrites the ledger keeping only its first seq
+// entries, e.g. to forget the entries of failed install steps whose effects
+// were undone by hand. Entries are numbered from 1 in the order they were
+// recorded, so TruncateTo(0) keeps only the header.
+func (l *Ledger) TruncateTo(seq uint64) error {
+	if seq > uint64(len(l.Entries)) {
+		return fmt.Errorf("sequence number %d is past the last entry (%d)", seq, len(l.Entries))
+	}
+
+	all := l.Entries
+	l.Entries = all[:seq]
+	if err := l.Rewrite(); err != nil {
+		l.Entries = all
+		return err
+	}
+	return nil
+}
+
+// FindLastSuccessfulSeq returns the sequence number of the last file_create
+// entry whose file still exists with the recorded checksum. Entries up to it
+// are a reasonable guess at what an interrupted install completed, making it
+// a safe point for TruncateTo. It returns 0 if no entry qualifies.
+func FindLastSuccessfulSeq(l *Ledger) (uint64, error) {
+	for idx := len(l.Entries) - 1; idx >= 0; idx-- {
+		entry := l.Entries[idx]
+		if entry.Op != OpFileCreate {
+			continue
+		}
+		checksum, err := Checksum(entry.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("checksum %s: %w", entry.Path, err)
+		}
+		if checksum == entry.Checksum {
+			return uint64(idx + 1), nil
+		}
+	}
+	return 0, nil
+}
+
 // writeJSON writes a value as a single JSON line.
 func (l *Ledger) writeJSON(v any) error {
+	return writeJSONLine(l.file, v)
+}
+
+// writeJSONLine writes a value as a single JSON line to w.
+func writeJSONLine(w io.Writer, v any) error {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 	data = append(data, '\n')
-	_, err = l.file.Write(data)
+	_, err = w.Write(data)
 	return err
 }
 
@@ -243,8 +547,128 @@ func List(dir string) ([]string, error) {
 	return packages, nil
 }
 
+// PackageSummaryJSON is the JSON form of an installed package used by
+// `alloy list --json`. Files is only set by PackageSummary.
+type PackageSummaryJSON struct {
+	Name        string          `json:"name"`
+	InstalledAt time.Time       `json:"installed_at"`
+	Source      string          `json:"source,omitempty"`
+	Pinned      bool            `json:"pinned,omitempty"`
+	Files       json.RawMessage `json:"files,omitempty"`
+}
+
+// summaryFile is one element of PackageSummaryJSON.Files.
+type summaryFile struct {
+	Path     string `json:"path"`
+	Op       Op     `json:"op"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// HeaderSummary returns the summary of a package without its files, which
+// only needs the ledger header.
+func HeaderSummary(h Header) PackageSummaryJSON {
+	return PackageSummaryJSON{
+		Name:        h.Package,
+		InstalledAt: h.InstalledAt,
+		Source:      h.Source,
+		Pinned:      h.Pinned,
+	}
+}
+
+// PackageSummary returns the summary of a package including every path its
+// ledger records, grouped by operation and otherwise in ledger order.
+// Reverted entries are left out.
+func PackageSummary(l *Ledger) PackageSummaryJSON {
+	files := []summaryFile{}
+	for _, e := range l.Entries {
+		if e.Path == "" || e.Reverted {
+			continue
+		}
+		files = append(files, summaryFile{Path: e.Path, Op: e.Op, Checksum: e.Checksum})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Op < files[j].Op
+	})
+
+	summary := HeaderSummary(l.Header)
+	// Marshaling a slice of plain structs cannot fail.
+	summary.Files, _ = json.Marshal(files)
+	return summary
+}
+
+// SortKey selects the order ListSorted returns packages in.
+type SortKey string
+
+// Sort keys for ListSorted.
+const (
+	SortByName   SortKey = "name"
+	SortByDate   SortKey = "date"
+	SortBySize   SortKey = "size"
+	SortBySource SortKey = "source"
+)
+
+// ListSorted returns the names of all packages with ledgers in the directory,
+// ordered by the given key. Dates sort oldest first and sizes largest first;
+// ties are broken by name.
+func ListSorted(dir string, by SortKey) ([]string, error) {
+	packages, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Per-package values to sort on, keyed by name
+	dates := make(map[string]time.Time)
+	sizes := make(map[string]int64)
+	sources := make(map[string]string)
+
+	for _, name := range packages {
+		switch by {
+		case SortByName:
+		case SortByDate, SortBySource:
+			s, err := OpenStream(dir, name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			dates[name] = s.Header().InstalledAt
+			sources[name] = s.Header().Source
+			s.Close()
+		case SortBySize:
+			l, err := Open(dir, name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			sizes[name] = l.TotalInstalledSize()
+		default:
+			return nil, fmt.Errorf("unknown sort key %q", by)
+		}
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		a, b := packages[i], packages[j]
+		switch by {
+		case SortByDate:
+			if !dates[a].Equal(dates[b]) {
+				return dates[a].Before(dates[b])
+			}
+		case SortBySize:
+			if sizes[a] != sizes[b] {
+				return sizes[a] > sizes[b]
+			}
+		case SortBySource:
+			if sources[a] != sources[b] {
+				return sources[a] < sources[b]
+			}
+		}
+		return a < b
+	})
+	return packages, nil
+}
+
 // Exists checks if a ledger exists for the given package.
 func Exists(dir, pkg string) bool {
+	if ValidateName(pkg) != nil {
+		return false
+	}
 	_, err := os.Stat(Path(dir, pkg))
 	return err == nil
 }
@@ -252,47 +676,65 @@ func Exists(dir, pkg string) bool {
 // Stream reads ledger entries one at a time without loading all into memory.
 // Useful for large ledgers or when processing entries sequentially.
 type Stream struct {
+	path    string
 	file    *os.File
-	scanner *bufio.Scanner
+	reader  *lineReader
 	header  Header
 	lineNum int
 	err     error
+
+	// peeked is the entry a Seek stopped at, returned by the next Next.
+	peeked *Entry
 }
 
 // OpenStream opens a ledger for streaming reads.
 func OpenStream(dir, pkg string) (*Stream, error) {
+	if err := ValidateName(pkg); err != nil {
+		return nil, err
+	}
 	path := Path(dir, pkg)
 	return OpenStreamPath(path)
 }
 
 // OpenStreamPath opens a ledger stream from a file path.
 func OpenStreamPath(path string) (*Stream, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("open ledger file: %w", err)
+	s := &Stream{path: path}
+	if err := s.open(); err != nil {
+		return nil, err
 	}
+	return s, nil
+}
 
-	s := &Stream{
-		file:    f,
-		scanner: bufio.NewScanner(f),
+// open opens the ledger file and reads its header, leaving the stream
+// positioned at the first entry.
+func (s *Stream) open() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("open ledger file: %w", err)
 	}
+	reader := newLineReader(f)
 
 	// Read header
-	if !s.scanner.Scan() {
+	line, err := reader.next()
+	if err != nil {
 		f.Close()
-		if err := s.scanner.Err(); err != nil {
-			return nil, fmt.Errorf("read header: %w", err)
+		if err == io.EOF {
+			return errors.New("ledger file is empty")
 		}
-		return nil, errors.New("ledger file is empty")
+		return fmt.Errorf("read header: %w", err)
 	}
 
-	if err := json.Unmarshal(s.scanner.Bytes(), &s.header); err != nil {
+	var header Header
+	if err := json.Unmarshal(line, &header); err != nil {
 		f.Close()
-		return nil, fmt.Errorf("parse header: %w", err)
+		return fmt.Errorf("parse header: %w", err)
 	}
 
+	s.file, s.reader, s.header = f, reader, header
 	s.lineNum = 1
-	return s, nil
+	s.err = nil
+	s.peeked = nil
+	return nil
 }
 
 // Header returns the ledger header.
@@ -302,22 +744,36 @@ func (s *Stream) Header() Header {
 
 // Next reads the next entry. Returns io.EOF when done.
 func (s *Stream) Next() (Entry, error) {
+	if s.peeked != nil {
+		entry := *s.peeked
+		s.peeked = nil
+		return entry, nil
+	}
 	if s.err != nil {
 		return Entry{}, s.err
 	}
 
-	if !s.scanner.Scan() {
-		if err := s.scanner.Err(); err != nil {
-			s.err = err
-			return Entry{}, err
-		}
+	line, err := s.reader.next()
+	if err == io.EOF {
 		s.err = io.EOF
 		return Entry{}, io.EOF
 	}
+	if err != nil {
+		s.err = fmt.Errorf("read entry (line %d): %w", s.lineNum+1, err)
+		return Entry{}, s.err
+	}
 
 	s.lineNum++
 	var entry Entry
-	if err := json.Unmarshal(s.scanner.Bytes(), &entry); err != nil {
+	if err := json.Unmarshal(line, &entry); err != nil {
+		// An incomplete last entry ends the stream, as in OpenPath
+		if _, nextErr := s.reader.next(); nextErr == io.EOF {
+			if _, torn, _ := tornTail(s.file); torn {
+				Warnf("%s: ignoring incomplete last entry (line %d): %v", s.path, s.lineNum, err)
+				s.err = io.EOF
+				return Entry{}, io.EOF
+			}
+		}
 		s.err = fmt.Errorf("parse entry (line %d): %w", s.lineNum, err)
 		return Entry{}, s.err
 	}
@@ -325,7 +781,87 @@ func (s *Stream) Next() (Entry, error) {
 	return entry, nil
 }
 
+// Seek skips entries until one with the given operation, which the next
+// call to Next returns. It returns io.EOF if there is none.
+func (s *Stream) Seek(op Op) error {
+	return s.SeekAll(op)
+}
+
+// SeekAll skips entries until one with any of the given operations, which
+// the next call to Next returns. It returns io.EOF if there is none.
+func (s *Stream) SeekAll(ops ...Op) error {
+	for {
+		entry, err := s.Next()
+		if err != nil {
+			return err
+		}
+		if slices.Contains(ops, entry.Op) {
+			s.peeked = &entry
+			return nil
+		}
+	}
+}
+
+// Rewind reopens the ledger and rereads its header, so the entries can be
+// read again from the first, e.g. after a pass building an index.
+func (s *Stream) Rewind() error {
+	s.file.Close()
+	return s.open()
+}
+
 // Close closes the stream.
 func (s *Stream) Close() error {
 	return s.file.Close()
 }
+
+// MaxLineSize is the longest ledger line that can be read. Lines are
+// reassembled in a buffer that starts small and doubles up to this size.
+var MaxLineSize = 64 * 1024 * 1024
+
+// initialLineSize is the starting size of a lineReader's buffer.
+const initialLineSize = 64 * 1024
+
+// lineReader reads newline-terminated lines of any length up to
+// MaxLineSize, unlike bufio.Scanner which stops at 64 KB.
+type lineReader struct {
+	r    *bufio.Reader
+	line []byte
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{
+		r:    bufio.NewReaderSize(r, initialLineSize),
+		line: make([]byte, 0, initialLineSize),
+	}
+}
+
+// next returns the next line without its line ending. The returned slice is
+// only valid until the following call. It returns io.EOF after the last line.
+func (lr *lineReader) next() ([]byte, error) {
+	lr.line = lr.line[:0]
+	for {
+		chunk, isPrefix, err := lr.r.ReadLine()
+		if err != nil {
+			if err == io.EOF && len(lr.line) > 0 {
+				return lr.line, nil
+			}
+			return nil, err
+		}
+		if len(lr.line)+len(chunk) > MaxLineSize {
+			return nil, fmt.Errorf("line exceeds maximum size of %d bytes", MaxLineSize)
+		}
+		if len(lr.line)+len(chunk) > cap(lr.line) {
+			size := 2 * cap(lr.line)
+			for size < len(lr.line)+len(chunk) {
+				size *= 2
+			}
+			grown := make([]byte, len(lr.line), min(size, MaxLineSize))
+			copy(grown, lr.line)
+			lr.line = grown
+		}
+		lr.line = append(lr.line, chunk...)
+		if !isPrefix {
+			return lr.line, nil
+		}
+	}
+}
@@ -1,9 +1,16 @@
 package ledger
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/anthropics/alloy/internal/paths"
 )
 
 func TestCreateAndOpen(t *testing.T) {
@@ -120,6 +127,58 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestListSorted(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	// name, source, installed, size
+	pkgs := []struct {
+		name   string
+		source string
+		age    time.Duration
+		size   int64
+	}{
+		{"beta", "https://a.example.com", 3 * time.Hour, 100},
+		{"alpha", "https://c.example.com", 1 * time.Hour, 300},
+		{"gamma", "https://b.example.com", 2 * time.Hour, 200},
+	}
+	for _, p := range pkgs {
+		l, err := CreateWithHeader(dir, Header{
+			Package:     p.name,
+			Source:      p.source,
+			InstalledAt: now.Add(-p.age),
+		})
+		if err != nil {
+			t.Fatalf("Create %s: %v", p.name, err)
+		}
+		l.Record(Entry{Op: OpFileCreate, Path: "/tmp/" + p.name, Size: p.size})
+		l.Close()
+	}
+
+	tests := []struct {
+		by   SortKey
+		want string
+	}{
+		{SortByName, "[alpha beta gamma]"},
+		{SortByDate, "[beta gamma alpha]"},
+		{SortBySize, "[alpha gamma beta]"},
+		{SortBySource, "[beta gamma alpha]"},
+	}
+	for _, tt := range tests {
+		got, err := ListSorted(dir, tt.by)
+		if err != nil {
+			t.Fatalf("ListSorted(%s): %v", tt.by, err)
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("ListSorted(%s) = %s, want %s", tt.by, s, tt.want)
+		}
+	}
+
+	if _, err := ListSorted(dir, "color"); err == nil {
+		t.Error("expected error for unknown sort key")
+	}
+}
+
 func TestExists(t *testing.T) {
 	dir := t.TempDir()
 
@@ -208,6 +267,55 @@ func TestAppend(t *testing.T) {
 	}
 }
 
+func TestTruncateTo(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	good := filepath.Join(targetDir, "good")
+	changed := filepath.Join(targetDir, "changed")
+	os.WriteFile(good, []byte("good"), 0644)
+	os.WriteFile(changed, []byte("changed by failed step"), 0644)
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	l.Record(Entry{Op: OpFileCreate, Path: good, Checksum: ChecksumBytes([]byte("good"))})
+	l.Record(Entry{Op: OpDirCreate, Path: filepath.Join(targetDir, "dir")})
+	l.Record(Entry{Op: OpFileCreate, Path: changed, Checksum: ChecksumBytes([]byte("original"))})
+	l.Record(Entry{Op: OpFileCreate, Path: filepath.Join(targetDir, "missing"), Checksum: "abc"})
+
+	seq, err := FindLastSuccessfulSeq(l)
+	if err != nil {
+		t.Fatalf("FindLastSuccessfulSeq: %v", err)
+	}
+	if seq != 1 {
+		t.Fatalf("FindLastSuccessfulSeq = %d, want 1", seq)
+	}
+
+	if err := l.TruncateTo(seq); err != nil {
+		t.Fatalf("TruncateTo: %v", err)
+	}
+	// Appends after truncation land after the kept entries
+	l.Record(Entry{Op: OpFileCreate, Path: "/opt/after"})
+	l.Close()
+
+	reopened, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if len(reopened.Entries) != 2 || reopened.Entries[0].Path != good || reopened.Entries[1].Path != "/opt/after" {
+		t.Errorf("entries after truncate = %+v", reopened.Entries)
+	}
+
+	if err := reopened.TruncateTo(5); err == nil {
+		t.Error("expected error truncating past the last entry")
+	}
+	if len(reopened.Entries) != 2 {
+		t.Errorf("failed truncate changed entries: %d", len(reopened.Entries))
+	}
+}
+
 func TestOriginalFileTracking(t *testing.T) {
 	dir := t.TempDir()
 
@@ -261,3 +369,454 @@ func TestOriginalFileTracking(t *testing.T) {
 		t.Errorf("Original.BackupPath = %q, want %q", e.Original.BackupPath, "/backup/original")
 	}
 }
+
+func TestSyncEachWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	l.SyncEachWrite = true
+
+	if err := l.Record(Entry{Op: OpFileCreate, Path: "/usr/local/bin/a"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	// Without closing (as after a crash), the entry must already be on disk
+	l2, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if len(l2.Entries) != 1 || l2.Entries[0].Path != "/usr/local/bin/a" {
+		t.Errorf("expected synced entry to be readable, got %+v", l2.Entries)
+	}
+
+	// A failed sync must be reported rather than silently ignored
+	l.file.Close()
+	if err := l.Record(Entry{Op: OpFileCreate, Path: "/usr/local/bin/b"}); err == nil {
+		t.Error("expected error recording to a closed ledger file")
+	}
+	if len(l.Entries) != 1 {
+		t.Errorf("failed write should not be added to entries, got %d", len(l.Entries))
+	}
+}
+
+func TestInProgressMarker(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer l.Close()
+
+	if !IsInProgress(dir, "test-pkg") {
+		t.Error("new ledger should be marked in progress")
+	}
+
+	// The marker must not show up as a package
+	packages, err := List(dir)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(packages) != 1 {
+		t.Errorf("List() = %v, want only test-pkg", packages)
+	}
+
+	if err := l.MarkComplete(); err != nil {
+		t.Fatalf("MarkComplete: %v", err)
+	}
+	if IsInProgress(dir, "test-pkg") {
+		t.Error("ledger should not be in progress after MarkComplete")
+	}
+
+	// Marking complete twice is harmless
+	if err := l.MarkComplete(); err != nil {
+		t.Errorf("second MarkComplete: %v", err)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	long := "/opt/" + strings.Repeat("x", 200*1024)
+	l.Record(Entry{Op: OpSymlinkCreate, Path: "/opt/link", Target: long})
+	l.Record(Entry{Op: OpFileCreate, Path: "/opt/file"})
+	l.Close()
+
+	opened, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if len(opened.Entries) != 2 || opened.Entries[0].Target != long {
+		t.Fatalf("Open did not read the long entry intact")
+	}
+
+	s, err := OpenStream(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer s.Close()
+	entry, err := s.Next()
+	if err != nil || entry.Target != long {
+		t.Fatalf("Next() = %v, want the long entry", err)
+	}
+	if entry, err = s.Next(); err != nil || entry.Path != "/opt/file" {
+		t.Fatalf("Next() = %q, %v, want /opt/file", entry.Path, err)
+	}
+
+	// Lines beyond MaxLineSize are rejected rather than truncated
+	defer func(old int) { MaxLineSize = old }(MaxLineSize)
+	MaxLineSize = 100 * 1024
+	if _, err := Open(dir, "test-pkg"); err == nil || !strings.Contains(err.Error(), "maximum size") {
+		t.Errorf("Open with a line over MaxLineSize: err = %v", err)
+	}
+}
+
+func TestDefaultDirs(t *testing.T) {
+	dataDir, err := paths.DataDir()
+	if err != nil {
+		t.Fatalf("DataDir: %v", err)
+	}
+	for name, fn := range map[string]func() (string, error){
+		"ledgers": DefaultDir,
+		"backups": DefaultBackupDir,
+	} {
+		if got, err := fn(); err != nil || got != filepath.Join(dataDir, name) {
+			t.Errorf("%s dir = %q, %v, want it under %s", name, got, err, dataDir)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"ripgrep", "bat", "g++", "python3.12", "my_tool-2"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) = %v, want nil", name, err)
+		}
+	}
+
+	dir := t.TempDir()
+	ledgerDir := filepath.Join(dir, "ledgers")
+	for _, name := range []string{"", ".", "..", "../evil", "../../evil", "a/b", `a\b`, "/etc/passwd", ".hidden", "-rf", "a b"} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%q) = nil, want error", name)
+		}
+		if _, err := Create(ledgerDir, name, ""); err == nil {
+			t.Errorf("Create(%q) succeeded", name)
+		}
+		if _, err := Open(ledgerDir, name); err == nil || !strings.Contains(err.Error(), "invalid package name") {
+			t.Errorf("Open(%q) = %v, want invalid name error", name, err)
+		}
+		if Exists(ledgerDir, name) {
+			t.Errorf("Exists(%q) = true", name)
+		}
+	}
+
+	// Nothing was written outside the ledger directory
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "ledgers" {
+			t.Errorf("unexpected file %s outside the ledger directory", e.Name())
+		}
+	}
+}
+
+func TestPackageSummary(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Create(dir, "test-pkg", "https://example.com/pkg.tar.gz")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, e := range []Entry{
+		{Op: OpDirCreate, Path: "/opt/test"},
+		{Op: OpFileCreate, Path: "/opt/test/a", Checksum: "aaa"},
+		{Op: OpStepComplete, Step: 1},
+		{Op: OpFileCreate, Path: "/opt/test/b", Checksum: "bbb", Reverted: true},
+		{Op: OpFileCreate, Path: "/opt/test/c", Checksum: "ccc"},
+	} {
+		if err := l.Record(e); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	l.Close()
+
+	l, err = Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	summary := PackageSummary(l)
+	if summary.Name != "test-pkg" || summary.Source != "https://example.com/pkg.tar.gz" {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	want := `[{"path":"/opt/test","op":"dir_create"},` +
+		`{"path":"/opt/test/a","op":"file_create","checksum":"aaa"},` +
+		`{"path":"/opt/test/c","op":"file_create","checksum":"ccc"}]`
+	if string(summary.Files) != want {
+		t.Errorf("Files = %s, want %s", summary.Files, want)
+	}
+
+	data, err := json.Marshal(HeaderSummary(l.Header))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if strings.Contains(string(data), `"files"`) {
+		t.Errorf("HeaderSummary should omit files: %s", data)
+	}
+}
+
+func TestSetPinned(t *testing.T) {
+	dir := t.TempDir()
+	ledg, err := Create(dir, "test-pkg", "test-source")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	ledg.Record(Entry{Op: OpFileCreate, Path: "/opt/test/a", Checksum: "aaa"})
+	ledg.Close()
+
+	for _, step := range []struct {
+		pinned, changed bool
+	}{{true, true}, {true, false}, {false, true}, {false, false}} {
+		changed, err := SetPinned(dir, "test-pkg", step.pinned)
+		if err != nil {
+			t.Fatalf("SetPinned(%v): %v", step.pinned, err)
+		}
+		if changed != step.changed {
+			t.Errorf("SetPinned(%v) changed = %v, want %v", step.pinned, changed, step.changed)
+		}
+		got, err := Open(dir, "test-pkg")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if got.Header.Pinned != step.pinned || len(got.Entries) != 1 {
+			t.Errorf("after SetPinned(%v): pinned = %v with %d entries", step.pinned, got.Header.Pinned, len(got.Entries))
+		}
+	}
+
+	if _, err := SetPinned(dir, "missing", true); err == nil {
+		t.Error("SetPinned of a package that isn't installed: expected an error")
+	}
+}
+
+func TestAddRequestedBy(t *testing.T) {
+	dir := t.TempDir()
+	l, err := CreateWithHeader(dir, Header{Package: "lib", InstallReason: ReasonDependency, RequestedBy: []string{"app"}})
+	if err != nil {
+		t.Fatalf("CreateWithHeader: %v", err)
+	}
+	l.Record(Entry{Op: OpDirCreate, Path: "/opt/lib"})
+	l.Close()
+
+	for _, tc := range []struct {
+		dependent string
+		changed   bool
+	}{{"tool", true}, {"tool", false}, {"app", false}} {
+		changed, err := AddRequestedBy(dir, "lib", tc.dependent)
+		if err != nil || changed != tc.changed {
+			t.Errorf("AddRequestedBy(%s) = %v, %v, want %v", tc.dependent, changed, err, tc.changed)
+		}
+	}
+	l, err = Open(dir, "lib")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got := strings.Join(l.Header.RequestedBy, ","); got != "app,tool" || len(l.Entries) != 1 {
+		t.Errorf("requested by %s with %d entries, want app,tool with 1", got, len(l.Entries))
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	path := Path(dir, "old-pkg")
+	data := `{"package":"old-pkg","installed_at":"2024-01-01T00:00:00Z"}
+{"op":"file_create","path":"/opt/old/a","checksum":"aaa"}
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("write ledger: %v", err)
+	}
+
+	migrated, err := Migrate(dir, "old-pkg")
+	if err != nil || !migrated {
+		t.Fatalf("Migrate = %v, %v, want true", migrated, err)
+	}
+	l, err := Open(dir, "old-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if l.Header.Version != CurrentVersion || len(l.Entries) != 1 || l.Entries[0].Path != "/opt/old/a" {
+		t.Errorf("after Migrate: version %d with entries %+v", l.Header.Version, l.Entries)
+	}
+
+	if migrated, err := Migrate(dir, "old-pkg"); err != nil || migrated {
+		t.Errorf("Migrate of a current ledger = %v, %v, want false", migrated, err)
+	}
+}
+
+func TestStreamSeekAndRewind(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, e := range []Entry{
+		{Op: OpDirCreate, Path: "/opt/test"},
+		{Op: OpFileCreate, Path: "/opt/test/a"},
+		{Op: OpFileCreate, Path: "/opt/test/b"},
+		{Op: OpSymlinkCreate, Path: "/opt/test/link", Target: "a"},
+		{Op: OpDirCreate, Path: "/opt/test/sub"},
+		{Op: OpFileCreate, Path: "/opt/test/sub/c"},
+	} {
+		l.Record(e)
+	}
+	l.Close()
+
+	s, err := OpenStream(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer s.Close()
+
+	next := func() string {
+		t.Helper()
+		e, err := s.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		return e.Path
+	}
+
+	// Seek leaves the matching entry for Next
+	if err := s.Seek(OpSymlinkCreate); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if got := next(); got != "/opt/test/link" {
+		t.Errorf("after Seek(symlink_create) Next = %s", got)
+	}
+	if err := s.SeekAll(OpFileCreate, OpDirCreate); err != nil {
+		t.Fatalf("SeekAll: %v", err)
+	}
+	if got := next(); got != "/opt/test/sub" {
+		t.Errorf("after SeekAll Next = %s, want /opt/test/sub", got)
+	}
+	if err := s.Seek(OpSymlinkCreate); err != io.EOF {
+		t.Errorf("Seek past the last match: err = %v, want io.EOF", err)
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("Next at the end: err = %v, want io.EOF", err)
+	}
+
+	// A second pass starts from the first entry again
+	if err := s.Rewind(); err != nil {
+		t.Fatalf("Rewind: %v", err)
+	}
+	if s.Header().Package != "test-pkg" {
+		t.Errorf("header after Rewind = %+v", s.Header())
+	}
+	if got := next(); got != "/opt/test" {
+		t.Errorf("first entry after Rewind = %s", got)
+	}
+	count := 1
+	for {
+		if _, err := s.Next(); err != nil {
+			break
+		}
+		count++
+	}
+	if count != 6 {
+		t.Errorf("second pass read %d entries, want 6", count)
+	}
+}
+
+func TestOpenIncompleteLastEntry(t *testing.T) {
+	dir := t.TempDir()
+	path := Path(dir, "torn-pkg")
+	data := `{"package":"torn-pkg","version":1,"installed_at":"2024-01-01T00:00:00Z"}
+{"op":"file_create","path":"/opt/torn/a","checksum":"aaa"}
+{"op":"file_cre`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("write ledger: %v", err)
+	}
+	var warnings []string
+	defer func(old func(string, ...any)) { Warnf = old }(Warnf)
+	Warnf = func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	l, err := Open(dir, "torn-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if len(l.Entries) != 1 || l.Entries[0].Path != "/opt/torn/a" {
+		t.Errorf("entries = %+v, want the one complete entry", l.Entries)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "line 3") {
+		t.Errorf("warnings = %q, want one for line 3", warnings)
+	}
+
+	s, err := OpenStream(dir, "torn-pkg")
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	if _, err := s.Next(); err != nil {
+		t.Errorf("first Next: %v", err)
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("Next at the incomplete entry: err = %v, want io.EOF", err)
+	}
+	s.Close()
+
+	// Appending cuts off the incomplete entry first
+	l, err = Append(dir, "torn-pkg")
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := l.Record(Entry{Op: OpFileCreate, Path: "/opt/torn/b"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	l.Close()
+	warnings = nil
+	l, err = Open(dir, "torn-pkg")
+	if err != nil {
+		t.Fatalf("Open after Append: %v", err)
+	}
+	if len(l.Entries) != 2 || l.Entries[1].Path != "/opt/torn/b" || len(warnings) != 0 {
+		t.Errorf("after Append: entries %+v, warnings %q", l.Entries, warnings)
+	}
+
+	// A corrupt entry before the last is still an error
+	data = strings.Replace(data, `"checksum":"aaa"}`, `"checksum":`, 1) + "\n" + `{"op":"file_create","path":"/opt/torn/c"}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("write ledger: %v", err)
+	}
+	if _, err := Open(dir, "torn-pkg"); err == nil {
+		t.Error("Open with a corrupt entry in the middle should fail")
+	}
+
+	// So is a complete last line that doesn't parse, which no crash leaves
+	data = strings.SplitAfter(data, "\n")[0] + `{"op":"file_create","path":` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("write ledger: %v", err)
+	}
+	if _, err := Open(dir, "torn-pkg"); err == nil {
+		t.Error("Open with a corrupt, newline-terminated last entry should fail")
+	}
+	if _, err := Append(dir, "torn-pkg"); err == nil {
+		t.Error("Append to a ledger with a corrupt last entry should fail")
+	}
+	s, err = OpenStream(dir, "torn-pkg")
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next at a corrupt last entry: err = %v, want a parse error", err)
+	}
+}
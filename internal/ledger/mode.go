@@ -0,0 +1,46 @@
+package ledger
+
+import "os"
+
+// ModeMask selects the parts of an os.FileMode the ledger records: the
+// permission bits together with the setuid, setgid and sticky bits.
+const ModeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Unix mode bits for setuid, setgid and sticky, as in chmod 4755.
+const (
+	unixSetuid = 04000
+	unixSetgid = 02000
+	unixSticky = 01000
+)
+
+// UnixMode converts mode to the Unix mode bits stored in Entry.Mode and
+// OriginalFile.Mode, e.g. 04755 for a setuid executable.
+func UnixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= unixSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= unixSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= unixSticky
+	}
+	return m
+}
+
+// FileMode converts Unix mode bits, as recorded in the ledger or given in
+// a package definition, to an os.FileMode.
+func FileMode(m uint32) os.FileMode {
+	mode := os.FileMode(m).Perm()
+	if m&unixSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&unixSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&unixSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
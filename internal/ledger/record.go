@@ -1,6 +1,7 @@
 package ledger
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
@@ -11,47 +12,93 @@ import (
 // Recorder provides high-level methods for recording file operations.
 // It wraps a Ledger and handles checksum computation and backup creation.
 type Recorder struct {
+	// CompressBackups if true, stores new backups gzip-compressed as
+	// <checksum>.gz instead of plain <checksum> files.
+	CompressBackups bool
+
+	// OnRecord is called with each entry after it is written to the ledger.
+	OnRecord func(entry Entry)
+
 	ledger    *Ledger
 	backupDir string
 	pkg       string
+	noBackup  bool
+	stats     RecorderStats
+}
+
+// RecorderStats counts the operations a Recorder has recorded.
+type RecorderStats struct {
+	FilesCreated     int
+	FilesOverwritten int
+	FilesAppended    int
+	DirsCreated      int
+	SymlinksCreated  int
+	HardlinksCreated int
+	FilesDeleted     int
+
+	// BytesTracked is the total size of files recorded in the ledger.
+	BytesTracked int64
+
+	// BackupsCreated is the number of backup files written.
+	// Deduplicated backups that already existed are not counted.
+	BackupsCreated int
+
+	// BackupBytesWritten is the on-disk size of the backups written.
+	BackupBytesWritten int64
 }
 
 // NewRecorder creates a new Recorder wrapping the given ledger.
-// Backups of overwritten/deleted files are stored in backupDir/<pkg>/.
+// Backups of overwritten/deleted files are stored in backupDir/<pkg>/,
+// unless the ledger header has NoBackup set.
 func NewRecorder(l *Ledger, backupDir string) *Recorder {
 	return &Recorder{
 		ledger:    l,
 		backupDir: backupDir,
 		pkg:       l.Header.Package,
+		noBackup:  l.Header.NoBackup,
 	}
 }
 
 // RecordFileCreate records creation of a new file.
 // Computes the file's checksum automatically.
 func (r *Recorder) RecordFileCreate(path string) error {
+	return r.recordFileCreate(path, false)
+}
+
+// RecordFilePreexisting records a file the package would have created but
+// that was already there with the same content, so the package owns it
+// without removing it on uninstall.
+func (r *Recorder) RecordFilePreexisting(path string) error {
+	return r.recordFileCreate(path, true)
+}
+
+// recordFileCreate records an OpFileCreate entry for path.
+func (r *Recorder) recordFileCreate(path string, preexisting bool) error {
 	info, err := os.Lstat(path)
 	if err != nil {
 		return fmt.Errorf("stat file: %w", err)
 	}
 
-	checksum, err := Checksum(path)
+	checksum, checksum512, err := checksumBoth(path)
 	if err != nil {
 		return fmt.Errorf("compute checksum: %w", err)
 	}
 
 	entry := Entry{
-		Op:        OpFileCreate,
-		Path:      path,
-		Timestamp: time.Now().UTC(),
-		Mode:      uint32(info.Mode().Perm()),
-		Size:      info.Size(),
-		Checksum:  checksum,
+		Op:          OpFileCreate,
+		Path:        path,
+		Timestamp:   time.Now().UTC(),
+		Mode:        UnixMode(info.Mode()),
+		Size:        info.Size(),
+		Checksum:    checksum,
+		Checksum512: checksum512,
+		Preexisting: preexisting,
 	}
 
 	// Get ownership info (Unix-specific, handled in stat helper)
 	entry.UID, entry.GID = getOwnership(info)
 
-	return r.ledger.Record(entry)
+	return r.record(entry)
 }
 
 // RecordFileDelete records deletion of a file.
@@ -74,14 +121,14 @@ func (r *Recorder) RecordFileDelete(path string) error {
 			Path:      path,
 			Timestamp: time.Now().UTC(),
 			Original: &OriginalFile{
-				Mode:    uint32(info.Mode().Perm()),
+				Mode:    UnixMode(info.Mode()),
 				Target:  target,
 				ModTime: info.ModTime(),
 			},
 		}
 		entry.Original.UID, entry.Original.GID = getOwnership(info)
 
-		return r.ledger.Record(entry)
+		return r.record(entry)
 	}
 
 	// Regular file: compute checksum and create backup
@@ -90,7 +137,7 @@ func (r *Recorder) RecordFileDelete(path string) error {
 		return fmt.Errorf("compute checksum: %w", err)
 	}
 
-	backupPath, err := r.createBackup(path, checksum)
+	backupPath, err := r.backup(path, checksum)
 	if err != nil {
 		return fmt.Errorf("create backup: %w", err)
 	}
@@ -102,7 +149,7 @@ func (r *Recorder) RecordFileDelete(path string) error {
 		Path:      path,
 		Timestamp: time.Now().UTC(),
 		Original: &OriginalFile{
-			Mode:       uint32(info.Mode().Perm()),
+			Mode:       UnixMode(info.Mode()),
 			UID:        uid,
 			GID:        gid,
 			Size:       info.Size(),
@@ -112,7 +159,7 @@ func (r *Recorder) RecordFileDelete(path string) error {
 		},
 	}
 
-	return r.ledger.Record(entry)
+	return r.record(entry)
 }
 
 // RecordFileOverwrite records replacement of an existing file.
@@ -136,7 +183,7 @@ func (r *Recorder) RecordFileOverwriteWithBackup(path string, orig *OriginalFile
 		Op:        OpFileOverwrite,
 		Path:      path,
 		Timestamp: time.Now().UTC(),
-		Mode:      uint32(newMode.Perm()),
+		Mode:      UnixMode(newMode),
 		UID:       uid,
 		GID:       gid,
 		Size:      newSize,
@@ -144,7 +191,32 @@ func (r *Recorder) RecordFileOverwriteWithBackup(path string, orig *OriginalFile
 		Original:  orig,
 	}
 
-	return r.ledger.Record(entry)
+	return r.record(entry)
+}
+
+// RecordFileAppend records that data was appended to the file at path,
+// starting at offset. Call it after appending.
+func (r *Recorder) RecordFileAppend(path string, offset int64, data []byte) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	uid, gid := getOwnership(info)
+
+	entry := Entry{
+		Op:        OpFileAppend,
+		Path:      path,
+		Timestamp: time.Now().UTC(),
+		Mode:      UnixMode(info.Mode()),
+		UID:       uid,
+		GID:       gid,
+		Size:      int64(len(data)),
+		Appended:  data,
+		Offset:    offset,
+	}
+
+	return r.record(entry)
 }
 
 // PrepareOverwrite prepares to overwrite a file by backing it up.
@@ -166,7 +238,7 @@ func (r *Recorder) PrepareOverwrite(path string) (*OriginalFile, error) {
 		}
 		uid, gid := getOwnership(info)
 		return &OriginalFile{
-			Mode:    uint32(info.Mode().Perm()),
+			Mode:    UnixMode(info.Mode()),
 			UID:     uid,
 			GID:     gid,
 			Target:  target,
@@ -180,7 +252,7 @@ func (r *Recorder) PrepareOverwrite(path string) (*OriginalFile, error) {
 		return nil, fmt.Errorf("compute checksum: %w", err)
 	}
 
-	backupPath, err := r.createBackup(path, checksum)
+	backupPath, err := r.backup(path, checksum)
 	if err != nil {
 		return nil, fmt.Errorf("create backup: %w", err)
 	}
@@ -188,7 +260,7 @@ func (r *Recorder) PrepareOverwrite(path string) (*OriginalFile, error) {
 	uid, gid := getOwnership(info)
 
 	return &OriginalFile{
-		Mode:       uint32(info.Mode().Perm()),
+		Mode:       UnixMode(info.Mode()),
 		UID:        uid,
 		GID:        gid,
 		Size:       info.Size(),
@@ -211,12 +283,12 @@ func (r *Recorder) RecordDirCreate(path string) error {
 		Op:        OpDirCreate,
 		Path:      path,
 		Timestamp: time.Now().UTC(),
-		Mode:      uint32(info.Mode().Perm()),
+		Mode:      UnixMode(info.Mode()),
 		UID:       uid,
 		GID:       gid,
 	}
 
-	return r.ledger.Record(entry)
+	return r.record(entry)
 }
 
 // RecordSymlinkCreate records creation of a symbolic link.
@@ -232,13 +304,13 @@ func (r *Recorder) RecordSymlinkCreate(path, target string) error {
 		Op:        OpSymlinkCreate,
 		Path:      path,
 		Timestamp: time.Now().UTC(),
-		Mode:      uint32(info.Mode().Perm()),
+		Mode:      UnixMode(info.Mode()),
 		UID:       uid,
 		GID:       gid,
 		Target:    target,
 	}
 
-	return r.ledger.Record(entry)
+	return r.record(entry)
 }
 
 // RecordHardlinkCreate records creation of a hard link.
@@ -259,7 +331,7 @@ func (r *Recorder) RecordHardlinkCreate(path, target string) error {
 		Op:        OpHardlinkCreate,
 		Path:      path,
 		Timestamp: time.Now().UTC(),
-		Mode:      uint32(info.Mode().Perm()),
+		Mode:      UnixMode(info.Mode()),
 		UID:       uid,
 		GID:       gid,
 		Size:      info.Size(),
@@ -267,7 +339,48 @@ func (r *Recorder) RecordHardlinkCreate(path, target string) error {
 		Target:    target,
 	}
 
-	return r.ledger.Record(entry)
+	return r.record(entry)
+}
+
+// Stats returns a copy of the recording metrics collected so far.
+func (r *Recorder) Stats() RecorderStats {
+	return r.stats
+}
+
+// record writes an entry to the ledger and updates the stats on success.
+func (r *Recorder) record(entry Entry) error {
+	if err := r.ledger.Record(entry); err != nil {
+		return err
+	}
+
+	switch entry.Op {
+	case OpFileCreate:
+		r.stats.FilesCreated++
+		r.stats.BytesTracked += entry.Size
+	case OpFileOverwrite:
+		r.stats.FilesOverwritten++
+		r.stats.BytesTracked += entry.Size
+	case OpFileAppend:
+		r.stats.FilesAppended++
+		r.stats.BytesTracked += entry.Size
+	case OpFileDelete:
+		r.stats.FilesDeleted++
+		if entry.Original != nil {
+			r.stats.BytesTracked += entry.Original.Size
+		}
+	case OpDirCreate:
+		r.stats.DirsCreated++
+	case OpSymlinkCreate:
+		r.stats.SymlinksCreated++
+	case OpHardlinkCreate:
+		r.stats.HardlinksCreated++
+		r.stats.BytesTracked += entry.Size
+	}
+
+	if r.OnRecord != nil {
+		r.OnRecord(entry)
+	}
+	return nil
 }
 
 // Close closes the underlying ledger.
@@ -275,6 +388,15 @@ func (r *Recorder) Close() error {
 	return r.ledger.Close()
 }
 
+// backup creates a backup of a file unless backups are disabled,
+// in which case it returns an empty path.
+func (r *Recorder) backup(path, checksum string) (string, error) {
+	if r.noBackup {
+		return "", nil
+	}
+	return r.createBackup(path, checksum)
+}
+
 // createBackup copies a file to the backup directory.
 // Returns the backup path.
 func (r *Recorder) createBackup(path, checksum string) (string, error) {
@@ -286,9 +408,15 @@ func (r *Recorder) createBackup(path, checksum string) (string, error) {
 	// Use checksum as filename to deduplicate identical files
 	backupPath := filepath.Join(pkgBackupDir, checksum)
 
-	// Skip if backup already exists (same content)
-	if _, err := os.Stat(backupPath); err == nil {
-		return backupPath, nil
+	// Skip if backup already exists (same content), compressed or not
+	for _, existing := range []string{backupPath, backupPath + CompressedBackupSuffix} {
+		if _, err := os.Stat(existing); err == nil {
+			return existing, nil
+		}
+	}
+
+	if r.CompressBackups {
+		backupPath += CompressedBackupSuffix
 	}
 
 	// Copy file to backup
@@ -304,7 +432,17 @@ func (r *Recorder) createBackup(path, checksum string) (string, error) {
 	}
 	defer dst.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
+	if r.CompressBackups {
+		gzw := gzip.NewWriter(dst)
+		if _, err := io.Copy(gzw, src); err != nil {
+			os.Remove(backupPath)
+			return "", err
+		}
+		if err := gzw.Close(); err != nil {
+			os.Remove(backupPath)
+			return "", err
+		}
+	} else if _, err := io.Copy(dst, src); err != nil {
 		os.Remove(backupPath)
 		return "", err
 	}
@@ -314,5 +452,10 @@ func (r *Recorder) createBackup(path, checksum string) (string, error) {
 		return "", err
 	}
 
+	r.stats.BackupsCreated++
+	if info, err := dst.Stat(); err == nil {
+		r.stats.BackupBytesWritten += info.Size()
+	}
+
 	return backupPath, nil
 }
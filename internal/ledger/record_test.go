@@ -60,6 +60,32 @@ func TestRecorderFileCreate(t *testing.T) {
 	}
 }
 
+func TestRecorderOnRecord(t *testing.T) {
+	l, err := Create(t.TempDir(), "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer l.Close()
+
+	var seen []Entry
+	r := NewRecorder(l, t.TempDir())
+	r.OnRecord = func(entry Entry) { seen = append(seen, entry) }
+
+	dir := filepath.Join(t.TempDir(), "dir")
+	os.Mkdir(dir, 0755)
+	if err := r.RecordDirCreate(dir); err != nil {
+		t.Fatalf("RecordDirCreate: %v", err)
+	}
+	// A failed record is not reported
+	if err := r.RecordFileCreate(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected error recording a missing file")
+	}
+
+	if len(seen) != 1 || seen[0].Op != OpDirCreate || seen[0].Path != dir {
+		t.Errorf("OnRecord saw %+v, want one dir_create for %s", seen, dir)
+	}
+}
+
 func TestRecorderDirCreate(t *testing.T) {
 	ledgerDir := t.TempDir()
 	backupDir := t.TempDir()
@@ -387,3 +413,133 @@ func TestRecorderDeleteSymlink(t *testing.T) {
 		t.Error("BackupPath should be empty for symlink")
 	}
 }
+
+func TestRecorderCompressBackups(t *testing.T) {
+	ledgerDir := t.TempDir()
+	backupDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	l, err := Create(ledgerDir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	r := NewRecorder(l, backupDir)
+	r.CompressBackups = true
+
+	testFile := filepath.Join(targetDir, "test.txt")
+	originalContent := []byte("original content")
+	if err := os.WriteFile(testFile, originalContent, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	orig, err := r.PrepareOverwrite(testFile)
+	if err != nil {
+		t.Fatalf("PrepareOverwrite: %v", err)
+	}
+	r.Close()
+
+	// Backup is named after the uncompressed content's checksum
+	want := filepath.Join(backupDir, "test-pkg", ChecksumBytes(originalContent)+CompressedBackupSuffix)
+	if orig.BackupPath != want {
+		t.Errorf("BackupPath = %q, want %q", orig.BackupPath, want)
+	}
+
+	match, err := VerifyBackup(orig.BackupPath, orig.Checksum)
+	if err != nil {
+		t.Fatalf("VerifyBackup: %v", err)
+	}
+	if !match {
+		t.Error("compressed backup should verify against the original checksum")
+	}
+
+	// Restoring through the replay path decompresses transparently
+	restored := filepath.Join(targetDir, "restored.txt")
+	if err := copyFile(orig.BackupPath, restored); err != nil {
+		t.Fatalf("copyFile: %v", err)
+	}
+	content, err := os.ReadFile(restored)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(content) != string(originalContent) {
+		t.Errorf("restored content = %q, want %q", content, originalContent)
+	}
+}
+
+func TestRecorderStats(t *testing.T) {
+	ledgerDir := t.TempDir()
+	backupDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	l, err := Create(ledgerDir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	r := NewRecorder(l, backupDir)
+	defer r.Close()
+
+	if r.Stats() != (RecorderStats{}) {
+		t.Fatalf("new recorder should have zero stats, got %+v", r.Stats())
+	}
+
+	// Two created files
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(targetDir, name)
+		if err := os.WriteFile(path, []byte("12345"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if err := r.RecordFileCreate(path); err != nil {
+			t.Fatalf("RecordFileCreate: %v", err)
+		}
+	}
+
+	// One directory
+	dir := filepath.Join(targetDir, "sub")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := r.RecordDirCreate(dir); err != nil {
+		t.Fatalf("RecordDirCreate: %v", err)
+	}
+
+	// One symlink
+	link := filepath.Join(targetDir, "link")
+	if err := os.Symlink("a.txt", link); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if err := r.RecordSymlinkCreate(link, "a.txt"); err != nil {
+		t.Fatalf("RecordSymlinkCreate: %v", err)
+	}
+
+	// One overwrite with a backup
+	over := filepath.Join(targetDir, "over.txt")
+	if err := os.WriteFile(over, []byte("old"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	orig, err := r.PrepareOverwrite(over)
+	if err != nil {
+		t.Fatalf("PrepareOverwrite: %v", err)
+	}
+	newContent := []byte("new content")
+	if err := os.WriteFile(over, newContent, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := r.RecordFileOverwriteWithBackup(over, orig, ChecksumBytes(newContent), int64(len(newContent)), 0644); err != nil {
+		t.Fatalf("RecordFileOverwriteWithBackup: %v", err)
+	}
+
+	want := RecorderStats{
+		FilesCreated:       2,
+		FilesOverwritten:   1,
+		DirsCreated:        1,
+		SymlinksCreated:    1,
+		BytesTracked:       5 + 5 + int64(len(newContent)),
+		BackupsCreated:     1,
+		BackupBytesWritten: 3,
+	}
+	if got := r.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package ledger
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -38,6 +39,14 @@ type ReplayResult struct {
 	// ModifiedFiles lists files that were modified externally
 	// (checksum mismatch) but were still processed.
 	ModifiedFiles []string
+
+	// SkippedModified lists created files that were modified externally
+	// and left in place without an error because SkipModified was set.
+	SkippedModified []string
+
+	// NonEmptyDirs lists directories the package created that were left
+	// in place because they still contain files it didn't install.
+	NonEmptyDirs []string
 }
 
 // HasErrors returns true if any errors occurred during replay.
@@ -45,6 +54,25 @@ func (r *ReplayResult) HasErrors() bool {
 	return len(r.Errors) > 0
 }
 
+// Actions passed to ReplayOptions.OnEntry that a caller may want to act on.
+// Other actions are free-form descriptions such as "deleted" or
+// "skip (not a file)".
+const (
+	// ActionWouldDelete means a dry run would delete the installed file.
+	ActionWouldDelete = "would-delete"
+
+	// ActionWouldRestore means a dry run would restore the original file
+	// from its backup.
+	ActionWouldRestore = "would-restore"
+
+	// ActionSkipModified means the file changed since it was installed and
+	// is left in place.
+	ActionSkipModified = "skip-modified"
+
+	// ActionSkipNotFound means the file is already gone.
+	ActionSkipNotFound = "skip-not-found"
+)
+
 // ReplayOptions configures the reverse replay behavior.
 type ReplayOptions struct {
 	// DryRun if true, doesn't actually perform operations.
@@ -62,6 +90,16 @@ type ReplayOptions struct {
 
 	// KeepBackups if true, doesn't delete backup files after restore.
 	KeepBackups bool
+
+	// NoBackup if true, entries without a backup are not treated as errors:
+	// deleted originals are left alone and overwritten files are removed.
+	// ReverseReplay sets this automatically for ledgers with Header.NoBackup.
+	NoBackup bool
+
+	// SkipModified if true, files the package created that were modified
+	// since are left in place as skipped entries rather than reported in
+	// ModifiedFiles, so a user's changes to config files are kept.
+	SkipModified bool
 }
 
 // ReverseReplay undoes all operations in the ledger in reverse order.
@@ -69,16 +107,43 @@ type ReplayOptions struct {
 func ReverseReplay(l *Ledger, opts ReplayOptions) (*ReplayResult, error) {
 	result := &ReplayResult{}
 
+	if l.Header.NoBackup {
+		opts.NoBackup = true
+	}
+
 	// Process entries in reverse order
 	for i := len(l.Entries) - 1; i >= 0; i-- {
 		entry := l.Entries[i]
 
+		// Step markers record no change to undo
+		if entry.Op == OpStepComplete {
+			continue
+		}
+
+		if entry.Reverted {
+			if opts.OnEntry != nil {
+				opts.OnEntry(entry, "skip (reverted)")
+			}
+			result.Skipped++
+			continue
+		}
+
 		action, err := replayEntry(entry, opts)
 		if opts.OnEntry != nil {
 			opts.OnEntry(entry, action)
 		}
 
 		if err != nil {
+			if errors.Is(err, errNotEmpty) {
+				result.NonEmptyDirs = append(result.NonEmptyDirs, entry.Path)
+				result.Skipped++
+				continue
+			}
+			if errors.Is(err, errKeptModified) {
+				result.SkippedModified = append(result.SkippedModified, entry.Path)
+				result.Skipped++
+				continue
+			}
 			if errors.Is(err, errSkipped) {
 				result.Skipped++
 				continue
@@ -102,9 +167,73 @@ func ReverseReplay(l *Ledger, opts ReplayOptions) (*ReplayResult, error) {
 	return result, nil
 }
 
+// RestoreOriginals restores the files a package overwrote, appended to, or
+// deleted, leaving the files it created in place. Restored entries are marked Reverted and the
+// ledger file is rewritten, so a later ReverseReplay won't undo them again.
+func RestoreOriginals(l *Ledger, opts ReplayOptions) (*ReplayResult, error) {
+	result := &ReplayResult{}
+
+	if l.Header.NoBackup {
+		opts.NoBackup = true
+	}
+
+	changed := false
+	for i := len(l.Entries) - 1; i >= 0; i-- {
+		entry := l.Entries[i]
+		if entry.Reverted || (entry.Op != OpFileOverwrite && entry.Op != OpFileAppend && entry.Op != OpFileDelete) {
+			continue
+		}
+
+		action, err := replayEntry(entry, opts)
+		if opts.OnEntry != nil {
+			opts.OnEntry(entry, action)
+		}
+
+		if err != nil {
+			if errors.Is(err, errSkipped) {
+				result.Skipped++
+				continue
+			}
+			// Files changed since install are never clobbered; Force
+			// only suppresses the error
+			if errors.Is(err, errModified) {
+				result.ModifiedFiles = append(result.ModifiedFiles, entry.Path)
+				if opts.Force {
+					result.Skipped++
+					continue
+				}
+			}
+			result.Errors = append(result.Errors, ReplayError{Entry: entry, Err: err})
+			continue
+		}
+
+		if !opts.DryRun {
+			l.Entries[i].Reverted = true
+			changed = true
+		}
+		result.Processed++
+	}
+
+	if changed {
+		if err := l.Rewrite(); err != nil {
+			return result, fmt.Errorf("update ledger: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
 var (
 	errSkipped  = errors.New("skipped")
 	errModified = errors.New("file was modified externally")
+
+	// errNotEmpty wraps errSkipped so callers that only check for a skip
+	// still treat it as one.
+	errNotEmpty = fmt.Errorf("directory not empty: %w", errSkipped)
+
+	// errKeptModified wraps errSkipped for a modified file left in place
+	// because of ReplayOptions.SkipModified.
+	errKeptModified = fmt.Errorf("file was modified externally: %w", errSkipped)
 )
 
 // replayEntry undoes a single ledger entry.
@@ -116,6 +245,8 @@ func replayEntry(entry Entry, opts ReplayOptions) (string, error) {
 		return replayFileDelete(entry, opts)
 	case OpFileOverwrite:
 		return replayFileOverwrite(entry, opts)
+	case OpFileAppend:
+		return replayFileAppend(entry, opts)
 	case OpDirCreate:
 		return replayDirCreate(entry, opts)
 	case OpSymlinkCreate:
@@ -129,11 +260,16 @@ func replayEntry(entry Entry, opts ReplayOptions) (string, error) {
 
 // replayFileCreate undoes a file creation by deleting the file.
 func replayFileCreate(entry Entry, opts ReplayOptions) (string, error) {
+	// The file was there before the package was installed
+	if entry.Preexisting {
+		return "skip (preexisting)", errSkipped
+	}
+
 	// Check if file exists
 	info, err := os.Lstat(entry.Path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "skip (not found)", errSkipped
+			return ActionSkipNotFound, errSkipped
 		}
 		return "error", fmt.Errorf("stat file: %w", err)
 	}
@@ -143,19 +279,23 @@ func replayFileCreate(entry Entry, opts ReplayOptions) (string, error) {
 		return "skip (not a file)", errSkipped
 	}
 
-	// Verify checksum if available
-	if entry.Checksum != "" {
-		match, err := VerifyChecksum(entry.Path, entry.Checksum)
+	// Verify checksums if available; a mismatch of either means the file
+	// was modified
+	if entry.Checksum != "" || entry.Checksum512 != "" {
+		match, err := VerifyBothChecksums(entry.Path, entry.Checksum, entry.Checksum512)
 		if err != nil {
 			return "error", fmt.Errorf("verify checksum: %w", err)
 		}
 		if !match {
-			return "modified", errModified
+			if opts.SkipModified {
+				return ActionSkipModified, errKeptModified
+			}
+			return ActionSkipModified, errModified
 		}
 	}
 
 	if opts.DryRun {
-		return "would delete", nil
+		return ActionWouldDelete, nil
 	}
 
 	if err := os.Remove(entry.Path); err != nil {
@@ -177,11 +317,18 @@ func replayFileDelete(entry Entry, opts ReplayOptions) (string, error) {
 	}
 
 	if entry.Original.BackupPath == "" {
+		if opts.NoBackup {
+			return "skip (no backup)", errSkipped
+		}
 		return "error", errors.New("no backup path")
 	}
 
 	if opts.DryRun {
-		return "would restore", nil
+		return ActionWouldRestore, nil
+	}
+
+	if err := checkBackup(entry.Original); err != nil {
+		return "error", err
 	}
 
 	// Ensure parent directory exists
@@ -195,7 +342,7 @@ func replayFileDelete(entry Entry, opts ReplayOptions) (string, error) {
 	}
 
 	// Restore permissions and ownership
-	if err := os.Chmod(entry.Path, os.FileMode(entry.Original.Mode)); err != nil {
+	if err := os.Chmod(entry.Path, FileMode(entry.Original.Mode)); err != nil {
 		// Non-fatal: log but continue
 	}
 
@@ -218,7 +365,7 @@ func replayFileOverwrite(entry Entry, opts ReplayOptions) (string, error) {
 		return "error", errors.New("no original file information")
 	}
 
-	if entry.Original.BackupPath == "" {
+	if entry.Original.BackupPath == "" && !opts.NoBackup {
 		return "error", errors.New("no backup path")
 	}
 
@@ -229,12 +376,28 @@ func replayFileOverwrite(entry Entry, opts ReplayOptions) (string, error) {
 			return "error", fmt.Errorf("verify checksum: %w", err)
 		}
 		if !match && !os.IsNotExist(err) {
-			return "modified", errModified
+			return ActionSkipModified, errModified
+		}
+	}
+
+	// Without a backup the original is gone; just remove our file
+	if entry.Original.BackupPath == "" {
+		if opts.DryRun {
+			return "would delete (no backup)", nil
+		}
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			return "error", fmt.Errorf("remove file: %w", err)
 		}
+		return "deleted (no backup)", nil
 	}
 
 	if opts.DryRun {
-		return "would restore", nil
+		return ActionWouldRestore, nil
+	}
+
+	// Our file is only removed once the backup is known to be good
+	if err := checkBackup(entry.Original); err != nil {
+		return "error", err
 	}
 
 	// Remove current file
@@ -251,7 +414,7 @@ func replayFileOverwrite(entry Entry, opts ReplayOptions) (string, error) {
 	}
 
 	// Restore permissions
-	if err := os.Chmod(entry.Path, os.FileMode(entry.Original.Mode)); err != nil {
+	if err := os.Chmod(entry.Path, FileMode(entry.Original.Mode)); err != nil {
 		// Non-fatal
 	}
 
@@ -268,12 +431,81 @@ func replayFileOverwrite(entry Entry, opts ReplayOptions) (string, error) {
 	return "restored", nil
 }
 
+// checkBackup returns an error unless the backup of orig holds the content
+// it had, so a damaged backup is never restored in its place.
+func checkBackup(orig *OriginalFile) error {
+	if orig.Checksum == "" {
+		return nil
+	}
+	match, err := VerifyBackup(orig.BackupPath, orig.Checksum)
+	if err != nil {
+		return fmt.Errorf("verify backup: %w", err)
+	}
+	if !match {
+		return fmt.Errorf("backup %s doesn't match the original's checksum", orig.BackupPath)
+	}
+	return nil
+}
+
+// replayFileAppend removes the data appended to a file, leaving the rest
+// of the file as it is now.
+func replayFileAppend(entry Entry, opts ReplayOptions) (string, error) {
+	info, err := os.Lstat(entry.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ActionSkipNotFound, errSkipped
+		}
+		return "error", fmt.Errorf("stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "skip (not a file)", errSkipped
+	}
+
+	data, err := os.ReadFile(entry.Path)
+	if err != nil {
+		return "error", fmt.Errorf("read file: %w", err)
+	}
+	start := findAppended(data, entry)
+	if start < 0 {
+		return ActionSkipModified, errModified
+	}
+
+	if opts.DryRun {
+		return "would remove appended data", nil
+	}
+
+	trimmed := slices.Concat(data[:start], data[start+len(entry.Appended):])
+	if err := os.WriteFile(entry.Path, trimmed, info.Mode()); err != nil {
+		return "error", fmt.Errorf("remove appended data: %w", err)
+	}
+
+	return "removed appended data", nil
+}
+
+// findAppended returns where the data appended by a file_append entry
+// starts in data, or -1 if it is gone or was changed. The data is looked for
+// at the recorded offset first; if the file was edited before it, a single
+// occurrence elsewhere is taken to be the appended data.
+func findAppended(data []byte, entry Entry) int {
+	if len(entry.Appended) == 0 {
+		return -1
+	}
+	end := entry.Offset + int64(len(entry.Appended))
+	if entry.Offset >= 0 && end <= int64(len(data)) && bytes.Equal(data[entry.Offset:end], entry.Appended) {
+		return int(entry.Offset)
+	}
+	if bytes.Count(data, entry.Appended) == 1 {
+		return bytes.Index(data, entry.Appended)
+	}
+	return -1
+}
+
 // replayDirCreate removes an empty directory.
 func replayDirCreate(entry Entry, opts ReplayOptions) (string, error) {
 	info, err := os.Lstat(entry.Path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "skip (not found)", errSkipped
+			return ActionSkipNotFound, errSkipped
 		}
 		return "error", fmt.Errorf("stat directory: %w", err)
 	}
@@ -289,7 +521,7 @@ func replayDirCreate(entry Entry, opts ReplayOptions) (string, error) {
 	// Only remove if empty
 	if err := os.Remove(entry.Path); err != nil {
 		if os.IsExist(err) || isNotEmpty(err) {
-			return "skip (not empty)", errSkipped
+			return "skip (not empty: contains foreign files)", errNotEmpty
 		}
 		return "error", fmt.Errorf("remove directory: %w", err)
 	}
@@ -302,7 +534,7 @@ func replaySymlinkCreate(entry Entry, opts ReplayOptions) (string, error) {
 	info, err := os.Lstat(entry.Path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "skip (not found)", errSkipped
+			return ActionSkipNotFound, errSkipped
 		}
 		return "error", fmt.Errorf("stat symlink: %w", err)
 	}
@@ -318,7 +550,7 @@ func replaySymlinkCreate(entry Entry, opts ReplayOptions) (string, error) {
 			return "error", fmt.Errorf("read symlink: %w", err)
 		}
 		if target != entry.Target {
-			return "modified", errModified
+			return ActionSkipModified, errModified
 		}
 	}
 
@@ -338,7 +570,7 @@ func replayHardlinkCreate(entry Entry, opts ReplayOptions) (string, error) {
 	info, err := os.Lstat(entry.Path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "skip (not found)", errSkipped
+			return ActionSkipNotFound, errSkipped
 		}
 		return "error", fmt.Errorf("stat hardlink: %w", err)
 	}
@@ -358,9 +590,9 @@ func replayHardlinkCreate(entry Entry, opts ReplayOptions) (string, error) {
 	return "removed", nil
 }
 
-// copyFile copies a file from src to dst.
+// copyFile copies a backup file from src to dst, decompressing it if needed.
 func copyFile(src, dst string) error {
-	in, err := os.Open(src)
+	in, err := OpenBackup(src)
 	if err != nil {
 		return err
 	}
@@ -412,6 +644,21 @@ func (l *Ledger) FilterByOp(op Op) []Entry {
 	return filtered
 }
 
+// TotalInstalledSize returns the combined size of the files the package
+// created or overwrote, excluding any since reverted.
+func (l *Ledger) TotalInstalledSize() int64 {
+	var total int64
+	for _, entry := range l.Entries {
+		if entry.Reverted {
+			continue
+		}
+		if entry.Op == OpFileCreate || entry.Op == OpFileOverwrite {
+			total += entry.Size
+		}
+	}
+	return total
+}
+
 // FilterByPath returns entries for a specific path.
 func (l *Ledger) FilterByPath(path string) []Entry {
 	var filtered []Entry
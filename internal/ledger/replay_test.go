@@ -3,6 +3,7 @@ package ledger
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -59,6 +60,80 @@ func TestReplayFileCreate(t *testing.T) {
 	}
 }
 
+func TestReplayFileAppend(t *testing.T) {
+	appended := []byte("source ~/.tool/completions.bash\n")
+	tests := []struct {
+		name     string
+		before   string // file content when removing
+		want     string
+		modified bool
+	}{
+		{
+			name:   "at recorded offset",
+			before: "alias ll='ls -l'\nsource ~/.tool/completions.bash\nexport EDITOR=vi\n",
+			want:   "alias ll='ls -l'\nexport EDITOR=vi\n",
+		},
+		{
+			name:   "moved by an edit above it",
+			before: "# aliases\nalias ll='ls -l'\nsource ~/.tool/completions.bash\n",
+			want:   "# aliases\nalias ll='ls -l'\n",
+		},
+		{
+			name:     "changed",
+			before:   "alias ll='ls -l'\nsource ~/.tool/completions.zsh\n",
+			want:     "alias ll='ls -l'\nsource ~/.tool/completions.zsh\n",
+			modified: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rc := filepath.Join(t.TempDir(), ".bashrc")
+			if err := os.WriteFile(rc, []byte(tt.before), 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			l, err := Create(dir, "test-pkg", "")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			l.Record(Entry{
+				Op:        OpFileAppend,
+				Path:      rc,
+				Timestamp: time.Now(),
+				Size:      int64(len(appended)),
+				Appended:  appended,
+				Offset:    int64(len("alias ll='ls -l'\n")),
+			})
+			l.Close()
+
+			l2, err := Open(dir, "test-pkg")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			result, err := ReverseReplay(l2, ReplayOptions{})
+			if err != nil {
+				t.Fatalf("ReverseReplay: %v", err)
+			}
+			if got := len(result.ModifiedFiles) > 0; got != tt.modified {
+				t.Errorf("ModifiedFiles = %v, want modified %v", result.ModifiedFiles, tt.modified)
+			}
+
+			data, err := os.ReadFile(rc)
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("content = %q, want %q", data, tt.want)
+			}
+			if info, err := os.Stat(rc); err == nil && info.Mode().Perm() != 0600 {
+				t.Errorf("mode = %o, want 0600", info.Mode().Perm())
+			}
+		})
+	}
+}
+
 func TestReplayDirCreate(t *testing.T) {
 	dir := t.TempDir()
 	targetDir := t.TempDir()
@@ -101,6 +176,48 @@ func TestReplayDirCreate(t *testing.T) {
 	}
 }
 
+func TestReplayDirCreateNonEmpty(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	outer := filepath.Join(targetDir, "a")
+	inner := filepath.Join(outer, "b")
+	if err := os.MkdirAll(inner, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer l.Close()
+	l.Record(Entry{Op: OpDirCreate, Path: outer})
+	l.Record(Entry{Op: OpDirCreate, Path: inner})
+
+	// A file the package never installed
+	if err := os.WriteFile(filepath.Join(outer, "user.conf"), []byte("mine"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	result, err := ReverseReplay(l, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+
+	if result.Processed != 1 || result.Skipped != 1 {
+		t.Errorf("Processed = %d, Skipped = %d, want 1 and 1", result.Processed, result.Skipped)
+	}
+	if len(result.NonEmptyDirs) != 1 || result.NonEmptyDirs[0] != outer {
+		t.Errorf("NonEmptyDirs = %v, want [%s]", result.NonEmptyDirs, outer)
+	}
+	if result.HasErrors() {
+		t.Errorf("unexpected errors: %v", result.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(outer, "user.conf")); err != nil {
+		t.Error("foreign file should be left alone")
+	}
+}
+
 func TestReplayDryRun(t *testing.T) {
 	dir := t.TempDir()
 	targetDir := t.TempDir()
@@ -145,6 +262,53 @@ func TestReplayDryRun(t *testing.T) {
 	}
 }
 
+func TestReplayDryRunActions(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	intact := filepath.Join(targetDir, "intact")
+	modified := filepath.Join(targetDir, "modified")
+	missing := filepath.Join(targetDir, "missing")
+	os.WriteFile(intact, []byte("installed"), 0644)
+	os.WriteFile(modified, []byte("edited"), 0644)
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	checksum := ChecksumBytes([]byte("installed"))
+	for _, path := range []string{intact, modified, missing} {
+		l.Record(Entry{Op: OpFileCreate, Path: path, Checksum: checksum})
+	}
+	l.Close()
+
+	l2, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	actions := make(map[string]string)
+	_, err = ReverseReplay(l2, ReplayOptions{
+		DryRun:  true,
+		Verbose: true,
+		OnEntry: func(entry Entry, action string) { actions[entry.Path] = action },
+	})
+	if err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+
+	want := map[string]string{
+		intact:   ActionWouldDelete,
+		modified: ActionSkipModified,
+		missing:  ActionSkipNotFound,
+	}
+	for path, action := range want {
+		if actions[path] != action {
+			t.Errorf("action for %s = %q, want %q", filepath.Base(path), actions[path], action)
+		}
+	}
+}
+
 func TestReplaySymlinkCreate(t *testing.T) {
 	dir := t.TempDir()
 	targetDir := t.TempDir()
@@ -247,6 +411,107 @@ func TestReplayModifiedFile(t *testing.T) {
 	}
 }
 
+func TestReplaySkipModified(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	config := filepath.Join(targetDir, "config.toml")
+	other := filepath.Join(targetDir, "other.txt")
+	for _, path := range []string{config, other} {
+		if err := os.WriteFile(path, []byte("installed"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, path := range []string{config, other} {
+		l.Record(Entry{Op: OpFileCreate, Path: path, Checksum: ChecksumBytes([]byte("installed"))})
+	}
+	l.Close()
+
+	if err := os.WriteFile(config, []byte("customized"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	l2, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	result, err := ReverseReplay(l2, ReplayOptions{SkipModified: true})
+	if err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+
+	if len(result.ModifiedFiles) != 0 || result.HasErrors() {
+		t.Errorf("modified files = %v, errors = %v, want neither", result.ModifiedFiles, result.Errors)
+	}
+	if len(result.SkippedModified) != 1 || result.SkippedModified[0] != config {
+		t.Errorf("SkippedModified = %v, want [%s]", result.SkippedModified, config)
+	}
+	if result.Processed != 1 || result.Skipped != 1 {
+		t.Errorf("Processed = %d, Skipped = %d, want 1 and 1", result.Processed, result.Skipped)
+	}
+	if data, err := os.ReadFile(config); err != nil || string(data) != "customized" {
+		t.Errorf("modified file = %q, %v, want it kept", data, err)
+	}
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Error("unmodified file should have been deleted")
+	}
+}
+
+func TestReplayChecksum512(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	testFile := filepath.Join(targetDir, "tool")
+	if err := os.WriteFile(testFile, []byte("original"), 0755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	recorder := NewRecorder(l, t.TempDir())
+	if err := recorder.RecordFileCreate(testFile); err != nil {
+		t.Fatalf("RecordFileCreate: %v", err)
+	}
+	l.Close()
+
+	if e := l.Entries[0]; e.Checksum512 != ChecksumBytesSHA512([]byte("original")) {
+		t.Fatalf("Checksum512 = %q, want the SHA-512 of the file", e.Checksum512)
+	}
+
+	// A ledger whose SHA-512 doesn't match treats the file as modified even
+	// though its SHA-256 does
+	l2, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	l2.Entries[0].Checksum512 = ChecksumBytesSHA512([]byte("tampered"))
+	result, err := ReverseReplay(l2, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+	if len(result.ModifiedFiles) != 1 {
+		t.Errorf("ModifiedFiles = %v, want the file with a mismatched SHA-512", result.ModifiedFiles)
+	}
+
+	l3, err := Open(dir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := ReverseReplay(l3, ReplayOptions{}); err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Error("file matching both checksums should have been removed")
+	}
+}
+
 func TestReplaySkipNonexistent(t *testing.T) {
 	dir := t.TempDir()
 
@@ -342,6 +607,55 @@ func TestReplayRestoreDeleted(t *testing.T) {
 	}
 }
 
+func TestReplayCorruptBackup(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	testFile := filepath.Join(targetDir, "test.conf")
+	installed := []byte("installed content")
+	if err := os.WriteFile(testFile, installed, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	original := []byte("original content")
+	backupPath := filepath.Join(t.TempDir(), ChecksumBytes(original))
+	if err := os.WriteFile(backupPath, []byte("damaged"), 0644); err != nil {
+		t.Fatalf("WriteFile backup: %v", err)
+	}
+
+	l, err := Create(dir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	l.Record(Entry{
+		Op:       OpFileOverwrite,
+		Path:     testFile,
+		Checksum: ChecksumBytes(installed),
+		Original: &OriginalFile{
+			Mode:       0644,
+			Size:       int64(len(original)),
+			Checksum:   ChecksumBytes(original),
+			BackupPath: backupPath,
+		},
+	})
+	l.Close()
+
+	result, err := ReverseReplay(l, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+	if !result.HasErrors() || !strings.Contains(result.Errors[0].Err.Error(), "doesn't match") {
+		t.Errorf("expected a damaged backup error, got %+v", result.Errors)
+	}
+
+	// Neither the installed file nor the backup is touched
+	if got, _ := os.ReadFile(testFile); string(got) != string(installed) {
+		t.Errorf("file = %q, want the installed content kept", got)
+	}
+	if _, err := os.Stat(backupPath); err != nil {
+		t.Errorf("backup removed: %v", err)
+	}
+}
+
 func TestReverseEntries(t *testing.T) {
 	l := &Ledger{
 		Entries: []Entry{
@@ -386,3 +700,148 @@ func TestFilterByOp(t *testing.T) {
 		t.Errorf("len(dirs) = %d, want 1", len(dirs))
 	}
 }
+
+func TestNoBackupInstallAndRemove(t *testing.T) {
+	ledgerDir := t.TempDir()
+	backupDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	l, err := CreateWithHeader(ledgerDir, Header{Package: "test-pkg", NoBackup: true})
+	if err != nil {
+		t.Fatalf("CreateWithHeader: %v", err)
+	}
+
+	r := NewRecorder(l, backupDir)
+
+	testFile := filepath.Join(targetDir, "config")
+	if err := os.WriteFile(testFile, []byte("original"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	orig, err := r.PrepareOverwrite(testFile)
+	if err != nil {
+		t.Fatalf("PrepareOverwrite: %v", err)
+	}
+	if orig == nil || orig.BackupPath != "" {
+		t.Fatalf("expected original info without backup path, got %+v", orig)
+	}
+
+	newContent := []byte("installed")
+	if err := os.WriteFile(testFile, newContent, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := r.RecordFileOverwriteWithBackup(testFile, orig, ChecksumBytes(newContent), int64(len(newContent)), 0644); err != nil {
+		t.Fatalf("RecordFileOverwriteWithBackup: %v", err)
+	}
+	r.Close()
+
+	if entries, _ := os.ReadDir(backupDir); len(entries) != 0 {
+		t.Errorf("expected no backups, found %d", len(entries))
+	}
+
+	l2, err := Open(ledgerDir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !l2.Header.NoBackup {
+		t.Error("Header.NoBackup should be persisted")
+	}
+
+	result, err := ReverseReplay(l2, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+	if result.HasErrors() {
+		t.Errorf("unexpected errors: %v", result.Errors)
+	}
+
+	// Without a backup the installed file is simply removed
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Error("overwritten file should be removed when there is no backup")
+	}
+}
+
+func TestRestoreOriginals(t *testing.T) {
+	ledgerDir := t.TempDir()
+	backupDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	l, err := Create(ledgerDir, "test-pkg", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	r := NewRecorder(l, backupDir)
+
+	// The package creates one file...
+	created := filepath.Join(targetDir, "bin")
+	if err := os.WriteFile(created, []byte("binary"), 0755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := r.RecordFileCreate(created); err != nil {
+		t.Fatalf("RecordFileCreate: %v", err)
+	}
+
+	// ...and overwrites a config file
+	config := filepath.Join(targetDir, "config")
+	if err := os.WriteFile(config, []byte("user config"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	orig, err := r.PrepareOverwrite(config)
+	if err != nil {
+		t.Fatalf("PrepareOverwrite: %v", err)
+	}
+	newContent := []byte("package config")
+	if err := os.WriteFile(config, newContent, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := r.RecordFileOverwriteWithBackup(config, orig, ChecksumBytes(newContent), int64(len(newContent)), 0644); err != nil {
+		t.Fatalf("RecordFileOverwriteWithBackup: %v", err)
+	}
+	r.Close()
+
+	l2, err := Open(ledgerDir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	result, err := RestoreOriginals(l2, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("RestoreOriginals: %v", err)
+	}
+	if result.HasErrors() || result.Processed != 1 {
+		t.Fatalf("expected 1 restored entry, got %+v", result)
+	}
+
+	content, _ := os.ReadFile(config)
+	if string(content) != "user config" {
+		t.Errorf("config = %q, want original content", content)
+	}
+	if _, err := os.Stat(created); err != nil {
+		t.Error("created file should be left in place")
+	}
+
+	// The revert is persisted to the ledger
+	l3, err := Open(ledgerDir, "test-pkg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !l3.Entries[1].Reverted {
+		t.Error("overwrite entry should be marked reverted")
+	}
+
+	// A full removal later leaves the restored config alone
+	result, err = ReverseReplay(l3, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("ReverseReplay: %v", err)
+	}
+	if result.HasErrors() {
+		t.Errorf("unexpected errors: %v", result.Errors)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Error("created file should be removed")
+	}
+	content, _ = os.ReadFile(config)
+	if string(content) != "user config" {
+		t.Errorf("config = %q, want original content after removal", content)
+	}
+}
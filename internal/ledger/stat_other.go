@@ -4,6 +4,10 @@ package ledger
 
 import "os"
 
+// OwnershipSupported reports whether files have a UID and GID that
+// getOwnership can read, so ownership can be recorded and checked.
+const OwnershipSupported = false
+
 // getOwnership returns 0, 0 on non-Unix systems where UID/GID aren't available.
 func getOwnership(info os.FileInfo) (uid, gid uint32) {
 	return 0, 0
@@ -7,6 +7,10 @@ import (
 	"syscall"
 )
 
+// OwnershipSupported reports whether files have a UID and GID that
+// getOwnership can read, so ownership can be recorded and checked.
+const OwnershipSupported = true
+
 // getOwnership extracts UID and GID from file info on Unix systems.
 func getOwnership(info os.FileInfo) (uid, gid uint32) {
 	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
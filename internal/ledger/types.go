@@ -24,6 +24,10 @@ const (
 	// The original file's checksum and backup path are stored for restoration.
 	OpFileOverwrite Op = "file_overwrite"
 
+	// OpFileAppend records bytes appended to an existing file, such as a
+	// line added to a shell rc file. Undoing it removes exactly those bytes.
+	OpFileAppend Op = "file_append"
+
 	// OpDirCreate records creation of a new directory.
 	OpDirCreate Op = "dir_create"
 
@@ -32,6 +36,10 @@ const (
 
 	// OpHardlinkCreate records creation of a hard link.
 	OpHardlinkCreate Op = "hardlink_create"
+
+	// OpStepComplete marks the end of an install step. It changes nothing
+	// on disk; resuming a partial install skips steps up to the last marker.
+	OpStepComplete Op = "step_complete"
 )
 
 // Entry represents a single ledger entry recording one file system operation.
@@ -40,7 +48,8 @@ type Entry struct {
 	// Op is the operation type (required).
 	Op Op `json:"op"`
 
-	// Path is the absolute path of the file/directory affected (required).
+	// Path is the absolute path of the file/directory affected (required,
+	// except for OpStepComplete markers).
 	Path string `json:"path"`
 
 	// Timestamp records when the operation occurred (required).
@@ -64,13 +73,35 @@ type Entry struct {
 	// Stored for file_create, file_overwrite to detect external modifications.
 	Checksum string `json:"checksum,omitempty"`
 
+	// Checksum512 is the SHA-512 hash of the file contents (hex-encoded).
+	// Stored for file_create alongside Checksum; when set, both must match
+	// for the file to count as unmodified.
+	Checksum512 string `json:"checksum512,omitempty"`
+
 	// Target is the link target path.
 	// Stored for symlink_create and hardlink_create.
 	Target string `json:"target,omitempty"`
 
+	// Appended is the exact data appended to the file, and Offset where in
+	// the file it was written. Stored for file_append.
+	Appended []byte `json:"appended,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+
 	// Original holds information about the pre-existing file/link that was
 	// replaced or deleted. Used for file_overwrite and file_delete operations.
 	Original *OriginalFile `json:"original,omitempty"`
+
+	// Step is the 1-based install step number of an OpStepComplete marker.
+	Step int `json:"step,omitempty"`
+
+	// Reverted is true if the original file has already been restored
+	// (e.g., by `alloy rollback`), so the entry must not be undone again.
+	Reverted bool `json:"reverted,omitempty"`
+
+	// Preexisting is true for an OpFileCreate of a file that was already in
+	// place with the same content. The package owns it, but undoing the
+	// entry leaves it alone.
+	Preexisting bool `json:"preexisting,omitempty"`
 }
 
 // OriginalFile stores information about a file that existed before an
@@ -111,6 +142,9 @@ type Header struct {
 	// Package is the name of the installed package.
 	Package string `json:"package"`
 
+	// PackageVersion is the version of the package that was installed.
+	PackageVersion string `json:"package_version,omitempty"`
+
 	// InstalledAt is when the installation started.
 	InstalledAt time.Time `json:"installed_at"`
 
@@ -118,7 +152,48 @@ type Header struct {
 	Source string `json:"source,omitempty"`
 
 	// SourceChecksum is the checksum of the source archive/binary if applicable.
+	// For git sources it holds the commit SHA that was checked out.
 	SourceChecksum string `json:"source_checksum,omitempty"`
+
+	// NoBackup is true if the package was installed without backing up
+	// overwritten or deleted files. Their originals cannot be restored.
+	NoBackup bool `json:"no_backup,omitempty"`
+
+	// PostRemoveMessage is the package's expanded post-remove message,
+	// saved at install time so removal shows the one for the installed
+	// version.
+	PostRemoveMessage string `json:"post_remove_message,omitempty"`
+
+	// InstallReason is ReasonExplicit if the user asked for the package and
+	// ReasonDependency if it was pulled in by another package. Ledgers
+	// written before the field existed leave it empty; see Explicit.
+	InstallReason string `json:"install_reason,omitempty"`
+
+	// RequestedBy lists the packages whose installation pulled this one in
+	// as a dependency.
+	RequestedBy []string `json:"requested_by,omitempty"`
+
+	// Pinned is true if the package is held at its installed version:
+	// upgrades skip it until it is unpinned.
+	Pinned bool `json:"pinned,omitempty"`
+
+	// SourceRef is the git branch, tag or commit installed in place of the
+	// ref in the package definition, e.g. with 'alloy install --ref'.
+	// Upgrades keep following it. Empty if the definition's ref was used.
+	SourceRef string `json:"source_ref,omitempty"`
+}
+
+// Install reasons recorded in Header.InstallReason.
+const (
+	ReasonExplicit   = "explicit"
+	ReasonDependency = "dependency"
+)
+
+// Explicit reports whether the package was installed at the user's request.
+// Packages from older ledgers without a reason count as explicit, so they
+// are never autoremoved.
+func (h Header) Explicit() bool {
+	return h.InstallReason != ReasonDependency
 }
 
 // CurrentVersion is the current ledger format version.
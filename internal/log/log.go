@@ -0,0 +1,76 @@
+// Package log writes a timestamped record of alloy operations to a file.
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileEnv is the environment variable naming the file alloy appends its
+// operation log to.
+const FileEnv = "ALLOY_LOG_FILE"
+
+// MaxSize is the log file size above which doctor suggests rotating it.
+const MaxSize = 100 << 20
+
+// Logger writes each line it is given to an underlying writer, prefixed with
+// an RFC3339 timestamp. A nil *Logger discards everything, so callers need
+// not check whether logging is enabled.
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+
+	// now returns the time stamped on each line.
+	now func() time.Time
+}
+
+// New returns a Logger that writes to w.
+func New(w io.Writer) *Logger {
+	return &Logger{w: w, now: time.Now}
+}
+
+// Open returns a Logger appending to the file at path, creating it if needed.
+func Open(path string) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open log file: %w", err)
+	}
+	l := New(f)
+	l.closer = f
+	return l, nil
+}
+
+// Printf formats a message and writes it with a timestamp on every line.
+func (l *Logger) Printf(format string, args ...any) {
+	if l == nil {
+		return
+	}
+
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	stamp := l.now().Format(time.RFC3339)
+
+	var b strings.Builder
+	for _, line := range strings.Split(msg, "\n") {
+		b.WriteString(stamp)
+		b.WriteByte(' ')
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+// Close closes the underlying file if the Logger opened it.
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerPrintf(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	l.now = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	l.Printf("install %s", "ripgrep")
+	l.Printf("first\nsecond\n")
+
+	want := "2026-03-01T12:00:00Z install ripgrep\n" +
+		"2026-03-01T12:00:00Z first\n" +
+		"2026-03-01T12:00:00Z second\n"
+	if buf.String() != want {
+		t.Errorf("log output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestLoggerNil(t *testing.T) {
+	var l *Logger
+	l.Printf("ignored")
+	if err := l.Close(); err != nil {
+		t.Errorf("Close on nil logger: %v", err)
+	}
+}
+
+func TestOpenAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alloy.log")
+
+	for _, msg := range []string{"first run", "second run"} {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		l.Printf("%s", msg)
+		l.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "first run") || !strings.HasSuffix(lines[1], "second run") {
+		t.Errorf("expected both runs in order, got %q", lines)
+	}
+}
@@ -0,0 +1,85 @@
+// Package paths locates the directories alloy keeps its files in.
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// DataDir returns the directory alloy keeps its state in: ledgers, backups
+// and synced remote indexes. This is $XDG_DATA_HOME/alloy, falling back to
+// ~/.alloy when XDG_DATA_HOME is unset and always on macOS.
+func DataDir() (string, error) {
+	return baseDir("XDG_DATA_HOME")
+}
+
+// CacheDir returns the directory downloads are cached in. This is
+// $XDG_CACHE_HOME/alloy, falling back to ~/.alloy/cache.
+func CacheDir() (string, error) {
+	dir, err := baseDir("XDG_CACHE_HOME")
+	if err != nil {
+		return "", err
+	}
+	if legacy, _ := LegacyDir(); dir == legacy {
+		return filepath.Join(dir, "cache"), nil
+	}
+	return dir, nil
+}
+
+// ConfigDir returns the directory holding config.toml. This is
+// $XDG_CONFIG_HOME/alloy, falling back to ~/.alloy.
+func ConfigDir() (string, error) {
+	return baseDir("XDG_CONFIG_HOME")
+}
+
+// LegacyDir returns ~/.alloy, where every alloy file lived before XDG base
+// directories were supported.
+func LegacyDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home directory: %w", err)
+	}
+	return filepath.Join(home, ".alloy"), nil
+}
+
+// UsingLegacyDir reports whether ~/.alloy is being kept in place of the XDG
+// directories because it holds the ledgers of packages installed before XDG
+// support, which would otherwise be orphaned. It is decided once per process,
+// so files alloy creates under ~/.alloy, such as the fallback cache, never
+// switch the directories in use halfway through.
+func UsingLegacyDir() bool {
+	return usingLegacyDir()
+}
+
+// usingLegacyDir checks for legacy ledgers the first time it is called.
+var usingLegacyDir = sync.OnceValue(hasLegacyLedgers)
+
+// hasLegacyLedgers reports whether ~/.alloy/ledgers is a directory.
+func hasLegacyLedgers() bool {
+	legacy, err := LegacyDir()
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(legacy, "ledgers"))
+	return err == nil && info.IsDir()
+}
+
+// baseDir returns $env/alloy, or ~/.alloy if env is unset or not absolute,
+// on macOS, or if ~/.alloy holds ledgers.
+func baseDir(env string) (string, error) {
+	legacy, err := LegacyDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "darwin" || UsingLegacyDir() {
+		return legacy, nil
+	}
+	// The spec says relative paths are invalid and should be ignored
+	if xdg := os.Getenv(env); filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "alloy"), nil
+	}
+	return legacy, nil
+}
@@ -0,0 +1,72 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestDataAndCacheDirs(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("macOS always uses ~/.alloy")
+	}
+
+	home := t.TempDir()
+	xdg := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(xdg, "data"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(xdg, "cache"))
+	redetect := func() { usingLegacyDir = sync.OnceValue(hasLegacyLedgers) }
+	redetect()
+	defer redetect()
+
+	check := func(name string, fn func() (string, error), want string) {
+		t.Helper()
+		got, err := fn()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	check("DataDir", DataDir, filepath.Join(xdg, "data", "alloy"))
+	check("CacheDir", CacheDir, filepath.Join(xdg, "cache", "alloy"))
+
+	// Unset and relative values fall back to ~/.alloy
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "relative/cache")
+	check("DataDir", DataDir, filepath.Join(home, ".alloy"))
+	check("CacheDir", CacheDir, filepath.Join(home, ".alloy", "cache"))
+
+	// The layout is decided once, so ~/.alloy appearing later, e.g. for
+	// the fallback cache, doesn't switch the other directories to it
+	t.Setenv("XDG_DATA_HOME", filepath.Join(xdg, "data"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(xdg, "cache"))
+	if err := os.MkdirAll(filepath.Join(home, ".alloy", "ledgers"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if UsingLegacyDir() {
+		t.Error("UsingLegacyDir() changed after it was first decided")
+	}
+	check("DataDir", DataDir, filepath.Join(xdg, "data", "alloy"))
+
+	// Ledgers in ~/.alloy keep it in use even when XDG is configured
+	redetect()
+	if !UsingLegacyDir() {
+		t.Error("UsingLegacyDir() = false with ~/.alloy/ledgers present")
+	}
+	check("DataDir", DataDir, filepath.Join(home, ".alloy"))
+	check("ConfigDir", ConfigDir, filepath.Join(home, ".alloy"))
+	check("CacheDir", CacheDir, filepath.Join(home, ".alloy", "cache"))
+
+	// A ~/.alloy without ledgers, such as a fallback cache, doesn't
+	if err := os.Remove(filepath.Join(home, ".alloy", "ledgers")); err != nil {
+		t.Fatal(err)
+	}
+	redetect()
+	check("DataDir", DataDir, filepath.Join(xdg, "data", "alloy"))
+}
@@ -0,0 +1,161 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DepNode is a node in a resolved dependency tree.
+type DepNode struct {
+	// Name is the package name.
+	Name string
+
+	// Package is the loaded definition, or nil if it could not be loaded.
+	Package *Package
+
+	// Err is set if the definition could not be loaded.
+	Err error
+
+	// Cycle is true if this package already appears on the path from the
+	// root, i.e. it is part of a dependency cycle. Its children are not
+	// resolved.
+	Cycle bool
+
+	// Missing is true if the package is required but not installed, in
+	// trees of installed packages such as ledger.BuildDepTree's.
+	Missing bool
+
+	// Children are the resolved dependencies, in declaration order.
+	Children []*DepNode
+}
+
+// ResolveTree resolves the dependency tree rooted at name, using load to
+// obtain package definitions. Cycles are marked rather than followed.
+func ResolveTree(name string, load func(name string) (*Package, error)) *DepNode {
+	return resolveNode(name, load, make(map[string]bool))
+}
+
+func resolveNode(name string, load func(string) (*Package, error), path map[string]bool) *DepNode {
+	node := &DepNode{Name: name}
+	if path[name] {
+		node.Cycle = true
+		return node
+	}
+
+	p, err := load(name)
+	if err != nil {
+		node.Err = err
+		return node
+	}
+	node.Package = p
+
+	path[name] = true
+	for _, dep := range p.Dependencies {
+		node.Children = append(node.Children, resolveNode(dep, load, path))
+	}
+	delete(path, name)
+
+	return node
+}
+
+// Walk calls fn for every node in the tree in depth-first order,
+// with depth 0 for the root.
+func (n *DepNode) Walk(fn func(node *DepNode, depth int)) {
+	n.walk(fn, 0)
+}
+
+func (n *DepNode) walk(fn func(*DepNode, int), depth int) {
+	fn(n, depth)
+	for _, child := range n.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// ToDOT renders the tree as a Graphviz digraph, with one edge per
+// dependency and missing packages drawn dashed. A root without a name, such
+// as ledger.BuildDepTree's, is left out.
+func (n *DepNode) ToDOT() string {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n")
+
+	written := make(map[string]bool)
+	write := func(line string) {
+		if !written[line] {
+			written[line] = true
+			b.WriteString(line)
+		}
+	}
+
+	var walk func(node *DepNode)
+	walk = func(node *DepNode) {
+		if node.Name != "" {
+			if node.Missing {
+				write(fmt.Sprintf("  %q [style=dashed];\n", node.Name))
+			} else {
+				write(fmt.Sprintf("  %q;\n", node.Name))
+			}
+		}
+		for _, child := range node.Children {
+			if node.Name != "" {
+				write(fmt.Sprintf("  %q -> %q;\n", node.Name, child.Name))
+			}
+			walk(child)
+		}
+	}
+	walk(n)
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// SortByDependencies orders names so every package comes after those of its
+// dependencies that are also in names, keeping the given order otherwise.
+// Dependencies outside names are ignored. It returns an error if a package
+// cannot be loaded or the packages depend on each other in a cycle.
+func SortByDependencies(names []string, load func(name string) (*Package, error)) ([]string, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(names))
+	var sorted []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle involving %q", name)
+		case done:
+			return nil
+		}
+		state[name] = visiting
+
+		p, err := load(name)
+		if err != nil {
+			return err
+		}
+		for _, dep := range p.Dependencies {
+			if wanted[dep] {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+
+		state[name] = done
+		sorted = append(sorted, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResolveTree(t *testing.T) {
+	defs := map[string]*Package{
+		"app":  {Name: "app", Dependencies: []string{"lib", "tool"}},
+		"lib":  {Name: "lib", Dependencies: []string{"base"}},
+		"tool": {Name: "tool", Dependencies: []string{"app"}}, // cycle back to app
+		"base": {Name: "base"},
+	}
+	load := func(name string) (*Package, error) {
+		if p, ok := defs[name]; ok {
+			return p, nil
+		}
+		return nil, fmt.Errorf("package %q not found", name)
+	}
+
+	root := ResolveTree("app", load)
+
+	var got []string
+	root.Walk(func(n *DepNode, depth int) {
+		marker := ""
+		if n.Cycle {
+			marker = "*"
+		}
+		got = append(got, fmt.Sprintf("%d:%s%s", depth, n.Name, marker))
+	})
+
+	want := []string{"0:app", "1:lib", "2:base", "1:tool", "2:app*"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("tree = %v, want %v", got, want)
+	}
+
+	missing := ResolveTree("nope", load)
+	if missing.Err == nil {
+		t.Error("expected error for unknown package")
+	}
+}
+
+func TestResolveTreeDiamond(t *testing.T) {
+	// A shared dependency reached by two paths is not a cycle
+	defs := map[string]*Package{
+		"app":  {Name: "app", Dependencies: []string{"a", "b"}},
+		"a":    {Name: "a", Dependencies: []string{"base"}},
+		"b":    {Name: "b", Dependencies: []string{"base"}},
+		"base": {Name: "base"},
+	}
+	root := ResolveTree("app", func(name string) (*Package, error) { return defs[name], nil })
+
+	root.Walk(func(n *DepNode, depth int) {
+		if n.Cycle {
+			t.Errorf("%s at depth %d incorrectly marked as cycle", n.Name, depth)
+		}
+	})
+}
+
+func TestSortByDependencies(t *testing.T) {
+	defs := map[string]*Package{
+		"app":  {Name: "app", Dependencies: []string{"lib", "external"}},
+		"lib":  {Name: "lib", Dependencies: []string{"base"}},
+		"base": {Name: "base"},
+		"cli":  {Name: "cli"},
+		"a":    {Name: "a", Dependencies: []string{"b"}},
+		"b":    {Name: "b", Dependencies: []string{"a"}},
+	}
+	load := func(name string) (*Package, error) {
+		if p, ok := defs[name]; ok {
+			return p, nil
+		}
+		return nil, fmt.Errorf("package %q not found", name)
+	}
+
+	got, err := SortByDependencies([]string{"app", "cli", "base", "lib"}, load)
+	if err != nil {
+		t.Fatalf("SortByDependencies: %v", err)
+	}
+	want := []string{"base", "lib", "app", "cli"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+
+	if _, err := SortByDependencies([]string{"a", "b"}, load); err == nil {
+		t.Error("expected error for a dependency cycle")
+	}
+	if _, err := SortByDependencies([]string{"app", "nope"}, load); err == nil {
+		t.Error("expected error for unknown package")
+	}
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Format returns p encoded as canonical TOML: metadata fields first, then
+// [source], [install_paths] and the [[install_steps]] in order, with
+// double-quoted strings and unindented keys, as in the bundled packages.
+// Empty fields are omitted. Comments are not preserved, since they are
+// discarded when parsing.
+func Format(p *Package) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(p); err != nil {
+		return nil, fmt.Errorf("encoding package definition: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// FormatSource validates a package definition and returns it in the
+// canonical form produced by Format. Defaults that Parse fills in are not
+// added, so only fields present in data are written.
+func FormatSource(data []byte) ([]byte, error) {
+	if _, err := Parse(data); err != nil {
+		return nil, err
+	}
+
+	var p Package
+	if err := toml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parsing package definition: %w", err)
+	}
+	return Format(&p)
+}
@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFormatSource(t *testing.T) {
+	data := []byte(`license = "MIT"
+version = "1.0"
+name = "tool"
+
+[[install_steps]]
+type = 'run'
+command = "make install"
+env = { PREFIX = "/usr/local" }
+
+[source]
+git = 'https://example.com/tool.git'
+`)
+
+	want := `name = "tool"
+version = "1.0"
+license = "MIT"
+
+[source]
+git = "https://example.com/tool.git"
+
+[[install_steps]]
+type = "run"
+command = "make install"
+[install_steps.env]
+PREFIX = "/usr/local"
+`
+
+	got, err := FormatSource(data)
+	if err != nil {
+		t.Fatalf("FormatSource: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("FormatSource =\n%s\nwant\n%s", got, want)
+	}
+
+	again, err := FormatSource(got)
+	if err != nil {
+		t.Fatalf("FormatSource of formatted output: %v", err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("formatting is not idempotent:\n%s", again)
+	}
+
+	if _, err := FormatSource([]byte(`name = "tool"`)); err == nil {
+		t.Error("expected an invalid definition to be rejected")
+	}
+}
+
+func TestFormatBundledPackages(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("..", "..", "packages", "*.toml"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no bundled packages found: %v", err)
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			formatted, err := FormatSource(data)
+			if err != nil {
+				t.Fatalf("FormatSource: %v", err)
+			}
+
+			// Formatting must never change what the definition means
+			before, err := Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := Parse(formatted)
+			if err != nil {
+				t.Fatalf("Parse formatted: %v", err)
+			}
+			if !reflect.DeepEqual(before, after) {
+				t.Errorf("formatted definition differs:\nbefore %+v\nafter  %+v", before, after)
+			}
+		})
+	}
+}
@@ -3,9 +3,14 @@ package pkg
 
 import (
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -19,8 +24,36 @@ type Package struct {
 	License     string   `toml:"license,omitempty"`
 	Provides    []string `toml:"provides,omitempty"`
 
-	Source       Source        `toml:"source"`
-	InstallPaths InstallPaths  `toml:"install_paths"`
+	Dependencies []string `toml:"dependencies,omitempty"`
+
+	// ConflictFiles lists paths, which may use template variables, that
+	// another package is known to install too. Installing is refused while
+	// another installed package owns one of them.
+	ConflictFiles []string `toml:"conflict_files,omitempty"`
+
+	// Notes are release notes for this version, and ChangelogURL links to
+	// the full changelog. Both are shown by info and when upgrading.
+	Notes        string `toml:"notes,omitempty"`
+	ChangelogURL string `toml:"changelog_url,omitempty"`
+
+	// MaxInstallTime limits how long the whole installation may take, as a
+	// Go duration such as "30m".
+	MaxInstallTime string `toml:"max_install_time,omitempty"`
+
+	// PostInstallMessage is shown after a successful install, e.g. to ask
+	// the user to add a line to their shell config. PostRemoveMessage is
+	// shown after removal. Both may use template variables other than
+	// {{srcdir}}.
+	PostInstallMessage string `toml:"post_install_message,omitempty"`
+	PostRemoveMessage  string `toml:"post_remove_message,omitempty"`
+
+	Source       Source       `toml:"source"`
+	InstallPaths InstallPaths `toml:"install_paths,omitempty"`
+
+	// Vars defines custom template variables, such as a completions_dir,
+	// for use in install steps. Values may reference the built-in variables.
+	Vars map[string]string `toml:"vars,omitempty"`
+
 	InstallSteps []InstallStep `toml:"install_steps"`
 }
 
@@ -29,12 +62,35 @@ type Source struct {
 	URL    string `toml:"url,omitempty"`
 	Git    string `toml:"git,omitempty"`
 	Binary string `toml:"binary,omitempty"`
+
+	// Path is a local directory used as the source tree without
+	// downloading anything, for developing packages. ParseFile resolves a
+	// relative path against the directory holding the definition.
+	Path string `toml:"path,omitempty"`
+
 	SHA256 string `toml:"sha256,omitempty"`
+	SHA512 string `toml:"sha512,omitempty"`
 	Ref    string `toml:"ref,omitempty"`
-	Strip  int    `toml:"strip,omitempty"`
+	Strip  int    `toml:"strip,omitzero"`
+
+	// ArchiveSHA256 is the SHA-256 of `git archive --format=tar HEAD` for
+	// the checked-out tree of a git source, verified after cloning.
+	ArchiveSHA256 string `toml:"archive_sha256,omitempty"`
+
+	// FetchHeaders are extra HTTP headers sent when downloading url and
+	// binary sources, e.g. an Accept header some CDNs require.
+	FetchHeaders map[string]string `toml:"fetch_headers,omitempty"`
+
+	// Decompress names the compression of a binary source, one of gz, xz,
+	// or bz2, for URLs whose suffix doesn't show it; "none" uses the
+	// download as is. By default it is taken from the URL's suffix.
+	Decompress string `toml:"decompress,omitempty"`
 }
 
-// SourceType returns the type of source (url, git, or binary).
+// Compressions lists the formats a binary source may be compressed with.
+var Compressions = []string{"gz", "xz", "bz2"}
+
+// SourceType returns the type of source (url, git, binary, or path).
 func (s Source) SourceType() string {
 	if s.URL != "" {
 		return "url"
@@ -45,10 +101,43 @@ func (s Source) SourceType() string {
 	if s.Binary != "" {
 		return "binary"
 	}
+	if s.Path != "" {
+		return "path"
+	}
+	return ""
+}
+
+// Checksum returns the checksum recorded for the source: the SHA-256 if
+// set, otherwise the SHA-512.
+func (s Source) Checksum() string {
+	if s.SHA256 != "" {
+		return s.SHA256
+	}
+	return s.SHA512
+}
+
+// Compression returns the format a binary source is compressed with, from
+// Decompress or else the suffix of its URL, or "" if it isn't compressed.
+func (s Source) Compression() string {
+	if s.Binary == "" || s.Decompress == "none" {
+		return ""
+	}
+	if s.Decompress != "" {
+		return s.Decompress
+	}
+	location := strings.ToLower(s.Binary)
+	location, _, _ = strings.Cut(location, "?")
+	location, _, _ = strings.Cut(location, "#")
+	for _, format := range Compressions {
+		if strings.HasSuffix(location, "."+format) {
+			return format
+		}
+	}
 	return ""
 }
 
-// Location returns the source location (URL, git repo, or binary URL).
+// Location returns the source location (URL, git repo, binary URL, or
+// local directory).
 func (s Source) Location() string {
 	if s.URL != "" {
 		return s.URL
@@ -59,6 +148,9 @@ func (s Source) Location() string {
 	if s.Binary != "" {
 		return s.Binary
 	}
+	if s.Path != "" {
+		return s.Path
+	}
 	return ""
 }
 
@@ -82,23 +174,92 @@ type InstallStep struct {
 	Path      string   `toml:"path,omitempty"`
 	Mode      string   `toml:"mode,omitempty"`
 	Platforms []string `toml:"platforms,omitempty"`
+
+	// Timeout limits how long a run step's command may take, as a Go
+	// duration such as "5m".
+	Timeout string `toml:"timeout,omitempty"`
+
+	// WorkDirCreate creates a run step's WorkDir if it doesn't exist. A
+	// created directory outside the source tree is removed after the install.
+	WorkDirCreate bool `toml:"workdir_create,omitempty"`
+
+	// Relative makes a symlink step link to its absolute Src by a path
+	// relative to the link's directory, so the tree can be relocated.
+	Relative bool `toml:"relative,omitempty"`
+
+	// Content is the text an append step adds to the end of Dest, with a
+	// trailing newline added if missing.
+	Content string `toml:"content,omitempty"`
+
+	// Vars overrides or adds variables available to a template step.
+	Vars map[string]string `toml:"vars,omitempty"`
+
+	// Env sets environment variables for a run step.
+	Env map[string]string `toml:"env,omitempty"`
+
+	// ClearEnv runs the command with only the variables in Env.
+	ClearEnv bool `toml:"clear_env,omitempty"`
+
+	// InheritEnv names parent environment variables passed through to a
+	// run step, e.g. ["CC", "CXX"].
+	InheritEnv []string `toml:"inherit_env,omitempty"`
+
+	// Plugin names the external executable (alloy-step-<plugin>) that
+	// performs a plugin step.
+	Plugin string `toml:"plugin,omitempty"`
+
+	// Args are passed to a plugin step.
+	Args []string `toml:"args,omitempty"`
 }
 
 // StepType constants for installation steps.
 const (
-	StepRun     = "run"
-	StepCopy    = "copy"
-	StepMkdir   = "mkdir"
-	StepSymlink = "symlink"
+	StepRun      = "run"
+	StepCopy     = "copy"
+	StepMkdir    = "mkdir"
+	StepSymlink  = "symlink"
+	StepTemplate = "template"
+	StepPlugin   = "plugin"
+	StepAppend   = "append"
 )
 
+// PluginPrefix is prepended to a plugin step's name to find its executable.
+const PluginPrefix = "alloy-step-"
+
+// StrictVersionsEnv is the environment variable that, when set to "1",
+// makes Validate reject versions that are not valid semver.
+const StrictVersionsEnv = "ALLOY_STRICT_VERSIONS"
+
+// ValidateOptions configures ValidateWith.
+type ValidateOptions struct {
+	// StrictVersions if true, rejects versions that are not valid semver.
+	StrictVersions bool
+}
+
+// LintWarning describes a non-fatal problem in a package definition.
+type LintWarning struct {
+	Field   string
+	Message string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Field, w.Message)
+}
+
 // ParseFile reads and parses a package definition from a TOML file.
 func ParseFile(path string) (*Package, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading package file: %w", err)
 	}
-	return Parse(data)
+	p, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if p.Source.Path != "" && !filepath.IsAbs(p.Source.Path) {
+		p.Source.Path = filepath.Join(filepath.Dir(path), p.Source.Path)
+	}
+	return p, nil
 }
 
 // Parse parses a package definition from TOML data.
@@ -116,14 +277,25 @@ func Parse(data []byte) (*Package, error) {
 	return &pkg, nil
 }
 
-// Validate checks that the package definition is valid.
+// Validate checks that the package definition is valid, with strict
+// versions if StrictVersionsEnv is set.
 func (p *Package) Validate() error {
+	return p.ValidateWith(ValidateOptions{StrictVersions: os.Getenv(StrictVersionsEnv) == "1"})
+}
+
+// ValidateWith checks that the package definition is valid.
+func (p *Package) ValidateWith(opts ValidateOptions) error {
 	if p.Name == "" {
 		return fmt.Errorf("package name is required")
 	}
 	if p.Version == "" {
 		return fmt.Errorf("package version is required")
 	}
+	if opts.StrictVersions {
+		if err := validateSemver(p.Version); err != nil {
+			return fmt.Errorf("package version: %w", err)
+		}
+	}
 
 	// Validate source
 	sourceCount := 0
@@ -136,16 +308,74 @@ func (p *Package) Validate() error {
 	if p.Source.Binary != "" {
 		sourceCount++
 	}
+	if p.Source.Path != "" {
+		sourceCount++
+	}
 	if sourceCount == 0 {
-		return fmt.Errorf("package source is required (url, git, or binary)")
+		return fmt.Errorf("package source is required (url, git, binary, or path)")
 	}
 	if sourceCount > 1 {
-		return fmt.Errorf("only one source type allowed (url, git, or binary)")
+		return fmt.Errorf("only one source type allowed (url, git, binary, or path)")
+	}
+
+	// Require a checksum for url and binary sources
+	if (p.Source.URL != "" || p.Source.Binary != "") && p.Source.SHA256 == "" && p.Source.SHA512 == "" {
+		return fmt.Errorf("sha256 or sha512 checksum required for url/binary sources")
+	}
+
+	if p.Source.ArchiveSHA256 != "" {
+		if p.Source.Git == "" {
+			return fmt.Errorf("archive_sha256 only applies to git sources")
+		}
+		if !sha256Pattern.MatchString(p.Source.ArchiveSHA256) {
+			return fmt.Errorf("archive_sha256 must be a 64-digit hex SHA-256")
+		}
+	}
+
+	if p.Source.Decompress != "" {
+		if p.Source.Binary == "" {
+			return fmt.Errorf("decompress only applies to binary sources")
+		}
+		if p.Source.Decompress != "none" && !slices.Contains(Compressions, p.Source.Decompress) {
+			return fmt.Errorf("decompress must be one of %s, or none", strings.Join(Compressions, ", "))
+		}
+	}
+
+	if len(p.Source.FetchHeaders) > 0 && (p.Source.Git != "" || p.Source.Path != "") {
+		return fmt.Errorf("fetch_headers only apply to url and binary sources")
+	}
+	for name := range p.Source.FetchHeaders {
+		switch {
+		case name == "":
+			return fmt.Errorf("fetch_headers: header name is required")
+		case strings.EqualFold(name, "Authorization"), strings.EqualFold(name, "Cookie"):
+			return fmt.Errorf("fetch_headers: %s must not be stored in a package definition", name)
+		}
+	}
+
+	for i, dep := range p.Dependencies {
+		if dep == "" {
+			return fmt.Errorf("dependencies[%d]: name is required", i)
+		}
+		if dep == p.Name {
+			return fmt.Errorf("dependencies[%d]: package cannot depend on itself", i)
+		}
+	}
+
+	for i, path := range p.ConflictFiles {
+		if path == "" {
+			return fmt.Errorf("conflict_files[%d]: path is required", i)
+		}
 	}
 
-	// Require checksum for url and binary sources
-	if (p.Source.URL != "" || p.Source.Binary != "") && p.Source.SHA256 == "" {
-		return fmt.Errorf("sha256 checksum required for url/binary sources")
+	if p.MaxInstallTime != "" {
+		if _, err := time.ParseDuration(p.MaxInstallTime); err != nil {
+			return fmt.Errorf("max_install_time: %w", err)
+		}
+	}
+
+	if err := p.validateTemplates(); err != nil {
+		return err
 	}
 
 	// Validate install steps
@@ -161,7 +391,130 @@ func (p *Package) Validate() error {
 	return nil
 }
 
+// templateVarPattern matches any {{...}} template reference.
+var templateVarPattern = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// sha256Pattern matches a hex-encoded SHA-256 digest.
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// customVarPattern matches valid names for custom [vars] entries.
+var customVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templateField is a package field that may contain template variables.
+type templateField struct {
+	name  string
+	value string
+	known []string
+}
+
+// validateTemplates checks that every template variable used in the package
+// is one that will be expanded for its field, so a typo such as {{bindr}}
+// fails validation instead of producing a literal path at install time.
+func (p *Package) validateTemplates() error {
+	base := []string{"name", "version", "arch", "os"}
+	withPrefix := slices.Concat(base, []string{"prefix"})
+	withDirs := slices.Concat(withPrefix, []string{"bindir", "libdir", "datadir"})
+	builtin := slices.Concat(withDirs, []string{"mandir", "docdir", "srcdir"})
+	all := slices.Concat(builtin, slices.Sorted(maps.Keys(p.Vars)))
+
+	for _, k := range slices.Sorted(maps.Keys(p.Vars)) {
+		if !customVarPattern.MatchString(k) {
+			return fmt.Errorf("vars.%s: invalid variable name", k)
+		}
+		if slices.Contains(builtin, k) {
+			return fmt.Errorf("vars.%s: cannot override the built-in {{%s}} variable", k, k)
+		}
+	}
+
+	fields := []templateField{
+		{"source.url", p.Source.URL, base},
+		{"source.git", p.Source.Git, base},
+		{"source.binary", p.Source.Binary, base},
+		{"source.path", p.Source.Path, base},
+		{"source.ref", p.Source.Ref, base},
+		{"install_paths.prefix", p.InstallPaths.Prefix, base},
+		{"install_paths.bindir", p.InstallPaths.BinDir, withPrefix},
+		{"install_paths.libdir", p.InstallPaths.LibDir, withPrefix},
+		{"install_paths.datadir", p.InstallPaths.DataDir, withPrefix},
+		{"install_paths.mandir", p.InstallPaths.ManDir, withDirs},
+		{"install_paths.docdir", p.InstallPaths.DocDir, withDirs},
+	}
+	for _, k := range slices.Sorted(maps.Keys(p.Vars)) {
+		fields = append(fields, templateField{"vars." + k, p.Vars[k], builtin})
+	}
+	// The source directory is gone by the time messages are shown
+	withoutSrc := slices.DeleteFunc(slices.Clone(all), func(v string) bool { return v == "srcdir" })
+	fields = append(fields,
+		templateField{"post_install_message", p.PostInstallMessage, withoutSrc},
+		templateField{"post_remove_message", p.PostRemoveMessage, withoutSrc},
+	)
+	for i, path := range p.ConflictFiles {
+		fields = append(fields, templateField{fmt.Sprintf("conflict_files[%d]", i), path, withoutSrc})
+	}
+	for i, step := range p.InstallSteps {
+		prefix := fmt.Sprintf("install_steps[%d].", i)
+		fields = append(fields,
+			templateField{prefix + "command", step.Command, all},
+			templateField{prefix + "workdir", step.WorkDir, all},
+			templateField{prefix + "src", step.Src, all},
+			templateField{prefix + "dest", step.Dest, all},
+			templateField{prefix + "path", step.Path, all},
+			templateField{prefix + "content", step.Content, all},
+		)
+		for j, arg := range step.Args {
+			fields = append(fields, templateField{fmt.Sprintf("%sargs[%d]", prefix, j), arg, all})
+		}
+		for _, k := range slices.Sorted(maps.Keys(step.Vars)) {
+			fields = append(fields, templateField{prefix + "vars." + k, step.Vars[k], all})
+		}
+		for _, k := range slices.Sorted(maps.Keys(step.Env)) {
+			fields = append(fields, templateField{prefix + "env." + k, step.Env[k], all})
+		}
+	}
+
+	for _, f := range fields {
+		for _, m := range templateVarPattern.FindAllStringSubmatch(f.value, -1) {
+			if envVarPattern.MatchString(m[0]) || slices.Contains(f.known, m[1]) {
+				continue
+			}
+			return fmt.Errorf("%s: unknown template variable {{%s}}", f.name, m[1])
+		}
+	}
+	return nil
+}
+
+// Lint returns non-fatal warnings about the package definition.
+// Problems reported here become errors in Validate under strict mode.
+func (p *Package) Lint() []LintWarning {
+	var warnings []LintWarning
+	if err := validateSemver(p.Version); err != nil {
+		warnings = append(warnings, LintWarning{
+			Field:   "version",
+			Message: fmt.Sprintf("%q is not a semantic version (MAJOR.MINOR.PATCH)", p.Version),
+		})
+	}
+	return warnings
+}
+
 func validateStep(step InstallStep) error {
+	if step.ClearEnv && len(step.InheritEnv) > 0 {
+		return fmt.Errorf("clear_env and inherit_env cannot both be set")
+	}
+	if step.WorkDirCreate && step.WorkDir == "" {
+		return fmt.Errorf("workdir_create requires workdir")
+	}
+	if step.Relative && step.Type != StepSymlink {
+		return fmt.Errorf("relative is only supported for symlink steps")
+	}
+	if step.Timeout != "" {
+		if step.Type != StepRun {
+			return fmt.Errorf("timeout is only supported for run steps")
+		}
+		if _, err := time.ParseDuration(step.Timeout); err != nil {
+			return fmt.Errorf("timeout: %w", err)
+		}
+	}
+
 	switch step.Type {
 	case StepRun:
 		if step.Command == "" {
@@ -185,6 +538,27 @@ func validateStep(step InstallStep) error {
 		if step.Dest == "" {
 			return fmt.Errorf("symlink step requires dest")
 		}
+	case StepTemplate:
+		if step.Src == "" {
+			return fmt.Errorf("template step requires src")
+		}
+		if step.Dest == "" {
+			return fmt.Errorf("template step requires dest")
+		}
+	case StepAppend:
+		if step.Dest == "" {
+			return fmt.Errorf("append step requires dest")
+		}
+		if step.Content == "" {
+			return fmt.Errorf("append step requires content")
+		}
+	case StepPlugin:
+		if step.Plugin == "" {
+			return fmt.Errorf("plugin step requires plugin")
+		}
+		if strings.ContainsAny(step.Plugin, `/\`) {
+			return fmt.Errorf("plugin name %q must not contain path separators", step.Plugin)
+		}
 	case "":
 		return fmt.Errorf("step type is required")
 	default:
@@ -246,15 +620,75 @@ func (p *Package) ExpandedSource() Source {
 		URL:    p.expand(p.Source.URL, vars),
 		Git:    p.expand(p.Source.Git, vars),
 		Binary: p.expand(p.Source.Binary, vars),
+		Path:   p.expand(p.Source.Path, vars),
 		SHA256: p.Source.SHA256,
+		SHA512: p.Source.SHA512,
 		Ref:    p.expand(p.Source.Ref, vars),
 		Strip:  p.Source.Strip,
+
+		ArchiveSHA256: p.Source.ArchiveSHA256,
+		FetchHeaders:  p.Source.FetchHeaders,
+		Decompress:    p.Source.Decompress,
 	}
 }
 
 // ExpandedSteps returns install steps with template variables expanded.
 // srcdir is the path to the extracted/cloned source directory.
 func (p *Package) ExpandedSteps(srcdir string) []InstallStep {
+	vars := p.stepVars(srcdir)
+
+	var steps []InstallStep
+	for _, step := range p.InstallSteps {
+		if !step.matchesPlatform() {
+			continue
+		}
+		expanded := InstallStep{
+			Type:          step.Type,
+			Command:       p.expand(step.Command, vars),
+			WorkDir:       p.expand(step.WorkDir, vars),
+			Timeout:       step.Timeout,
+			WorkDirCreate: step.WorkDirCreate,
+			Src:           p.expand(step.Src, vars),
+			Dest:          p.expand(step.Dest, vars),
+			Path:          p.expand(step.Path, vars),
+			Content:       p.expand(step.Content, vars),
+			Mode:          step.Mode,
+			Relative:      step.Relative,
+			Platforms:     step.Platforms,
+			ClearEnv:      step.ClearEnv,
+			InheritEnv:    step.InheritEnv,
+			Plugin:        step.Plugin,
+		}
+		switch step.Type {
+		case StepTemplate:
+			// Templates render with the full variable set plus overrides
+			expanded.Vars = maps.Clone(vars)
+			for k, v := range step.Vars {
+				expanded.Vars[k] = p.expand(v, vars)
+			}
+		case StepPlugin:
+			expanded.Vars = maps.Clone(vars)
+			for _, arg := range step.Args {
+				expanded.Args = append(expanded.Args, p.expand(arg, vars))
+			}
+		case StepRun:
+			// Commands see the variable set in their environment
+			expanded.Vars = maps.Clone(vars)
+			if step.Env != nil {
+				expanded.Env = make(map[string]string, len(step.Env))
+				for k, v := range step.Env {
+					expanded.Env[k] = p.expand(v, vars)
+				}
+			}
+		}
+		steps = append(steps, expanded)
+	}
+	return steps
+}
+
+// stepVars returns the variables expanded in install steps: the built-ins,
+// the expanded install paths, srcdir, and the package's custom variables.
+func (p *Package) stepVars(srcdir string) map[string]string {
 	paths := p.ExpandedPaths()
 	vars := p.baseVars()
 	vars["prefix"] = paths.Prefix
@@ -265,23 +699,39 @@ func (p *Package) ExpandedSteps(srcdir string) []InstallStep {
 	vars["docdir"] = paths.DocDir
 	vars["srcdir"] = srcdir
 
-	var steps []InstallStep
-	for _, step := range p.InstallSteps {
-		if !step.matchesPlatform() {
-			continue
+	// Custom variables may reference the built-ins but not each other,
+	// and never replace a built-in
+	custom := make(map[string]string, len(p.Vars))
+	for k, v := range p.Vars {
+		if _, reserved := vars[k]; !reserved {
+			custom[k] = p.expand(v, vars)
 		}
-		steps = append(steps, InstallStep{
-			Type:      step.Type,
-			Command:   p.expand(step.Command, vars),
-			WorkDir:   p.expand(step.WorkDir, vars),
-			Src:       p.expand(step.Src, vars),
-			Dest:      p.expand(step.Dest, vars),
-			Path:      p.expand(step.Path, vars),
-			Mode:      step.Mode,
-			Platforms: step.Platforms,
-		})
 	}
-	return steps
+	maps.Copy(vars, custom)
+	return vars
+}
+
+// ExpandedPostInstallMessage returns PostInstallMessage with template
+// variables expanded.
+func (p *Package) ExpandedPostInstallMessage() string {
+	return p.expand(p.PostInstallMessage, p.stepVars(""))
+}
+
+// ExpandedPostRemoveMessage returns PostRemoveMessage with template
+// variables expanded.
+func (p *Package) ExpandedPostRemoveMessage() string {
+	return p.expand(p.PostRemoveMessage, p.stepVars(""))
+}
+
+// ExpandedConflictFiles returns ConflictFiles with template variables
+// expanded.
+func (p *Package) ExpandedConflictFiles() []string {
+	vars := p.stepVars("")
+	var paths []string
+	for _, path := range p.ConflictFiles {
+		paths = append(paths, p.expand(path, vars))
+	}
+	return paths
 }
 
 func (p *Package) baseVars() map[string]string {
@@ -302,12 +752,18 @@ func (p *Package) baseVars() map[string]string {
 	}
 }
 
+// envVarPattern matches {{env.NAME}} references to environment variables.
+var envVarPattern = regexp.MustCompile(`\{\{env\.([A-Za-z_][A-Za-z0-9_]*)\}\}`)
+
 func (p *Package) expand(s string, vars map[string]string) string {
 	result := s
 	for k, v := range vars {
 		result = strings.ReplaceAll(result, "{{"+k+"}}", v)
 	}
-	return result
+	// Unset environment variables expand to an empty string
+	return envVarPattern.ReplaceAllStringFunc(result, func(ref string) string {
+		return os.Getenv(envVarPattern.FindStringSubmatch(ref)[1])
+	})
 }
 
 func (s InstallStep) matchesPlatform() bool {
@@ -1,6 +1,10 @@
 package pkg
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -103,7 +107,7 @@ url = "https://example.com/test.tar.gz"
 type = "mkdir"
 path = "/tmp"
 `,
-			wantErr: "sha256 checksum required",
+			wantErr: "sha256 or sha512 checksum required",
 		},
 		{
 			name: "missing install steps",
@@ -143,6 +147,200 @@ dest = "/usr/bin/test"
 `,
 			wantErr: "copy step requires src",
 		},
+		{
+			name: "plugin step missing plugin",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[[install_steps]]
+type = "plugin"
+args = ["x"]
+`,
+			wantErr: "plugin step requires plugin",
+		},
+		{
+			name: "clear_env with inherit_env",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[[install_steps]]
+type = "run"
+command = "make"
+clear_env = true
+inherit_env = ["CC"]
+`,
+			wantErr: "clear_env and inherit_env cannot both be set",
+		},
+		{
+			name: "workdir_create without workdir",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[[install_steps]]
+type = "run"
+command = "make"
+workdir_create = true
+`,
+			wantErr: "workdir_create requires workdir",
+		},
+		{
+			name: "invalid step timeout",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[[install_steps]]
+type = "run"
+command = "make"
+timeout = "five minutes"
+`,
+			wantErr: "install_steps[0]: timeout:",
+		},
+		{
+			name: "unknown template variable in step",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[[install_steps]]
+type = "copy"
+src = "test"
+dest = "{{bindr}}/test"
+`,
+			wantErr: "install_steps[0].dest: unknown template variable {{bindr}}",
+		},
+		{
+			name: "unknown template variable in conflict_files",
+			data: `
+name = "test"
+version = "1.0"
+conflict_files = ["{{srcdir}}/test"]
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[[install_steps]]
+type = "mkdir"
+path = "/tmp"
+`,
+			wantErr: "conflict_files[0]: unknown template variable {{srcdir}}",
+		},
+		{
+			name: "path variable in source",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/{{prefix}}/test.tar.gz"
+sha256 = "abc123"
+[[install_steps]]
+type = "mkdir"
+path = "/tmp"
+`,
+			wantErr: "source.url: unknown template variable {{prefix}}",
+		},
+		{
+			name: "fetch_headers with credentials",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+fetch_headers = { authorization = "Bearer secret" }
+[[install_steps]]
+type = "mkdir"
+path = "/tmp"
+`,
+			wantErr: "fetch_headers: authorization must not be stored",
+		},
+		{
+			name: "fetch_headers on git source",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+git = "https://example.com/test.git"
+fetch_headers = { Accept = "application/octet-stream" }
+[[install_steps]]
+type = "mkdir"
+path = "/tmp"
+`,
+			wantErr: "fetch_headers only apply to url and binary sources",
+		},
+		{
+			name: "archive_sha256 on url source",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+archive_sha256 = "0000000000000000000000000000000000000000000000000000000000000000"
+[[install_steps]]
+type = "mkdir"
+path = "/tmp"
+`,
+			wantErr: "archive_sha256 only applies to git sources",
+		},
+		{
+			name: "invalid archive_sha256",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+git = "https://example.com/test.git"
+archive_sha256 = "abc123"
+[[install_steps]]
+type = "mkdir"
+path = "/tmp"
+`,
+			wantErr: "archive_sha256 must be a 64-digit hex SHA-256",
+		},
+		{
+			name: "custom variable overriding a built-in",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[vars]
+bindir = "/opt/bin"
+[[install_steps]]
+type = "mkdir"
+path = "{{bindir}}"
+`,
+			wantErr: "vars.bindir: cannot override the built-in {{bindir}} variable",
+		},
+		{
+			name: "unknown template variable in custom variable",
+			data: `
+name = "test"
+version = "1.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc123"
+[vars]
+completions_dir = "{{datadr}}/completions"
+[[install_steps]]
+type = "mkdir"
+path = "{{completions_dir}}"
+`,
+			wantErr: "vars.completions_dir: unknown template variable {{datadr}}",
+		},
 	}
 
 	for _, tt := range tests {
@@ -227,6 +425,86 @@ func TestExpandedSteps(t *testing.T) {
 	}
 }
 
+func TestExpandedStepsCustomVars(t *testing.T) {
+	data := []byte(`
+name = "test"
+version = "1.0.0"
+[source]
+url = "https://example.com/test.tar.gz"
+sha256 = "abc"
+[vars]
+completions_dir = "{{datadir}}/bash-completion/completions"
+[[install_steps]]
+type = "copy"
+src = "completions/{{name}}.bash"
+dest = "{{completions_dir}}/{{name}}"
+[[install_steps]]
+type = "copy"
+src = "bin/test"
+dest = "{{bindir}}/test"
+`)
+	pkg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	steps := pkg.ExpandedSteps("/tmp/src")
+	if want := "/usr/local/share/bash-completion/completions/test"; steps[0].Dest != want {
+		t.Errorf("expected dest %q, got %q", want, steps[0].Dest)
+	}
+	if steps[1].Dest != "/usr/local/bin/test" {
+		t.Errorf("expected dest '/usr/local/bin/test', got %q", steps[1].Dest)
+	}
+}
+
+func TestExpandedPostInstallMessage(t *testing.T) {
+	data := []byte(`
+name = "tool"
+version = "1.2.0"
+post_install_message = """
+Add {{bindir}} to your PATH, and source
+{{completions_dir}}/{{name}} from your shell config.
+"""
+post_remove_message = "Remove {{completions_dir}} from your shell config."
+[source]
+url = "https://example.com/tool.tar.gz"
+sha256 = "abc"
+[install_paths]
+prefix = "/opt/tool"
+[vars]
+completions_dir = "{{datadir}}/completions"
+[[install_steps]]
+type = "copy"
+src = "tool"
+dest = "{{bindir}}/tool"
+[[install_steps]]
+type = "copy"
+src = "tool.bash"
+dest = "{{completions_dir}}/{{name}}"
+`)
+	pkg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	// Messages see the same variables as the steps
+	steps := pkg.ExpandedSteps("/tmp/src")
+	want := fmt.Sprintf("Add %s to your PATH, and source\n%s from your shell config.\n",
+		filepath.Dir(steps[0].Dest), steps[1].Dest)
+	if got := pkg.ExpandedPostInstallMessage(); got != want {
+		t.Errorf("ExpandedPostInstallMessage = %q, want %q", got, want)
+	}
+	if got := pkg.ExpandedPostRemoveMessage(); got != "Remove /opt/tool/share/completions from your shell config." {
+		t.Errorf("ExpandedPostRemoveMessage = %q", got)
+	}
+
+	// The source directory is gone by the time the message is shown
+	bad := strings.Replace(string(data), "Add {{bindir}}", "Add {{srcdir}}", 1)
+	if _, err := Parse([]byte(bad)); err == nil || !strings.Contains(err.Error(), "post_install_message: unknown template variable {{srcdir}}") {
+		t.Errorf("expected {{srcdir}} in a message to be rejected, got %v", err)
+	}
+}
+
 func TestGitSource(t *testing.T) {
 	data := []byte(`
 name = "test"
@@ -254,6 +532,40 @@ command = "make install PREFIX={{prefix}}"
 	}
 }
 
+func TestPathSource(t *testing.T) {
+	dir := t.TempDir()
+	def := filepath.Join(dir, "test.toml")
+	data := []byte(`
+name = "test"
+version = "1.0.0"
+
+[source]
+path = "./build"
+
+[[install_steps]]
+type = "copy"
+src = "test"
+dest = "{{bindir}}/test"
+`)
+	if err := os.WriteFile(def, data, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	pkg, err := ParseFile(def)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if pkg.Source.SourceType() != "path" {
+		t.Errorf("expected source type 'path', got %q", pkg.Source.SourceType())
+	}
+	if want := filepath.Join(dir, "build"); pkg.Source.Path != want {
+		t.Errorf("expected path resolved to %q, got %q", want, pkg.Source.Path)
+	}
+	if pkg.Source.Location() != pkg.Source.Path {
+		t.Errorf("Location = %q, want the path", pkg.Source.Location())
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsAt(s, substr, 0))
 }
@@ -266,3 +578,91 @@ func containsAt(s, substr string, start int) bool {
 	}
 	return false
 }
+
+func TestEnvExpansion(t *testing.T) {
+	t.Setenv("ALLOY_TEST_HOME", "/home/tester")
+	t.Setenv("ALLOY_TEST_MIRROR", "mirror.example.com")
+
+	pkg := &Package{
+		Name:    "test",
+		Version: "1.0.0",
+		Source: Source{
+			URL:    "https://{{env.ALLOY_TEST_MIRROR}}/test-{{version}}.tar.gz",
+			SHA256: "abc",
+		},
+		InstallPaths: InstallPaths{
+			Prefix: "{{env.ALLOY_TEST_HOME}}/.local",
+		},
+		InstallSteps: []InstallStep{
+			{Type: "copy", Src: "test", Dest: "{{bindir}}/test{{env.ALLOY_TEST_UNSET}}"},
+		},
+	}
+	pkg.applyDefaults()
+
+	if paths := pkg.ExpandedPaths(); paths.BinDir != "/home/tester/.local/bin" {
+		t.Errorf("expected bindir '/home/tester/.local/bin', got %q", paths.BinDir)
+	}
+
+	if src := pkg.ExpandedSource(); src.URL != "https://mirror.example.com/test-1.0.0.tar.gz" {
+		t.Errorf("unexpected URL %q", src.URL)
+	}
+
+	// Unset variables expand to empty
+	steps := pkg.ExpandedSteps("/tmp/src")
+	if steps[0].Dest != "/home/tester/.local/bin/test" {
+		t.Errorf("expected dest '/home/tester/.local/bin/test', got %q", steps[0].Dest)
+	}
+}
+
+func TestSHA512Source(t *testing.T) {
+	data := []byte(`
+name = "test"
+version = "1.0.0"
+
+[source]
+url = "https://example.com/test.tar.gz"
+sha512 = "abc123"
+
+[[install_steps]]
+type = "mkdir"
+path = "/tmp"
+`)
+
+	pkg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if pkg.Source.Checksum() != "abc123" {
+		t.Errorf("expected checksum 'abc123', got %q", pkg.Source.Checksum())
+	}
+}
+
+func TestSourceCompression(t *testing.T) {
+	tests := []struct {
+		source Source
+		want   string
+	}{
+		{Source{Binary: "https://example.com/tool"}, ""},
+		{Source{Binary: "https://example.com/tool.gz"}, "gz"},
+		{Source{Binary: "https://example.com/tool.XZ?download=1"}, "xz"},
+		{Source{Binary: "https://example.com/tool.bz2"}, "bz2"},
+		{Source{Binary: "https://example.com/download?id=1", Decompress: "gz"}, "gz"},
+		{Source{Binary: "https://example.com/tool.gz", Decompress: "none"}, ""},
+		{Source{URL: "https://example.com/tool.tar.gz"}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.source.Compression(); got != tt.want {
+			t.Errorf("Compression() of %+v = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"[source]\nbinary = \"https://example.com/tool\"\nsha256 = \"abc\"\ndecompress = \"zstd\"\n",
+		"[source]\nurl = \"https://example.com/tool.tar.gz\"\nsha256 = \"abc\"\ndecompress = \"gz\"\n",
+	} {
+		data := "name = \"test\"\nversion = \"1.0\"\n" + bad + "[[install_steps]]\ntype = \"mkdir\"\npath = \"/tmp\"\n"
+		if _, err := Parse([]byte(data)); err == nil || !strings.Contains(err.Error(), "decompress") {
+			t.Errorf("expected a decompress error for %q, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// identifiers matches dot-separated pre-release or build identifiers.
+const identifiers = `[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*`
+
+// semverPattern matches MAJOR.MINOR.PATCH with optional pre-release and build metadata.
+var semverPattern = regexp.MustCompile(`^([0-9]+)\.([0-9]+)\.([0-9]+)(?:-(` + identifiers + `))?(?:\+(` + identifiers + `))?$`)
+
+// looseVersionPattern also matches the common non-strict forms ParseVersion
+// accepts: a leading "v" and a missing patch number.
+var looseVersionPattern = regexp.MustCompile(`^[vV]?([0-9]+)\.([0-9]+)(?:\.([0-9]+))?(?:-(` + identifiers + `))?(?:\+(` + identifiers + `))?$`)
+
+// Version is a parsed semantic version.
+type Version struct {
+	Major, Minor, Patch int
+
+	// Prerelease is the part after "-", e.g. "rc.1", and Build the part
+	// after "+", which is ignored when comparing versions.
+	Prerelease string
+	Build      string
+}
+
+// ParseVersion parses a semantic version string such as "1.2.3-rc.1+build.5".
+// A leading "v" and a missing patch number are accepted, so "v1.2" parses as
+// 1.2.0.
+func ParseVersion(s string) (Version, error) {
+	m := looseVersionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid semantic version %q", s)
+	}
+
+	var nums [3]int
+	for i := range nums {
+		if m[i+1] == "" {
+			continue // missing patch
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid semantic version %q: %w", s, err)
+		}
+		nums[i] = n
+	}
+
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2], Prerelease: m[4], Build: m[5]}, nil
+}
+
+// validateSemver reports whether v is a strict semantic version, as
+// required by ValidateOptions.StrictVersions.
+func validateSemver(v string) error {
+	if !semverPattern.MatchString(v) {
+		return fmt.Errorf("invalid semantic version %q", v)
+	}
+	return nil
+}
+
+// String formats v as MAJOR.MINOR.PATCH[-prerelease][+build].
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare compares v and w by semver precedence, returning -1, 0, or 1.
+// Build metadata is ignored.
+func (v Version) Compare(w Version) int {
+	for _, pair := range [][2]int{{v.Major, w.Major}, {v.Minor, w.Minor}, {v.Patch, w.Patch}} {
+		if c := compareInts(pair[0], pair[1]); c != 0 {
+			return c
+		}
+	}
+	return comparePrerelease(v.Prerelease, w.Prerelease)
+}
+
+// CompareVersions compares two versions, returning -1, 0, or 1.
+// Semantic versions are compared by precedence (build metadata is ignored).
+// If either version is not semver (e.g., date-style "20241201"), the strings
+// are compared lexically.
+func CompareVersions(a, b string) int {
+	av, aErr := ParseVersion(a)
+	bv, bErr := ParseVersion(b)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+	return av.Compare(bv)
+}
+
+// comparePrerelease compares pre-release strings per semver precedence rules.
+// A version without a pre-release has higher precedence than one with.
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInts(aNum, bNum)
+		case aErr == nil:
+			c = -1 // Numeric identifiers sort before alphanumeric ones
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(aParts[i], bParts[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return compareInts(len(aParts), len(bParts))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package pkg
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+	}{
+		{"1.22.3-rc.1+build.5", Version{1, 22, 3, "rc.1", "build.5"}},
+		{"0.0.0", Version{0, 0, 0, "", ""}},
+		{"1.2.3+linux-amd64", Version{1, 2, 3, "", "linux-amd64"}},
+		{"1.2.3-x-y.7", Version{1, 2, 3, "x-y.7", ""}},
+		{"v1.2.3", Version{1, 2, 3, "", ""}},
+		{"V2.0.1-beta", Version{2, 0, 1, "beta", ""}},
+		{"1.2", Version{1, 2, 0, "", ""}},
+		{"v0.9-rc.2", Version{0, 9, 0, "rc.2", ""}},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if err != nil {
+			t.Errorf("ParseVersion(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseVersion(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, v := range []string{"", "1", "v", "vv1.2.3", "1.2.3-", "1.2.3+", "1.2.3-rc..1", "20241201", "1.2.3.4", " 1.2.3", "1.x.3"} {
+		if _, err := ParseVersion(v); err == nil {
+			t.Errorf("ParseVersion(%q) should fail", v)
+		}
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	for in, want := range map[string]string{
+		"1.22.3-rc.1+build.5": "1.22.3-rc.1+build.5",
+		"v1.2":                "1.2.0",
+		"3.0.0+meta":          "3.0.0+meta",
+	} {
+		v, err := ParseVersion(in)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q): %v", in, err)
+		}
+		if got := v.String(); got != want {
+			t.Errorf("ParseVersion(%q).String() = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// The precedence example from the semver specification, in order
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0",
+		"1.0.1", "1.1.0", "1.9.0", "1.10.0", "2.0.0", "10.0.0",
+	}
+	versions := make([]Version, len(ordered))
+	for i, s := range ordered {
+		v, err := ParseVersion(s)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q): %v", s, err)
+		}
+		versions[i] = v
+	}
+	for i, a := range versions {
+		for j, b := range versions {
+			want := compareInts(i, j)
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-rc.1", "1.0.0-RC.1", 1},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-rc.1+a", "1.0.0-rc.1+b", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"v1.2", "1.2.1", -1},
+		{"1.10", "v1.9.9", 1},
+		{"20241201", "20250101", -1},
+		{"20250101", "20241201", 1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestStrictVersions(t *testing.T) {
+	p := &Package{
+		Name:         "test",
+		Version:      "20241201",
+		Source:       Source{Git: "https://example.com/test.git"},
+		InstallSteps: []InstallStep{{Type: StepRun, Command: "make"}},
+	}
+
+	// Non-strict: valid, but linted
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate (non-strict): %v", err)
+	}
+	if warnings := p.Lint(); len(warnings) != 1 || warnings[0].Field != "version" {
+		t.Errorf("Lint() = %v, want one version warning", warnings)
+	}
+
+	if err := p.ValidateWith(ValidateOptions{StrictVersions: true}); err == nil {
+		t.Error("ValidateWith (strict) should reject non-semver version")
+	}
+
+	t.Setenv(StrictVersionsEnv, "1")
+	if err := p.Validate(); err == nil {
+		t.Error("Validate (strict) should reject non-semver version")
+	}
+
+	// ParseVersion accepts these, but strict mode wants plain semver
+	for _, v := range []string{"v1.2.3", "1.2"} {
+		p.Version = v
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate (strict) should reject %q", v)
+		}
+	}
+
+	p.Version = "1.2.3"
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate (strict) with semver: %v", err)
+	}
+	if warnings := p.Lint(); len(warnings) != 0 {
+		t.Errorf("Lint() = %v, want none", warnings)
+	}
+}
@@ -0,0 +1,120 @@
+// Package registry provides clients for remote package registries.
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// TokenEnv is the environment variable holding the registry bearer token.
+const TokenEnv = "ALLOY_REGISTRY_TOKEN"
+
+// SignatureHeader carries the base64-encoded detached signature of a
+// published definition.
+const SignatureHeader = "X-Alloy-Signature"
+
+// Client submits package definitions to a registry.
+type Client struct {
+	// URL is the registry base URL.
+	URL string
+
+	// Token is the bearer token used for authentication, if any.
+	Token string
+
+	// Sign if set, signs the definition before publishing. The returned
+	// signature is sent in the X-Alloy-Signature header.
+	Sign func(data []byte) ([]byte, error)
+
+	// HTTPClient is used for requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// PublishResult is the registry's response to a publish request.
+type PublishResult struct {
+	// ID identifies the submission in the registry.
+	ID string `json:"id"`
+
+	// Status is the submission status (e.g., "pending", "accepted").
+	Status string `json:"status"`
+}
+
+// NewClient creates a registry client for the given URL and token.
+func NewClient(url, token string) *Client {
+	return &Client{
+		URL:   url,
+		Token: token,
+	}
+}
+
+// Publish submits a package definition. data is the raw TOML the package
+// was parsed from, which is sent unchanged.
+func (c *Client) Publish(p *pkg.Package, data []byte) (*PublishResult, error) {
+	url := strings.TrimRight(c.URL, "/") + "/packages/" + p.Name
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/toml")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	if c.Sign != nil {
+		sig, err := c.Sign(data)
+		if err != nil {
+			return nil, fmt.Errorf("sign definition: %w", err)
+		}
+		req.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("publish: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("publish failed: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result PublishResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+	return &result, nil
+}
+
+// GPGSign creates a detached binary signature of data with the user's
+// default gpg key.
+func GPGSign(data []byte) ([]byte, error) {
+	cmd := exec.Command("gpg", "--batch", "--detach-sign", "--output", "-")
+	cmd.Stdin = bytes.NewReader(data)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	sig, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return sig, nil
+}
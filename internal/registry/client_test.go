@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+func TestPublish(t *testing.T) {
+	data := []byte(`name = "tool"`)
+
+	var gotPath, gotAuth, gotSig string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotSig = r.Header.Get(SignatureHeader)
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"id": "sub-42", "status": "pending"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL+"/", "secret")
+	c.Sign = func(b []byte) ([]byte, error) { return []byte("sig"), nil }
+
+	result, err := c.Publish(&pkg.Package{Name: "tool"}, data)
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	if result.ID != "sub-42" || result.Status != "pending" {
+		t.Errorf("result = %+v", result)
+	}
+	if gotPath != "/packages/tool" {
+		t.Errorf("path = %q, want /packages/tool", gotPath)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	if gotSig != base64.StdEncoding.EncodeToString([]byte("sig")) {
+		t.Errorf("signature header = %q", gotSig)
+	}
+	if string(gotBody) != string(data) {
+		t.Errorf("body = %q, want %q", gotBody, data)
+	}
+}
+
+func TestPublishError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "").Publish(&pkg.Package{Name: "tool"}, nil)
+	if err == nil {
+		t.Fatal("expected error for HTTP 401")
+	}
+}
@@ -0,0 +1,359 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/anthropics/alloy/internal/httpretry"
+	"github.com/anthropics/alloy/internal/pkg"
+)
+
+// IndexFile is the name of the package index served by a remote.
+const IndexFile = "packages.index"
+
+// IndexChecksumFile is the name of the index checksum served alongside
+// IndexFile. It holds the hex SHA-256 of the index.
+const IndexChecksumFile = IndexFile + ".sha256"
+
+// checksumRequiredFile is written in a remote's sync directory once the
+// remote has published an index checksum, holding the last one verified.
+// It is named like IndexChecksumFile, so directories synced by earlier
+// versions still require checksums.
+const checksumRequiredFile = IndexChecksumFile
+
+// StaleIndexAge is how old a synced index may get before doctor warns.
+const StaleIndexAge = 7 * 24 * time.Hour
+
+// Remote is a package repository that serves a package index.
+type Remote struct {
+	// Name identifies the remote locally.
+	Name string
+
+	// URL is the base URL the index is served from.
+	URL string
+
+	// HTTPClient is used for requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Index lists the packages a remote provides.
+type Index struct {
+	Packages []IndexEntry `toml:"packages"`
+}
+
+// IndexEntry describes one package in a remote index.
+type IndexEntry struct {
+	Name    string `toml:"name"`
+	Version string `toml:"version"`
+
+	// TOMLURL is where the package definition can be downloaded.
+	TOMLURL string `toml:"toml_url"`
+
+	// SHA256 is the checksum of the package definition file.
+	SHA256 string `toml:"sha256_of_toml"`
+}
+
+// FetchIndex downloads the remote index and every package definition it
+// lists, verifying the index against IndexChecksumFile if the remote
+// publishes one and each definition against its checksum.
+func (r *Remote) FetchIndex() ([]*pkg.Package, error) {
+	index, _, _, err := r.fetchIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []*pkg.Package
+	for _, entry := range index.Packages {
+		data, err := r.fetchDefinition(entry)
+		if err != nil {
+			return nil, err
+		}
+		p, err := pkg.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", entry.Name, err)
+		}
+		packages = append(packages, p)
+	}
+	return packages, nil
+}
+
+// FetchPackage downloads the index and the definition of a single package,
+// verifying them as FetchIndex does. It returns an error wrapping
+// os.ErrNotExist if the index doesn't list it.
+func (r *Remote) FetchPackage(name string) (*pkg.Package, error) {
+	index, _, _, err := r.fetchIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range index.Packages {
+		if entry.Name != name {
+			continue
+		}
+		data, err := r.fetchDefinition(entry)
+		if err != nil {
+			return nil, err
+		}
+		p, err := pkg.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", name, err)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("package %s: %w", name, os.ErrNotExist)
+}
+
+// SyncResult describes the changes a sync made to a remote's local index.
+type SyncResult struct {
+	Index *Index
+
+	// Added, Updated, and Removed list package names, sorted.
+	Added   []string
+	Updated []string
+	Removed []string
+}
+
+// Sync downloads the remote index and package definitions into dir,
+// storing the index as index.toml and definitions under packages/.
+//
+// If the remote serves IndexChecksumFile the index is verified against
+// it. The index changes between syncs, so the checksum is trusted anew each
+// time: it catches corrupted or truncated downloads, not a server that
+// publishes a matching checksum for a tampered index. Once a remote has
+// published a checksum, later syncs require one (trust on first use), so
+// it can't silently be dropped.
+func (r *Remote) Sync(dir string) (*SyncResult, error) {
+	index, raw, checksum, err := r.fetchIndex()
+	if err != nil {
+		return nil, err
+	}
+	if err := requireChecksum(dir, checksum); err != nil {
+		return nil, err
+	}
+
+	previous, err := LoadIndex(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Download into a fresh directory, so packages dropped from the index
+	// disappear, and only replace the current definitions once every new
+	// one has arrived
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create remote dir: %w", err)
+	}
+	newDir, err := os.MkdirTemp(dir, "packages.new-")
+	if err != nil {
+		return nil, fmt.Errorf("create package cache: %w", err)
+	}
+	defer os.RemoveAll(newDir)
+	if err := os.Chmod(newDir, 0755); err != nil {
+		return nil, fmt.Errorf("create package cache: %w", err)
+	}
+
+	for _, entry := range index.Packages {
+		if entry.Name == "" || strings.ContainsAny(entry.Name, `/\`) {
+			return nil, fmt.Errorf("invalid package name %q in index", entry.Name)
+		}
+		data, err := r.fetchDefinition(entry)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(newDir, entry.Name+".toml"), data, 0644); err != nil {
+			return nil, fmt.Errorf("write package %s: %w", entry.Name, err)
+		}
+	}
+
+	if err := replaceDir(newDir, filepath.Join(dir, "packages")); err != nil {
+		return nil, fmt.Errorf("replace package cache: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.toml"), raw, 0644); err != nil {
+		return nil, fmt.Errorf("write index: %w", err)
+	}
+
+	result := DiffIndexes(previous, index)
+	result.Index = index
+	return result, nil
+}
+
+// replaceDir moves the directory src to dest, replacing dest if it exists.
+// The old dest is moved aside first and put back if the move fails.
+func replaceDir(src, dest string) error {
+	old := dest + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dest, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(src, dest); err != nil {
+		os.Rename(old, dest)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// LoadIndex reads the index stored in dir by a previous Sync.
+func LoadIndex(dir string) (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := toml.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("parse index: %w", err)
+	}
+	return &index, nil
+}
+
+// IndexAge returns how long ago the index in dir was synced.
+func IndexAge(dir string) (time.Duration, error) {
+	info, err := os.Stat(filepath.Join(dir, "index.toml"))
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(info.ModTime()), nil
+}
+
+// DiffIndexes compares two indexes by package name and version.
+// A nil old index is treated as empty.
+func DiffIndexes(old, updated *Index) *SyncResult {
+	oldVersions := make(map[string]string)
+	if old != nil {
+		for _, e := range old.Packages {
+			oldVersions[e.Name] = e.Version
+		}
+	}
+
+	result := &SyncResult{}
+	seen := make(map[string]bool)
+	for _, e := range updated.Packages {
+		seen[e.Name] = true
+		version, ok := oldVersions[e.Name]
+		switch {
+		case !ok:
+			result.Added = append(result.Added, e.Name)
+		case version != e.Version:
+			result.Updated = append(result.Updated, e.Name)
+		}
+	}
+	for name := range oldVersions {
+		if !seen[name] {
+			result.Removed = append(result.Removed, name)
+		}
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Updated)
+	sort.Strings(result.Removed)
+	return result
+}
+
+// requireChecksum records in dir that the remote published the index
+// checksum, or fails if it no longer does after having done so before.
+func requireChecksum(dir, checksum string) error {
+	path := filepath.Join(dir, checksumRequiredFile)
+	if checksum == "" {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("remote no longer publishes %s, which earlier syncs verified", IndexChecksumFile)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create remote dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(checksum+"\n"), 0644); err != nil {
+		return fmt.Errorf("write index checksum: %w", err)
+	}
+	return nil
+}
+
+// fetchIndex downloads and parses the remote's package index. If the
+// remote publishes IndexChecksumFile, the index is verified against it and
+// the checksum returned; otherwise the checksum is empty.
+func (r *Remote) fetchIndex() (*Index, []byte, string, error) {
+	data, err := r.get(strings.TrimRight(r.URL, "/") + "/" + IndexFile)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("fetch index: %w", err)
+	}
+	checksum, err := r.verifyIndex(data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var index Index
+	if err := toml.Unmarshal(data, &index); err != nil {
+		return nil, nil, "", fmt.Errorf("parse index: %w", err)
+	}
+	return &index, data, checksum, nil
+}
+
+// verifyIndex checks raw against the remote's published index checksum,
+// returning it, or "" if the remote publishes none.
+func (r *Remote) verifyIndex(raw []byte) (string, error) {
+	data, err := r.get(strings.TrimRight(r.URL, "/") + "/" + IndexChecksumFile)
+	if errors.Is(err, errNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetch index checksum: %w", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("index checksum file is empty")
+	}
+	sum := sha256.Sum256(raw)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(fields[0], actual) {
+		return "", fmt.Errorf("index checksum mismatch: expected %s, got %s", fields[0], actual)
+	}
+	return fields[0], nil
+}
+
+// fetchDefinition downloads a package definition and verifies its checksum.
+func (r *Remote) fetchDefinition(entry IndexEntry) ([]byte, error) {
+	data, err := r.get(entry.TOMLURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch package %s: %w", entry.Name, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != entry.SHA256 {
+		return nil, fmt.Errorf("package %s: checksum mismatch: expected %s, got %s",
+			entry.Name, entry.SHA256, actual)
+	}
+	return data, nil
+}
+
+// errNotFound is returned by get for HTTP 404 responses.
+var errNotFound = errors.New("HTTP 404")
+
+// get downloads a URL and returns the response body.
+func (r *Remote) get(url string) ([]byte, error) {
+	resp, err := httpretry.Get(r.HTTPClient, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,223 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDefinition = `
+name = "tool"
+version = "1.0.0"
+
+[source]
+git = "https://example.com/tool.git"
+
+[[install_steps]]
+type = "run"
+command = "make install"
+`
+
+// newIndexServer serves an index listing one package. If badChecksum is set,
+// the index advertises the wrong checksum for it.
+func newIndexServer(t *testing.T, badChecksum bool) *httptest.Server {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(testDefinition))
+	checksum := hex.EncodeToString(sum[:])
+	if badChecksum {
+		checksum = "0000"
+	}
+
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/"+IndexFile, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "[[packages]]\nname = \"tool\"\nversion = \"1.0.0\"\ntoml_url = %q\nsha256_of_toml = %q\n",
+			srv.URL+"/tool.toml", checksum)
+	})
+	mux.HandleFunc("/tool.toml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDefinition))
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchIndex(t *testing.T) {
+	srv := newIndexServer(t, false)
+
+	r := &Remote{Name: "test", URL: srv.URL}
+	packages, err := r.FetchIndex()
+	if err != nil {
+		t.Fatalf("FetchIndex: %v", err)
+	}
+	if len(packages) != 1 || packages[0].Name != "tool" {
+		t.Errorf("packages = %+v", packages)
+	}
+}
+
+func TestFetchIndexChecksumMismatch(t *testing.T) {
+	srv := newIndexServer(t, true)
+
+	r := &Remote{Name: "test", URL: srv.URL}
+	if _, err := r.FetchIndex(); err == nil {
+		t.Fatal("expected checksum mismatch error")
+	}
+}
+
+func TestRemoteSync(t *testing.T) {
+	srv := newIndexServer(t, false)
+	dir := t.TempDir()
+
+	r := &Remote{Name: "test", URL: srv.URL}
+	result, err := r.Sync(dir)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(result.Index.Packages) != 1 {
+		t.Errorf("expected 1 package in index, got %d", len(result.Index.Packages))
+	}
+	if len(result.Added) != 1 || result.Added[0] != "tool" {
+		t.Errorf("Added = %v, want [tool]", result.Added)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "index.toml")); err != nil {
+		t.Errorf("index.toml not written: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "packages", "tool.toml"))
+	if err != nil {
+		t.Fatalf("definition not cached: %v", err)
+	}
+	if string(data) != testDefinition {
+		t.Error("cached definition does not match")
+	}
+}
+
+func TestRemoteSyncFailureKeepsPackages(t *testing.T) {
+	dir := t.TempDir()
+	r := &Remote{Name: "test", URL: newIndexServer(t, false).URL}
+	if _, err := r.Sync(dir); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// A definition failing its checksum leaves the last sync in place
+	r.URL = newIndexServer(t, true).URL
+	if _, err := r.Sync(dir); err == nil {
+		t.Fatal("Sync with a bad definition checksum should fail")
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "packages", "tool.toml")); err != nil || string(data) != testDefinition {
+		t.Errorf("definition after a failed sync: %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("remote dir holds %d entries, want index.toml and packages only", len(entries))
+	}
+}
+
+func TestRemoteSyncIndexChecksum(t *testing.T) {
+	index := ""
+	checksum := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+IndexFile, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(index))
+	})
+	mux.HandleFunc("/"+IndexChecksumFile, func(w http.ResponseWriter, r *http.Request) {
+		if checksum == "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%s  %s\n", checksum, IndexFile)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	r := &Remote{Name: "test", URL: srv.URL}
+
+	// No checksum published and none seen before: accepted
+	if _, err := r.Sync(dir); err != nil {
+		t.Fatalf("first Sync: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(index))
+	checksum = hex.EncodeToString(sum[:])
+	if _, err := r.Sync(dir); err != nil {
+		t.Fatalf("Sync with checksum: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, checksumRequiredFile)); err != nil {
+		t.Errorf("published checksum not recorded: %v", err)
+	}
+
+	// The index changes between syncs, each verified by its own checksum
+	index = "# updated\n"
+	sum = sha256.Sum256([]byte(index))
+	checksum = hex.EncodeToString(sum[:])
+	if _, err := r.Sync(dir); err != nil {
+		t.Fatalf("Sync of an updated index: %v", err)
+	}
+	if _, err := r.FetchIndex(); err != nil {
+		t.Errorf("FetchIndex with checksum: %v", err)
+	}
+
+	checksum = "0000"
+	if _, err := r.Sync(dir); err == nil {
+		t.Error("expected checksum mismatch error")
+	}
+	if _, err := r.FetchPackage("tool"); err == nil || !strings.Contains(err.Error(), "index checksum mismatch") {
+		t.Errorf("FetchPackage with a bad index checksum: err = %v", err)
+	}
+
+	// Checksum disappears after having been published
+	checksum = ""
+	if _, err := r.Sync(dir); err == nil {
+		t.Error("expected error when the checksum is no longer published")
+	}
+}
+
+func TestDiffIndexes(t *testing.T) {
+	old := &Index{Packages: []IndexEntry{
+		{Name: "a", Version: "1.0"},
+		{Name: "b", Version: "1.0"},
+		{Name: "c", Version: "1.0"},
+	}}
+	updated := &Index{Packages: []IndexEntry{
+		{Name: "a", Version: "1.0"},
+		{Name: "b", Version: "2.0"},
+		{Name: "d", Version: "1.0"},
+	}}
+
+	result := DiffIndexes(old, updated)
+	if fmt.Sprint(result.Added) != "[d]" {
+		t.Errorf("Added = %v", result.Added)
+	}
+	if fmt.Sprint(result.Updated) != "[b]" {
+		t.Errorf("Updated = %v", result.Updated)
+	}
+	if fmt.Sprint(result.Removed) != "[c]" {
+		t.Errorf("Removed = %v", result.Removed)
+	}
+}
+
+func TestFetchPackage(t *testing.T) {
+	srv := newIndexServer(t, false)
+	r := &Remote{Name: "test", URL: srv.URL}
+
+	p, err := r.FetchPackage("tool")
+	if err != nil {
+		t.Fatalf("FetchPackage: %v", err)
+	}
+	if p.Name != "tool" || p.Version != "1.0.0" {
+		t.Errorf("got %s@%s, want tool@1.0.0", p.Name, p.Version)
+	}
+
+	if _, err := r.FetchPackage("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist for unknown package, got %v", err)
+	}
+}